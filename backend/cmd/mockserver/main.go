@@ -54,10 +54,13 @@ func main() {
 	logger.Printf("  GET  %s/admin/merchants\n", server.URL())
 	logger.Printf("  POST %s/admin/merchants/{id}/reset\n", server.URL())
 	logger.Printf("  GET  %s/health\n", server.URL())
+	logger.Printf("  POST %s/api/v1/payments (fake LNbits)\n", server.URL())
+	logger.Printf("  POST %s/api/v1/payments/{hash}/settle\n", server.URL())
 	logger.Println("")
 
 	logger.Println("To use with dashboard backend, set:")
 	logger.Printf("  export SOURCE_BASE_URL=%s\n", server.URL())
+	logger.Printf("  export LNBITS_URL=%s LNBITS_INVOICE_KEY=mock\n", server.URL())
 	logger.Println("")
 	logger.Println("Then add merchants via dashboard admin API:")
 	logger.Println("  curl -X POST http://localhost:8080/v1/admin/merchants \\")
@@ -32,6 +32,14 @@ func main() {
 	if err := st.Init(ctx); err != nil {
 		logger.Fatalf("init db: %v", err)
 	}
+	if cfg.DefaultEvent != "" {
+		if _, err := st.EnsureEvent(ctx, cfg.DefaultEvent); err != nil {
+			logger.Fatalf("ensure default event: %v", err)
+		}
+		if _, err := st.ActivateEvent(ctx, cfg.DefaultEvent); err != nil {
+			logger.Fatalf("activate default event: %v", err)
+		}
+	}
 
 	poller := ingest.NewPoller(st, ingest.Config{
 		Interval:    cfg.PollInterval,
@@ -39,9 +47,11 @@ func main() {
 		Timeout:     cfg.HTTPTimeout,
 		BaseURL:     cfg.DataAPIBaseURL,
 	}, logger)
-	go poller.Start(ctx)
-
 	server := api.NewServer(cfg, st, poller, logger)
+	if err := server.LoadSettings(ctx); err != nil {
+		logger.Printf("ignoring stored settings: %v\n", err)
+	}
+	go poller.Start(ctx)
 
 	if err := server.Run(ctx); err != nil {
 		logger.Fatalf("server error: %v", err)
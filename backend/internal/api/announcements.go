@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/adopting-bitcoin/dashboard/internal/store"
+)
+
+// announcementPayload is the body of announcement creates and updates.
+// Omitted fields keep their current value on update; an ends_at of null
+// only clears the end when clear_ends_at is set.
+type announcementPayload struct {
+	Message     *string                     `json:"message"`
+	Priority    *store.AnnouncementPriority `json:"priority"`
+	StartsAt    *time.Time                  `json:"starts_at"`
+	EndsAt      *time.Time                  `json:"ends_at"`
+	ClearEndsAt bool                        `json:"clear_ends_at"`
+	DisplayIDs  *[]string                   `json:"display_ids"`
+	SceneIDs    *[]string                   `json:"scene_ids"`
+}
+
+func (p announcementPayload) apply(a *store.Announcement) {
+	if p.Message != nil {
+		a.Message = *p.Message
+	}
+	if p.Priority != nil {
+		a.Priority = *p.Priority
+	}
+	if p.StartsAt != nil {
+		a.StartsAt = *p.StartsAt
+	}
+	if p.EndsAt != nil {
+		a.EndsAt = p.EndsAt
+	}
+	if p.ClearEndsAt {
+		a.EndsAt = nil
+	}
+	if p.DisplayIDs != nil {
+		a.DisplayIDs = *p.DisplayIDs
+	}
+	if p.SceneIDs != nil {
+		a.SceneIDs = *p.SceneIDs
+	}
+}
+
+// handleAnnouncements returns the announcements running now, most urgent
+// first. Screens can pass display and scene to leave out announcements
+// targeted elsewhere.
+func (s *Server) handleAnnouncements(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	items, err := s.storeFor(r).ActiveAnnouncements(r.Context(), time.Now(), q.Get("display"), q.Get("scene"), false)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// handleDisplayAnnouncements returns a display's running and upcoming
+// announcements, so it can show scheduled ones on time without polling.
+func (s *Server) handleDisplayAnnouncements(w http.ResponseWriter, r *http.Request) {
+	d := displayFrom(r)
+	if d.Status != store.DisplayApproved {
+		writeError(w, http.StatusForbidden, store.ErrDisplayNotApproved)
+		return
+	}
+	pl, err := s.displayPlaylist(r.Context(), d)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	items, err := s.store.ForEvent(pl.EventID).ActiveAnnouncements(r.Context(), time.Now(), d.ID, "", true)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// handleListAnnouncements lists the event's announcements; current=true
+// leaves out ended ones.
+func (s *Server) handleListAnnouncements(w http.ResponseWriter, r *http.Request) {
+	current := r.URL.Query().Get("current") == "true"
+	items, err := s.storeFor(r).ListAnnouncements(r.Context(), current, time.Now())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (s *Server) handleCreateAnnouncement(w http.ResponseWriter, r *http.Request) {
+	var payload announcementPayload
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var a store.Announcement
+	payload.apply(&a)
+	created, err := s.storeFor(r).CreateAnnouncement(r.Context(), a)
+	if err != nil {
+		writeAnnouncementError(w, err)
+		return
+	}
+	s.pushAnnouncements(r.Context())
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) handleGetAnnouncement(w http.ResponseWriter, r *http.Request) {
+	a, err := s.announcement(r)
+	if err != nil {
+		writeAnnouncementError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+func (s *Server) handleUpdateAnnouncement(w http.ResponseWriter, r *http.Request) {
+	a, err := s.announcement(r)
+	if err != nil {
+		writeAnnouncementError(w, err)
+		return
+	}
+	var payload announcementPayload
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	payload.apply(&a)
+	updated, err := s.storeFor(r).UpdateAnnouncement(r.Context(), a)
+	if err != nil {
+		writeAnnouncementError(w, err)
+		return
+	}
+	s.pushAnnouncements(r.Context())
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (s *Server) handleDeleteAnnouncement(w http.ResponseWriter, r *http.Request) {
+	a, err := s.announcement(r)
+	if err != nil {
+		writeAnnouncementError(w, err)
+		return
+	}
+	if err := s.storeFor(r).DeleteAnnouncement(r.Context(), a.ID); err != nil {
+		writeAnnouncementError(w, err)
+		return
+	}
+	s.pushAnnouncements(r.Context())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pushAnnouncements tells every paired display to fetch its announcements
+// again. There are only a handful of screens, so they all get it regardless
+// of targets.
+func (s *Server) pushAnnouncements(ctx context.Context) {
+	displays, err := s.store.ListDisplays(ctx)
+	if err != nil {
+		s.logger.Printf("announcements: list displays failed: %v\n", err)
+		return
+	}
+	for _, d := range displays {
+		if d.Status != store.DisplayApproved {
+			continue
+		}
+		if _, err := s.store.QueueDisplayCommand(ctx, store.DisplayCommand{DisplayID: d.ID, Type: store.DisplayAnnouncements}); err != nil {
+			s.logger.Printf("display %s: queue announcements command failed: %v\n", d.ID, err)
+			continue
+		}
+		s.displays.notify(d.ID)
+	}
+}
+
+// announcement loads the announcement named in the URL from the request's
+// event.
+func (s *Server) announcement(r *http.Request) (store.Announcement, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "announcementID"), 10, 64)
+	if err != nil {
+		return store.Announcement{}, sql.ErrNoRows
+	}
+	return s.storeFor(r).GetAnnouncement(r.Context(), id)
+}
+
+func writeAnnouncementError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, errors.New("announcement not found"))
+		return
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
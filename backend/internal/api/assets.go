@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/adopting-bitcoin/dashboard/internal/store"
+)
+
+// maxImageSize and maxMediaSize cap uploads. Both are larger than
+// maxBodySize, which is meant for JSON.
+const (
+	maxImageSize = 5 << 20
+	maxMediaSize = 20 << 20 // sounds and clips
+)
+
+// assetTypes are the formats slides and milestone celebrations can use,
+// with their size limits. SVG is left out since it can carry scripts.
+var assetTypes = map[string]int{
+	"image/png":  maxImageSize,
+	"image/jpeg": maxImageSize,
+	"image/gif":  maxImageSize,
+	"image/webp": maxImageSize,
+	"audio/mpeg": maxMediaSize,
+	"audio/wave": maxMediaSize,
+	"video/mp4":  maxMediaSize,
+	"video/webm": maxMediaSize,
+}
+
+// handleUploadAsset stores the image, sound or clip in the multipart "file"
+// field. The content type is sniffed rather than taken from the client.
+func (s *Server) handleUploadAsset(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxMediaSize+64<<10) // room for the multipart envelope
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("file is required: %w", err))
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxMediaSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	contentType := http.DetectContentType(data)
+	limit, ok := assetTypes[contentType]
+	if !ok {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported file type %s", contentType))
+		return
+	}
+	if len(data) > limit {
+		kind, _, _ := strings.Cut(contentType, "/")
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("%s files are limited to %d MB", kind, limit>>20))
+		return
+	}
+	asset, err := s.store.SaveAsset(r.Context(), header.Filename, contentType, data)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, asset)
+}
+
+func (s *Server) handleListAssets(w http.ResponseWriter, r *http.Request) {
+	items, err := s.store.ListAssets(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (s *Server) handleDeleteAsset(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "assetID")
+	err := s.store.DeleteAsset(r.Context(), id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, errors.New("asset not found"))
+	case errors.Is(err, store.ErrAssetInUse):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		// Only existing assets get here, so id is a hash and safe as a file name
+		if err := os.Remove(filepath.Join(s.cfg.AssetCacheDir, id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			s.logger.Printf("asset %s: remove cached copy failed: %v\n", id, err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleGetAsset serves an asset, with range requests so clips can be
+// seeked. Ids are content hashes, so responses can be cached forever.
+func (s *Server) handleGetAsset(w http.ResponseWriter, r *http.Request) {
+	asset, err := s.store.GetAsset(r.Context(), chi.URLParam(r, "assetID"))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, errors.New("asset not found"))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	f, err := s.assetFile(r.Context(), asset.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("ETag", `"`+asset.ID+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Content-Type", asset.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
+
+// assetFile opens the cached copy of an asset, copying it out of the
+// database on first use so requests stream from disk instead of loading the
+// whole blob. Ids are content hashes, so a cached file never goes stale.
+func (s *Server) assetFile(ctx context.Context, id string) (*os.File, error) {
+	path := filepath.Join(s.cfg.AssetCacheDir, id)
+	if f, err := os.Open(path); err == nil {
+		return f, nil
+	}
+	_, data, err := s.store.AssetData(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.cfg.AssetCacheDir, 0o755); err != nil {
+		return nil, err
+	}
+	// Write under a temporary name so concurrent requests never see a
+	// partial file
+	tmp, err := os.CreateTemp(s.cfg.AssetCacheDir, id+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// handleSlideAirtime reports how often and how long each content slide was
+// on screen, optionally between from and to (RFC 3339).
+func (s *Server) handleSlideAirtime(w http.ResponseWriter, r *http.Request) {
+	var from, to time.Time
+	for key, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		val := r.URL.Query().Get(key)
+		if val == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: use RFC 3339", key))
+			return
+		}
+		*dst = t
+	}
+	items, err := s.storeFor(r).SlideReport(r.Context(), from, to)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adopting-bitcoin/dashboard/internal/store"
+)
+
+const (
+	// gzipMinSize is the smallest body worth compressing.
+	gzipMinSize = 1024
+	// maxCacheEntries bounds the number of distinct query keys held per data version.
+	maxCacheEntries = 256
+	// clockResolution is how long a response that depends on the clock, such
+	// as a rate over the last few minutes, is served unchanged.
+	clockResolution = 15 * time.Second
+)
+
+// responseCache memoises encoded public responses per request key until the
+// store's data version changes.
+type responseCache struct {
+	mu      sync.Mutex
+	seq     uint64
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	contentType string
+	body        []byte
+	gzipped     []byte    // nil when the body is too small to compress
+	expires     time.Time // zero when only a data change invalidates it
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[string]cacheEntry)}
+}
+
+func (c *responseCache) get(key string, seq uint64, now time.Time) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seq != seq {
+		return cacheEntry{}, false
+	}
+	entry, ok := c.entries[key]
+	if ok && !entry.expires.IsZero() && !now.Before(entry.expires) {
+		return cacheEntry{}, false
+	}
+	return entry, ok
+}
+
+func (c *responseCache) put(key string, seq uint64, now time.Time, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if seq < c.seq {
+		// A newer version has already been observed; this entry is stale.
+		return
+	}
+	if seq > c.seq {
+		c.seq = seq
+		c.entries = make(map[string]cacheEntry)
+	}
+	if len(c.entries) >= maxCacheEntries {
+		// Make room by dropping entries whose clock period has passed
+		for k, e := range c.entries {
+			if !e.expires.IsZero() && !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			return
+		}
+	}
+	c.entries[key] = entry
+}
+
+// cached wraps a public GET handler with conditional request handling,
+// in-process response caching keyed by path and query, and gzip encoding.
+// Entries are tagged with the data version read before the handler runs, so
+// a cached body is never older than the version it is served under.
+func (s *Server) cached(next http.HandlerFunc) http.HandlerFunc {
+	return s.cachedFor(0, next)
+}
+
+// cachedClock is cached for handlers whose response also depends on the
+// clock, such as windows ending now. Responses are also versioned by
+// clockResolution period, so a rate falls back to zero without new data.
+func (s *Server) cachedClock(next http.HandlerFunc) http.HandlerFunc {
+	return s.cachedFor(clockResolution, next)
+}
+
+// cachedFor implements cached; a non-zero resolution adds the current clock
+// period to the version.
+func (s *Server) cachedFor(resolution time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version := s.store.DataVersion()
+		modified := version.ModifiedAt.Truncate(time.Second)
+		now := time.Now()
+		var period, expires time.Time
+		if resolution > 0 {
+			period = now.Truncate(resolution)
+			expires = period.Add(resolution)
+			if period.After(modified) {
+				modified = period.Truncate(time.Second)
+			}
+		}
+		etag := versionETag(version, period)
+
+		h := w.Header()
+		h.Set("ETag", etag)
+		h.Set("Last-Modified", modified.Format(http.TimeFormat))
+		h.Set("Cache-Control", "no-cache")
+		h.Add("Vary", "Accept-Encoding")
+
+		if notModified(r, etag, modified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		key := r.URL.Path + "?" + r.URL.Query().Encode()
+		entry, ok := s.cache.get(key, version.Seq, now)
+		if !ok {
+			rec := newBufferedResponse()
+			next(rec, r)
+			if rec.status != http.StatusOK {
+				h.Del("ETag")
+				h.Del("Last-Modified")
+				rec.flushTo(w)
+				return
+			}
+			entry = cacheEntry{
+				contentType: rec.header.Get("Content-Type"),
+				body:        rec.body.Bytes(),
+				expires:     expires,
+			}
+			if len(entry.body) >= gzipMinSize {
+				gz, err := gzipBytes(entry.body)
+				if err != nil {
+					s.logger.Printf("gzip response: %v\n", err)
+				} else {
+					entry.gzipped = gz
+				}
+			}
+			s.cache.put(key, version.Seq, now, entry)
+		}
+
+		h.Set("Content-Type", entry.contentType)
+		body := entry.body
+		if entry.gzipped != nil && acceptsGzip(r) {
+			h.Set("Content-Encoding", "gzip")
+			body = entry.gzipped
+		}
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(body)
+	}
+}
+
+// versionETag tags a data version, and the clock period for responses that
+// depend on one.
+func versionETag(v store.DataVersion, period time.Time) string {
+	// Weak because the same version may be served gzipped or identity-encoded.
+	if period.IsZero() {
+		return fmt.Sprintf(`W/"%x-%x"`, v.Boot, v.Seq)
+	}
+	return fmt.Sprintf(`W/"%x-%x-%x"`, v.Boot, v.Seq, period.Unix())
+}
+
+// notModified evaluates If-None-Match, falling back to If-Modified-Since as
+// described in RFC 9110 section 13.2.2.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		if err == nil && !modified.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// bufferedResponse captures a handler's output so it can be cached.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *bufferedResponse) WriteHeader(status int) { b.status = status }
+
+func (b *bufferedResponse) flushTo(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(b.status)
+	_, _ = w.Write(b.body.Bytes())
+}
@@ -0,0 +1,341 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/adopting-bitcoin/dashboard/internal/store"
+)
+
+const (
+	// displayHeartbeatInterval is how often displays are asked to check in.
+	displayHeartbeatInterval = 30 * time.Second
+	// displayOfflineAfter is how long a display can miss heartbeats before
+	// it is listed as offline.
+	displayOfflineAfter = 3 * displayHeartbeatInterval
+	// displayCommandTTL drops commands a display didn't pick up in time, so
+	// a screen coming back online doesn't replay old reloads and messages.
+	displayCommandTTL = 5 * time.Minute
+	// displayKeepAlive keeps idle command streams open through proxies.
+	displayKeepAlive = 25 * time.Second
+)
+
+// displayHub wakes command streams when something is queued for their
+// display.
+type displayHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan struct{}]struct{}
+}
+
+func newDisplayHub() *displayHub {
+	return &displayHub{subs: make(map[string]map[chan struct{}]struct{})}
+}
+
+func (h *displayHub) subscribe(displayID string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	h.mu.Lock()
+	if h.subs[displayID] == nil {
+		h.subs[displayID] = make(map[chan struct{}]struct{})
+	}
+	h.subs[displayID][ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs[displayID], ch)
+		if len(h.subs[displayID]) == 0 {
+			delete(h.subs, displayID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+func (h *displayHub) notify(displayID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[displayID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (h *displayHub) connected(displayID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs[displayID]) > 0
+}
+
+// displayStatus is a display as admins see it.
+type displayStatus struct {
+	store.Display
+	Online    bool `json:"online"`    // heartbeat within displayOfflineAfter
+	Connected bool `json:"connected"` // command stream open
+}
+
+func (s *Server) displayStatus(d store.Display, now time.Time) displayStatus {
+	return displayStatus{
+		Display:   d,
+		Online:    d.LastSeenAt != nil && now.Sub(*d.LastSeenAt) < displayOfflineAfter,
+		Connected: s.displays.connected(d.ID),
+	}
+}
+
+// displayResponse is what a display gets back from registering and
+// heartbeats.
+type displayResponse struct {
+	store.Display
+	Token                    string `json:"token,omitempty"` // only on registration
+	HeartbeatIntervalSeconds int64  `json:"heartbeat_interval_seconds"`
+}
+
+func newDisplayResponse(d store.Display, token string) displayResponse {
+	return displayResponse{Display: d, Token: token, HeartbeatIntervalSeconds: int64(displayHeartbeatInterval.Seconds())}
+}
+
+func (s *Server) handleRegisterDisplay(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name       string `json:"name"`
+		AppVersion string `json:"app_version"`
+	}
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	d, token, err := s.store.RegisterDisplay(r.Context(), payload.Name, payload.AppVersion)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newDisplayResponse(d, token))
+}
+
+// displayAuth identifies the display by the token it got at registration.
+// Displays whose token is rejected should register again.
+func (s *Server) displayAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, err := s.store.DisplayByToken(r.Context(), extractToken(r))
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusUnauthorized, errors.New("unknown display"))
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), displayCtxKey, d)))
+	})
+}
+
+func displayFrom(r *http.Request) store.Display {
+	d, _ := r.Context().Value(displayCtxKey).(store.Display)
+	return d
+}
+
+// handleDisplayHeartbeat also takes the content slides shown since the last
+// heartbeat, counted towards sponsor airtime in the display's event.
+func (s *Server) handleDisplayHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Scene       string                  `json:"scene"`
+		AppVersion  string                  `json:"app_version"`
+		Impressions []store.SlideImpression `json:"impressions"`
+	}
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	d := displayFrom(r)
+	if err := s.store.RecordDisplayHeartbeat(r.Context(), d.ID, payload.Scene, payload.AppVersion, time.Now()); err != nil {
+		writeDisplayError(w, err)
+		return
+	}
+	if len(payload.Impressions) > 0 && d.Status == store.DisplayApproved {
+		pl, err := s.displayPlaylist(r.Context(), d)
+		if err == nil {
+			_, err = s.store.ForEvent(pl.EventID).RecordSlideImpressions(r.Context(), d.ID, payload.Impressions)
+		}
+		if err != nil {
+			// The heartbeat itself went through; don't make the display look offline
+			s.logger.Printf("display %s: record impressions failed: %v\n", d.ID, err)
+		}
+	}
+	d, err := s.store.GetDisplay(r.Context(), d.ID)
+	if err != nil {
+		writeDisplayError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newDisplayResponse(d, ""))
+}
+
+// handleDisplayCommands streams queued commands to an approved display as
+// server-sent "command" events. Commands are marked delivered once they were
+// flushed to the display, so a stream that broke leaves them queued for the
+// next one. The stream ends when the display is removed.
+func (s *Server) handleDisplayCommands(w http.ResponseWriter, r *http.Request) {
+	if _, ok := w.(http.Flusher); !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+	d := displayFrom(r)
+	if d.Status != store.DisplayApproved {
+		writeError(w, http.StatusForbidden, store.ErrDisplayNotApproved)
+		return
+	}
+	wake, unsubscribe := s.displays.subscribe(d.ID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ctx := r.Context()
+	keepAlive := time.NewTicker(displayKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		cmds, err := s.store.PendingDisplayCommands(ctx, d.ID, time.Now().Add(-displayCommandTTL))
+		if err != nil {
+			s.logger.Printf("display %s: load commands failed: %v\n", d.ID, err)
+		}
+		for _, cmd := range cmds {
+			data, _ := json.Marshal(cmd)
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: command\ndata: %s\n\n", cmd.ID, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		for _, cmd := range cmds {
+			if err := s.store.MarkDisplayCommandDelivered(ctx, cmd.ID, time.Now()); err != nil {
+				s.logger.Printf("display %s: mark command %d delivered failed: %v\n", d.ID, cmd.ID, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-wake:
+			if _, err := s.store.GetDisplay(ctx, d.ID); errors.Is(err, sql.ErrNoRows) {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) handleListDisplays(w http.ResponseWriter, r *http.Request) {
+	displays, err := s.store.ListDisplays(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	now := time.Now()
+	items := make([]displayStatus, 0, len(displays))
+	for _, d := range displays {
+		items = append(items, s.displayStatus(d, now))
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (s *Server) handleApproveDisplay(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		PairingCode string `json:"pairing_code"`
+		Name        string `json:"name"`
+	}
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	d, err := s.store.ApproveDisplay(r.Context(), payload.PairingCode, payload.Name)
+	if err != nil {
+		writeDisplayError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.displayStatus(d, time.Now()))
+}
+
+// handleUpdateDisplay renames a display and assigns its playlist; a
+// playlist_id of 0 goes back to the default rotation. Omitted fields are
+// left alone.
+func (s *Server) handleUpdateDisplay(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name       *string `json:"name"`
+		PlaylistID *int64  `json:"playlist_id"`
+	}
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	id := chi.URLParam(r, "displayID")
+	if payload.Name != nil {
+		if err := s.store.RenameDisplay(r.Context(), id, *payload.Name); err != nil {
+			writeDisplayError(w, err)
+			return
+		}
+	}
+	if payload.PlaylistID != nil {
+		if err := s.store.AssignDisplayPlaylist(r.Context(), id, *payload.PlaylistID); err != nil {
+			writeDisplayError(w, err)
+			return
+		}
+		s.sendPlaylistCommand(r.Context(), id)
+	}
+	d, err := s.store.GetDisplay(r.Context(), id)
+	if err != nil {
+		writeDisplayError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.displayStatus(d, time.Now()))
+}
+
+func (s *Server) handleDeleteDisplay(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "displayID")
+	if err := s.store.DeleteDisplay(r.Context(), id); err != nil {
+		writeDisplayError(w, err)
+		return
+	}
+	// Wake any open stream so it notices the display is gone
+	s.displays.notify(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleSendDisplayCommand(w http.ResponseWriter, r *http.Request) {
+	var cmd store.DisplayCommand
+	if err := decodeJSON(w, r, &cmd); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	cmd.DisplayID = chi.URLParam(r, "displayID")
+	cmd, err := s.store.QueueDisplayCommand(r.Context(), cmd)
+	if err != nil {
+		writeDisplayError(w, err)
+		return
+	}
+	s.displays.notify(cmd.DisplayID)
+	writeJSON(w, http.StatusAccepted, cmd)
+}
+
+func writeDisplayError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, errors.New("display not found"))
+	case errors.Is(err, store.ErrDisplayNotApproved):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
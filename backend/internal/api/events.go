@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/adopting-bitcoin/dashboard/internal/store"
+)
+
+type ctxKey int
+
+const (
+	storeCtxKey ctxKey = iota
+	displayCtxKey
+)
+
+// storeFor returns the store scoped to the request's event.
+func (s *Server) storeFor(r *http.Request) *store.Store {
+	if st, ok := r.Context().Value(storeCtxKey).(*store.Store); ok {
+		return st
+	}
+	return s.store
+}
+
+func withStore(r *http.Request, st *store.Store) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), storeCtxKey, st))
+}
+
+// defaultEventScope pins every request to the default event as of the start
+// of the request, so an event switch can't split one request across events.
+func (s *Server) defaultEventScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, withStore(r, s.store.ForEvent(s.store.EventID())))
+	})
+}
+
+// eventScope scopes public routes to the event named in the URL.
+func (s *Server) eventScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.scopeToSlug(w, r, chi.URLParam(r, "eventSlug"), next)
+	})
+}
+
+// adminEventScope lets admin requests target a non-default event via the
+// X-Event header or the event query parameter.
+func (s *Server) adminEventScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slug := r.Header.Get("X-Event")
+		if slug == "" {
+			slug = r.URL.Query().Get("event")
+		}
+		if slug == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.scopeToSlug(w, r, slug, next)
+	})
+}
+
+func (s *Server) scopeToSlug(w http.ResponseWriter, r *http.Request, slug string, next http.Handler) {
+	event, err := s.store.GetEventBySlug(r.Context(), slug)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, errors.New("event not found"))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	next.ServeHTTP(w, withStore(r, s.store.ForEvent(event.ID)))
+}
+
+type eventPayload struct {
+	Slug                 string     `json:"slug"`
+	Name                 string     `json:"name"`
+	StartsAt             *time.Time `json:"starts_at"`
+	EndsAt               *time.Time `json:"ends_at"`
+	WifiLightningAddress *string    `json:"wifi_lightning_address"`
+}
+
+func (p eventPayload) event() store.Event {
+	e := store.Event{
+		Slug:     p.Slug,
+		Name:     p.Name,
+		StartsAt: p.StartsAt,
+		EndsAt:   p.EndsAt,
+	}
+	if p.WifiLightningAddress != nil {
+		e.WifiLightningAddress = *p.WifiLightningAddress
+	}
+	return e
+}
+
+func (s *Server) handleListEvents(w http.ResponseWriter, r *http.Request) {
+	events, err := s.store.ListEvents(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (s *Server) handleCreateEvent(w http.ResponseWriter, r *http.Request) {
+	var payload eventPayload
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if payload.Slug == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing fields"))
+		return
+	}
+	event, err := s.store.CreateEvent(r.Context(), payload.event())
+	if err != nil {
+		writeEventError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, event)
+}
+
+func (s *Server) handleUpdateEvent(w http.ResponseWriter, r *http.Request) {
+	var payload eventPayload
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	current, err := s.store.GetEventBySlug(r.Context(), chi.URLParam(r, "eventSlug"))
+	if err != nil {
+		writeEventError(w, err)
+		return
+	}
+	if payload.Name != "" {
+		current.Name = payload.Name
+	}
+	if payload.StartsAt != nil {
+		current.StartsAt = payload.StartsAt
+	}
+	if payload.EndsAt != nil {
+		current.EndsAt = payload.EndsAt
+	}
+	if payload.WifiLightningAddress != nil {
+		current.WifiLightningAddress = *payload.WifiLightningAddress
+	}
+	updated, err := s.store.UpdateEvent(r.Context(), current)
+	if err != nil {
+		writeEventError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (s *Server) handleActivateEvent(w http.ResponseWriter, r *http.Request) {
+	event, err := s.store.ActivateEvent(r.Context(), chi.URLParam(r, "eventSlug"))
+	if err != nil {
+		writeEventError(w, err)
+		return
+	}
+	s.logger.Printf("default event switched to %s\n", event.Slug)
+	writeJSON(w, http.StatusOK, event)
+}
+
+func (s *Server) handleCloneEvent(w http.ResponseWriter, r *http.Request) {
+	var payload eventPayload
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if payload.Slug == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing fields"))
+		return
+	}
+	event, err := s.store.CloneEvent(r.Context(), chi.URLParam(r, "eventSlug"), payload.event())
+	if err != nil {
+		writeEventError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, event)
+}
+
+func writeEventError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, errors.New("event not found"))
+	case errors.Is(err, store.ErrEventExists):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adopting-bitcoin/dashboard/internal/ingest"
+	"github.com/adopting-bitcoin/dashboard/internal/store"
+)
+
+// importRow is one merchant from an import file along with its validation
+// outcome. Row numbers are 1-based and count data rows only.
+type importRow struct {
+	Row       int                 `json:"row"`
+	ID        string              `json:"id"`
+	PublicKey string              `json:"public_key"`
+	Alias     string              `json:"alias"`
+	Enabled   bool                `json:"enabled"`
+	Exists    bool                `json:"exists"`
+	Valid     bool                `json:"valid"`
+	Error     string              `json:"error,omitempty"`
+	Warning   string              `json:"warning,omitempty"` // the row could not be checked upstream
+	Upstream  *ingest.ProbeResult `json:"upstream,omitempty"`
+}
+
+type importResponse struct {
+	DryRun  bool        `json:"dry_run"`
+	Valid   bool        `json:"valid"`
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Rows    []importRow `json:"rows"`
+	Error   string      `json:"error,omitempty"`
+}
+
+func (s *Server) handleImportMerchants(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	var (
+		rows []importRow
+		err  error
+	)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		rows, err = parseImportCSV(r.Body)
+	} else {
+		rows, err = parseImportJSON(r.Body)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(rows) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("no merchants to import"))
+		return
+	}
+
+	st := s.storeFor(r)
+	existing, err := st.ListMerchants(r.Context(), false)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	known := make(map[string]bool, len(existing))
+	for _, m := range existing {
+		known[m.ID] = true
+	}
+
+	resp := importResponse{DryRun: r.URL.Query().Get("dry_run") == "true", Valid: true, Rows: rows}
+	seen := make(map[string]int, len(rows))
+	for i := range rows {
+		row := &rows[i]
+		row.Exists = known[row.ID]
+		switch {
+		case row.Error != "":
+		case row.ID == "" || row.PublicKey == "" || row.Alias == "":
+			row.Error = "missing fields"
+		case seen[row.ID] != 0:
+			row.Error = fmt.Sprintf("duplicate of row %d", seen[row.ID])
+		}
+		if row.ID != "" && seen[row.ID] == 0 {
+			seen[row.ID] = row.Row
+		}
+		row.Valid = row.Error == ""
+		if !row.Valid {
+			resp.Valid = false
+		}
+	}
+
+	if resp.DryRun {
+		s.probeImport(r, rows)
+		for _, row := range rows {
+			if !row.Valid {
+				resp.Valid = false
+			}
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	if !resp.Valid {
+		resp.Error = "import contains invalid rows; nothing was imported"
+		writeJSON(w, http.StatusBadRequest, resp)
+		return
+	}
+	merchants := make([]store.Merchant, len(rows))
+	for i, row := range rows {
+		merchants[i] = store.Merchant{
+			ID:        row.ID,
+			PublicKey: row.PublicKey,
+			Alias:     row.Alias,
+			Enabled:   row.Enabled,
+		}
+	}
+	created, err := st.ImportMerchants(r.Context(), merchants)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp.Created = created
+	resp.Updated = len(merchants) - created
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// probeImport checks every valid row against the upstream API. Rows the
+// upstream rejects as a bad request, unauthorized, forbidden or unknown are
+// invalid; rows it couldn't answer for, because of a timeout, network error,
+// rate limit or server error, keep their validity and get a warning instead.
+func (s *Server) probeImport(r *http.Request, rows []importRow) {
+	var (
+		merchants []store.Merchant
+		index     []int
+	)
+	for i, row := range rows {
+		if !row.Valid {
+			continue
+		}
+		merchants = append(merchants, store.Merchant{ID: row.ID, PublicKey: row.PublicKey})
+		index = append(index, i)
+	}
+	for i, result := range s.poller.Probe(r.Context(), merchants) {
+		row := &rows[index[i]]
+		row.Upstream = &result
+		switch {
+		case result.AuthOK:
+		case upstreamRejected(result.Status):
+			row.Valid = false
+			row.Error = "upstream rejected merchant"
+		default:
+			row.Warning = "upstream unavailable"
+		}
+	}
+}
+
+// upstreamRejected reports whether an upstream status says the merchant's
+// key is wrong, as opposed to the request failing for another reason.
+func upstreamRejected(status int) bool {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return true
+	}
+	return false
+}
+
+func parseImportJSON(body io.Reader) ([]importRow, error) {
+	var payload []struct {
+		ID        string `json:"id"`
+		PublicKey string `json:"public_key"`
+		Alias     string `json:"alias"`
+		Enabled   *bool  `json:"enabled"`
+	}
+	if err := json.NewDecoder(body).Decode(&payload); err != nil {
+		return nil, err
+	}
+	rows := make([]importRow, len(payload))
+	for i, p := range payload {
+		rows[i] = importRow{
+			Row:       i + 1,
+			ID:        strings.TrimSpace(p.ID),
+			PublicKey: strings.TrimSpace(p.PublicKey),
+			Alias:     strings.TrimSpace(p.Alias),
+			Enabled:   p.Enabled == nil || *p.Enabled,
+		}
+	}
+	return rows, nil
+}
+
+// parseImportCSV reads id, public_key, alias and an optional enabled column.
+// A header row naming those columns may be given in any order; it is
+// recognised by every cell naming one of them, so a data row that happens to
+// contain "alias" isn't mistaken for it. Without one, columns are read
+// positionally.
+func parseImportCSV(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{"id": 0, "public_key": 1, "alias": 2, "enabled": 3}
+	header := true
+	for _, name := range records[0] {
+		if _, ok := columns[strings.ToLower(strings.TrimSpace(name))]; !ok {
+			header = false
+		}
+	}
+	if header {
+		columns = map[string]int{"enabled": -1}
+		for i, name := range records[0] {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		for _, name := range []string{"id", "public_key", "alias"} {
+			if _, ok := columns[name]; !ok {
+				return nil, fmt.Errorf("csv header missing %q column", name)
+			}
+		}
+		records = records[1:]
+	}
+
+	field := func(record []string, name string) string {
+		i := columns[name]
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	rows := make([]importRow, 0, len(records))
+	for i, record := range records {
+		row := importRow{
+			Row:       i + 1,
+			ID:        field(record, "id"),
+			PublicKey: field(record, "public_key"),
+			Alias:     field(record, "alias"),
+			Enabled:   true,
+		}
+		if raw := field(record, "enabled"); raw != "" {
+			enabled, err := strconv.ParseBool(raw)
+			if err != nil {
+				row.Error = fmt.Sprintf("invalid enabled value %q", raw)
+			}
+			row.Enabled = enabled
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
@@ -0,0 +1,291 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/adopting-bitcoin/dashboard/internal/store"
+)
+
+// optionalTime is a window bound in a milestone payload: omitted keeps the
+// current value and null clears it.
+type optionalTime struct {
+	set   bool
+	value *time.Time
+}
+
+func (o *optionalTime) UnmarshalJSON(data []byte) error {
+	o.set = true
+	return json.Unmarshal(data, &o.value)
+}
+
+func (o optionalTime) apply(dst **time.Time) {
+	if o.set {
+		*dst = o.value
+	}
+}
+
+// milestonePayload is the body of milestone creates and updates. Omitted
+// fields keep their current value on update; params and celebration replace
+// the whole set.
+type milestonePayload struct {
+	Name         *string                     `json:"name"`
+	Type         *store.MilestoneType        `json:"type"`
+	Params       *store.MilestoneParams      `json:"params"`
+	Threshold    *int64                      `json:"threshold"`
+	Recurring    *bool                       `json:"recurring"`
+	MaxOrdinal   *int64                      `json:"max_ordinal"`
+	ActiveFrom   optionalTime                `json:"active_from"`
+	ActiveUntil  optionalTime                `json:"active_until"`
+	MeasureFrom  optionalTime                `json:"measure_from"`
+	MeasureUntil optionalTime                `json:"measure_until"`
+	Celebration  *store.MilestoneCelebration `json:"celebration"`
+	Enabled      *bool                       `json:"enabled"`
+	ResetTrigger bool                        `json:"reset_trigger"` // updates only: re-arm a triggered milestone
+}
+
+func (p milestonePayload) apply(m *store.Milestone) {
+	if p.Name != nil {
+		m.Name = *p.Name
+	}
+	if p.Type != nil {
+		m.Type = *p.Type
+	}
+	if p.Params != nil {
+		m.Params = *p.Params
+	}
+	if p.Threshold != nil {
+		m.Threshold = *p.Threshold
+	}
+	if p.Recurring != nil {
+		m.Recurring = *p.Recurring
+	}
+	if p.MaxOrdinal != nil {
+		m.MaxOrdinal = *p.MaxOrdinal
+	}
+	p.ActiveFrom.apply(&m.ActiveFrom)
+	p.ActiveUntil.apply(&m.ActiveUntil)
+	p.MeasureFrom.apply(&m.MeasureFrom)
+	p.MeasureUntil.apply(&m.MeasureUntil)
+	if p.Celebration != nil {
+		m.Celebration = *p.Celebration
+	}
+	if p.Enabled != nil {
+		m.Enabled = *p.Enabled
+	}
+}
+
+// handleListMilestones lists the event's milestones; archived=true includes
+// archived ones.
+func (s *Server) handleListMilestones(w http.ResponseWriter, r *http.Request) {
+	archived := r.URL.Query().Get("archived") == "true"
+	items, err := s.storeFor(r).ListMilestones(r.Context(), archived)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// handleCreateMilestone adds a milestone, enabled unless the body says
+// otherwise.
+func (s *Server) handleCreateMilestone(w http.ResponseWriter, r *http.Request) {
+	var payload milestonePayload
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	m := store.Milestone{Enabled: true}
+	payload.apply(&m)
+	if m.Name == "" || m.Type == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing fields"))
+		return
+	}
+	created, err := s.storeFor(r).UpsertMilestone(r.Context(), m)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// handlePreviewMilestone validates a milestone definition and reports its
+// current value without saving it, so conditions can be checked while they
+// are written.
+func (s *Server) handlePreviewMilestone(w http.ResponseWriter, r *http.Request) {
+	var payload milestonePayload
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var m store.Milestone
+	payload.apply(&m)
+	preview, err := s.storeFor(r).PreviewMilestone(r.Context(), m)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, preview)
+}
+
+// handleSimulateMilestones replays the selected event's transactions
+// against candidate milestones, none of which need to be saved, to show
+// when each would have fired.
+func (s *Server) handleSimulateMilestones(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Milestones []milestonePayload `json:"milestones"`
+	}
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	candidates := make([]store.Milestone, len(payload.Milestones))
+	for i, p := range payload.Milestones {
+		p.apply(&candidates[i])
+	}
+	sim, err := s.storeFor(r).SimulateMilestones(r.Context(), candidates)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sim)
+}
+
+// handleConditionMetrics lists the metrics expression conditions can use.
+func (s *Server) handleConditionMetrics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, store.ConditionMetricHelp())
+}
+
+func (s *Server) handleGetMilestone(w http.ResponseWriter, r *http.Request) {
+	m, err := s.milestone(r)
+	if err != nil {
+		writeMilestoneError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// handleUpdateMilestone serves both PUT and PATCH: only the fields in the
+// body change.
+func (s *Server) handleUpdateMilestone(w http.ResponseWriter, r *http.Request) {
+	m, err := s.milestone(r)
+	if err != nil {
+		writeMilestoneError(w, err)
+		return
+	}
+	var payload milestonePayload
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	payload.apply(&m)
+	updated, err := s.storeFor(r).UpdateMilestone(r.Context(), m.ID, m, payload.ResetTrigger)
+	if err != nil {
+		writeMilestoneError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// handleDeleteMilestone removes a milestone, keeping its triggers in the
+// history.
+func (s *Server) handleDeleteMilestone(w http.ResponseWriter, r *http.Request) {
+	m, err := s.milestone(r)
+	if err != nil {
+		writeMilestoneError(w, err)
+		return
+	}
+	if err := s.storeFor(r).DeleteMilestone(r.Context(), m.ID); err != nil {
+		writeMilestoneError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleArchiveMilestone(w http.ResponseWriter, r *http.Request) {
+	s.archiveMilestone(w, r, true)
+}
+
+func (s *Server) handleUnarchiveMilestone(w http.ResponseWriter, r *http.Request) {
+	s.archiveMilestone(w, r, false)
+}
+
+func (s *Server) archiveMilestone(w http.ResponseWriter, r *http.Request, archived bool) {
+	m, err := s.milestone(r)
+	if err != nil {
+		writeMilestoneError(w, err)
+		return
+	}
+	updated, err := s.storeFor(r).ArchiveMilestone(r.Context(), m.ID, archived)
+	if err != nil {
+		writeMilestoneError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// handleDuplicateMilestone copies a milestone, disabled, under the name in
+// the optional body.
+func (s *Server) handleDuplicateMilestone(w http.ResponseWriter, r *http.Request) {
+	m, err := s.milestone(r)
+	if err != nil {
+		writeMilestoneError(w, err)
+		return
+	}
+	var payload struct {
+		Name string `json:"name"`
+	}
+	if err := decodeJSON(w, r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	created, err := s.storeFor(r).DuplicateMilestone(r.Context(), m.ID, payload.Name)
+	if err != nil {
+		writeMilestoneError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) handleListMilestoneTemplates(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, store.MilestoneTemplates())
+}
+
+// handleImportMilestoneTemplate sets up a template's milestones in the
+// request's event, skipping names it already has.
+func (s *Server) handleImportMilestoneTemplate(w http.ResponseWriter, r *http.Request) {
+	created, err := s.storeFor(r).ImportMilestoneTemplate(r.Context(), chi.URLParam(r, "templateID"))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, errors.New("template not found"))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// milestone loads the milestone named in the URL from the request's event.
+func (s *Server) milestone(r *http.Request) (store.Milestone, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "milestoneID"), 10, 64)
+	if err != nil {
+		return store.Milestone{}, sql.ErrNoRows
+	}
+	return s.storeFor(r).GetMilestone(r.Context(), id)
+}
+
+func writeMilestoneError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, errors.New("milestone not found"))
+		return
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/adopting-bitcoin/dashboard/internal/store"
+)
+
+// playlistPayload is the body of playlist creates and updates. Omitted
+// fields keep their current value on update; entries replace the whole list.
+type playlistPayload struct {
+	Name    *string                `json:"name"`
+	Entries *[]store.PlaylistEntry `json:"entries"`
+}
+
+func (p playlistPayload) apply(pl *store.Playlist) {
+	if p.Name != nil {
+		pl.Name = *p.Name
+	}
+	if p.Entries != nil {
+		pl.Entries = *p.Entries
+	}
+}
+
+func (s *Server) handleListPlaylists(w http.ResponseWriter, r *http.Request) {
+	items, err := s.storeFor(r).ListPlaylists(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+func (s *Server) handleCreatePlaylist(w http.ResponseWriter, r *http.Request) {
+	var payload playlistPayload
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	pl := store.Playlist{Entries: []store.PlaylistEntry{}}
+	payload.apply(&pl)
+	created, err := s.storeFor(r).CreatePlaylist(r.Context(), pl)
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) handleGetPlaylist(w http.ResponseWriter, r *http.Request) {
+	pl, err := s.playlist(r)
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pl)
+}
+
+func (s *Server) handleUpdatePlaylist(w http.ResponseWriter, r *http.Request) {
+	pl, err := s.playlist(r)
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	var payload playlistPayload
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	payload.apply(&pl)
+	updated, err := s.storeFor(r).UpdatePlaylist(r.Context(), pl)
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	s.notifyPlaylistDisplays(r.Context(), updated.ID)
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// handleReorderPlaylist applies a new entry order in one transaction, so a
+// display never sees a half-moved playlist.
+func (s *Server) handleReorderPlaylist(w http.ResponseWriter, r *http.Request) {
+	pl, err := s.playlist(r)
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	var payload struct {
+		EntryIDs []int64 `json:"entry_ids"`
+	}
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	updated, err := s.storeFor(r).ReorderPlaylist(r.Context(), pl.ID, payload.EntryIDs)
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	s.notifyPlaylistDisplays(r.Context(), updated.ID)
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (s *Server) handleDeletePlaylist(w http.ResponseWriter, r *http.Request) {
+	pl, err := s.playlist(r)
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	// Look the displays up first; deleting unassigns them
+	displays, err := s.store.PlaylistDisplays(r.Context(), pl.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := s.storeFor(r).DeletePlaylist(r.Context(), pl.ID); err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	for _, id := range displays {
+		s.sendPlaylistCommand(r.Context(), id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// notifyPlaylistDisplays tells every display playing a playlist to fetch it
+// again.
+func (s *Server) notifyPlaylistDisplays(ctx context.Context, playlistID int64) {
+	displays, err := s.store.PlaylistDisplays(ctx, playlistID)
+	if err != nil {
+		s.logger.Printf("playlist %d: list displays failed: %v\n", playlistID, err)
+		return
+	}
+	for _, id := range displays {
+		s.sendPlaylistCommand(ctx, id)
+	}
+}
+
+func (s *Server) sendPlaylistCommand(ctx context.Context, displayID string) {
+	_, err := s.store.QueueDisplayCommand(ctx, store.DisplayCommand{DisplayID: displayID, Type: store.DisplayPlaylist})
+	switch {
+	case err == nil:
+		s.displays.notify(displayID)
+	case !errors.Is(err, store.ErrDisplayNotApproved):
+		s.logger.Printf("display %s: queue playlist command failed: %v\n", displayID, err)
+	}
+}
+
+// displayPlaylistEntry is a playlist entry resolved for playback.
+type displayPlaylistEntry struct {
+	SceneID  string              `json:"scene_id"`
+	Name     string              `json:"name"`
+	Type     store.SceneType     `json:"type"`
+	Duration int64               `json:"duration"` // milliseconds
+	Params   store.SceneParams   `json:"params"`
+	Content  *store.SlideContent `json:"content,omitempty"`
+}
+
+func newDisplayPlaylistEntry(sc store.Scene, duration int64, params store.SceneParams) displayPlaylistEntry {
+	if duration == 0 {
+		duration = sc.Duration
+	}
+	return displayPlaylistEntry{SceneID: sc.ID, Name: sc.Name, Type: sc.Type, Duration: duration, Params: params, Content: sc.Content}
+}
+
+// displayPlaylist is what a display plays: its playlist, or the event's
+// default rotation when none is assigned (ID 0).
+type displayPlaylist struct {
+	ID      int64                  `json:"id"`
+	EventID int64                  `json:"event_id"`
+	Name    string                 `json:"name"`
+	Entries []displayPlaylistEntry `json:"entries"`
+}
+
+// handleDisplayPlaylist resolves the display's playlist against the current
+// scenes, filling in default durations and skipping disabled scenes and
+// content slides outside their schedule.
+func (s *Server) handleDisplayPlaylist(w http.ResponseWriter, r *http.Request) {
+	d := displayFrom(r)
+	if d.Status != store.DisplayApproved {
+		writeError(w, http.StatusForbidden, store.ErrDisplayNotApproved)
+		return
+	}
+	ctx := r.Context()
+	pl, err := s.displayPlaylist(ctx, d)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	scenes, err := s.store.ForEvent(pl.EventID).ListScenes(ctx, true)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	now := time.Now()
+	out := displayPlaylist{ID: pl.ID, EventID: pl.EventID, Name: pl.Name, Entries: make([]displayPlaylistEntry, 0)}
+	if pl.ID == 0 {
+		for _, sc := range scenes {
+			if sc.ActiveAt(now) {
+				out.Entries = append(out.Entries, newDisplayPlaylistEntry(sc, 0, store.SceneParams{}))
+			}
+		}
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+	active := make(map[string]store.Scene, len(scenes))
+	for _, sc := range scenes {
+		if sc.ActiveAt(now) {
+			active[sc.ID] = sc
+		}
+	}
+	for _, e := range pl.Entries {
+		if sc, ok := active[e.SceneID]; ok {
+			out.Entries = append(out.Entries, newDisplayPlaylistEntry(sc, e.Duration, e.Params))
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// displayPlaylist returns the playlist assigned to a display, or an empty
+// one (ID 0) in the default event when none is.
+func (s *Server) displayPlaylist(ctx context.Context, d store.Display) (store.Playlist, error) {
+	pl, err := s.store.DisplayPlaylist(ctx, d.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.Playlist{EventID: s.store.EventID(), Name: "Default rotation"}, nil
+	}
+	return pl, err
+}
+
+// playlist loads the playlist named in the URL from the request's event.
+func (s *Server) playlist(r *http.Request) (store.Playlist, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "playlistID"), 10, 64)
+	if err != nil {
+		return store.Playlist{}, sql.ErrNoRows
+	}
+	return s.storeFor(r).GetPlaylist(r.Context(), id)
+}
+
+func writePlaylistError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, errors.New("playlist not found"))
+		return
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRateLimitClients bounds the clients one limiter tracks; expired windows
+// are swept once it is reached.
+const maxRateLimitClients = 10000
+
+// rateLimiter allows each client a number of requests per fixed window.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, clients: make(map[string]*rateWindow)}
+}
+
+// allow counts a request from client and reports whether it is within the
+// limit, and if not, how long until the client's window resets.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w := l.clients[client]
+	if w == nil || !now.Before(w.start.Add(l.window)) {
+		if w == nil && len(l.clients) >= maxRateLimitClients {
+			for k, other := range l.clients {
+				if !now.Before(other.start.Add(l.window)) {
+					delete(l.clients, k)
+				}
+			}
+		}
+		w = &rateWindow{start: now}
+		l.clients[client] = w
+	}
+	if w.count >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+// rateLimited answers 429 once the requesting client has used up its
+// allowance on l. Clients are told apart by IP address, as set by the
+// realIP middleware.
+func (s *Server) rateLimited(l *rateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if ok, retry := l.allow(client, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, errors.New("too many requests, try again later"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// realIP replaces the request's remote address with the client address
+// reported by a trusted proxy in X-Forwarded-For or X-Real-IP. Requests that
+// don't come from TRUSTED_PROXIES keep their peer address, so clients can't
+// choose the address they are logged and rate limited under.
+func (s *Server) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if client := s.forwardedClient(r); client != "" {
+			r.RemoteAddr = client
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedClient returns the client address forwarded by a trusted proxy,
+// or "" if the peer isn't one or didn't forward an address. X-Forwarded-For
+// is read from the right, skipping the proxies' own hops, since everything
+// left of the first untrusted hop was written by the client.
+func (s *Server) forwardedClient(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if peer, err := netip.ParseAddr(host); err != nil || !s.trustedProxy(peer) {
+		return ""
+	}
+	if forwarded := strings.Join(r.Header.Values("X-Forwarded-For"), ","); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if addr, err := netip.ParseAddr(hop); err != nil || !s.trustedProxy(addr) {
+				return hop
+			}
+		}
+	}
+	return strings.TrimSpace(r.Header.Get("X-Real-IP"))
+}
+
+func (s *Server) trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range s.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
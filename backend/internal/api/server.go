@@ -9,8 +9,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -19,20 +22,59 @@ import (
 
 	"github.com/adopting-bitcoin/dashboard/internal/config"
 	"github.com/adopting-bitcoin/dashboard/internal/ingest"
+	"github.com/adopting-bitcoin/dashboard/internal/lnbits"
 	"github.com/adopting-bitcoin/dashboard/internal/store"
+	"github.com/adopting-bitcoin/dashboard/internal/voucher"
 )
 
 // Server wires HTTP handlers with storage and ingestion.
 type Server struct {
-	cfg    config.Config
+	cfg    config.Config // environment config; defaults for runtime settings
+	live   atomic.Pointer[config.Config]
 	store  *store.Store
 	poller *ingest.Poller
+	lnbits *lnbits.Client // nil unless LNBITS_URL is set
 	logger *log.Logger
+	cache  *responseCache
+
+	voucherHook voucher.Hook // nil unless VOUCHER_HOOK_URL is set or SetVoucherHook is called
+	displays    *displayHub
+
+	invoiceLimit   *rateLimiter   // POST /wifi/invoices per client
+	registerLimit  *rateLimiter   // POST /displays/register per client
+	trustedProxies []netip.Prefix // peers whose forwarded client address is believed
+
+	settingsMu sync.Mutex
 }
 
 // NewServer builds the HTTP server.
 func NewServer(cfg config.Config, st *store.Store, poller *ingest.Poller, logger *log.Logger) *Server {
-	return &Server{cfg: cfg, store: st, poller: poller, logger: logger}
+	s := &Server{cfg: cfg, store: st, poller: poller, logger: logger, cache: newResponseCache(), displays: newDisplayHub(),
+		invoiceLimit:  newRateLimiter(cfg.WifiInvoiceRateLimit, time.Minute),
+		registerLimit: newRateLimiter(cfg.DisplayRegisterLimit, time.Minute)}
+	// Validate rejects unparsable entries, so an error here leaves no
+	// proxy trusted
+	s.trustedProxies, _ = cfg.TrustedProxyPrefixes()
+	if cfg.LNbitsURL != "" {
+		s.lnbits = lnbits.New(cfg.LNbitsURL, cfg.LNbitsInvoiceKey, cfg.HTTPTimeout)
+	}
+	if cfg.VoucherHookURL != "" {
+		s.voucherHook = voucher.NewHTTPHook(cfg.VoucherHookURL, cfg.VoucherHookSecret, cfg.HTTPTimeout)
+	}
+	s.live.Store(&cfg)
+	return s
+}
+
+// SetVoucherHook replaces the hook notified of minted WiFi vouchers, for
+// portals that need something other than the HTTP hook. Call it before
+// serving requests.
+func (s *Server) SetVoucherHook(h voucher.Hook) {
+	s.voucherHook = h
+}
+
+// config returns the live config: cfg with runtime settings applied.
+func (s *Server) config() *config.Config {
+	return s.live.Load()
 }
 
 // ServeHTTP makes Server implement http.Handler for testing purposes.
@@ -66,82 +108,194 @@ func (s *Server) Run(ctx context.Context) error {
 func (s *Server) routes() http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(s.realIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   s.cfg.CORSOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Admin-Token", "X-Requested-With"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "If-Modified-Since", "If-None-Match", "X-Admin-Token", "X-Event", "X-Requested-With"},
+		ExposedHeaders:   []string{"ETag", "Last-Modified", "Link"},
 		AllowCredentials: false,
 		MaxAge:           300,
 	}))
+	r.Use(s.defaultEventScope)
+
+	r.Route("/v1", func(v chi.Router) {
+		v.Get("/health", s.handleHealth)
+		v.Post("/webhooks/{endpointID}", s.handleWebhook)
+		v.Get("/events", s.handleListEvents)
+
+		// Unscoped public routes serve the default event.
+		s.publicRoutes(v)
+		v.Route("/events/{eventSlug}", func(er chi.Router) {
+			er.Use(s.eventScope)
+			s.publicRoutes(er)
+		})
+
+		v.Get("/assets/{assetID}", s.handleGetAsset)
+		v.Post("/displays/register", s.rateLimited(s.registerLimit, s.handleRegisterDisplay))
+		v.Group(func(dr chi.Router) {
+			dr.Use(s.displayAuth)
+			dr.Post("/displays/heartbeat", s.handleDisplayHeartbeat)
+			dr.Get("/displays/commands", s.handleDisplayCommands)
+			dr.Get("/displays/playlist", s.handleDisplayPlaylist)
+			dr.Get("/displays/announcements", s.handleDisplayAnnouncements)
+			dr.Get("/displays/triggers", s.handleDisplayTriggers)
+			dr.Post("/displays/triggers/{triggerID}/ack", s.handleAckTrigger)
+		})
+		v.Route("/admin", s.adminRoutes)
+		v.Route("/portal", func(pr chi.Router) {
+			pr.Use(s.portalAuth)
+			pr.Get("/vouchers/{code}", s.handleValidateVoucher)
+			pr.Post("/vouchers/{code}/redeem", s.handleRedeemVoucher)
+		})
+	})
 
-	r.Get("/v1/health", s.handleHealth)
-	r.Get("/v1/wifi/config", s.handleWifiConfig)
-	r.Get("/v1/summary", s.handleSummary)
-	r.Get("/v1/ticker", s.handleTicker)
-	r.Get("/v1/leaderboard/merchants", s.handleMerchantLeaderboard)
-	r.Get("/v1/leaderboard/products", s.handleProductLeaderboard)
-	r.Get("/v1/milestones/triggers", s.handleMilestoneTriggers)
-	r.Get("/v1/scenes", s.handleListScenes)
-	r.Post("/v1/webhooks/wifi", s.handleWifiWebhook)
-
-	r.Route("/v1/admin", func(ar chi.Router) {
-		ar.Post("/auth/login", s.handleAdminLogin)
-		ar.Group(func(protected chi.Router) {
-			protected.Use(s.authMiddleware)
-			protected.Get("/summary", s.handleSummary)
-			protected.Route("/merchants", func(mr chi.Router) {
-				mr.Get("/", s.handleListMerchants)
-				mr.Post("/", s.handleCreateMerchant)
-				mr.Route("/{merchantID}", func(sr chi.Router) {
-					sr.Put("/", s.handleUpdateMerchant)
-					sr.Delete("/", s.handleDeleteMerchant)
-					sr.Post("/refetch", s.handleRefetchMerchant)
-				})
+	return r
+}
+
+// publicRoutes registers the dashboard routes, read-only apart from WiFi
+// invoices. They are mounted both unscoped and under /v1/events/{eventSlug}.
+func (s *Server) publicRoutes(r chi.Router) {
+	r.Get("/wifi/config", s.handleWifiConfig)
+	r.Post("/wifi/invoices", s.rateLimited(s.invoiceLimit, s.handleCreateWifiInvoice))
+	r.Get("/wifi/invoices/{paymentHash}", s.handleWifiInvoiceStatus)
+	r.Get("/wifi/invoices/{paymentHash}/events", s.handleWifiInvoiceEvents)
+	r.Get("/wifi/vouchers/{paymentHash}", s.handleWifiVoucher)
+	r.Get("/summary", s.cachedClock(s.handleSummary))
+	r.Get("/ticker", s.cached(s.handleTicker))
+	r.Get("/leaderboard/merchants", s.cachedClock(s.handleMerchantLeaderboard))
+	r.Get("/leaderboard/products", s.cached(s.handleProductLeaderboard))
+	r.Get("/milestones/triggers", s.handleMilestoneTriggers)
+	r.Get("/milestones/progress", s.handleMilestoneProgress)
+	r.Get("/scenes", s.handleListScenes)
+	r.Get("/announcements", s.handleAnnouncements)
+}
+
+func (s *Server) adminRoutes(ar chi.Router) {
+	ar.Post("/auth/login", s.handleAdminLogin)
+	ar.Group(func(protected chi.Router) {
+		protected.Use(s.authMiddleware)
+		protected.Use(s.adminEventScope)
+		protected.Get("/summary", s.handleSummary)
+		protected.Get("/settings", s.handleGetSettings)
+		protected.Patch("/settings", s.handlePatchSettings)
+		protected.Route("/events", func(er chi.Router) {
+			er.Get("/", s.handleListEvents)
+			er.Post("/", s.handleCreateEvent)
+			er.Route("/{eventSlug}", func(sr chi.Router) {
+				sr.Put("/", s.handleUpdateEvent)
+				sr.Post("/activate", s.handleActivateEvent)
+				sr.Post("/clone", s.handleCloneEvent)
+			})
+		})
+		protected.Route("/merchants", func(mr chi.Router) {
+			mr.Get("/", s.handleListMerchants)
+			mr.Post("/", s.handleCreateMerchant)
+			mr.Post("/import", s.handleImportMerchants)
+			mr.Route("/{merchantID}", func(sr chi.Router) {
+				sr.Put("/", s.handleUpdateMerchant)
+				sr.Delete("/", s.handleDeleteMerchant)
+				sr.Post("/refetch", s.handleRefetchMerchant)
+			})
+		})
+		protected.Route("/webhooks", func(wr chi.Router) {
+			wr.Get("/", s.handleListWebhookEndpoints)
+			wr.Post("/", s.handleCreateWebhookEndpoint)
+			wr.Get("/inbox", s.handleListWebhookDeliveries)
+			wr.Get("/inbox/{deliveryID}", s.handleGetWebhookDelivery)
+			wr.Post("/inbox/{deliveryID}/replay", s.handleReplayWebhookDelivery)
+			wr.Route("/{endpointID}", func(er chi.Router) {
+				er.Put("/", s.handleUpdateWebhookEndpoint)
+				er.Delete("/", s.handleDeleteWebhookEndpoint)
+				er.Post("/rotate", s.handleRotateWebhookSecret)
+			})
+		})
+		protected.Route("/wifi", func(wr chi.Router) {
+			wr.Get("/", s.handleAdminWifiConfig)
+			wr.Post("/tiers", s.handleCreateWifiTier)
+			wr.Route("/tiers/{tierID}", func(tr chi.Router) {
+				tr.Put("/", s.handleUpdateWifiTier)
+				tr.Delete("/", s.handleDeleteWifiTier)
+			})
+		})
+		protected.Route("/milestones", func(mr chi.Router) {
+			mr.Get("/", s.handleListMilestones)
+			mr.Post("/", s.handleCreateMilestone)
+			mr.Post("/preview", s.handlePreviewMilestone)
+			mr.Post("/simulate", s.handleSimulateMilestones)
+			mr.Get("/metrics", s.handleConditionMetrics)
+			mr.Get("/templates", s.handleListMilestoneTemplates)
+			mr.Post("/templates/{templateID}/import", s.handleImportMilestoneTemplate)
+			mr.Get("/triggers", s.handleMilestoneTriggerReports)
+			mr.Post("/triggers/{triggerID}/refire", s.handleRefireTrigger)
+			mr.Route("/{milestoneID}", func(sr chi.Router) {
+				sr.Get("/", s.handleGetMilestone)
+				sr.Put("/", s.handleUpdateMilestone)
+				sr.Patch("/", s.handleUpdateMilestone)
+				sr.Delete("/", s.handleDeleteMilestone)
+				sr.Post("/archive", s.handleArchiveMilestone)
+				sr.Post("/unarchive", s.handleUnarchiveMilestone)
+				sr.Post("/duplicate", s.handleDuplicateMilestone)
+			})
+		})
+		protected.Route("/displays", func(dr chi.Router) {
+			dr.Get("/", s.handleListDisplays)
+			dr.Post("/approve", s.handleApproveDisplay)
+			dr.Route("/{displayID}", func(sr chi.Router) {
+				sr.Put("/", s.handleUpdateDisplay)
+				sr.Delete("/", s.handleDeleteDisplay)
+				sr.Post("/commands", s.handleSendDisplayCommand)
 			})
-			protected.Route("/milestones", func(mr chi.Router) {
-				mr.Get("/", s.handleListMilestones)
-				mr.Post("/", s.handleCreateMilestone)
-				mr.Put("/{milestoneID}", s.handleUpdateMilestone)
+		})
+		protected.Route("/announcements", func(ar chi.Router) {
+			ar.Get("/", s.handleListAnnouncements)
+			ar.Post("/", s.handleCreateAnnouncement)
+			ar.Route("/{announcementID}", func(sr chi.Router) {
+				sr.Get("/", s.handleGetAnnouncement)
+				sr.Put("/", s.handleUpdateAnnouncement)
+				sr.Delete("/", s.handleDeleteAnnouncement)
 			})
-			protected.Route("/scenes", func(sr chi.Router) {
-				sr.Get("/", s.handleListScenesAdmin)
-				sr.Post("/", s.handleCreateScene)
-				sr.Route("/{sceneID}", func(ssr chi.Router) {
-					ssr.Put("/", s.handleUpdateScene)
-					ssr.Delete("/", s.handleDeleteScene)
-				})
+		})
+		protected.Route("/assets", func(ar chi.Router) {
+			ar.Get("/", s.handleListAssets)
+			ar.Post("/", s.handleUploadAsset)
+			ar.Delete("/{assetID}", s.handleDeleteAsset)
+		})
+		protected.Get("/slides/airtime", s.handleSlideAirtime)
+		protected.Route("/playlists", func(pr chi.Router) {
+			pr.Get("/", s.handleListPlaylists)
+			pr.Post("/", s.handleCreatePlaylist)
+			pr.Route("/{playlistID}", func(sr chi.Router) {
+				sr.Get("/", s.handleGetPlaylist)
+				sr.Put("/", s.handleUpdatePlaylist)
+				sr.Delete("/", s.handleDeletePlaylist)
+				sr.Post("/reorder", s.handleReorderPlaylist)
+			})
+		})
+		protected.Route("/scenes", func(sr chi.Router) {
+			sr.Get("/", s.handleListScenesAdmin)
+			sr.Post("/", s.handleCreateScene)
+			sr.Route("/{sceneID}", func(ssr chi.Router) {
+				ssr.Put("/", s.handleUpdateScene)
+				ssr.Delete("/", s.handleDeleteScene)
 			})
 		})
 	})
-
-	return r
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-func (s *Server) handleWifiConfig(w http.ResponseWriter, r *http.Request) {
-	config := map[string]string{
-		"lightning_address": s.cfg.WifiLightningAddress,
-		"description":       "Upgrade your wifi from 30mbps to 100mbps. 8 hours for 2100 satoshis. 5% Gets donated to \"Tollgate\" which is making this possible.",
-		"price_sats":        "2100",
-		"duration_hours":    "8",
-	}
-	writeJSON(w, http.StatusOK, config)
-}
-
 func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	source := r.URL.Query().Get("source")
 	if source == "" {
 		source = "all"
 	}
-	summary, err := s.store.SummaryBySource(ctx, s.cfg.RateWindow, source)
+	summary, err := s.storeFor(r).SummaryBySource(ctx, s.config().RateWindow, source)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -151,12 +305,12 @@ func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleTicker(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	limit := parseIntQuery(r, "limit", s.cfg.TickerLimit)
+	limit := parseIntQuery(r, "limit", s.config().TickerLimit)
 	source := r.URL.Query().Get("source")
 	if source == "" {
 		source = "all"
 	}
-	items, err := s.store.LatestTransactions(ctx, limit, source)
+	items, err := s.storeFor(r).LatestTransactions(ctx, limit, source)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -183,8 +337,8 @@ func (s *Server) handleMerchantLeaderboard(w http.ResponseWriter, r *http.Reques
 		}
 		window = parsed
 	}
-	limit := parseIntQuery(r, "limit", s.cfg.DefaultLeaderboardLimit)
-	rows, err := s.store.MerchantLeaderboard(ctx, window, metric, limit)
+	limit := parseIntQuery(r, "limit", s.config().DefaultLeaderboardLimit)
+	rows, err := s.storeFor(r).MerchantLeaderboard(ctx, window, metric, limit)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -198,8 +352,8 @@ func (s *Server) handleProductLeaderboard(w http.ResponseWriter, r *http.Request
 	if metric == "" {
 		metric = "transactions"
 	}
-	limit := parseIntQuery(r, "limit", s.cfg.DefaultLeaderboardLimit)
-	rows, err := s.store.ProductLeaderboard(ctx, metric, limit)
+	limit := parseIntQuery(r, "limit", s.config().DefaultLeaderboardLimit)
+	rows, err := s.storeFor(r).ProductLeaderboard(ctx, metric, limit)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -207,9 +361,29 @@ func (s *Server) handleProductLeaderboard(w http.ResponseWriter, r *http.Request
 	writeJSON(w, http.StatusOK, rows)
 }
 
+// handleMilestoneTriggers returns recent triggers, newest first, or with
+// after_id the ones recorded after that trigger, oldest first.
 func (s *Server) handleMilestoneTriggers(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	sinceStr := r.URL.Query().Get("since")
+	if r.URL.Query().Has("after_id") {
+		if sinceStr != "" {
+			writeError(w, http.StatusBadRequest, errors.New("use either since or after_id"))
+			return
+		}
+		filter, err := triggerFilter(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		triggers, err := s.storeFor(r).ListMilestoneTriggers(ctx, filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, triggers)
+		return
+	}
 	var since time.Time
 	if sinceStr == "" {
 		since = time.Now().Add(-24 * time.Hour)
@@ -221,7 +395,7 @@ func (s *Server) handleMilestoneTriggers(w http.ResponseWriter, r *http.Request)
 		}
 		since = parsed
 	}
-	triggers, err := s.store.MilestoneTriggersSince(ctx, since)
+	triggers, err := s.storeFor(r).MilestoneTriggersSince(ctx, since)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -229,103 +403,44 @@ func (s *Server) handleMilestoneTriggers(w http.ResponseWriter, r *http.Request)
 	writeJSON(w, http.StatusOK, triggers)
 }
 
-func (s *Server) handleAdminLogin(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		Token string `json:"token"`
-	}
-	if err := decodeJSON(w, r, &payload); err != nil {
-		writeError(w, http.StatusBadRequest, err)
-		return
+// handleMilestoneProgress reports how close each pending milestone is, with
+// ETAs from the growth over window (default: the summary rate window). Not
+// cached: the ETAs move with the clock, not just with new data.
+func (s *Server) handleMilestoneProgress(w http.ResponseWriter, r *http.Request) {
+	window := s.config().RateWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed < time.Minute {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid window: use a duration of at least 1m"))
+			return
+		}
+		window = parsed
 	}
-	if subtle.ConstantTimeCompare([]byte(payload.Token), []byte(s.cfg.AdminToken)) != 1 {
-		writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+	progress, err := s.storeFor(r).MilestoneProgress(r.Context(), window)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	writeJSON(w, http.StatusOK, progress)
 }
 
-func (s *Server) handleWifiWebhook(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-
-	// Validate webhook secret if configured (accepts header or query param)
-	if s.cfg.WebhookSecret != "" {
-		providedSecret := r.Header.Get("X-Webhook-Secret")
-		if providedSecret == "" {
-			providedSecret = r.URL.Query().Get("secret")
-		}
-		if subtle.ConstantTimeCompare([]byte(providedSecret), []byte(s.cfg.WebhookSecret)) != 1 {
-			writeError(w, http.StatusUnauthorized, errors.New("invalid webhook secret"))
-			return
-		}
-	}
-
-	// Parse LNBITS webhook payload
+func (s *Server) handleAdminLogin(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
-		Amount      int64  `json:"amount"`       // millisats
-		Memo        string `json:"memo"`
-		PaymentHash string `json:"payment_hash"`
-		Time        int64  `json:"time"`         // unix timestamp
+		Token string `json:"token"`
 	}
 	if err := decodeJSON(w, r, &payload); err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-
-	// Convert millisats to sats
-	amountSats := payload.Amount / 1000
-	if amountSats <= 0 {
-		amountSats = payload.Amount // Fallback if already in sats
-	}
-
-	// Create transaction with source=wifi
-	// Use payment_hash as sale_id (convert to int64 hash)
-	saleID := int64(hashString(payload.PaymentHash))
-	saleDate := time.Unix(payload.Time, 0).UTC()
-	if payload.Time == 0 {
-		saleDate = time.Now().UTC()
-	}
-
-	txn := store.TransactionInput{
-		SaleID:     saleID,
-		SaleOrigin: "lnbits",
-		SaleDate:   saleDate,
-		AmountSats: amountSats,
-		Source:     store.SourceWifi,
-	}
-
-	// Record transaction
-	inserted, err := s.store.RecordTransactions(ctx, "wifi", []store.TransactionInput{txn})
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, err)
+	if subtle.ConstantTimeCompare([]byte(payload.Token), []byte(s.cfg.AdminToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
 		return
 	}
-
-	// Check milestones
-	if inserted > 0 {
-		if _, err := s.store.ProcessMilestones(ctx); err != nil {
-			// Log error but don't fail the webhook
-			fmt.Printf("[webhook] milestone check failed: %v\n", err)
-		}
-	}
-
-	writeJSON(w, http.StatusOK, map[string]any{
-		"status":   "ok",
-		"inserted": inserted,
-		"amount_sats": amountSats,
-	})
-}
-
-// hashString converts a string to a uint64 hash for use as sale_id
-func hashString(s string) uint64 {
-	h := uint64(0)
-	for i := 0; i < len(s) && i < 8; i++ {
-		h = h<<8 | uint64(s[i])
-	}
-	return h
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
 func (s *Server) handleListMerchants(w http.ResponseWriter, r *http.Request) {
-	merchants, err := s.store.ListMerchants(r.Context(), false)
+	merchants, err := s.storeFor(r).ListMerchants(r.Context(), false)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -358,7 +473,7 @@ func (s *Server) handleCreateMerchant(w http.ResponseWriter, r *http.Request) {
 		Alias:     payload.Alias,
 		Enabled:   enabled,
 	}
-	if err := s.store.UpsertMerchant(r.Context(), merchant); err != nil {
+	if err := s.storeFor(r).UpsertMerchant(r.Context(), merchant); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -381,7 +496,7 @@ func (s *Server) handleUpdateMerchant(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, errors.New("missing merchant id"))
 		return
 	}
-	current, err := s.store.GetMerchant(r.Context(), oldID)
+	current, err := s.storeFor(r).GetMerchant(r.Context(), oldID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			writeError(w, http.StatusNotFound, err)
@@ -408,7 +523,7 @@ func (s *Server) handleUpdateMerchant(w http.ResponseWriter, r *http.Request) {
 	if payload.Enabled != nil {
 		current.Enabled = *payload.Enabled
 	}
-	if err := s.store.UpdateMerchant(r.Context(), current); err != nil {
+	if err := s.storeFor(r).UpdateMerchant(r.Context(), current); err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -421,7 +536,7 @@ func (s *Server) handleDeleteMerchant(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, errors.New("missing merchant id"))
 		return
 	}
-	if err := s.store.DeleteMerchant(r.Context(), id); err != nil {
+	if err := s.storeFor(r).DeleteMerchant(r.Context(), id); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			writeError(w, http.StatusNotFound, err)
 			return
@@ -438,7 +553,7 @@ func (s *Server) handleRefetchMerchant(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, errors.New("missing merchant id"))
 		return
 	}
-	if err := s.poller.RefreshMerchant(r.Context(), id); err != nil {
+	if err := s.poller.RefreshMerchant(r.Context(), s.storeFor(r).EventID(), id); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			writeError(w, http.StatusNotFound, err)
 			return
@@ -449,89 +564,25 @@ func (s *Server) handleRefetchMerchant(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusAccepted, map[string]string{"status": "refreshing"})
 }
 
-func (s *Server) handleListMilestones(w http.ResponseWriter, r *http.Request) {
-	items, err := s.store.ListMilestones(r.Context())
+func (s *Server) handleListScenes(w http.ResponseWriter, r *http.Request) {
+	scenes, err := s.storeFor(r).ListScenes(r.Context(), true) // only enabled scenes
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, items)
-}
-
-func (s *Server) handleCreateMilestone(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		Name      string `json:"name"`
-		Type      string `json:"type"`
-		Threshold int64  `json:"threshold"`
-		Enabled   bool   `json:"enabled"`
-	}
-	if err := decodeJSON(w, r, &payload); err != nil {
-		writeError(w, http.StatusBadRequest, err)
-		return
-	}
-	if payload.Name == "" || payload.Type == "" {
-		writeError(w, http.StatusBadRequest, errors.New("missing fields"))
-		return
-	}
-	milestone, err := s.store.UpsertMilestone(r.Context(), store.Milestone{
-		Name:      payload.Name,
-		Type:      store.MilestoneType(payload.Type),
-		Threshold: payload.Threshold,
-		Enabled:   payload.Enabled,
-	})
-	if err != nil {
-		writeError(w, http.StatusBadRequest, err)
-		return
-	}
-	writeJSON(w, http.StatusCreated, milestone)
-}
-
-func (s *Server) handleUpdateMilestone(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		Name         string `json:"name"`
-		Type         string `json:"type"`
-		Threshold    int64  `json:"threshold"`
-		Enabled      bool   `json:"enabled"`
-		ResetTrigger bool   `json:"reset_trigger"`
-	}
-	if err := decodeJSON(w, r, &payload); err != nil {
-		writeError(w, http.StatusBadRequest, err)
-		return
-	}
-	idStr := chi.URLParam(r, "milestoneID")
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, errors.New("invalid id"))
-		return
-	}
-	m, err := s.store.UpdateMilestone(r.Context(), id, store.Milestone{
-		Name:      payload.Name,
-		Type:      store.MilestoneType(payload.Type),
-		Threshold: payload.Threshold,
-		Enabled:   payload.Enabled,
-	}, payload.ResetTrigger)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			writeError(w, http.StatusNotFound, err)
-			return
+	// Content slides outside their schedule are left out
+	now := time.Now()
+	items := make([]store.Scene, 0, len(scenes))
+	for _, sc := range scenes {
+		if sc.ActiveAt(now) {
+			items = append(items, sc)
 		}
-		writeError(w, http.StatusBadRequest, err)
-		return
-	}
-	writeJSON(w, http.StatusOK, m)
-}
-
-func (s *Server) handleListScenes(w http.ResponseWriter, r *http.Request) {
-	items, err := s.store.ListScenes(r.Context(), true) // only enabled scenes
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, err)
-		return
 	}
 	writeJSON(w, http.StatusOK, items)
 }
 
 func (s *Server) handleListScenesAdmin(w http.ResponseWriter, r *http.Request) {
-	items, err := s.store.ListScenes(r.Context(), false) // all scenes
+	items, err := s.storeFor(r).ListScenes(r.Context(), false) // all scenes
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -546,6 +597,9 @@ func (s *Server) handleCreateScene(w http.ResponseWriter, r *http.Request) {
 		Duration int64  `json:"duration"`
 		Enabled  *bool  `json:"enabled"`
 		Order    int64  `json:"order"`
+
+		Type    store.SceneType     `json:"type"`
+		Content *store.SlideContent `json:"content"`
 	}
 	if err := decodeJSON(w, r, &payload); err != nil {
 		writeError(w, http.StatusBadRequest, err)
@@ -565,12 +619,14 @@ func (s *Server) handleCreateScene(w http.ResponseWriter, r *http.Request) {
 		Duration: payload.Duration,
 		Enabled:  enabled,
 		Order:    payload.Order,
+		Type:     payload.Type,
+		Content:  payload.Content,
 	}
-	if err := s.store.UpsertScene(r.Context(), scene); err != nil {
-		writeError(w, http.StatusInternalServerError, err)
+	if err := s.storeFor(r).UpsertScene(r.Context(), scene); err != nil {
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	created, err := s.store.GetScene(r.Context(), scene.ID)
+	created, err := s.storeFor(r).GetScene(r.Context(), scene.ID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err)
 		return
@@ -584,6 +640,8 @@ func (s *Server) handleUpdateScene(w http.ResponseWriter, r *http.Request) {
 		Duration int64  `json:"duration"`
 		Enabled  *bool  `json:"enabled"`
 		Order    int64  `json:"order"`
+
+		Content *store.SlideContent `json:"content"` // replaces a content slide's content
 	}
 	if err := decodeJSON(w, r, &payload); err != nil {
 		writeError(w, http.StatusBadRequest, err)
@@ -594,7 +652,7 @@ func (s *Server) handleUpdateScene(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, errors.New("missing scene id"))
 		return
 	}
-	current, err := s.store.GetScene(r.Context(), id)
+	current, err := s.storeFor(r).GetScene(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			writeError(w, http.StatusNotFound, err)
@@ -616,8 +674,11 @@ func (s *Server) handleUpdateScene(w http.ResponseWriter, r *http.Request) {
 	if payload.Order > 0 {
 		current.Order = payload.Order
 	}
-	if err := s.store.UpdateScene(r.Context(), current); err != nil {
-		writeError(w, http.StatusInternalServerError, err)
+	if payload.Content != nil {
+		current.Content = payload.Content
+	}
+	if err := s.storeFor(r).UpdateScene(r.Context(), current); err != nil {
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, current)
@@ -629,7 +690,7 @@ func (s *Server) handleDeleteScene(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, errors.New("missing scene id"))
 		return
 	}
-	if err := s.store.DeleteScene(r.Context(), id); err != nil {
+	if err := s.storeFor(r).DeleteScene(r.Context(), id); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			writeError(w, http.StatusNotFound, err)
 			return
@@ -685,8 +746,9 @@ func writeError(w http.ResponseWriter, status int, err error) {
 	writeJSON(w, status, map[string]string{"error": err.Error()})
 }
 
+const maxBodySize = 1 << 20 // 1 MB
+
 func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
-	const maxBodySize = 1 << 20 // 1 MB
 	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
 	return json.NewDecoder(r.Body).Decode(v)
 }
@@ -1,12 +1,21 @@
 package api_test
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -14,12 +23,41 @@ import (
 	"github.com/adopting-bitcoin/dashboard/internal/api"
 	"github.com/adopting-bitcoin/dashboard/internal/config"
 	"github.com/adopting-bitcoin/dashboard/internal/ingest"
+	"github.com/adopting-bitcoin/dashboard/internal/mock"
 	"github.com/adopting-bitcoin/dashboard/internal/store"
+	"github.com/adopting-bitcoin/dashboard/internal/webhook"
 )
 
+// adminToken is the admin token every test server is configured with.
+const adminToken = "test-token"
+
+// webhookSecret is the WEBHOOK_SECRET of every test server, which the built-in
+// wifi endpoint authenticates with.
+const webhookSecret = "webhook-secret"
+
 func setupTestServer(t *testing.T) (*api.Server, *store.Store) {
 	t.Helper()
-	st, err := store.New(":memory:")
+	return setupTestServerWithUpstream(t, "http://localhost")
+}
+
+// setupTestServerWithUpstream points the poller at the given upstream API.
+func setupTestServerWithUpstream(t *testing.T, upstream string) (*api.Server, *store.Store) {
+	t.Helper()
+	return setupTestServerWithConfig(t, func(cfg *config.Config) { cfg.DataAPIBaseURL = upstream })
+}
+
+// setupTestServerWithConfig lets a test adjust the config before the server
+// is built.
+func setupTestServerWithConfig(t *testing.T, configure func(*config.Config)) (*api.Server, *store.Store) {
+	t.Helper()
+	return setupTestServerAt(t, ":memory:", configure)
+}
+
+// setupTestServerAt builds the server on a database at path, for tests that
+// need a second connection to it.
+func setupTestServerAt(t *testing.T, path string, configure func(*config.Config)) (*api.Server, *store.Store) {
+	t.Helper()
+	st, err := store.New(path)
 	if err != nil {
 		t.Fatalf("create store: %v", err)
 	}
@@ -28,19 +66,29 @@ func setupTestServer(t *testing.T) (*api.Server, *store.Store) {
 	}
 
 	cfg := config.Config{
-		AdminToken:              "test-token",
+		AdminToken:              adminToken,
 		RateWindow:              5 * time.Minute,
 		TickerLimit:             20,
 		DefaultLeaderboardLimit: 10,
+		PollInterval:            time.Hour,
+		PollConcurrency:         1,
+		HTTPTimeout:             10 * time.Second,
+		WebhookTolerance:        5 * time.Minute,
+		WebhookSecret:           webhookSecret,
+		WifiInvoiceRateLimit:    10,
+		DisplayRegisterLimit:    10,
+		DataAPIBaseURL:          "http://localhost",
+		AssetCacheDir:           t.TempDir(),
 		CORSOrigins:             []string{"*"},
 	}
+	configure(&cfg)
 
 	logger := log.New(os.Stderr, "[test] ", log.LstdFlags)
 	poller := ingest.NewPoller(st, ingest.Config{
 		Interval:    time.Hour, // Long interval for testing
 		Concurrency: 1,
 		Timeout:     10 * time.Second,
-		BaseURL:     "http://localhost",
+		BaseURL:     cfg.DataAPIBaseURL,
 	}, logger)
 
 	server := api.NewServer(cfg, st, poller, logger)
@@ -52,6 +100,58 @@ func setupTestServer(t *testing.T) (*api.Server, *store.Store) {
 	return server, st
 }
 
+// doRequest serves one request, sending token as a bearer token unless it
+// is empty.
+func doRequest(t *testing.T, server *api.Server, method, path, body, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	return doRequestWithHeaders(t, server, method, path, body, token, nil)
+}
+
+// doRequestWithHeaders is doRequest with extra request headers.
+func doRequestWithHeaders(t *testing.T, server *api.Server, method, path, body, token string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	return w
+}
+
+// postWifiWebhook delivers an LNbits payload to the built-in wifi endpoint.
+func postWifiWebhook(t *testing.T, server *api.Server, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/wifi", body, "", map[string]string{"X-Webhook-Secret": webhookSecret})
+}
+
+// pairedDisplay is a display registration along with its display token.
+type pairedDisplay struct {
+	store.Display
+	Token string `json:"token"`
+}
+
+// pairDisplay registers a display named "Hall A" over the API and approves
+// it.
+func pairDisplay(t *testing.T, server *api.Server, st *store.Store) pairedDisplay {
+	t.Helper()
+	w := doRequest(t, server, http.MethodPost, "/v1/displays/register", `{"name":"Hall A"}`, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register display: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var display pairedDisplay
+	if err := json.NewDecoder(w.Body).Decode(&display); err != nil {
+		t.Fatalf("decode registration: %v", err)
+	}
+	if _, err := st.ApproveDisplay(context.Background(), display.PairingCode, ""); err != nil {
+		t.Fatalf("approve display: %v", err)
+	}
+	return display
+}
+
 func TestHealthEndpoint(t *testing.T) {
 	server, _ := setupTestServer(t)
 
@@ -303,3 +403,2058 @@ func TestEmptySlicesReturnArray(t *testing.T) {
 		t.Errorf("expected empty array [], got %s", body)
 	}
 }
+
+func TestSummaryConditionalGet(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+
+	if err := st.UpsertMerchant(ctx, store.Merchant{
+		ID:        "test-merchant",
+		PublicKey: "test-key",
+		Alias:     "Test Merchant",
+		Enabled:   true,
+	}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+
+	w := doRequest(t, server, http.MethodGet, "/v1/summary", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected ETag header")
+	}
+	if w.Header().Get("Last-Modified") == "" {
+		t.Fatal("expected Last-Modified header")
+	}
+
+	// Unchanged data answers 304.
+	w = doRequestWithHeaders(t, server, http.MethodGet, "/v1/summary", "", "", map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("expected status 304, got %d", w.Code)
+	}
+
+	// A write invalidates the version and the cached body.
+	if _, err := st.RecordTransactions(ctx, "test-merchant", []store.TransactionInput{
+		{SaleID: 1, SaleOrigin: "pos", SaleDate: time.Now(), AmountSats: 1000},
+	}); err != nil {
+		t.Fatalf("record transactions: %v", err)
+	}
+	w = doRequestWithHeaders(t, server, http.MethodGet, "/v1/summary", "", "", map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 after write, got %d", w.Code)
+	}
+	if w.Header().Get("ETag") == etag {
+		t.Error("expected ETag to change after write")
+	}
+	var summary struct {
+		TotalTransactions int64 `json:"total_transactions"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&summary); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if summary.TotalTransactions != 1 {
+		t.Errorf("expected 1 transaction, got %d", summary.TotalTransactions)
+	}
+}
+
+func TestTickerGzip(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+
+	if err := st.UpsertMerchant(ctx, store.Merchant{
+		ID:        "test-merchant",
+		PublicKey: "test-key",
+		Alias:     "Test Merchant",
+		Enabled:   true,
+	}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+	txs := make([]store.TransactionInput, 0, 50)
+	for i := 1; i <= 50; i++ {
+		txs = append(txs, store.TransactionInput{SaleID: int64(i), SaleOrigin: "pos", SaleDate: time.Now(), AmountSats: 1000})
+	}
+	if _, err := st.RecordTransactions(ctx, "test-merchant", txs); err != nil {
+		t.Fatalf("record transactions: %v", err)
+	}
+
+	w := doRequestWithHeaders(t, server, http.MethodGet, "/v1/ticker?limit=50", "", "", map[string]string{"Accept-Encoding": "gzip"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip encoding, got %q", w.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	var ticker []map[string]any
+	if err := json.NewDecoder(zr).Decode(&ticker); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(ticker) != 50 {
+		t.Errorf("expected 50 ticker entries, got %d", len(ticker))
+	}
+}
+
+func TestEventScopedRoutes(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+
+	ab24, err := st.CreateEvent(ctx, store.Event{Slug: "ab24", Name: "Adopting Bitcoin 2024"})
+	if err != nil {
+		t.Fatalf("create event: %v", err)
+	}
+	scoped := st.ForEvent(ab24.ID)
+	if err := scoped.UpsertMerchant(ctx, store.Merchant{ID: "m1", PublicKey: "pk", Alias: "Booth", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+	if _, err := scoped.RecordTransactions(ctx, "m1", []store.TransactionInput{
+		{SaleID: 1, SaleOrigin: "pos", SaleDate: time.Now(), AmountSats: 1000},
+	}); err != nil {
+		t.Fatalf("record transactions: %v", err)
+	}
+
+	summaryTotal := func(path string) int64 {
+		t.Helper()
+		w := doRequest(t, server, http.MethodGet, path, "", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", path, w.Code)
+		}
+		var summary struct {
+			TotalTransactions int64 `json:"total_transactions"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&summary); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return summary.TotalTransactions
+	}
+
+	if got := summaryTotal("/v1/events/ab24/summary"); got != 1 {
+		t.Errorf("expected 1 transaction for ab24, got %d", got)
+	}
+	if got := summaryTotal("/v1/summary"); got != 0 {
+		t.Errorf("expected 0 transactions for default event, got %d", got)
+	}
+
+	w := doRequest(t, server, http.MethodGet, "/v1/events/missing/summary", "", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown event, got %d", w.Code)
+	}
+
+	w = doRequest(t, server, http.MethodPost, "/v1/admin/events/ab24/activate", "", adminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 on activate, got %d", w.Code)
+	}
+	if got := summaryTotal("/v1/summary"); got != 1 {
+		t.Errorf("expected unscoped routes to follow activated event, got %d transactions", got)
+	}
+}
+
+func TestImportMerchants(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("user_public_key") {
+		case "good-key":
+		case "flaky-key":
+			http.Error(w, "try again later", http.StatusServiceUnavailable)
+			return
+		case "busy-key":
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		default:
+			http.Error(w, "invalid public key", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"id":1,"name":"Upstream Pupusas","products":[],"sales":[{"SaleId":1,"SaleDate":"2025-08-22T19:27:23.532000+00:00","TotalCostSats":"9"}]}}`))
+	}))
+	defer upstream.Close()
+	server, st := setupTestServerWithUpstream(t, upstream.URL)
+	ctx := context.Background()
+
+	if err := st.UpsertMerchant(ctx, store.Merchant{ID: "m1", PublicKey: "old-key", Alias: "Old", Enabled: true}); err != nil {
+		t.Fatalf("seed merchant: %v", err)
+	}
+
+	post := func(path, contentType, body string) (*httptest.ResponseRecorder, map[string]any) {
+		w := doRequestWithHeaders(t, server, http.MethodPost, path, body, adminToken, map[string]string{"Content-Type": contentType})
+		var resp map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return w, resp
+	}
+
+	csvBody := "id,alias,public_key\nm1,Pupusas,good-key\nm2,Coffee,bad-key\n"
+	w, resp := post("/v1/admin/merchants/import?dry_run=true", "text/csv", csvBody)
+	if w.Code != http.StatusOK {
+		t.Fatalf("dry run: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if resp["valid"] != false {
+		t.Errorf("dry run: expected valid=false with a rejected key")
+	}
+	rows := resp["rows"].([]any)
+	first := rows[0].(map[string]any)
+	upstreamInfo := first["upstream"].(map[string]any)
+	if first["valid"] != true || first["exists"] != true || upstreamInfo["upstream_name"] != "Upstream Pupusas" || upstreamInfo["sales"] != float64(1) {
+		t.Errorf("dry run: unexpected first row %v", first)
+	}
+	if second := rows[1].(map[string]any); second["valid"] != false || second["upstream"].(map[string]any)["auth_ok"] != false {
+		t.Errorf("dry run: expected second row rejected, got %v", second)
+	}
+	if m, _ := st.GetMerchant(ctx, "m1"); m.PublicKey != "old-key" {
+		t.Errorf("dry run must not write, got public key %q", m.PublicKey)
+	}
+
+	// Header columns in any order; an upstream outage or rate limit only warns
+	w, resp = post("/v1/admin/merchants/import?dry_run=true", "text/csv", "alias,public_key,id\nPupusas,good-key,m1\nTea,flaky-key,m4\nJuice,busy-key,m5\n")
+	if w.Code != http.StatusOK || resp["valid"] != true {
+		t.Fatalf("dry run with outage: expected 200 and valid, got %d: %s", w.Code, w.Body.String())
+	}
+	rows = resp["rows"].([]any)
+	if first := rows[0].(map[string]any); first["id"] != "m1" || first["alias"] != "Pupusas" || first["warning"] != nil {
+		t.Errorf("dry run with outage: unexpected first row %v", first)
+	}
+	if second := rows[1].(map[string]any); second["valid"] != true || second["warning"] != "upstream unavailable" ||
+		second["upstream"].(map[string]any)["status"] != float64(http.StatusServiceUnavailable) {
+		t.Errorf("dry run with outage: expected a warning on the second row, got %v", second)
+	}
+	if third := rows[2].(map[string]any); third["valid"] != true || third["warning"] != "upstream unavailable" {
+		t.Errorf("dry run with rate limit: expected a warning on the third row, got %v", third)
+	}
+
+	// A headerless data row is kept even if one of its cells names a column
+	w, resp = post("/v1/admin/merchants/import?dry_run=true", "text/csv", "m6,good-key,alias\n")
+	if w.Code != http.StatusOK {
+		t.Fatalf("headerless dry run: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if rows := resp["rows"].([]any); len(rows) != 1 || rows[0].(map[string]any)["id"] != "m6" || rows[0].(map[string]any)["alias"] != "alias" {
+		t.Errorf("headerless dry run: expected the row as data, got %v", rows)
+	}
+
+	w, _ = post("/v1/admin/merchants/import", "application/json", `[{"id":"m3","public_key":"k","alias":"A"},{"id":"m3","public_key":"k","alias":"B"}]`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("duplicate ids: expected 400, got %d", w.Code)
+	}
+	if _, err := st.GetMerchant(ctx, "m3"); err == nil {
+		t.Errorf("rejected import must not write")
+	}
+
+	w, resp = post("/v1/admin/merchants/import", "application/json", `[{"id":"m1","public_key":"good-key","alias":"Pupusas"},{"id":"m2","public_key":"k2","alias":"Coffee","enabled":false}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("import: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if resp["created"] != float64(1) || resp["updated"] != float64(1) {
+		t.Errorf("import: expected 1 created and 1 updated, got %v", resp)
+	}
+	merchants, err := st.ListMerchants(ctx, false)
+	if err != nil {
+		t.Fatalf("list merchants: %v", err)
+	}
+	if len(merchants) != 3 { // m1, m2 and the seeded wifi merchant
+		t.Fatalf("expected 3 merchants, got %d", len(merchants))
+	}
+	for _, m := range merchants {
+		if m.ID == "m2" && m.Enabled {
+			t.Errorf("expected m2 disabled")
+		}
+		if m.ID == "m1" && m.PublicKey != "good-key" {
+			t.Errorf("expected m1 updated, got %q", m.PublicKey)
+		}
+	}
+}
+
+func TestSettingsPatch(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	txns := make([]store.TransactionInput, 5)
+	for i := range txns {
+		txns[i] = store.TransactionInput{SaleID: int64(i + 1), SaleDate: now, AmountSats: 100, Source: store.SourcePayWithFlash}
+	}
+	if err := st.UpsertMerchant(ctx, store.Merchant{ID: "m1", PublicKey: "k", Alias: "M", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+	if _, err := st.RecordTransactions(ctx, "m1", txns); err != nil {
+		t.Fatalf("record transactions: %v", err)
+	}
+
+	tickerLen := func() int {
+		w := doRequest(t, server, http.MethodGet, "/v1/ticker", "", "")
+		var entries []store.TickerEntry
+		if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+			t.Fatalf("decode ticker: %v", err)
+		}
+		return len(entries)
+	}
+
+	if n := tickerLen(); n != 5 {
+		t.Fatalf("expected 5 ticker entries, got %d", n)
+	}
+	if w := doRequest(t, server, http.MethodPatch, "/v1/admin/settings", `{"ticker_limit": 2, "rate_window": "10m"}`, adminToken); w.Code != http.StatusOK {
+		t.Fatalf("patch: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if n := tickerLen(); n != 2 {
+		t.Errorf("expected ticker limit 2 to apply live, got %d entries", n)
+	}
+
+	for _, body := range []string{`{"ticker_limit": 0}`, `{"rate_window": "soon"}`, `{"unknown": 1}`} {
+		if w := doRequest(t, server, http.MethodPatch, "/v1/admin/settings", body, adminToken); w.Code != http.StatusBadRequest {
+			t.Errorf("patch %s: expected 400, got %d", body, w.Code)
+		}
+	}
+
+	stored, err := st.Settings(ctx)
+	if err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+	if stored["ticker_limit"] != "2" || stored["rate_window"] != "10m" {
+		t.Errorf("unexpected stored settings %v", stored)
+	}
+
+	w := doRequest(t, server, http.MethodPatch, "/v1/admin/settings", `{"ticker_limit": null}`, adminToken)
+	var view map[string]struct {
+		Value      any  `json:"value"`
+		Default    any  `json:"default"`
+		Overridden bool `json:"overridden"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&view); err != nil {
+		t.Fatalf("decode settings: %v", err)
+	}
+	if view["ticker_limit"].Overridden || view["ticker_limit"].Value != float64(20) {
+		t.Errorf("expected ticker_limit reset to default, got %+v", view["ticker_limit"])
+	}
+	if !view["rate_window"].Overridden || view["rate_window"].Value != "10m0s" {
+		t.Errorf("expected rate_window override kept, got %+v", view["rate_window"])
+	}
+	if n := tickerLen(); n != 5 {
+		t.Errorf("expected reset ticker limit, got %d entries", n)
+	}
+}
+
+func TestWifiTiers(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+
+	w := doRequest(t, server, http.MethodGet, "/v1/wifi/config", "", adminToken)
+	var config struct {
+		PriceSats     string           `json:"price_sats"`
+		DurationHours string           `json:"duration_hours"`
+		Tiers         []store.WifiTier `json:"tiers"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&config); err != nil {
+		t.Fatalf("decode config: %v", err)
+	}
+	if config.PriceSats != "2100" || config.DurationHours != "8" || len(config.Tiers) != 1 {
+		t.Fatalf("expected migrated default tier, got %+v", config)
+	}
+
+	w = doRequest(t, server, http.MethodPost, "/v1/admin/wifi/tiers", `{"name":"Day pass","price_sats":5000,"duration_minutes":1440,"speed_mbps":200,"order":2}`, adminToken)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create tier: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var dayPass store.WifiTier
+	if err := json.NewDecoder(w.Body).Decode(&dayPass); err != nil {
+		t.Fatalf("decode tier: %v", err)
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/admin/wifi/tiers", `{"name":"Bad","price_sats":100,"duration_minutes":60,"donation_percent":120}`, adminToken); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid tier: expected 400, got %d", w.Code)
+	}
+
+	cases := []struct {
+		amountMsat int64
+		want       any
+	}{
+		{1000 * 1000, nil},
+		{2100 * 1000, "Upgrade"},
+		{3000 * 1000, "Upgrade"},
+		{5000 * 1000, "Day pass"},
+	}
+	for i, tc := range cases {
+		body := `{"amount":` + strconv.FormatInt(tc.amountMsat, 10) + `,"payment_hash":"hash` + strconv.Itoa(i) + `"}`
+		w := postWifiWebhook(t, server, body)
+		var resp map[string]any
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode webhook: %v", err)
+		}
+		if resp["wifi_tier"] != tc.want {
+			t.Errorf("amount %d msat: expected tier %v, got %v", tc.amountMsat, tc.want, resp["wifi_tier"])
+		}
+	}
+
+	if w := doRequest(t, server, http.MethodPut, "/v1/admin/wifi/tiers/"+strconv.FormatInt(dayPass.ID, 10), `{"enabled":false}`, adminToken); w.Code != http.StatusOK {
+		t.Fatalf("disable tier: expected 200, got %d", w.Code)
+	}
+	tiers, err := st.ListWifiTiers(ctx, true)
+	if err != nil {
+		t.Fatalf("list tiers: %v", err)
+	}
+	if len(tiers) != 1 {
+		t.Errorf("expected 1 enabled tier, got %d", len(tiers))
+	}
+	if w := doRequest(t, server, http.MethodDelete, "/v1/admin/wifi/tiers/9999", "", adminToken); w.Code != http.StatusNotFound {
+		t.Errorf("delete missing tier: expected 404, got %d", w.Code)
+	}
+}
+
+func TestWifiWebhookExternalIDs(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+
+	post := func(hash string) map[string]any {
+		body := `{"amount":2100000,"memo":"WiFi upgrade","payment_hash":"` + hash + `","time":1699000000}`
+		w := postWifiWebhook(t, server, body)
+		var resp map[string]any
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode webhook: %v", err)
+		}
+		return resp
+	}
+
+	// These share their first 8 bytes, which used to collide.
+	for _, hash := range []string{"51e106df64aaaa", "51e106df64bbbb"} {
+		if resp := post(hash); resp["inserted"] != float64(1) {
+			t.Errorf("payment %s: expected insert, got %v", hash, resp)
+		}
+	}
+	if resp := post("51e106df64aaaa"); resp["inserted"] != float64(0) {
+		t.Errorf("redelivered payment: expected no insert, got %v", resp)
+	}
+
+	entries, err := st.LatestTransactions(ctx, 10, "wifi")
+	if err != nil {
+		t.Fatalf("ticker: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 wifi transactions, got %d", len(entries))
+	}
+	if entries[0].SaleID != nil || entries[0].ID == entries[1].ID {
+		t.Errorf("expected null sale ids and distinct row ids, got %+v", entries)
+	}
+}
+
+func TestWebhookEndpointsRequireSecret(t *testing.T) {
+	server, st := setupTestServerWithConfig(t, func(cfg *config.Config) { cfg.WebhookSecret = "" })
+	if err := st.UpsertMerchant(context.Background(), store.Merchant{ID: "booth", PublicKey: "-", Alias: "Booth", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+
+	for _, mode := range []string{"hmac", "header", "btcpay"} {
+		body := fmt.Sprintf(`{"name":"Booth","provider":"lnbits","auth_mode":"%s","merchant_id":"booth","source":"booth"}`, mode)
+		if w := doRequest(t, server, http.MethodPost, "/v1/admin/webhooks", body, adminToken); w.Code != http.StatusBadRequest {
+			t.Errorf("%s endpoint without a secret: expected 400, got %d", mode, w.Code)
+		}
+	}
+	w := doRequest(t, server, http.MethodPost, "/v1/admin/webhooks", `{"id":"booth-pay","name":"Booth","provider":"lnbits","secret":"s3cret","merchant_id":"booth","source":"booth"}`, adminToken)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create endpoint: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, server, http.MethodPut, "/v1/admin/webhooks/booth-pay", `{"secret":""}`, adminToken); w.Code != http.StatusBadRequest {
+		t.Errorf("clearing the secret: expected 400, got %d", w.Code)
+	}
+
+	// The built-in wifi endpoint has no secret of its own, so without
+	// WEBHOOK_SECRET nothing can pass as an LNbits payment
+	body := `{"amount":2100000,"payment_hash":"forged","time":1699000000}`
+	if w := doRequest(t, server, http.MethodPost, "/v1/webhooks/wifi", body, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("endpoint without a secret: expected 401, got %d", w.Code)
+	}
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/wifi", body, "", map[string]string{"X-Webhook-Secret": ""}); w.Code != http.StatusUnauthorized {
+		t.Errorf("empty secret header: expected 401, got %d", w.Code)
+	}
+}
+
+func TestWebhookEndpointsScopedToEvent(t *testing.T) {
+	server, st := setupTestServer(t)
+	if _, err := st.CreateEvent(context.Background(), store.Event{Slug: "ab26", Name: "Adopting Bitcoin 2026"}); err != nil {
+		t.Fatalf("create event: %v", err)
+	}
+	ab26 := map[string]string{"X-Event": "ab26"}
+	w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/admin/webhooks", `{"id":"ab26-pay","name":"Booth","provider":"lnbits","secret":"s3cret","merchant_id":"wifi","source":"booth"}`, adminToken, ab26)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create endpoint: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Admins of the default event can't touch another event's endpoint
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPut, "/v1/admin/webhooks/ab26-pay", `{"name":"Taken"}`},
+		{http.MethodPost, "/v1/admin/webhooks/ab26-pay/rotate", ""},
+		{http.MethodDelete, "/v1/admin/webhooks/ab26-pay", ""},
+	} {
+		if w := doRequest(t, server, req.method, req.path, req.body, adminToken); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s without the event: expected 404, got %d", req.method, req.path, w.Code)
+		}
+		if w := doRequest(t, server, req.method, req.path+"?event=ab26", req.body, adminToken); w.Code/100 != 2 {
+			t.Errorf("%s %s in the event: expected success, got %d: %s", req.method, req.path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestWebhookEndpoints(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+
+	if err := st.UpsertMerchant(ctx, store.Merchant{ID: "booth", PublicKey: "-", Alias: "Booth", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+
+	w := doRequest(t, server, http.MethodPost, "/v1/admin/webhooks", `{"id":"booth-pay","name":"Booth","provider":"generic","auth_mode":"header","secret":"s3cret","merchant_id":"booth","source":"booth","mapping":{"external_id":"id","amount":"sats"}}`, adminToken)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create endpoint: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/admin/webhooks", `{"name":"Bad","provider":"generic","merchant_id":"booth","source":"booth","mapping":{"amount":"sats"}}`, adminToken); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid mapping: expected 400, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/admin/webhooks", `{"name":"Bad","provider":"lnbits","merchant_id":"nobody","source":"x"}`, adminToken); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown merchant: expected 400, got %d", w.Code)
+	}
+
+	if w := doRequest(t, server, http.MethodPost, "/v1/webhooks/booth-pay", `{"id":"p1","sats":500}`, adminToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("missing secret: expected 401, got %d", w.Code)
+	}
+	secret := map[string]string{"X-Webhook-Secret": "s3cret"}
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay", `{"id":"p1","sats":500}`, adminToken, secret); w.Code != http.StatusOK {
+		t.Fatalf("payment: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay", `{"sats":500}`, adminToken, secret); w.Code != http.StatusBadRequest {
+		t.Errorf("unmappable payload: expected 400, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/webhooks/missing", `{}`, adminToken); w.Code != http.StatusNotFound {
+		t.Errorf("unknown endpoint: expected 404, got %d", w.Code)
+	}
+
+	summary, err := st.SummaryBySource(ctx, time.Minute, "booth")
+	if err != nil {
+		t.Fatalf("summary: %v", err)
+	}
+	if summary.TotalTransactions != 1 || summary.TotalVolumeSats != 500 {
+		t.Errorf("expected one 500 sat booth payment, got %+v", summary)
+	}
+
+	w = doRequest(t, server, http.MethodGet, "/v1/admin/webhooks", "", adminToken)
+	var endpoints []store.WebhookEndpoint
+	if err := json.NewDecoder(w.Body).Decode(&endpoints); err != nil {
+		t.Fatalf("decode endpoints: %v", err)
+	}
+	if len(endpoints) != 2 { // booth-pay plus the seeded wifi endpoint
+		t.Errorf("expected 2 endpoints, got %d", len(endpoints))
+	}
+}
+
+func TestWebhookSignatures(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+
+	if err := st.UpsertMerchant(ctx, store.Merchant{ID: "booth", PublicKey: "-", Alias: "Booth", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+	signed := func(secret string, at time.Time, body string) map[string]string {
+		return map[string]string{
+			webhook.HeaderTimestamp: strconv.FormatInt(at.Unix(), 10),
+			webhook.HeaderSignature: webhook.Sign(secret, at.Unix(), []byte(body)),
+		}
+	}
+
+	w := doRequest(t, server, http.MethodPost, "/v1/admin/webhooks", `{"id":"booth-pay","name":"Booth","provider":"generic","secret":"old","merchant_id":"booth","source":"booth","mapping":{"external_id":"id","amount":"sats"}}`, adminToken)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create endpoint: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var endpoint store.WebhookEndpoint
+	if err := json.NewDecoder(w.Body).Decode(&endpoint); err != nil {
+		t.Fatalf("decode endpoint: %v", err)
+	}
+	if endpoint.AuthMode != webhook.AuthHMAC {
+		t.Errorf("expected new endpoints to default to hmac, got %q", endpoint.AuthMode)
+	}
+
+	now := time.Now()
+	body := `{"id":"p1","sats":500}`
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay", body, adminToken, map[string]string{"X-Webhook-Secret": "old"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("plain secret on hmac endpoint: expected 401, got %d", w.Code)
+	}
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay?secret=old", body, adminToken, signed("old", now, body)); w.Code != http.StatusUnauthorized {
+		t.Errorf("query secret: expected 401, got %d", w.Code)
+	}
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay", `{"id":"p1","sats":5000}`, adminToken, signed("old", now, body)); w.Code != http.StatusUnauthorized {
+		t.Errorf("tampered body: expected 401, got %d", w.Code)
+	}
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay", body, adminToken, signed("old", now.Add(-10*time.Minute), body)); w.Code != http.StatusUnauthorized {
+		t.Errorf("stale timestamp: expected 401, got %d", w.Code)
+	}
+	headers := signed("old", now, body)
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay", body, adminToken, headers); w.Code != http.StatusOK {
+		t.Fatalf("signed payment: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay", body, adminToken, headers); w.Code != http.StatusConflict {
+		t.Errorf("replay: expected 409, got %d", w.Code)
+	}
+
+	// During a rotation both secrets are accepted until the old one is cleared
+	w = doRequest(t, server, http.MethodPost, "/v1/admin/webhooks/booth-pay/rotate", "", adminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rotate: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := json.NewDecoder(w.Body).Decode(&endpoint); err != nil {
+		t.Fatalf("decode endpoint: %v", err)
+	}
+	if endpoint.PreviousSecret != "old" || endpoint.Secret == "" || endpoint.Secret == "old" {
+		t.Fatalf("unexpected secrets after rotation: %+v", endpoint)
+	}
+	body = `{"id":"p2","sats":200}`
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay", body, adminToken, signed("old", now, body)); w.Code != http.StatusOK {
+		t.Errorf("previous secret during rotation: expected 200, got %d", w.Code)
+	}
+	body = `{"id":"p3","sats":300}`
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay", body, adminToken, signed(endpoint.Secret, now, body)); w.Code != http.StatusOK {
+		t.Errorf("new secret: expected 200, got %d", w.Code)
+	}
+
+	w = doRequest(t, server, http.MethodPut, "/v1/admin/webhooks/booth-pay", `{"previous_secret":""}`, adminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("clear previous secret: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body = `{"id":"p4","sats":400}`
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay", body, adminToken, signed("old", now, body)); w.Code != http.StatusUnauthorized {
+		t.Errorf("retired secret: expected 401, got %d", w.Code)
+	}
+
+	summary, err := st.SummaryBySource(ctx, time.Minute, "booth")
+	if err != nil {
+		t.Fatalf("summary: %v", err)
+	}
+	if summary.TotalTransactions != 3 || summary.TotalVolumeSats != 1000 {
+		t.Errorf("expected three booth payments totalling 1000 sats, got %+v", summary)
+	}
+}
+
+func TestWebhookRetryAfterFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dashboard.db")
+	server, st := setupTestServerAt(t, path, func(*config.Config) {})
+	ctx := context.Background()
+
+	if err := st.UpsertMerchant(ctx, store.Merchant{ID: "booth", PublicKey: "-", Alias: "Booth", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+	if _, err := st.CreateWebhookEndpoint(ctx, store.WebhookEndpoint{
+		ID: "booth-pay", Name: "Booth", Provider: "generic", Secret: "s3cret", AuthMode: webhook.AuthHMAC,
+		MerchantID: "booth", Source: "booth", Enabled: true,
+		Mapping: map[string]string{"external_id": "id", "amount": "sats"},
+	}); err != nil {
+		t.Fatalf("create endpoint: %v", err)
+	}
+
+	// A second connection makes recording the payment fail once
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TRIGGER fail_insert BEFORE INSERT ON transactions BEGIN SELECT RAISE(ABORT, 'disk full'); END`); err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+
+	body := `{"id":"p1","sats":500}`
+	now := time.Now()
+	headers := map[string]string{
+		webhook.HeaderTimestamp: strconv.FormatInt(now.Unix(), 10),
+		webhook.HeaderSignature: webhook.Sign("s3cret", now.Unix(), []byte(body)),
+	}
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay", body, "", headers); w.Code != http.StatusInternalServerError {
+		t.Fatalf("failing delivery: expected 500, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := db.Exec(`DROP TRIGGER fail_insert`); err != nil {
+		t.Fatalf("drop trigger: %v", err)
+	}
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay", body, "", headers); w.Code != http.StatusOK {
+		t.Fatalf("retry: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay", body, "", headers); w.Code != http.StatusConflict {
+		t.Errorf("replay after success: expected 409, got %d", w.Code)
+	}
+}
+
+func TestWebhookInbox(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+
+	if err := st.UpsertMerchant(ctx, store.Merchant{ID: "booth", PublicKey: "-", Alias: "Booth", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+	inbox := func(query string) []store.WebhookDelivery {
+		t.Helper()
+		w := doRequest(t, server, http.MethodGet, "/v1/admin/webhooks/inbox"+query, "", adminToken)
+		if w.Code != http.StatusOK {
+			t.Fatalf("inbox: expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var deliveries []store.WebhookDelivery
+		if err := json.NewDecoder(w.Body).Decode(&deliveries); err != nil {
+			t.Fatalf("decode inbox: %v", err)
+		}
+		return deliveries
+	}
+
+	// The mapping points at the wrong amount field, so the first payment fails
+	w := doRequest(t, server, http.MethodPost, "/v1/admin/webhooks", `{"id":"booth-pay","name":"Booth","provider":"generic","auth_mode":"header","secret":"s3cret","merchant_id":"booth","source":"booth","mapping":{"external_id":"id","amount":"amount"}}`, adminToken)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create endpoint: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	secret := map[string]string{"X-Webhook-Secret": "s3cret"}
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/webhooks/booth-pay", `{"id":"p1","sats":500}`, adminToken, secret); w.Code != http.StatusBadRequest {
+		t.Fatalf("unmapped payment: expected 400, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/webhooks/booth-pay", `{"id":"p2","sats":700}`, adminToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated payment: expected 401, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/webhooks/missing", `{}`, adminToken); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown endpoint: expected 404, got %d", w.Code)
+	}
+
+	// Requests to unknown endpoints aren't stored
+	deliveries := inbox("")
+	if len(deliveries) != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", len(deliveries))
+	}
+	failed, unauthorized := deliveries[1], deliveries[0]
+	if failed.StatusCode != http.StatusBadRequest || failed.Error == "" || failed.Body != `{"id":"p1","sats":500}` {
+		t.Errorf("unexpected failed delivery: %+v", failed)
+	}
+	if got := failed.Headers["X-Webhook-Secret"]; len(got) != 1 || got[0] != "[redacted]" {
+		t.Errorf("expected the secret header to be redacted, got %v", got)
+	}
+	if got := inbox("?endpoint=booth-pay"); len(got) != 2 {
+		t.Errorf("endpoint filter: expected 2 deliveries, got %d", len(got))
+	}
+	if got := inbox("?limit=1&before=" + strconv.FormatInt(failed.ID+1, 10)); len(got) != 1 || got[0].ID != failed.ID {
+		t.Errorf("paging: expected only delivery %d, got %+v", failed.ID, got)
+	}
+
+	// Fix the mapping, then replay the failed delivery
+	if w := doRequest(t, server, http.MethodPut, "/v1/admin/webhooks/booth-pay", `{"mapping":{"external_id":"id","amount":"sats"}}`, adminToken); w.Code != http.StatusOK {
+		t.Fatalf("fix mapping: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, server, http.MethodPost, "/v1/admin/webhooks/inbox/"+strconv.FormatInt(failed.ID, 10)+"/replay", "", adminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("replay: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var result struct {
+		Inserted int64 `json:"inserted"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil || result.Inserted != 1 {
+		t.Errorf("replay: expected one inserted payment, got %+v (%v)", result, err)
+	}
+	replay := inbox("?limit=1")[0]
+	if replay.ReplayOf == nil || *replay.ReplayOf != failed.ID || replay.StatusCode != http.StatusOK {
+		t.Errorf("unexpected replay delivery: %+v", replay)
+	}
+
+	unauthorizedPath := "/v1/admin/webhooks/inbox/" + strconv.FormatInt(unauthorized.ID, 10) + "/replay"
+	if w := doRequest(t, server, http.MethodPost, unauthorizedPath, "", adminToken); w.Code != http.StatusConflict {
+		t.Errorf("replay of unauthenticated delivery: expected 409, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodPost, unauthorizedPath+"?force=true", "", adminToken); w.Code != http.StatusOK {
+		t.Errorf("forced replay: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/admin/webhooks/inbox/9999/replay", "", adminToken); w.Code != http.StatusNotFound {
+		t.Errorf("replay of missing delivery: expected 404, got %d", w.Code)
+	}
+
+	// Only the start of a large unauthenticated body is kept, and it can't
+	// be replayed
+	large := `{"id":"p3","sats":900,"pad":"` + strings.Repeat("x", 10000) + `"}`
+	if w := doRequest(t, server, http.MethodPost, "/v1/webhooks/booth-pay", large, adminToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("large unauthenticated payment: expected 401, got %d", w.Code)
+	}
+	truncated := inbox("?limit=1")[0]
+	if !truncated.Truncated || len(truncated.Body) != 4096 || !strings.HasPrefix(large, truncated.Body) {
+		t.Errorf("expected a truncated body of 4096 bytes, got %d (truncated=%v)", len(truncated.Body), truncated.Truncated)
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/admin/webhooks/inbox/"+strconv.FormatInt(truncated.ID, 10)+"/replay?force=true", "", adminToken); w.Code != http.StatusConflict {
+		t.Errorf("replay of truncated delivery: expected 409, got %d", w.Code)
+	}
+
+	summary, err := st.SummaryBySource(ctx, time.Minute, "booth")
+	if err != nil {
+		t.Fatalf("summary: %v", err)
+	}
+	if summary.TotalTransactions != 2 || summary.TotalVolumeSats != 1200 {
+		t.Errorf("expected both replayed payments, got %+v", summary)
+	}
+}
+
+func TestWifiInvoices(t *testing.T) {
+	wallet := mock.NewLNbits("invoice-key")
+	lnbitsServer := httptest.NewServer(wallet)
+	defer lnbitsServer.Close()
+	server, st := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.LNbitsURL = lnbitsServer.URL
+		cfg.LNbitsInvoiceKey = "invoice-key"
+		cfg.WifiInvoiceExpiry = 10 * time.Minute
+	})
+	ctx := context.Background()
+
+	decode := func(w *httptest.ResponseRecorder) store.WifiInvoice {
+		t.Helper()
+		var inv store.WifiInvoice
+		if err := json.NewDecoder(w.Body).Decode(&inv); err != nil {
+			t.Fatalf("decode invoice: %v", err)
+		}
+		return inv
+	}
+
+	tiers, err := st.ListWifiTiers(ctx, true)
+	if err != nil || len(tiers) == 0 {
+		t.Fatalf("expected the seeded wifi tier, got %v (%v)", tiers, err)
+	}
+	tier := tiers[0]
+
+	if w := doRequest(t, server, http.MethodPost, "/v1/wifi/invoices", `{"tier_id":9999}`, ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown tier: expected 404, got %d", w.Code)
+	}
+	w := doRequest(t, server, http.MethodPost, "/v1/wifi/invoices", fmt.Sprintf(`{"tier_id":%d}`, tier.ID), "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create invoice: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	invoice := decode(w)
+	if invoice.Bolt11 == "" || invoice.PaymentHash == "" || invoice.AmountSats != tier.PriceSats || invoice.Status != store.WifiInvoicePending {
+		t.Fatalf("unexpected invoice: %+v", invoice)
+	}
+
+	statusPath := "/v1/wifi/invoices/" + invoice.PaymentHash
+	if got := decode(doRequest(t, server, http.MethodGet, statusPath, "", "")); got.Status != store.WifiInvoicePending {
+		t.Errorf("expected pending before payment, got %s", got.Status)
+	}
+
+	wallet.Settle(invoice.PaymentHash)
+	w = doRequest(t, server, http.MethodGet, statusPath+"/events", "", "")
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected an event stream, got %q", ct)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "event: status") || !strings.Contains(body, `"status":"paid"`) {
+		t.Errorf("expected a paid status event, got %q", body)
+	}
+	w = doRequest(t, server, http.MethodGet, statusPath, "", "")
+	var paid struct {
+		store.WifiInvoice
+		Voucher *store.WifiVoucher `json:"voucher"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&paid); err != nil {
+		t.Fatalf("decode paid invoice: %v", err)
+	}
+	if paid.Status != store.WifiInvoicePaid || paid.PaidAt == nil {
+		t.Errorf("expected paid invoice, got %+v", paid.WifiInvoice)
+	}
+	if paid.Voucher == nil || paid.Voucher.PaymentHash != invoice.PaymentHash {
+		t.Errorf("expected the paid invoice to carry its voucher, got %+v", paid.Voucher)
+	}
+
+	// Settlement goes through the webhook path, and repeated checks or a
+	// matching LNbits webhook don't count it twice
+	lnbitsBody := fmt.Sprintf(`{"amount":%d,"payment_hash":"%s","time":%d}`, tier.PriceSats*1000, invoice.PaymentHash, time.Now().Unix())
+	if w := postWifiWebhook(t, server, lnbitsBody); w.Code != http.StatusOK {
+		t.Fatalf("wifi webhook: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	entries, err := st.LatestTransactions(ctx, 10, "wifi")
+	if err != nil {
+		t.Fatalf("latest transactions: %v", err)
+	}
+	if len(entries) != 1 || entries[0].AmountSats != tier.PriceSats {
+		t.Errorf("expected one wifi transaction of %d sats, got %+v", tier.PriceSats, entries)
+	}
+
+	if w := doRequest(t, server, http.MethodGet, "/v1/wifi/invoices/unknown", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown invoice: expected 404, got %d", w.Code)
+	}
+}
+
+func TestWifiInvoicesDisabled(t *testing.T) {
+	server, _ := setupTestServer(t)
+	w := doRequest(t, server, http.MethodPost, "/v1/wifi/invoices", `{"tier_id":1}`, "")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without LNbits, got %d", w.Code)
+	}
+}
+
+func TestWifiInvoiceExpiryAndLimit(t *testing.T) {
+	wallet := mock.NewLNbits("invoice-key")
+	lnbitsServer := httptest.NewServer(wallet)
+	server, st := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.LNbitsURL = lnbitsServer.URL
+		cfg.LNbitsInvoiceKey = "invoice-key"
+		cfg.WifiInvoiceExpiry = time.Millisecond
+	})
+	tiers, err := st.ListWifiTiers(context.Background(), true)
+	if err != nil || len(tiers) == 0 {
+		t.Fatalf("expected the seeded wifi tier, got %v (%v)", tiers, err)
+	}
+	body := fmt.Sprintf(`{"tier_id":%d}`, tiers[0].ID)
+
+	w := doRequest(t, server, http.MethodPost, "/v1/wifi/invoices", body, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create invoice: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var invoice store.WifiInvoice
+	if err := json.NewDecoder(w.Body).Decode(&invoice); err != nil {
+		t.Fatalf("decode invoice: %v", err)
+	}
+
+	// An expired invoice is reported as expired even while LNbits is down
+	lnbitsServer.Close()
+	time.Sleep(5 * time.Millisecond)
+	w = doRequest(t, server, http.MethodGet, "/v1/wifi/invoices/"+invoice.PaymentHash, "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status with LNbits down: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := json.NewDecoder(w.Body).Decode(&invoice); err != nil || invoice.Status != store.WifiInvoiceExpired {
+		t.Errorf("expected an expired invoice, got %s (%v)", invoice.Status, err)
+	}
+
+	// One client can only create so many invoices a minute
+	for i := 1; i < 10; i++ {
+		if w := doRequest(t, server, http.MethodPost, "/v1/wifi/invoices", body, ""); w.Code == http.StatusTooManyRequests {
+			t.Fatalf("invoice %d: limited too early", i+1)
+		}
+	}
+	w = doRequest(t, server, http.MethodPost, "/v1/wifi/invoices", body, "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 429 with Retry-After, got %d", w.Code)
+	}
+}
+
+func TestRateLimitClientAddress(t *testing.T) {
+	wallet := mock.NewLNbits("invoice-key")
+	lnbitsServer := httptest.NewServer(wallet)
+	defer lnbitsServer.Close()
+	server, st := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.LNbitsURL = lnbitsServer.URL
+		cfg.LNbitsInvoiceKey = "invoice-key"
+		cfg.WifiInvoiceRateLimit = 1
+		cfg.TrustedProxies = []string{"10.0.0.0/24"}
+	})
+	tiers, err := st.ListWifiTiers(context.Background(), true)
+	if err != nil || len(tiers) == 0 {
+		t.Fatalf("expected the seeded wifi tier, got %v (%v)", tiers, err)
+	}
+	body := fmt.Sprintf(`{"tier_id":%d}`, tiers[0].ID)
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded string
+		want      int
+	}{
+		{"direct client", "192.0.2.1:1234", "203.0.113.1", http.StatusCreated},
+		{"direct client spoofing", "192.0.2.1:1234", "203.0.113.2", http.StatusTooManyRequests},
+		{"proxied client", "10.0.0.1:1234", "198.51.100.1, 203.0.113.5", http.StatusCreated},
+		{"proxied client spoofing", "10.0.0.1:1234", "198.51.100.9, 203.0.113.5, 10.0.0.2", http.StatusTooManyRequests},
+		{"another proxied client", "10.0.0.1:1234", "203.0.113.6", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/v1/wifi/invoices", strings.NewReader(body))
+		req.RemoteAddr = tt.peer
+		req.Header.Set("X-Forwarded-For", tt.forwarded)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestWifiInvoiceKeepsItsTier(t *testing.T) {
+	wallet := mock.NewLNbits("invoice-key")
+	lnbitsServer := httptest.NewServer(wallet)
+	defer lnbitsServer.Close()
+	server, st := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.LNbitsURL = lnbitsServer.URL
+		cfg.LNbitsInvoiceKey = "invoice-key"
+		cfg.WifiInvoiceExpiry = 10 * time.Minute
+	})
+	ctx := context.Background()
+	tiers, err := st.ListWifiTiers(ctx, true)
+	if err != nil || len(tiers) == 0 {
+		t.Fatalf("expected the seeded wifi tier, got %v (%v)", tiers, err)
+	}
+	tier := tiers[0]
+
+	w := doRequest(t, server, http.MethodPost, "/v1/wifi/invoices", fmt.Sprintf(`{"tier_id":%d}`, tier.ID), "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create invoice: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var invoice store.WifiInvoice
+	if err := json.NewDecoder(w.Body).Decode(&invoice); err != nil {
+		t.Fatalf("decode invoice: %v", err)
+	}
+
+	// Reprice and disable the tier, and add another one the invoice amount
+	// pays for, before the invoice is settled
+	repriced := tier
+	repriced.PriceSats *= 2
+	repriced.Enabled = false
+	if _, err := st.UpdateWifiTier(ctx, repriced); err != nil {
+		t.Fatalf("update tier: %v", err)
+	}
+	other, err := st.CreateWifiTier(ctx, store.WifiTier{Name: "Hour", PriceSats: tier.PriceSats, DurationMinutes: 60, Enabled: true})
+	if err != nil {
+		t.Fatalf("create tier: %v", err)
+	}
+
+	wallet.Settle(invoice.PaymentHash)
+	w = doRequest(t, server, http.MethodGet, "/v1/wifi/invoices/"+invoice.PaymentHash, "", "")
+	var paid struct {
+		store.WifiInvoice
+		Voucher *store.WifiVoucher `json:"voucher"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&paid); err != nil {
+		t.Fatalf("decode paid invoice: %v", err)
+	}
+	if paid.Status != store.WifiInvoicePaid || paid.Voucher == nil {
+		t.Fatalf("expected a paid invoice with a voucher, got %+v", paid)
+	}
+	if paid.Voucher.TierID != tier.ID || paid.Voucher.DurationMinutes != tier.DurationMinutes {
+		t.Errorf("expected a voucher for tier %d, got tier %d (other tier %d)", tier.ID, paid.Voucher.TierID, other.ID)
+	}
+}
+
+// voucherRecorder is a voucher hook standing in for a captive portal.
+type voucherRecorder chan store.WifiVoucher
+
+func (r voucherRecorder) Minted(_ context.Context, v store.WifiVoucher) error {
+	r <- v
+	return nil
+}
+
+func TestWifiVouchers(t *testing.T) {
+	server, st := setupTestServerWithConfig(t, func(cfg *config.Config) {
+		cfg.PortalToken = "portal-token"
+	})
+	hook := make(voucherRecorder, 1)
+	server.SetVoucherHook(hook)
+	ctx := context.Background()
+
+	decode := func(w *httptest.ResponseRecorder) (v struct {
+		store.WifiVoucher
+		Valid  bool   `json:"valid"`
+		Reason string `json:"reason"`
+	}) {
+		t.Helper()
+		if err := json.NewDecoder(w.Body).Decode(&v); err != nil {
+			t.Fatalf("decode voucher: %v", err)
+		}
+		return v
+	}
+
+	tiers, err := st.ListWifiTiers(ctx, true)
+	if err != nil || len(tiers) == 0 {
+		t.Fatalf("expected the seeded wifi tier, got %v (%v)", tiers, err)
+	}
+	body := fmt.Sprintf(`{"amount":%d,"payment_hash":"hash-v1","time":%d}`, tiers[0].PriceSats*1000, time.Now().Unix())
+	for i := 0; i < 2; i++ { // a retried delivery mints nothing new
+		if w := postWifiWebhook(t, server, body); w.Code != http.StatusOK {
+			t.Fatalf("wifi webhook: expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if w := postWifiWebhook(t, server, `{"amount":1000,"payment_hash":"too-little"}`); w.Code != http.StatusOK {
+		t.Fatalf("small wifi payment: expected 200, got %d", w.Code)
+	}
+
+	w := doRequest(t, server, http.MethodGet, "/v1/wifi/vouchers/hash-v1", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("voucher: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	voucher := decode(w)
+	if !voucher.Valid || voucher.Code == "" || voucher.TierID != tiers[0].ID || voucher.DurationMinutes != tiers[0].DurationMinutes {
+		t.Fatalf("unexpected voucher: %+v", voucher)
+	}
+	select {
+	case hooked := <-hook:
+		if hooked.Code != voucher.Code {
+			t.Errorf("hook got voucher %s, expected %s", hooked.Code, voucher.Code)
+		}
+	case <-time.After(time.Second):
+		t.Error("voucher hook was not called")
+	}
+	if w := doRequest(t, server, http.MethodGet, "/v1/wifi/vouchers/too-little", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("payment below every tier: expected no voucher, got %d", w.Code)
+	}
+
+	codePath := "/v1/portal/vouchers/" + voucher.Code
+	if w := doRequest(t, server, http.MethodGet, codePath, "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("portal without token: expected 401, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodGet, codePath, "", adminToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("portal with admin token: expected 401, got %d", w.Code)
+	}
+	if got := decode(doRequest(t, server, http.MethodGet, codePath, "", "portal-token")); !got.Valid {
+		t.Errorf("expected a valid unredeemed voucher, got %+v", got)
+	}
+	if w := doRequest(t, server, http.MethodPost, codePath+"/redeem", `{"client_id":"aa:bb"}`, "portal-token"); w.Code != http.StatusOK {
+		t.Fatalf("redeem: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, server, http.MethodPost, codePath+"/redeem", `{"client_id":"cc:dd"}`, "portal-token"); w.Code != http.StatusConflict {
+		t.Errorf("redeem by another client: expected 409, got %d", w.Code)
+	}
+	if got := decode(doRequest(t, server, http.MethodGet, codePath+"?client_id=cc:dd", "", "portal-token")); got.Valid || got.Reason == "" {
+		t.Errorf("expected the voucher to be invalid for another client, got %+v", got)
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/portal/vouchers/NOPE0-NOPE0/redeem", `{"client_id":"aa:bb"}`, "portal-token"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown code: expected 404, got %d", w.Code)
+	}
+}
+
+func TestDisplays(t *testing.T) {
+	server, _ := setupTestServer(t)
+
+	w := doRequest(t, server, http.MethodPost, "/v1/displays/register", `{"name":"Stage left","app_version":"1.2.0"}`, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var registered pairedDisplay
+	if err := json.NewDecoder(w.Body).Decode(&registered); err != nil {
+		t.Fatalf("decode registration: %v", err)
+	}
+	if registered.Token == "" || len(registered.PairingCode) != 6 || registered.Status != store.DisplayPending {
+		t.Fatalf("unexpected registration: %+v", registered)
+	}
+	token := registered.Token
+
+	if w := doRequest(t, server, http.MethodGet, "/v1/displays/commands", "", token); w.Code != http.StatusForbidden {
+		t.Errorf("commands before approval: expected 403, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/displays/heartbeat", `{"scene":"summary"}`, "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("heartbeat with unknown token: expected 401, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/admin/displays/approve", `{"pairing_code":"000000x"}`, adminToken); w.Code != http.StatusNotFound {
+		t.Errorf("approve unknown code: expected 404, got %d", w.Code)
+	}
+	body := fmt.Sprintf(`{"pairing_code":%q,"name":"Main stage"}`, registered.PairingCode)
+	if w := doRequest(t, server, http.MethodPost, "/v1/admin/displays/approve", body, adminToken); w.Code != http.StatusOK {
+		t.Fatalf("approve: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/displays/heartbeat", `{"scene":"summary","app_version":"1.3.0"}`, token); w.Code != http.StatusOK {
+		t.Fatalf("heartbeat: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A second display that never checks in
+	doRequest(t, server, http.MethodPost, "/v1/displays/register", `{"name":"Hall A"}`, "")
+
+	w = doRequest(t, server, http.MethodGet, "/v1/admin/displays", "", adminToken)
+	var displays []struct {
+		store.Display
+		Online bool `json:"online"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&displays); err != nil {
+		t.Fatalf("decode displays: %v", err)
+	}
+	if len(displays) != 2 {
+		t.Fatalf("expected 2 displays, got %d", len(displays))
+	}
+	var main struct {
+		store.Display
+		Online bool `json:"online"`
+	}
+	for _, d := range displays {
+		if d.ID == registered.ID {
+			main = d
+		} else if d.Online || d.Status != store.DisplayPending {
+			t.Errorf("expected the silent display to be pending and offline, got %+v", d)
+		}
+	}
+	if !main.Online || main.Name != "Main stage" || main.Status != store.DisplayApproved ||
+		main.Scene != "summary" || main.AppVersion != "1.3.0" || main.PairingCode != "" {
+		t.Errorf("unexpected approved display: %+v", main)
+	}
+
+	cmdPath := "/v1/admin/displays/" + registered.ID + "/commands"
+	if w := doRequest(t, server, http.MethodPost, cmdPath, `{"type":"scene"}`, adminToken); w.Code != http.StatusBadRequest {
+		t.Errorf("scene command without scene: expected 400, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodPost, cmdPath, `{"type":"reload"}`, adminToken); w.Code != http.StatusAccepted {
+		t.Fatalf("queue reload: expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/v1/displays/commands", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("open command stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("command stream: expected 200, got %d", resp.StatusCode)
+	}
+	lines := bufio.NewScanner(resp.Body)
+	next := func() store.DisplayCommand {
+		t.Helper()
+		for lines.Scan() {
+			if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+				var cmd store.DisplayCommand
+				if err := json.Unmarshal([]byte(data), &cmd); err != nil {
+					t.Fatalf("decode command: %v", err)
+				}
+				return cmd
+			}
+		}
+		t.Fatalf("command stream ended: %v", lines.Err())
+		return store.DisplayCommand{}
+	}
+	if cmd := next(); cmd.Type != store.DisplayReload {
+		t.Errorf("expected the queued reload first, got %+v", cmd)
+	}
+	if w := doRequest(t, server, http.MethodPost, cmdPath, `{"type":"message","message":"Doors close in 5"}`, adminToken); w.Code != http.StatusAccepted {
+		t.Fatalf("queue message: expected 202, got %d", w.Code)
+	}
+	if cmd := next(); cmd.Type != store.DisplayMessage || cmd.Message != "Doors close in 5" || cmd.DurationSeconds == 0 {
+		t.Errorf("unexpected pushed message: %+v", cmd)
+	}
+
+	if w := doRequest(t, server, http.MethodDelete, "/v1/admin/displays/"+registered.ID, "", adminToken); w.Code != http.StatusNoContent {
+		t.Fatalf("delete display: expected 204, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/displays/heartbeat", `{}`, token); w.Code != http.StatusUnauthorized {
+		t.Errorf("heartbeat after removal: expected 401, got %d", w.Code)
+	}
+}
+
+// brokenStream is a response writer whose connection drops once the
+// headers are sent.
+type brokenStream struct{ header http.Header }
+
+func (b *brokenStream) Header() http.Header       { return b.header }
+func (b *brokenStream) WriteHeader(int)           {}
+func (b *brokenStream) Write([]byte) (int, error) { return 0, errors.New("connection reset") }
+func (b *brokenStream) Flush()                    {}
+
+func TestDisplayCommandDeliveryAndLimit(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+	display := pairDisplay(t, server, st)
+	if w := doRequest(t, server, http.MethodPost, "/v1/admin/displays/"+display.ID+"/commands", `{"type":"reload"}`, adminToken); w.Code != http.StatusAccepted {
+		t.Fatalf("queue reload: expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A command that never reached the display stays queued
+	req := httptest.NewRequest(http.MethodGet, "/v1/displays/commands", nil)
+	req.Header.Set("Authorization", "Bearer "+display.Token)
+	server.ServeHTTP(&brokenStream{header: make(http.Header)}, req)
+	cmds, err := st.PendingDisplayCommands(ctx, display.ID, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("pending commands: %v", err)
+	}
+	if len(cmds) != 1 || cmds[0].Type != store.DisplayReload {
+		t.Errorf("expected the reload to stay queued, got %+v", cmds)
+	}
+
+	// One client can only register so many displays a minute
+	for i := 1; i < 10; i++ {
+		if w := doRequest(t, server, http.MethodPost, "/v1/displays/register", `{}`, ""); w.Code != http.StatusCreated {
+			t.Fatalf("registration %d: expected 201, got %d", i+1, w.Code)
+		}
+	}
+	w := doRequest(t, server, http.MethodPost, "/v1/displays/register", `{}`, "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 429 with Retry-After, got %d", w.Code)
+	}
+	// A forwarded address from a peer that isn't a trusted proxy is ignored
+	forwarded := map[string]string{"X-Forwarded-For": "203.0.113.7", "X-Real-IP": "203.0.113.7"}
+	if w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/displays/register", `{}`, "", forwarded); w.Code != http.StatusTooManyRequests {
+		t.Errorf("spoofed address: expected 429, got %d", w.Code)
+	}
+}
+
+func TestPlaylists(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+
+	invalid := map[string]string{
+		"unknown scene":  `{"name":"Hall","entries":[{"scene_id":"nope"}]}`,
+		"unknown param":  `{"name":"Hall","entries":[{"scene_id":"merchants","params":{"metrc":"volume"}}]}`,
+		"bad metric":     `{"name":"Hall","entries":[{"scene_id":"merchants","params":{"metric":"likes"}}]}`,
+		"bad window":     `{"name":"Hall","entries":[{"scene_id":"merchants","params":{"window":"yesterday"}}]}`,
+		"limit too high": `{"name":"Hall","entries":[{"scene_id":"merchants","params":{"limit":5000}}]}`,
+		"missing name":   `{"entries":[]}`,
+	}
+	for name, body := range invalid {
+		if w := doRequest(t, server, http.MethodPost, "/v1/admin/playlists", body, adminToken); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	w := doRequest(t, server, http.MethodPost, "/v1/admin/playlists", `{"name":"Hall A","entries":[
+		{"scene_id":"overview"},
+		{"scene_id":"merchants","duration":20000,"params":{"metric":"volume","window":"1h","limit":5,"source":"pwf"}},
+		{"scene_id":"wifi"}
+	]}`, adminToken)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create playlist: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var pl store.Playlist
+	if err := json.NewDecoder(w.Body).Decode(&pl); err != nil {
+		t.Fatalf("decode playlist: %v", err)
+	}
+	if len(pl.Entries) != 3 || pl.Entries[1].Params.Metric != "volume" || pl.Entries[1].Params.Limit != 5 {
+		t.Fatalf("unexpected playlist: %+v", pl)
+	}
+	path := fmt.Sprintf("/v1/admin/playlists/%d", pl.ID)
+
+	first, second, third := pl.Entries[0].ID, pl.Entries[1].ID, pl.Entries[2].ID
+	if w := doRequest(t, server, http.MethodPost, path+"/reorder", fmt.Sprintf(`{"entry_ids":[%d,%d]}`, third, first), adminToken); w.Code != http.StatusBadRequest {
+		t.Errorf("reorder with a missing entry: expected 400, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodPost, path+"/reorder", fmt.Sprintf(`{"entry_ids":[%d,%d,%d]}`, third, first, first), adminToken); w.Code != http.StatusBadRequest {
+		t.Errorf("reorder with a duplicate entry: expected 400, got %d", w.Code)
+	}
+	w = doRequest(t, server, http.MethodPost, path+"/reorder", fmt.Sprintf(`{"entry_ids":[%d,%d,%d]}`, third, first, second), adminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("reorder: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := json.NewDecoder(w.Body).Decode(&pl); err != nil {
+		t.Fatalf("decode playlist: %v", err)
+	}
+	if got := []string{pl.Entries[0].SceneID, pl.Entries[1].SceneID, pl.Entries[2].SceneID}; got[0] != "wifi" || got[1] != "overview" || got[2] != "merchants" {
+		t.Errorf("unexpected order after reorder: %v", got)
+	}
+
+	// Pair a display and assign the playlist to it
+	registered := pairDisplay(t, server, st)
+	displayPath := "/v1/admin/displays/" + registered.ID
+	if w := doRequest(t, server, http.MethodPut, displayPath, `{"playlist_id":9999}`, adminToken); w.Code != http.StatusBadRequest {
+		t.Errorf("assign unknown playlist: expected 400, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodPut, displayPath, fmt.Sprintf(`{"playlist_id":%d}`, pl.ID), adminToken); w.Code != http.StatusOK {
+		t.Fatalf("assign playlist: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	cmds, err := st.PendingDisplayCommands(ctx, registered.ID, time.Now().Add(-time.Minute))
+	if err != nil || len(cmds) != 1 || cmds[0].Type != store.DisplayPlaylist {
+		t.Errorf("expected a playlist command after assignment, got %+v (%v)", cmds, err)
+	}
+
+	// Disabled scenes are skipped and durations filled in
+	if w := doRequest(t, server, http.MethodPut, "/v1/admin/scenes/wifi", `{"enabled":false}`, adminToken); w.Code != http.StatusOK {
+		t.Fatalf("disable scene: expected 200, got %d", w.Code)
+	}
+	var resolved struct {
+		ID      int64 `json:"id"`
+		Entries []struct {
+			SceneID  string            `json:"scene_id"`
+			Duration int64             `json:"duration"`
+			Params   store.SceneParams `json:"params"`
+		} `json:"entries"`
+	}
+	w = doRequest(t, server, http.MethodGet, "/v1/displays/playlist", "", registered.Token)
+	if err := json.NewDecoder(w.Body).Decode(&resolved); err != nil {
+		t.Fatalf("decode display playlist: %v", err)
+	}
+	if resolved.ID != pl.ID || len(resolved.Entries) != 2 || resolved.Entries[0].SceneID != "overview" ||
+		resolved.Entries[0].Duration != 10000 || resolved.Entries[1].Duration != 20000 || resolved.Entries[1].Params.Window != "1h" {
+		t.Errorf("unexpected resolved playlist: %+v", resolved)
+	}
+
+	// Deleting the playlist sends the display back to the default rotation
+	if w := doRequest(t, server, http.MethodDelete, path, "", adminToken); w.Code != http.StatusNoContent {
+		t.Fatalf("delete playlist: expected 204, got %d", w.Code)
+	}
+	w = doRequest(t, server, http.MethodGet, "/v1/displays/playlist", "", registered.Token)
+	if err := json.NewDecoder(w.Body).Decode(&resolved); err != nil {
+		t.Fatalf("decode display playlist: %v", err)
+	}
+	if resolved.ID != 0 || len(resolved.Entries) != 3 {
+		t.Errorf("expected the default rotation of enabled scenes, got %+v", resolved)
+	}
+}
+
+func TestContentSlides(t *testing.T) {
+	server, st := setupTestServer(t)
+	upload := func(name string, data []byte) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		part, _ := mw.CreateFormFile("file", name)
+		part.Write(data)
+		mw.Close()
+		return doRequestWithHeaders(t, server, http.MethodPost, "/v1/admin/assets", buf.String(), adminToken, map[string]string{"Content-Type": mw.FormDataContentType()})
+	}
+
+	if w := upload("notes.txt", []byte("just text")); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("text upload: expected 415, got %d", w.Code)
+	}
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)
+	w := upload("logo.png", png)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var asset store.Asset
+	if err := json.NewDecoder(w.Body).Decode(&asset); err != nil {
+		t.Fatalf("decode asset: %v", err)
+	}
+	if asset.ContentType != "image/png" || asset.Size != int64(len(png)) || asset.Name != "logo.png" {
+		t.Fatalf("unexpected asset: %+v", asset)
+	}
+	w = doRequest(t, server, http.MethodGet, "/v1/assets/"+asset.ID, "", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || !bytes.Equal(w.Body.Bytes(), png) {
+		t.Errorf("serve asset: got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	now := time.Now().UTC()
+	invalid := map[string]string{
+		"missing content": `{"id":"acme","name":"Acme","type":"content"}`,
+		"unknown asset":   `{"id":"acme","name":"Acme","type":"content","content":{"asset_id":"nope"}}`,
+		"bad window":      fmt.Sprintf(`{"id":"acme","name":"Acme","type":"content","content":{"title":"Acme","schedule":[{"start":%q,"end":%q}]}}`, now.Format(time.RFC3339), now.Add(-time.Hour).Format(time.RFC3339)),
+		"builtin content": `{"id":"acme","name":"Acme","content":{"title":"Acme"}}`,
+	}
+	for name, body := range invalid {
+		if w := doRequest(t, server, http.MethodPost, "/v1/admin/scenes", body, adminToken); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+	body := fmt.Sprintf(`{"id":"acme","name":"Acme","duration":8000,"order":5,"type":"content",
+		"content":{"title":"Powered by Acme","body":"Lightning for everyone","asset_id":%q,"sponsor":"Acme",
+		"schedule":[{"start":%q,"end":%q}]}}`, asset.ID, now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339))
+	if w := doRequest(t, server, http.MethodPost, "/v1/admin/scenes", body, adminToken); w.Code != http.StatusCreated {
+		t.Fatalf("create slide: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	later := fmt.Sprintf(`{"id":"tomorrow","name":"Tomorrow","type":"content","content":{"title":"Tomorrow","sponsor":"Beta",
+		"schedule":[{"start":%q,"end":%q}]}}`, now.Add(24*time.Hour).Format(time.RFC3339), now.Add(25*time.Hour).Format(time.RFC3339))
+	if w := doRequest(t, server, http.MethodPost, "/v1/admin/scenes", later, adminToken); w.Code != http.StatusCreated {
+		t.Fatalf("create scheduled slide: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var scenes []store.Scene
+	if err := json.NewDecoder(doRequest(t, server, http.MethodGet, "/v1/scenes", "", "").Body).Decode(&scenes); err != nil {
+		t.Fatalf("decode scenes: %v", err)
+	}
+	var ids []string
+	for _, sc := range scenes {
+		ids = append(ids, sc.ID)
+		if sc.ID == "acme" && (sc.Type != store.SceneContent || sc.Content == nil || sc.Content.AssetID != asset.ID) {
+			t.Errorf("unexpected slide: %+v", sc)
+		}
+		if sc.ID == "overview" && (sc.Type != store.SceneBuiltin || sc.Content != nil) {
+			t.Errorf("unexpected builtin scene: %+v", sc)
+		}
+	}
+	if got := strings.Join(ids, ","); got != "overview,merchants,wifi,merch,acme" {
+		t.Errorf("expected the scheduled-later slide to be left out, got %s", got)
+	}
+
+	if w := doRequest(t, server, http.MethodDelete, "/v1/admin/assets/"+asset.ID, "", adminToken); w.Code != http.StatusConflict {
+		t.Errorf("delete used asset: expected 409, got %d", w.Code)
+	}
+
+	// Impressions come in through heartbeats of an approved display
+	registered := pairDisplay(t, server, st)
+	shown := now.Add(-time.Minute).Format(time.RFC3339)
+	heartbeat := fmt.Sprintf(`{"scene":"overview","impressions":[
+		{"scene_id":"acme","shown_at":%q,"seconds":8},
+		{"scene_id":"acme","shown_at":%q,"seconds":8},
+		{"scene_id":"overview","shown_at":%q,"seconds":10},
+		{"scene_id":"acme","shown_at":%q,"seconds":-3}
+	]}`, shown, now.Add(-30*time.Second).Format(time.RFC3339), shown, now.Format(time.RFC3339))
+	for i := 0; i < 2; i++ { // a retried heartbeat counts once
+		if w := doRequest(t, server, http.MethodPost, "/v1/displays/heartbeat", heartbeat, registered.Token); w.Code != http.StatusOK {
+			t.Fatalf("heartbeat: expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	var report []store.SlideAirtime
+	if err := json.NewDecoder(doRequest(t, server, http.MethodGet, "/v1/admin/slides/airtime", "", adminToken).Body).Decode(&report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if len(report) != 2 || report[0].SceneID != "acme" || report[0].Impressions != 2 || report[0].AirtimeSeconds != 16 ||
+		report[0].Displays != 1 || report[1].Impressions != 0 {
+		t.Errorf("unexpected airtime report: %+v", report)
+	}
+	w = doRequest(t, server, http.MethodGet, "/v1/admin/slides/airtime?from="+now.Add(-45*time.Second).Format(time.RFC3339), "", adminToken)
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if report[0].Impressions != 1 {
+		t.Errorf("expected 1 impression after from, got %+v", report[0])
+	}
+}
+
+func TestAnnouncements(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+	active := func(path string) []store.Announcement {
+		t.Helper()
+		var items []store.Announcement
+		w := doRequest(t, server, http.MethodGet, path, "", "")
+		if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+		return items
+	}
+
+	display := pairDisplay(t, server, st)
+
+	now := time.Now().UTC()
+	invalid := map[string]string{
+		"empty message":   `{"message":"  "}`,
+		"bad priority":    `{"message":"Hi","priority":"critical"}`,
+		"unknown scene":   `{"message":"Hi","scene_ids":["nope"]}`,
+		"unknown display": `{"message":"Hi","display_ids":["nope"]}`,
+		"ends first":      fmt.Sprintf(`{"message":"Hi","ends_at":%q}`, now.Add(-time.Hour).Format(time.RFC3339)),
+		"too long":        fmt.Sprintf(`{"message":%q}`, strings.Repeat("x", 281)),
+	}
+	for name, body := range invalid {
+		if w := doRequest(t, server, http.MethodPost, "/v1/admin/announcements", body, adminToken); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	create := func(body string) store.Announcement {
+		t.Helper()
+		w := doRequest(t, server, http.MethodPost, "/v1/admin/announcements", body, adminToken)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create: expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+		var a store.Announcement
+		if err := json.NewDecoder(w.Body).Decode(&a); err != nil {
+			t.Fatalf("decode announcement: %v", err)
+		}
+		return a
+	}
+	general := create(`{"message":"Welcome to the conference"}`)
+	if general.Priority != store.PriorityNormal || general.StartsAt.IsZero() || general.EndsAt != nil {
+		t.Errorf("unexpected defaults: %+v", general)
+	}
+	keynote := create(fmt.Sprintf(`{"message":"Keynote starts in 5 min in Hall A","priority":"urgent","display_ids":[%q]}`, display.ID))
+	create(`{"message":"Merch is 20% off","priority":"low","scene_ids":["merch"]}`)
+	create(fmt.Sprintf(`{"message":"Closing party","starts_at":%q}`, now.Add(time.Hour).Format(time.RFC3339)))
+
+	// An unidentified screen only gets untargeted announcements
+	if got := active("/v1/announcements"); len(got) != 2 || got[0].ID != general.ID {
+		t.Errorf("public announcements: got %+v", got)
+	}
+	got := active("/v1/announcements?display=" + display.ID + "&scene=merch")
+	if len(got) != 3 || got[0].ID != keynote.ID || got[2].Priority != store.PriorityLow {
+		t.Errorf("expected keynote first and merch last, got %+v", got)
+	}
+	if got := active("/v1/announcements?display=" + display.ID + "&scene=overview"); len(got) != 2 {
+		t.Errorf("expected the merch announcement to be left out on other scenes, got %+v", got)
+	}
+
+	// The display sees its own announcements plus upcoming ones
+	w := doRequest(t, server, http.MethodGet, "/v1/displays/announcements", "", display.Token)
+	var own []store.Announcement
+	if err := json.NewDecoder(w.Body).Decode(&own); err != nil {
+		t.Fatalf("decode display announcements: %v", err)
+	}
+	if len(own) != 4 {
+		t.Errorf("expected 4 display announcements, got %+v", own)
+	}
+	commands, err := st.PendingDisplayCommands(ctx, display.ID, now.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("pending commands: %v", err)
+	}
+	if len(commands) != 4 || commands[0].Type != store.DisplayAnnouncements {
+		t.Errorf("expected an announcements command per change, got %+v", commands)
+	}
+
+	path := fmt.Sprintf("/v1/admin/announcements/%d", keynote.ID)
+	ended := fmt.Sprintf(`{"starts_at":%q,"ends_at":%q}`, now.Add(-time.Hour).Format(time.RFC3339), now.Add(-time.Minute).Format(time.RFC3339))
+	if w := doRequest(t, server, http.MethodPut, path, ended, adminToken); w.Code != http.StatusOK {
+		t.Fatalf("update: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := active("/v1/announcements?display=" + display.ID + "&scene=overview"); len(got) != 1 {
+		t.Errorf("expected the ended keynote to drop off, got %+v", got)
+	}
+	var all []store.Announcement
+	json.NewDecoder(doRequest(t, server, http.MethodGet, "/v1/admin/announcements", "", adminToken).Body).Decode(&all)
+	var current []store.Announcement
+	json.NewDecoder(doRequest(t, server, http.MethodGet, "/v1/admin/announcements?current=true", "", adminToken).Body).Decode(&current)
+	if len(all) != 4 || len(current) != 3 {
+		t.Errorf("expected 4 announcements and 3 current, got %d and %d", len(all), len(current))
+	}
+
+	if w := doRequest(t, server, http.MethodDelete, path, "", adminToken); w.Code != http.StatusNoContent {
+		t.Errorf("delete: expected 204, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodGet, path, "", adminToken); w.Code != http.StatusNotFound {
+		t.Errorf("get deleted: expected 404, got %d", w.Code)
+	}
+}
+
+func TestMilestoneProgress(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+	if err := st.UpsertMerchant(ctx, store.Merchant{ID: "m1", PublicKey: "pk", Alias: "Merchant", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+
+	milestones := []store.Milestone{
+		{Name: "2000 sats", Type: store.MilestoneVolume, Threshold: 2000, Enabled: true},
+		{Name: "Every 4 sales", Type: store.MilestoneTransactions, Threshold: 4, Recurring: true, Enabled: true},
+		{Name: "Whale", Type: store.MilestoneSingleTransaction, Threshold: 5000, Enabled: true},
+		{Name: "Paused", Type: store.MilestoneVolume, Threshold: 5000},
+		{Name: "First sale", Type: store.MilestoneTransactions, Threshold: 1, Enabled: true},
+	}
+	for _, m := range milestones {
+		if _, err := st.UpsertMilestone(ctx, m); err != nil {
+			t.Fatalf("upsert %s: %v", m.Name, err)
+		}
+	}
+	// One 100 sat sale a minute for the last ten minutes
+	now := time.Now()
+	var txs []store.TransactionInput
+	for i := 0; i < 10; i++ {
+		txs = append(txs, store.TransactionInput{SaleID: int64(i + 1), SaleDate: now.Add(-time.Duration(i)*time.Minute - 30*time.Second), AmountSats: 100})
+	}
+	if _, err := st.RecordTransactions(ctx, "m1", txs); err != nil {
+		t.Fatalf("record transactions: %v", err)
+	}
+	if _, err := st.ProcessMilestones(ctx); err != nil {
+		t.Fatalf("process milestones: %v", err)
+	}
+
+	w := doRequest(t, server, http.MethodGet, "/v1/milestones/progress?window=10m", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var progress []store.MilestoneProgress
+	if err := json.NewDecoder(w.Body).Decode(&progress); err != nil {
+		t.Fatalf("decode progress: %v", err)
+	}
+	byName := make(map[string]store.MilestoneProgress)
+	for _, p := range progress {
+		byName[p.Name] = p
+	}
+	if len(progress) != 3 {
+		t.Fatalf("expected only enabled, untriggered milestones, got %+v", progress)
+	}
+
+	volume := byName["2000 sats"]
+	if volume.Current != 1000 || volume.Remaining != 1000 || volume.Percent != 50 {
+		t.Errorf("unexpected volume progress: %+v", volume)
+	}
+	if volume.RatePerMinute == nil || *volume.RatePerMinute != 100 || volume.ETASeconds == nil || *volume.ETASeconds != 600 {
+		t.Errorf("expected 100 sats/min and a 10 minute ETA, got %+v", volume)
+	}
+
+	// Ten sales passed crossings 1 and 2; the next is 12, half a step away
+	series := byName["Every 4 sales"]
+	if series.Ordinal != 3 || series.Target != 12 || series.Remaining != 2 || series.Percent != 50 ||
+		series.ETASeconds == nil || *series.ETASeconds != 120 {
+		t.Errorf("unexpected recurring progress: %+v", series)
+	}
+
+	whale := byName["Whale"]
+	if whale.Current != 100 || whale.Percent != 2 || whale.RatePerMinute != nil || whale.ETASeconds != nil {
+		t.Errorf("expected no ETA for single payments, got %+v", whale)
+	}
+
+	w = doRequest(t, server, http.MethodGet, "/v1/milestones/progress?window=soon", "", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid window: expected 400, got %d", w.Code)
+	}
+}
+
+func TestMilestoneTriggerCursors(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+	list := func(path, token string) []store.MilestoneTrigger {
+		t.Helper()
+		w := doRequest(t, server, http.MethodGet, path, "", token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		var items []store.MilestoneTrigger
+		if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+		return items
+	}
+
+	token := pairDisplay(t, server, st).Token
+	if err := st.UpsertMerchant(ctx, store.Merchant{ID: "m1", PublicKey: "pk", Alias: "Merchant", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+	for _, threshold := range []int64{1, 2} {
+		m := store.Milestone{Name: fmt.Sprintf("%d sales", threshold), Type: store.MilestoneTransactions, Threshold: threshold, Enabled: true}
+		if _, err := st.UpsertMilestone(ctx, m); err != nil {
+			t.Fatalf("upsert milestone: %v", err)
+		}
+	}
+	if _, err := st.RecordTransactions(ctx, "m1", []store.TransactionInput{
+		{SaleID: 1, SaleDate: time.Now().Add(-time.Minute), AmountSats: 100},
+		{SaleID: 2, SaleDate: time.Now(), AmountSats: 100},
+	}); err != nil {
+		t.Fatalf("record transactions: %v", err)
+	}
+	if _, err := st.ProcessMilestones(ctx); err != nil {
+		t.Fatalf("process milestones: %v", err)
+	}
+
+	all := list("/v1/milestones/triggers?after_id=0", "")
+	if len(all) != 2 || all[0].ID >= all[1].ID {
+		t.Fatalf("expected two triggers oldest first, got %+v", all)
+	}
+	first, second := all[0], all[1]
+	if got := list(fmt.Sprintf("/v1/milestones/triggers?after_id=%d", first.ID), ""); len(got) != 1 || got[0].ID != second.ID {
+		t.Errorf("expected only the trigger after the cursor, got %+v", got)
+	}
+	for _, path := range []string{"/v1/milestones/triggers?after_id=x", "/v1/milestones/triggers?after_id=1&since=2025-01-01T00:00:00Z"} {
+		if w := doRequest(t, server, http.MethodGet, path, "", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: expected 400, got %d", path, w.Code)
+		}
+	}
+
+	// A display gets what it hasn't acknowledged yet
+	if got := list("/v1/displays/triggers", token); len(got) != 2 {
+		t.Fatalf("expected both triggers for the display, got %+v", got)
+	}
+	ack := fmt.Sprintf("/v1/displays/triggers/%d/ack", first.ID)
+	for range 2 {
+		if w := doRequest(t, server, http.MethodPost, ack, "", token); w.Code != http.StatusNoContent {
+			t.Fatalf("ack: expected 204, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if got := list("/v1/displays/triggers", token); len(got) != 1 || got[0].ID != second.ID {
+		t.Errorf("expected the acknowledged trigger to be left out, got %+v", got)
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/displays/triggers/999/ack", "", token); w.Code != http.StatusNotFound {
+		t.Errorf("ack unknown trigger: expected 404, got %d", w.Code)
+	}
+
+	w := doRequest(t, server, http.MethodGet, "/v1/admin/milestones/triggers", "", adminToken)
+	var reports []store.MilestoneTriggerReport
+	if err := json.NewDecoder(w.Body).Decode(&reports); err != nil {
+		t.Fatalf("decode reports: %v", err)
+	}
+	if len(reports) != 2 || len(reports[0].Acks) != 1 || reports[0].Acks[0].DisplayName != "Hall A" || len(reports[1].Acks) != 0 {
+		t.Fatalf("unexpected ack report: %+v", reports)
+	}
+
+	// Re-firing plays it again without resetting the milestone
+	w = doRequest(t, server, http.MethodPost, fmt.Sprintf("/v1/admin/milestones/triggers/%d/refire", first.ID), "", adminToken)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("refire: expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var refired store.MilestoneTrigger
+	if err := json.NewDecoder(w.Body).Decode(&refired); err != nil {
+		t.Fatalf("decode refire: %v", err)
+	}
+	if refired.ID <= second.ID || refired.RefireOf == nil || *refired.RefireOf != first.ID || refired.MilestoneID != first.MilestoneID {
+		t.Errorf("unexpected re-fire: %+v", refired)
+	}
+	if got := list("/v1/displays/triggers", token); len(got) != 2 || got[1].ID != refired.ID {
+		t.Errorf("expected the display to get the re-fire, got %+v", got)
+	}
+	milestones, err := st.ListMilestones(ctx, false)
+	if err != nil {
+		t.Fatalf("list milestones: %v", err)
+	}
+	for _, m := range milestones {
+		if !m.Triggered {
+			t.Errorf("milestone %s was reset by the re-fire", m.Name)
+		}
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/admin/milestones/triggers/999/refire", "", adminToken); w.Code != http.StatusNotFound {
+		t.Errorf("refire unknown trigger: expected 404, got %d", w.Code)
+	}
+}
+
+func TestMilestoneLifecycle(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+	milestone := func(w *httptest.ResponseRecorder, status int) store.Milestone {
+		t.Helper()
+		if w.Code != status {
+			t.Fatalf("expected %d, got %d: %s", status, w.Code, w.Body.String())
+		}
+		var m store.Milestone
+		if err := json.NewDecoder(w.Body).Decode(&m); err != nil {
+			t.Fatalf("decode milestone: %v", err)
+		}
+		return m
+	}
+	list := func(path string) []store.Milestone {
+		t.Helper()
+		var items []store.Milestone
+		if err := json.NewDecoder(doRequest(t, server, http.MethodGet, path, "", adminToken).Body).Decode(&items); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+		return items
+	}
+
+	// Omitted fields default on create and are kept on update
+	m := milestone(doRequest(t, server, http.MethodPost, "/v1/admin/milestones", `{"name":"Sales","type":"transactions","threshold":10}`, adminToken), http.StatusCreated)
+	if !m.Enabled {
+		t.Errorf("expected milestones to be enabled by default")
+	}
+	path := fmt.Sprintf("/v1/admin/milestones/%d", m.ID)
+	m = milestone(doRequest(t, server, http.MethodPatch, path, `{"threshold":2}`, adminToken), http.StatusOK)
+	if m.Name != "Sales" || m.Threshold != 2 || !m.Enabled {
+		t.Errorf("unexpected patched milestone: %+v", m)
+	}
+	if err := st.UpsertMerchant(ctx, store.Merchant{ID: "m1", PublicKey: "pk", Alias: "Merchant", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+	if _, err := st.RecordTransactions(ctx, "m1", []store.TransactionInput{
+		{SaleID: 1, SaleDate: time.Now(), AmountSats: 100},
+		{SaleID: 2, SaleDate: time.Now(), AmountSats: 100},
+	}); err != nil {
+		t.Fatalf("record transactions: %v", err)
+	}
+	if _, err := st.ProcessMilestones(ctx); err != nil {
+		t.Fatalf("process milestones: %v", err)
+	}
+	m = milestone(doRequest(t, server, http.MethodPut, path, `{"name":"Two sales"}`, adminToken), http.StatusOK)
+	if m.Name != "Two sales" || !m.Enabled || !m.Triggered {
+		t.Errorf("expected a rename to keep the rest, got %+v", m)
+	}
+	if w := doRequest(t, server, http.MethodPatch, path, `{"type":"rate"}`, adminToken); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid patch: expected 400, got %d", w.Code)
+	}
+
+	// Copies start disabled and untriggered
+	dup := milestone(doRequest(t, server, http.MethodPost, path+"/duplicate", "", adminToken), http.StatusCreated)
+	if dup.Name != "Two sales (copy)" || dup.Enabled || dup.Triggered || dup.Threshold != 2 {
+		t.Errorf("unexpected duplicate: %+v", dup)
+	}
+	named := milestone(doRequest(t, server, http.MethodPost, path+"/duplicate", `{"name":"Again"}`, adminToken), http.StatusCreated)
+	if named.Name != "Again" {
+		t.Errorf("expected the given name, got %q", named.Name)
+	}
+
+	// Archived milestones are hidden and never fire
+	dupPath := fmt.Sprintf("/v1/admin/milestones/%d", dup.ID)
+	milestone(doRequest(t, server, http.MethodPatch, dupPath, `{"enabled":true}`, adminToken), http.StatusOK)
+	if a := milestone(doRequest(t, server, http.MethodPost, dupPath+"/archive", "", adminToken), http.StatusOK); !a.Archived || a.ArchivedAt == nil {
+		t.Errorf("expected an archived milestone, got %+v", a)
+	}
+	if got := list("/v1/admin/milestones"); len(got) != 2 {
+		t.Errorf("expected archived milestones to be hidden, got %+v", got)
+	}
+	if got := list("/v1/admin/milestones?archived=true"); len(got) != 3 {
+		t.Errorf("expected archived milestones with archived=true, got %+v", got)
+	}
+	if triggers, err := st.ProcessMilestones(ctx); err != nil || len(triggers) != 0 {
+		t.Errorf("expected archived milestones not to fire, got %+v, %v", triggers, err)
+	}
+	if a := milestone(doRequest(t, server, http.MethodPost, dupPath+"/unarchive", "", adminToken), http.StatusOK); a.Archived {
+		t.Errorf("expected the milestone to be restored, got %+v", a)
+	}
+
+	// Deleting keeps the trigger history
+	if w := doRequest(t, server, http.MethodDelete, path, "", adminToken); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if w := doRequest(t, server, method, path, "", adminToken); w.Code != http.StatusNotFound {
+			t.Errorf("%s deleted milestone: expected 404, got %d", method, w.Code)
+		}
+	}
+	triggers, err := st.ListMilestoneTriggers(ctx, store.MilestoneTriggerFilter{})
+	if err != nil {
+		t.Fatalf("list triggers: %v", err)
+	}
+	if len(triggers) != 1 || triggers[0].MilestoneID != m.ID || triggers[0].Name != "Sales" {
+		t.Errorf("expected the deleted milestone's trigger to be kept, got %+v", triggers)
+	}
+
+	// Templates import once
+	var templates []store.MilestoneTemplate
+	if err := json.NewDecoder(doRequest(t, server, http.MethodGet, "/v1/admin/milestones/templates", "", adminToken).Body).Decode(&templates); err != nil {
+		t.Fatalf("decode templates: %v", err)
+	}
+	if len(templates) == 0 {
+		t.Fatal("expected built-in templates")
+	}
+	for _, tmpl := range templates {
+		w := doRequest(t, server, http.MethodPost, "/v1/admin/milestones/templates/"+tmpl.ID+"/import", "", adminToken)
+		var created []store.Milestone
+		if w.Code != http.StatusCreated || json.NewDecoder(w.Body).Decode(&created) != nil || len(created) != len(tmpl.Milestones) {
+			t.Errorf("import %s: expected %d milestones, got %d: %s", tmpl.ID, len(tmpl.Milestones), w.Code, w.Body.String())
+		}
+	}
+	w := doRequest(t, server, http.MethodPost, "/v1/admin/milestones/templates/conference-ladder/import", "", adminToken)
+	if w.Code != http.StatusCreated || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("second import: expected nothing created, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/admin/milestones/templates/nope/import", "", adminToken); w.Code != http.StatusNotFound {
+		t.Errorf("unknown template: expected 404, got %d", w.Code)
+	}
+}
+
+func TestMilestoneWindowsPayload(t *testing.T) {
+	server, _ := setupTestServer(t)
+	save := func(method, path, body string) store.Milestone {
+		t.Helper()
+		w := doRequest(t, server, method, path, body, adminToken)
+		if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+			t.Fatalf("%s %s: got %d: %s", method, path, w.Code, w.Body.String())
+		}
+		var m store.Milestone
+		if err := json.NewDecoder(w.Body).Decode(&m); err != nil {
+			t.Fatalf("decode milestone: %v", err)
+		}
+		return m
+	}
+
+	m := save(http.MethodPost, "/v1/admin/milestones", `{"name":"Lunch","type":"volume","threshold":1000,
+		"active_from":"2025-11-17T12:00:00Z","active_until":"2025-11-17T13:30:00Z",
+		"measure_from":"2025-11-17T12:00:00Z","measure_until":"2025-11-17T13:00:00Z"}`)
+	if m.ActiveFrom == nil || m.ActiveUntil == nil || m.MeasureFrom == nil || !m.MeasureUntil.Equal(time.Date(2025, 11, 17, 13, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected all windows to be set, got %+v", m)
+	}
+	// Omitted bounds are kept, null ones cleared
+	path := fmt.Sprintf("/v1/admin/milestones/%d", m.ID)
+	m = save(http.MethodPatch, path, `{"active_until":null,"measure_until":"2025-11-17T14:00:00Z"}`)
+	if m.ActiveFrom == nil || m.ActiveUntil != nil || m.MeasureFrom == nil || !m.MeasureUntil.Equal(time.Date(2025, 11, 17, 14, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected windows after patch: %+v", m)
+	}
+}
+
+func TestMilestonePreview(t *testing.T) {
+	server, _ := setupTestServer(t)
+
+	w := doRequest(t, server, http.MethodPost, "/v1/admin/milestones/preview", `{"name":"Quiet","type":"expression","params":{"condition":"transactions < 1 && rate(\"5m\") == 0"}}`, adminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("preview: got %d: %s", w.Code, w.Body.String())
+	}
+	var preview store.MilestonePreview
+	if err := json.NewDecoder(w.Body).Decode(&preview); err != nil {
+		t.Fatalf("decode preview: %v", err)
+	}
+	if !preview.WouldTrigger || len(preview.Metrics) != 2 {
+		t.Errorf("unexpected preview: %+v", preview)
+	}
+
+	w = doRequest(t, server, http.MethodPost, "/v1/admin/milestones/preview", `{"name":"Broken","type":"expression","params":{"condition":"volume >"}}`, adminToken)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid condition at 8") {
+		t.Errorf("expected a positioned parse error, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, server, http.MethodGet, "/v1/admin/milestones", "", adminToken); strings.Contains(w.Body.String(), "Quiet") {
+		t.Error("previews must not save milestones")
+	}
+
+	w = doRequest(t, server, http.MethodGet, "/v1/admin/milestones/metrics", "", adminToken)
+	var metrics map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&metrics); err != nil || metrics["source_volume"] == "" {
+		t.Errorf("expected the metrics catalogue, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSimulateMilestonesEndpoint(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+	if err := st.UpsertMerchant(ctx, store.Merchant{ID: "bar", PublicKey: "pk", Alias: "Bar", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+	if _, err := st.RecordTransactions(ctx, "bar", []store.TransactionInput{
+		{SaleID: 1, SaleDate: time.Now().Add(-time.Hour), AmountSats: 600},
+	}); err != nil {
+		t.Fatalf("record transaction: %v", err)
+	}
+
+	w := doRequest(t, server, http.MethodPost, "/v1/admin/milestones/simulate", `{"milestones":[{"name":"500 sats","type":"volume","threshold":500},{"name":"1k sats","type":"volume","threshold":1000}]}`, adminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("simulate: got %d: %s", w.Code, w.Body.String())
+	}
+	var sim store.MilestoneSimulation
+	if err := json.NewDecoder(w.Body).Decode(&sim); err != nil {
+		t.Fatalf("decode simulation: %v", err)
+	}
+	if len(sim.Triggers) != 1 || sim.Triggers[0].Name != "500 sats" || sim.Transactions != 1 {
+		t.Errorf("unexpected simulation: %+v", sim)
+	}
+	if w := doRequest(t, server, http.MethodPost, "/v1/admin/milestones/simulate", `{"milestones":[{"name":"x","type":"volume","threshold":1,"params":{"window":"5m"}}]}`, adminToken); w.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid candidates to be rejected, got %d", w.Code)
+	}
+}
+
+func TestMilestoneCelebrations(t *testing.T) {
+	server, st := setupTestServer(t)
+	ctx := context.Background()
+	upload := func(name string, data []byte) store.Asset {
+		t.Helper()
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		part, _ := mw.CreateFormFile("file", name)
+		part.Write(data)
+		mw.Close()
+		w := doRequestWithHeaders(t, server, http.MethodPost, "/v1/admin/assets", buf.String(), adminToken, map[string]string{"Content-Type": mw.FormDataContentType()})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("upload %s: got %d: %s", name, w.Code, w.Body.String())
+		}
+		var asset store.Asset
+		if err := json.NewDecoder(w.Body).Decode(&asset); err != nil {
+			t.Fatalf("decode asset: %v", err)
+		}
+		return asset
+	}
+	image := upload("confetti.png", append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...))
+	sound := upload("fanfare.mp3", append([]byte("ID3"), make([]byte, 64)...))
+	if sound.ContentType != "audio/mpeg" || sound.Kind() != "audio" {
+		t.Fatalf("unexpected sound asset: %+v", sound)
+	}
+	if w := doRequestWithHeaders(t, server, http.MethodGet, "/v1/assets/"+sound.ID, "", adminToken, map[string]string{"Range": "bytes=0-2"}); w.Code != http.StatusPartialContent || w.Body.String() != "ID3" {
+		t.Errorf("range request: got %d %q", w.Code, w.Body.String())
+	}
+
+	invalid := map[string]string{
+		"sound as picture": fmt.Sprintf(`{"asset_id":%q}`, sound.ID),
+		"picture as sound": fmt.Sprintf(`{"sound_asset_id":%q}`, image.ID),
+		"unknown asset":    `{"asset_id":"nope"}`,
+		"too long":         `{"duration":120000}`,
+	}
+	for name, celebration := range invalid {
+		w := doRequest(t, server, http.MethodPost, "/v1/admin/milestones", `{"name":"First sale","type":"transactions","threshold":1,"celebration":`+celebration+`}`, adminToken)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, w.Code)
+		}
+	}
+	want := store.MilestoneCelebration{AssetID: image.ID, SoundAssetID: sound.ID, Text: "We're live!", Duration: 8000}
+	body, _ := json.Marshal(map[string]any{"name": "First sale", "type": "transactions", "threshold": 1, "celebration": want})
+	w := doRequest(t, server, http.MethodPost, "/v1/admin/milestones", string(body), adminToken)
+	var m store.Milestone
+	if err := json.NewDecoder(w.Body).Decode(&m); err != nil || w.Code != http.StatusCreated || m.Celebration != want {
+		t.Fatalf("create: got %d %+v", w.Code, m)
+	}
+
+	if err := st.UpsertMerchant(ctx, store.Merchant{ID: "bar", PublicKey: "pk", Alias: "Bar", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+	if _, err := st.RecordTransactions(ctx, "bar", []store.TransactionInput{{SaleID: 1, SaleDate: time.Now(), AmountSats: 100}}); err != nil {
+		t.Fatalf("record transaction: %v", err)
+	}
+	if triggers, err := st.ProcessMilestones(ctx); err != nil || len(triggers) != 1 || triggers[0].Celebration != want {
+		t.Fatalf("process milestones: %+v, %v", triggers, err)
+	}
+	// Triggers keep the celebration they fired with
+	if w := doRequest(t, server, http.MethodPatch, fmt.Sprintf("/v1/admin/milestones/%d", m.ID), `{"celebration":{}}`, adminToken); w.Code != http.StatusOK {
+		t.Fatalf("clear celebration: got %d: %s", w.Code, w.Body.String())
+	}
+	w = doRequest(t, server, http.MethodGet, "/v1/admin/milestones/triggers", "", adminToken)
+	var reports []store.MilestoneTriggerReport
+	if err := json.NewDecoder(w.Body).Decode(&reports); err != nil || len(reports) != 1 || reports[0].Celebration != want {
+		t.Errorf("expected the recorded celebration, got %s", w.Body.String())
+	}
+
+	if w := doRequest(t, server, http.MethodDelete, "/v1/admin/assets/"+image.ID, "", adminToken); w.Code != http.StatusConflict {
+		t.Errorf("delete asset of a recorded trigger: expected 409, got %d", w.Code)
+	}
+	unused := upload("spare.png", append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 32)...))
+	if w := doRequest(t, server, http.MethodGet, "/v1/assets/"+unused.ID, "", ""); w.Code != http.StatusOK {
+		t.Fatalf("serve unused asset: got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodDelete, "/v1/admin/assets/"+unused.ID, "", adminToken); w.Code != http.StatusNoContent {
+		t.Errorf("delete unused asset: expected 204, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodGet, "/v1/assets/"+unused.ID, "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("serve deleted asset: expected 404, got %d", w.Code)
+	}
+	if w := doRequest(t, server, http.MethodPatch, fmt.Sprintf("/v1/admin/milestones/%d", m.ID), fmt.Sprintf(`{"celebration":{"sound_asset_id":%q}}`, sound.ID), adminToken); w.Code != http.StatusOK {
+		t.Fatalf("set sound: got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(t, server, http.MethodDelete, "/v1/admin/assets/"+sound.ID, "", adminToken); w.Code != http.StatusConflict {
+		t.Errorf("delete used sound: expected 409, got %d", w.Code)
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/adopting-bitcoin/dashboard/internal/config"
+)
+
+type settingView struct {
+	Value      any  `json:"value"`
+	Default    any  `json:"default"`
+	Overridden bool `json:"overridden"`
+}
+
+// LoadSettings applies setting overrides stored in the database on top of the
+// environment config and reconfigures the poller to match.
+func (s *Server) LoadSettings(ctx context.Context) error {
+	stored, err := s.store.Settings(ctx)
+	if err != nil {
+		return err
+	}
+	next, err := s.cfg.WithSettings(stored)
+	if err != nil {
+		return err
+	}
+	if err := next.Validate(); err != nil {
+		return err
+	}
+	s.applyConfig(next)
+	return nil
+}
+
+func (s *Server) applyConfig(next config.Config) {
+	s.live.Store(&next)
+	s.poller.Reconfigure(next.PollInterval, next.PollConcurrency)
+}
+
+func (s *Server) handleGetSettings(w http.ResponseWriter, r *http.Request) {
+	stored, err := s.store.Settings(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.settingsView(stored))
+}
+
+// handlePatchSettings updates settings given as a JSON object. Values use the
+// environment variable format (numbers, or durations such as "30s"); null
+// resets a setting to its environment default.
+func (s *Server) handlePatchSettings(w http.ResponseWriter, r *http.Request) {
+	var payload map[string]json.RawMessage
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	changes := make(map[string]*string, len(payload))
+	for key, raw := range payload {
+		if string(raw) == "null" {
+			changes[key] = nil
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			// Numbers are accepted as-is.
+			value = strings.TrimSpace(string(raw))
+		}
+		changes[key] = &value
+	}
+
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	stored, err := s.store.Settings(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(stored, key)
+		} else {
+			stored[key] = *value
+		}
+	}
+	next, err := s.cfg.WithSettings(stored)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := next.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid settings: %w", err))
+		return
+	}
+	if err := s.store.SaveSettings(r.Context(), changes); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.applyConfig(next)
+	// Saving bumped the data version before the new config was live, so a
+	// request in between may have cached a response built with the old one
+	s.store.Touch()
+	s.logger.Printf("settings updated: %d change(s)\n", len(changes))
+	writeJSON(w, http.StatusOK, s.settingsView(stored))
+}
+
+func (s *Server) settingsView(stored map[string]string) map[string]settingView {
+	live := s.config()
+	out := make(map[string]settingView, len(config.SettingKeys))
+	for _, key := range config.SettingKeys {
+		_, overridden := stored[key]
+		out[key] = settingView{
+			Value:      live.SettingValue(key),
+			Default:    s.cfg.SettingValue(key),
+			Overridden: overridden,
+		}
+	}
+	return out
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/adopting-bitcoin/dashboard/internal/store"
+)
+
+// defaultTriggerLimit caps a page of cursor results; clients follow up with
+// the last id they got.
+const defaultTriggerLimit = 100
+
+// triggerFilter reads the after_id cursor, since and limit query
+// parameters.
+func triggerFilter(r *http.Request) (store.MilestoneTriggerFilter, error) {
+	q := r.URL.Query()
+	filter := store.MilestoneTriggerFilter{Limit: parseIntQuery(r, "limit", defaultTriggerLimit)}
+	if raw := q.Get("after_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id < 0 {
+			return filter, errors.New("invalid after_id")
+		}
+		filter.AfterID = id
+	}
+	if raw := q.Get("since"); raw != "" {
+		since, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return filter, errors.New("invalid since: use RFC 3339")
+		}
+		filter.Since = since
+	}
+	return filter, nil
+}
+
+// handleDisplayTriggers returns the triggers a display still has to play:
+// ones recorded since it was approved that it hasn't acknowledged, oldest
+// first. after_id skips ahead further.
+func (s *Server) handleDisplayTriggers(w http.ResponseWriter, r *http.Request) {
+	d := displayFrom(r)
+	if d.Status != store.DisplayApproved {
+		writeError(w, http.StatusForbidden, store.ErrDisplayNotApproved)
+		return
+	}
+	filter, err := triggerFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.DisplayID = d.ID
+	if d.ApprovedAt != nil && d.ApprovedAt.After(filter.Since) {
+		filter.Since = *d.ApprovedAt
+	}
+	pl, err := s.displayPlaylist(r.Context(), d)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	triggers, err := s.store.ForEvent(pl.EventID).ListMilestoneTriggers(r.Context(), filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, triggers)
+}
+
+// handleAckTrigger records that the display played a trigger, so it isn't
+// handed out to that display again.
+func (s *Server) handleAckTrigger(w http.ResponseWriter, r *http.Request) {
+	d := displayFrom(r)
+	if d.Status != store.DisplayApproved {
+		writeError(w, http.StatusForbidden, store.ErrDisplayNotApproved)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "triggerID"), 10, 64)
+	if err != nil {
+		writeTriggerError(w, sql.ErrNoRows)
+		return
+	}
+	pl, err := s.displayPlaylist(r.Context(), d)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err := s.store.ForEvent(pl.EventID).AckMilestoneTrigger(r.Context(), id, d.ID, time.Now()); err != nil {
+		writeTriggerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMilestoneTriggerReports lists triggers, oldest first, with the
+// displays that played each.
+func (s *Server) handleMilestoneTriggerReports(w http.ResponseWriter, r *http.Request) {
+	filter, err := triggerFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	items, err := s.storeFor(r).MilestoneTriggerReports(r.Context(), filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// handleRefireTrigger plays a celebration again without touching the
+// milestone.
+func (s *Server) handleRefireTrigger(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "triggerID"), 10, 64)
+	if err != nil {
+		writeTriggerError(w, sql.ErrNoRows)
+		return
+	}
+	tr, err := s.storeFor(r).RefireMilestoneTrigger(r.Context(), id)
+	if err != nil {
+		writeTriggerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, tr)
+}
+
+func writeTriggerError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, errors.New("trigger not found"))
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/adopting-bitcoin/dashboard/internal/store"
+	"github.com/adopting-bitcoin/dashboard/internal/webhook"
+)
+
+// voucherStatus is what the portal API reports for a code.
+type voucherStatus struct {
+	store.WifiVoucher
+	Valid            bool   `json:"valid"`
+	Reason           string `json:"reason,omitempty"` // why the code can't be used
+	RemainingSeconds int64  `json:"remaining_seconds"`
+}
+
+func newVoucherStatus(v store.WifiVoucher, now time.Time) voucherStatus {
+	out := voucherStatus{WifiVoucher: v, Valid: true}
+	if remaining := v.ExpiresAt.Sub(now); remaining > 0 {
+		out.RemainingSeconds = int64(remaining.Seconds())
+	} else {
+		out.Valid = false
+		out.Reason = store.ErrVoucherExpired.Error()
+	}
+	return out
+}
+
+// mintVoucher issues the voucher for a WiFi payment and, when it is new,
+// hands it to the voucher hook in the background so a slow portal doesn't
+// hold up the payment response. Payments without a hash are keyed by their
+// external id.
+func (s *Server) mintVoucher(ctx context.Context, st *store.Store, payment webhook.Payment, tier store.WifiTier, paidAt time.Time) error {
+	key := payment.PaymentHash
+	if key == "" {
+		key = payment.ExternalID
+	}
+	v, minted, err := st.MintWifiVoucher(ctx, key, tier, paidAt)
+	if err != nil || !minted || s.voucherHook == nil {
+		return err
+	}
+	go func() {
+		hookCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.voucherHook.Minted(hookCtx, v); err != nil {
+			s.logger.Printf("voucher %s: hook failed: %v\n", v.Code, err)
+		}
+	}()
+	return nil
+}
+
+// handleWifiVoucher returns the voucher minted for a payment. Knowing the
+// payment hash is what entitles the payer to the code.
+func (s *Server) handleWifiVoucher(w http.ResponseWriter, r *http.Request) {
+	v, err := s.store.GetWifiVoucherByPaymentHash(r.Context(), chi.URLParam(r, "paymentHash"))
+	if err != nil {
+		writeVoucherError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newVoucherStatus(v, time.Now()))
+}
+
+// portalAuth guards the captive portal API with PORTAL_TOKEN. The API is off
+// when no token is configured.
+func (s *Server) portalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.PortalToken == "" {
+			writeError(w, http.StatusServiceUnavailable, errors.New("portal API is not configured"))
+			return
+		}
+		token := extractToken(r)
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.PortalToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleValidateVoucher reports whether a code grants access without
+// consuming it.
+func (s *Server) handleValidateVoucher(w http.ResponseWriter, r *http.Request) {
+	v, err := s.store.GetWifiVoucher(r.Context(), chi.URLParam(r, "code"))
+	if err != nil {
+		writeVoucherError(w, err)
+		return
+	}
+	status := newVoucherStatus(v, time.Now())
+	if clientID := r.URL.Query().Get("client_id"); status.Valid && v.RedeemedAt != nil && v.RedeemedBy != clientID {
+		status.Valid = false
+		status.Reason = store.ErrVoucherRedeemed.Error()
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleRedeemVoucher consumes a code for one client, such as a device MAC
+// address.
+func (s *Server) handleRedeemVoucher(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ClientID string `json:"client_id"`
+	}
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	now := time.Now()
+	v, err := s.store.RedeemWifiVoucher(r.Context(), chi.URLParam(r, "code"), strings.TrimSpace(payload.ClientID), now)
+	if err != nil {
+		writeVoucherError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newVoucherStatus(v, now))
+}
+
+func writeVoucherError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, errors.New("voucher not found"))
+	case errors.Is(err, store.ErrVoucherExpired):
+		writeError(w, http.StatusGone, err)
+	case errors.Is(err, store.ErrVoucherRedeemed):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
@@ -0,0 +1,491 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/adopting-bitcoin/dashboard/internal/store"
+	"github.com/adopting-bitcoin/dashboard/internal/webhook"
+)
+
+var (
+	// errWebhookReplayed rejects a signed delivery whose signature was
+	// already used.
+	errWebhookReplayed = errors.New("webhook delivery already received")
+	// errUnknownWebhookEndpoint answers deliveries to endpoints that don't
+	// exist or are disabled. They aren't stored in the inbox.
+	errUnknownWebhookEndpoint = errors.New("unknown webhook endpoint")
+)
+
+// maxUnauthenticatedBody is how much of a body that failed authentication
+// the inbox keeps; enough to identify the sender, not a full 1 MB per probe.
+const maxUnauthenticatedBody = 4 << 10
+
+// paymentResult is the webhook response body.
+type paymentResult struct {
+	Status     string  `json:"status"`
+	Inserted   int64   `json:"inserted"`
+	AmountSats int64   `json:"amount_sats"`
+	WifiTier   *string `json:"wifi_tier"`
+}
+
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	delivery := store.WebhookDelivery{
+		EndpointID: chi.URLParam(r, "endpointID"),
+		EventID:    s.storeFor(r).EventID(),
+		Headers:    redactHeaders(r.Header),
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	delivery.Body = string(body)
+	status, result := http.StatusBadRequest, paymentResult{}
+	var nonce string
+	if err == nil {
+		status, result, err = s.processWebhook(r.Context(), s.storeFor(r), delivery.EndpointID, body,
+			func(endpoint store.WebhookEndpoint) (authErr error) {
+				nonce, authErr = s.authenticateWebhook(r, endpoint, body)
+				return authErr
+			})
+	}
+	// A failed delivery didn't record anything, so its nonce is released
+	// for the sender's retry instead of rejecting that as a replay.
+	if nonce != "" && (status < 200 || status > 299) {
+		if err := s.store.ReleaseWebhookNonce(r.Context(), delivery.EndpointID, nonce); err != nil {
+			s.logger.Printf("webhook %s: releasing nonce failed: %v\n", delivery.EndpointID, err)
+		}
+	}
+	s.respondWebhook(r.Context(), w, delivery, status, result, err)
+}
+
+// processWebhook runs a delivery's body through its endpoint and records the
+// payment into st, or into the endpoint's own event if it has one. auth is
+// skipped when nil, as for replays. The returned status is the HTTP status
+// for the response.
+func (s *Server) processWebhook(ctx context.Context, st *store.Store, endpointID string, body []byte, auth func(store.WebhookEndpoint) error) (int, paymentResult, error) {
+	endpoint, err := s.store.FindWebhookEndpoint(ctx, endpointID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return http.StatusNotFound, paymentResult{}, errUnknownWebhookEndpoint
+		}
+		return http.StatusInternalServerError, paymentResult{}, err
+	}
+	if !endpoint.Enabled {
+		return http.StatusNotFound, paymentResult{}, errUnknownWebhookEndpoint
+	}
+
+	if auth != nil {
+		if err := auth(endpoint); err != nil {
+			switch {
+			case errors.Is(err, errWebhookReplayed):
+				return http.StatusConflict, paymentResult{}, err
+			case errors.Is(err, webhook.ErrUnauthorized), errors.Is(err, webhook.ErrStale):
+				return http.StatusUnauthorized, paymentResult{}, err
+			}
+			return http.StatusInternalServerError, paymentResult{}, err
+		}
+	}
+
+	provider, err := webhook.New(endpoint.Provider, endpoint.Mapping)
+	if err != nil {
+		return http.StatusInternalServerError, paymentResult{}, err
+	}
+	payment, err := provider.Parse(body)
+	if errors.Is(err, webhook.ErrIgnored) {
+		return http.StatusOK, paymentResult{Status: "ignored"}, nil
+	}
+	if err != nil {
+		return http.StatusBadRequest, paymentResult{}, err
+	}
+
+	if endpoint.EventID != 0 {
+		st = s.store.ForEvent(endpoint.EventID)
+	}
+	result, err := s.recordPayment(ctx, st, endpoint, payment, nil)
+	if err != nil {
+		return http.StatusInternalServerError, result, err
+	}
+	return http.StatusOK, result, nil
+}
+
+// respondWebhook writes the webhook response and stores the delivery with it
+// in the inbox. Deliveries to unknown endpoints are not stored, and only the
+// start of a body that failed authentication is kept. A failure to store is
+// logged; the sender still gets its response.
+func (s *Server) respondWebhook(ctx context.Context, w http.ResponseWriter, delivery store.WebhookDelivery, status int, result paymentResult, err error) {
+	var body any = result
+	if err != nil {
+		body = map[string]string{"error": err.Error()}
+		delivery.Error = err.Error()
+	}
+	delivery.StatusCode = status
+	delivery.Response, _ = json.Marshal(body)
+	if (status == http.StatusUnauthorized || status == http.StatusConflict) && len(delivery.Body) > maxUnauthenticatedBody {
+		delivery.Body, delivery.Truncated = delivery.Body[:maxUnauthenticatedBody], true
+	}
+	if !errors.Is(err, errUnknownWebhookEndpoint) {
+		if _, err := s.store.RecordWebhookDelivery(ctx, delivery); err != nil {
+			s.logger.Printf("webhook %s: storing delivery failed: %v\n", delivery.EndpointID, err)
+		}
+	}
+	writeJSON(w, status, body)
+}
+
+// redactedHeaders carry credentials and are not stored in the inbox.
+var redactedHeaders = []string{"Authorization", "Cookie", webhook.HeaderSecret}
+
+func redactHeaders(h http.Header) map[string][]string {
+	out := h.Clone()
+	for _, name := range redactedHeaders {
+		if out.Get(name) != "" {
+			out.Set(name, "[redacted]")
+		}
+	}
+	return out
+}
+
+// authenticateWebhook checks a delivery against the endpoint's current and
+// previous secrets, or WEBHOOK_SECRET for endpoints without their own, and
+// rejects signatures seen within the tolerance window. Endpoints with no
+// secret at all reject every delivery. The returned nonce is the one this
+// delivery claimed, if any.
+func (s *Server) authenticateWebhook(r *http.Request, endpoint store.WebhookEndpoint, body []byte) (string, error) {
+	// Secrets in the URL end up in access logs, so they are refused outright
+	if r.URL.Query().Has("secret") {
+		return "", fmt.Errorf("%w: pass the secret in the %s header, not the query string", webhook.ErrUnauthorized, webhook.HeaderSecret)
+	}
+	secrets := []string{endpoint.Secret, endpoint.PreviousSecret}
+	if endpoint.Secret == "" && endpoint.PreviousSecret == "" {
+		if s.cfg.WebhookSecret == "" {
+			return "", fmt.Errorf("%w: endpoint has no secret", webhook.ErrUnauthorized)
+		}
+		secrets = []string{s.cfg.WebhookSecret}
+	}
+	nonce, err := webhook.Verify(endpoint.AuthMode, secrets, r.Header, body, time.Now(), s.cfg.WebhookTolerance)
+	if err != nil || nonce == "" {
+		return "", err
+	}
+	// Keep nonces a little longer than the tolerance so a request signed at
+	// the edge of the window can't be replayed at its opposite edge.
+	fresh, err := s.store.ClaimWebhookNonce(r.Context(), endpoint.ID, nonce, 2*s.cfg.WebhookTolerance)
+	if err != nil {
+		return "", err
+	}
+	if !fresh {
+		return "", errWebhookReplayed
+	}
+	return nonce, nil
+}
+
+// recordPayment stores a payment as a transaction of the endpoint's merchant
+// and runs milestone checks.
+func (s *Server) recordPayment(ctx context.Context, st *store.Store, endpoint store.WebhookEndpoint, payment webhook.Payment, tier *store.WifiTier) (paymentResult, error) {
+	result := paymentResult{Status: "ok", AmountSats: payment.AmountSats}
+	paidAt := payment.PaidAt
+	if paidAt.IsZero() {
+		paidAt = time.Now().UTC()
+	}
+	txn := store.TransactionInput{
+		ExternalID:  payment.ExternalID,
+		SaleOrigin:  endpoint.Provider,
+		SaleDate:    paidAt,
+		AmountSats:  payment.AmountSats,
+		Source:      endpoint.Source,
+		PaymentHash: payment.PaymentHash,
+		Memo:        payment.Memo,
+	}
+
+	// Attribute WiFi payments to the tier they were issued for, or else to
+	// the tier their amount pays for
+	if tier != nil {
+		txn.WifiTierID = &tier.ID
+		result.WifiTier = &tier.Name
+	} else if endpoint.Source == store.SourceWifi {
+		t, err := st.ClassifyWifiPayment(ctx, payment.AmountSats)
+		switch {
+		case err == nil:
+			tier = &t
+			txn.WifiTierID = &t.ID
+			result.WifiTier = &t.Name
+		case !errors.Is(err, sql.ErrNoRows):
+			return result, err
+		}
+	}
+
+	inserted, err := st.RecordTransactions(ctx, endpoint.MerchantID, []store.TransactionInput{txn})
+	if err != nil {
+		return result, err
+	}
+	result.Inserted = inserted
+
+	// Minting is idempotent, so a delivery retried after a failed mint still
+	// gets its voucher even though the transaction already exists
+	if tier != nil {
+		if err := s.mintVoucher(ctx, st, payment, *tier, paidAt); err != nil {
+			return result, err
+		}
+	}
+	if inserted > 0 {
+		if _, err := st.ProcessMilestones(ctx); err != nil {
+			// Log error but don't fail the webhook
+			s.logger.Printf("webhook %s: milestone check failed: %v\n", endpoint.ID, err)
+		}
+	}
+	return result, nil
+}
+
+type webhookEndpointPayload struct {
+	ID             string            `json:"id"`
+	Name           *string           `json:"name"`
+	Provider       *string           `json:"provider"`
+	Secret         *string           `json:"secret"`
+	PreviousSecret *string           `json:"previous_secret"`
+	AuthMode       *string           `json:"auth_mode"`
+	MerchantID     *string           `json:"merchant_id"`
+	Source         *string           `json:"source"`
+	Mapping        map[string]string `json:"mapping"`
+	Enabled        *bool             `json:"enabled"`
+}
+
+// apply copies the fields present in the payload onto e.
+func (p webhookEndpointPayload) apply(e *store.WebhookEndpoint) {
+	if p.Name != nil {
+		e.Name = *p.Name
+	}
+	if p.Provider != nil {
+		e.Provider = *p.Provider
+	}
+	if p.Secret != nil {
+		e.Secret = *p.Secret
+	}
+	if p.PreviousSecret != nil {
+		e.PreviousSecret = *p.PreviousSecret
+	}
+	if p.AuthMode != nil {
+		e.AuthMode = *p.AuthMode
+	}
+	if p.MerchantID != nil {
+		e.MerchantID = *p.MerchantID
+	}
+	if p.Source != nil {
+		e.Source = store.TransactionSource(*p.Source)
+	}
+	if p.Mapping != nil {
+		e.Mapping = p.Mapping
+	}
+	if p.Enabled != nil {
+		e.Enabled = *p.Enabled
+	}
+}
+
+func (s *Server) handleListWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := s.storeFor(r).ListWebhookEndpoints(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, endpoints)
+}
+
+func (s *Server) handleCreateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	var payload webhookEndpointPayload
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	endpoint := store.WebhookEndpoint{ID: payload.ID, AuthMode: webhook.AuthHMAC, Enabled: true}
+	payload.apply(&endpoint)
+	if err := s.validateWebhookEndpoint(endpoint); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	created, err := s.storeFor(r).CreateWebhookEndpoint(r.Context(), endpoint)
+	if err != nil {
+		writeWebhookEndpointError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) handleUpdateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	var payload webhookEndpointPayload
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	st := s.storeFor(r)
+	endpoint, err := st.GetWebhookEndpoint(r.Context(), chi.URLParam(r, "endpointID"))
+	if err != nil {
+		writeWebhookEndpointError(w, err)
+		return
+	}
+	payload.apply(&endpoint)
+	if err := s.validateWebhookEndpoint(endpoint); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	updated, err := st.UpdateWebhookEndpoint(r.Context(), endpoint)
+	if err != nil {
+		writeWebhookEndpointError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// handleRotateWebhookSecret installs a new secret, given or generated, and
+// keeps the current one valid as the previous secret. Clear previous_secret
+// with a PUT once every sender has switched.
+func (s *Server) handleRotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Secret string `json:"secret"`
+	}
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, &payload); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if payload.Secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		payload.Secret = hex.EncodeToString(buf)
+	}
+	st := s.storeFor(r)
+	endpoint, err := st.GetWebhookEndpoint(r.Context(), chi.URLParam(r, "endpointID"))
+	if err != nil {
+		writeWebhookEndpointError(w, err)
+		return
+	}
+	if payload.Secret == endpoint.Secret {
+		writeError(w, http.StatusBadRequest, errors.New("new secret must differ from the current one"))
+		return
+	}
+	rotated, err := st.RotateWebhookSecret(r.Context(), endpoint.ID, payload.Secret)
+	if err != nil {
+		writeWebhookEndpointError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rotated)
+}
+
+func (s *Server) handleDeleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	if err := s.storeFor(r).DeleteWebhookEndpoint(r.Context(), chi.URLParam(r, "endpointID")); err != nil {
+		writeWebhookEndpointError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "webhook endpoint deleted"})
+}
+
+func (s *Server) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	filter := store.WebhookDeliveryFilter{
+		EndpointID: r.URL.Query().Get("endpoint"),
+		FailedOnly: r.URL.Query().Get("failed") == "true",
+		Limit:      parseIntQuery(r, "limit", 50),
+	}
+	if raw := r.URL.Query().Get("before"); raw != "" {
+		before, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid before id"))
+			return
+		}
+		filter.BeforeID = before
+	}
+	deliveries, err := s.store.ListWebhookDeliveries(r.Context(), filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+func (s *Server) handleGetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	delivery, err := s.webhookDelivery(r)
+	if err != nil {
+		writeWebhookDeliveryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, delivery)
+}
+
+// handleReplayWebhookDelivery re-runs a stored delivery through its endpoint
+// as it is configured now, in the event it was originally sent to. Signature
+// checks are skipped, so deliveries that failed authentication need
+// force=true after the payload has been checked by hand. The replay is stored
+// as a new delivery pointing at the original.
+func (s *Server) handleReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	original, err := s.webhookDelivery(r)
+	if err != nil {
+		writeWebhookDeliveryError(w, err)
+		return
+	}
+	if (original.StatusCode == http.StatusUnauthorized || original.StatusCode == http.StatusConflict) &&
+		r.URL.Query().Get("force") != "true" {
+		writeError(w, http.StatusConflict, errors.New("delivery failed authentication; replay with force=true after verifying it"))
+		return
+	}
+	if original.Truncated {
+		writeError(w, http.StatusConflict, errors.New("delivery body was truncated and can't be replayed"))
+		return
+	}
+	delivery := store.WebhookDelivery{
+		EndpointID: original.EndpointID,
+		EventID:    original.EventID,
+		Headers:    original.Headers,
+		Body:       original.Body,
+		ReplayOf:   &original.ID,
+	}
+	status, result, err := s.processWebhook(r.Context(), s.store.ForEvent(original.EventID), original.EndpointID, []byte(original.Body), nil)
+	s.respondWebhook(r.Context(), w, delivery, status, result, err)
+}
+
+func (s *Server) webhookDelivery(r *http.Request) (store.WebhookDelivery, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "deliveryID"), 10, 64)
+	if err != nil {
+		return store.WebhookDelivery{}, sql.ErrNoRows
+	}
+	return s.store.GetWebhookDelivery(r.Context(), id)
+}
+
+func writeWebhookDeliveryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, errors.New("webhook delivery not found"))
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+func writeWebhookEndpointError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, errors.New("webhook endpoint not found"))
+	case errors.Is(err, store.ErrWebhookEndpointExists):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
+
+func (s *Server) validateWebhookEndpoint(e store.WebhookEndpoint) error {
+	if !webhook.ValidAuthMode(e.AuthMode) {
+		return fmt.Errorf("auth_mode must be %s, %s or %s", webhook.AuthHMAC, webhook.AuthHeader, webhook.AuthBTCPay)
+	}
+	// Every auth mode needs a secret, and deliveries to an endpoint without
+	// one are rejected
+	if e.Secret == "" && e.PreviousSecret == "" && s.cfg.WebhookSecret == "" {
+		return errors.New("secret is required when WEBHOOK_SECRET is not set")
+	}
+	_, err := webhook.New(e.Provider, e.Mapping)
+	return err
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/adopting-bitcoin/dashboard/internal/store"
+)
+
+// wifiConfigResponse keeps the single-offer fields older displays read,
+// filled from the first enabled tier, alongside the full tier list.
+type wifiConfigResponse struct {
+	LightningAddress string           `json:"lightning_address"`
+	Description      string           `json:"description"`
+	PriceSats        string           `json:"price_sats"`
+	DurationHours    string           `json:"duration_hours"`
+	InvoicesEnabled  bool             `json:"invoices_enabled"` // POST /wifi/invoices is available
+	Tiers            []store.WifiTier `json:"tiers"`
+}
+
+type wifiTierPayload struct {
+	Name              *string `json:"name"`
+	Description       *string `json:"description"`
+	PriceSats         *int64  `json:"price_sats"`
+	DurationMinutes   *int64  `json:"duration_minutes"`
+	SpeedMbps         *int64  `json:"speed_mbps"`
+	DonationPercent   *int64  `json:"donation_percent"`
+	DonationRecipient *string `json:"donation_recipient"`
+	Enabled           *bool   `json:"enabled"`
+	Order             *int64  `json:"order"`
+}
+
+// apply copies the fields present in the payload onto t.
+func (p wifiTierPayload) apply(t *store.WifiTier) {
+	if p.Name != nil {
+		t.Name = *p.Name
+	}
+	if p.Description != nil {
+		t.Description = *p.Description
+	}
+	if p.PriceSats != nil {
+		t.PriceSats = *p.PriceSats
+	}
+	if p.DurationMinutes != nil {
+		t.DurationMinutes = *p.DurationMinutes
+	}
+	if p.SpeedMbps != nil {
+		t.SpeedMbps = *p.SpeedMbps
+	}
+	if p.DonationPercent != nil {
+		t.DonationPercent = *p.DonationPercent
+	}
+	if p.DonationRecipient != nil {
+		t.DonationRecipient = *p.DonationRecipient
+	}
+	if p.Enabled != nil {
+		t.Enabled = *p.Enabled
+	}
+	if p.Order != nil {
+		t.Order = *p.Order
+	}
+}
+
+func (s *Server) wifiConfig(r *http.Request, onlyEnabled bool) (wifiConfigResponse, error) {
+	st := s.storeFor(r)
+	event, err := st.CurrentEvent(r.Context())
+	if err != nil {
+		return wifiConfigResponse{}, err
+	}
+	tiers, err := st.ListWifiTiers(r.Context(), onlyEnabled)
+	if err != nil {
+		return wifiConfigResponse{}, err
+	}
+	out := wifiConfigResponse{LightningAddress: event.WifiLightningAddress, InvoicesEnabled: s.lnbits != nil, Tiers: tiers}
+	if out.LightningAddress == "" {
+		out.LightningAddress = s.cfg.WifiLightningAddress
+	}
+	for _, t := range tiers {
+		if !t.Enabled {
+			continue
+		}
+		out.Description = t.Description
+		out.PriceSats = strconv.FormatInt(t.PriceSats, 10)
+		out.DurationHours = strconv.FormatFloat(float64(t.DurationMinutes)/60, 'f', -1, 64)
+		break
+	}
+	return out, nil
+}
+
+func (s *Server) handleWifiConfig(w http.ResponseWriter, r *http.Request) {
+	config, err := s.wifiConfig(r, true)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, config)
+}
+
+func (s *Server) handleAdminWifiConfig(w http.ResponseWriter, r *http.Request) {
+	config, err := s.wifiConfig(r, false)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, config)
+}
+
+func (s *Server) handleCreateWifiTier(w http.ResponseWriter, r *http.Request) {
+	var payload wifiTierPayload
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	tier := store.WifiTier{Enabled: true}
+	payload.apply(&tier)
+	created, err := s.storeFor(r).CreateWifiTier(r.Context(), tier)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) handleUpdateWifiTier(w http.ResponseWriter, r *http.Request) {
+	var payload wifiTierPayload
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "tierID"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	st := s.storeFor(r)
+	tier, err := st.GetWifiTier(r.Context(), id)
+	if err != nil {
+		writeWifiTierError(w, err)
+		return
+	}
+	payload.apply(&tier)
+	updated, err := st.UpdateWifiTier(r.Context(), tier)
+	if err != nil {
+		writeWifiTierError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (s *Server) handleDeleteWifiTier(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "tierID"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	if err := s.storeFor(r).DeleteWifiTier(r.Context(), id); err != nil {
+		writeWifiTierError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "tier deleted"})
+}
+
+func writeWifiTierError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, errors.New("tier not found"))
+		return
+	}
+	writeError(w, http.StatusBadRequest, err)
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/adopting-bitcoin/dashboard/internal/store"
+	"github.com/adopting-bitcoin/dashboard/internal/webhook"
+)
+
+const (
+	// wifiInvoicePollInterval is how often the event stream asks LNbits
+	// whether a pending invoice was paid.
+	wifiInvoicePollInterval = 2 * time.Second
+)
+
+// wifiInvoiceEndpoint records paid WiFi invoices the way the built-in wifi
+// webhook records LNbits payments, so both land as the same transaction.
+var wifiInvoiceEndpoint = store.WebhookEndpoint{
+	ID:         "wifi-invoice",
+	Provider:   webhook.ProviderLNbits,
+	MerchantID: "wifi",
+	Source:     store.SourceWifi,
+}
+
+// wifiInvoiceResponse adds the voucher to paid invoices, so the payer gets
+// their access code from the same status check that saw the payment.
+type wifiInvoiceResponse struct {
+	store.WifiInvoice
+	Voucher *store.WifiVoucher `json:"voucher"`
+}
+
+func (s *Server) wifiInvoiceResponse(ctx context.Context, invoice store.WifiInvoice) (wifiInvoiceResponse, error) {
+	out := wifiInvoiceResponse{WifiInvoice: invoice}
+	if invoice.Status != store.WifiInvoicePaid {
+		return out, nil
+	}
+	v, err := s.store.GetWifiVoucherByPaymentHash(ctx, invoice.PaymentHash)
+	switch {
+	case err == nil:
+		out.Voucher = &v
+	case !errors.Is(err, sql.ErrNoRows):
+		return out, err
+	}
+	return out, nil
+}
+
+var (
+	errWifiInvoicesDisabled = errors.New("wifi invoices are not configured")
+	errLNbitsUnavailable    = errors.New("lnbits unavailable")
+)
+
+func (s *Server) handleCreateWifiInvoice(w http.ResponseWriter, r *http.Request) {
+	if s.lnbits == nil {
+		writeError(w, http.StatusServiceUnavailable, errWifiInvoicesDisabled)
+		return
+	}
+	var payload struct {
+		TierID int64 `json:"tier_id"`
+	}
+	if err := decodeJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	st := s.storeFor(r)
+	tier, err := st.GetWifiTier(r.Context(), payload.TierID)
+	if err == nil && !tier.Enabled {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		writeWifiTierError(w, err)
+		return
+	}
+
+	memo := fmt.Sprintf("WiFi upgrade: %s", tier.Name)
+	created, err := s.lnbits.CreateInvoice(r.Context(), tier.PriceSats, memo, s.cfg.WifiInvoiceExpiry)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("%w: %v", errLNbitsUnavailable, err))
+		return
+	}
+	invoice, err := st.CreateWifiInvoice(r.Context(), store.WifiInvoice{
+		PaymentHash: created.PaymentHash,
+		TierID:      tier.ID,
+		AmountSats:  tier.PriceSats,
+		Bolt11:      created.PaymentRequest,
+		CheckingID:  created.CheckingID,
+		Memo:        memo,
+		ExpiresAt:   time.Now().Add(s.cfg.WifiInvoiceExpiry),
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, invoice)
+}
+
+func (s *Server) handleWifiInvoiceStatus(w http.ResponseWriter, r *http.Request) {
+	invoice, err := s.refreshWifiInvoice(r.Context(), chi.URLParam(r, "paymentHash"))
+	if err != nil {
+		writeWifiInvoiceError(w, err)
+		return
+	}
+	resp, err := s.wifiInvoiceResponse(r.Context(), invoice)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleWifiInvoiceEvents streams the invoice as server-sent "status" events
+// whenever its status changes, ending once it is paid or expired.
+func (s *Server) handleWifiInvoiceEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+	hash := chi.URLParam(r, "paymentHash")
+	invoice, err := s.refreshWifiInvoice(r.Context(), hash)
+	if err != nil {
+		writeWifiInvoiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(wifiInvoicePollInterval)
+	defer ticker.Stop()
+	var sent store.WifiInvoiceStatus
+	for {
+		if invoice.Status != sent {
+			resp, err := s.wifiInvoiceResponse(r.Context(), invoice)
+			if err != nil {
+				s.logger.Printf("wifi invoice %s: voucher lookup failed: %v\n", hash, err)
+			}
+			data, _ := json.Marshal(resp)
+			fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+			flusher.Flush()
+			sent = invoice.Status
+		}
+		if invoice.Status != store.WifiInvoicePending {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		next, err := s.refreshWifiInvoice(r.Context(), hash)
+		if err != nil {
+			// LNbits may be briefly unreachable; keep the stream open
+			s.logger.Printf("wifi invoice %s: status check failed: %v\n", hash, err)
+			continue
+		}
+		invoice = next
+	}
+}
+
+// refreshWifiInvoice asks LNbits about a pending invoice. A paid invoice is
+// recorded as a WiFi transaction before it is marked paid, so a failed insert
+// is retried on the next check; the payment hash keeps it from being counted
+// twice. An unpaid invoice past its expiry is marked expired.
+func (s *Server) refreshWifiInvoice(ctx context.Context, hash string) (store.WifiInvoice, error) {
+	invoice, err := s.store.GetWifiInvoice(ctx, hash)
+	if err != nil || invoice.Status == store.WifiInvoicePaid || s.lnbits == nil {
+		return invoice, err
+	}
+	// An invoice past its expiry can't be paid any more, so it is expired
+	// even while LNbits can't be reached; a later check still settles it if
+	// LNbits saw a payment in time.
+	status, err := s.lnbits.PaymentStatus(ctx, hash)
+	expired := invoice.Status == store.WifiInvoicePending && time.Now().After(invoice.ExpiresAt)
+	if err != nil && !expired {
+		return invoice, fmt.Errorf("%w: %v", errLNbitsUnavailable, err)
+	}
+	st := s.store.ForEvent(invoice.EventID)
+	switch {
+	case err == nil && status.Paid:
+		paidAt := time.Now().UTC()
+		// The invoice pays for the tier it was issued for, even if that tier
+		// has since been repriced or disabled
+		var tier *store.WifiTier
+		t, err := st.GetWifiTier(ctx, invoice.TierID)
+		switch {
+		case err == nil:
+			tier = &t
+		case !errors.Is(err, sql.ErrNoRows):
+			return invoice, err
+		}
+		if _, err := s.recordPayment(ctx, st, wifiInvoiceEndpoint, webhook.Payment{
+			ExternalID:  hash,
+			AmountSats:  invoice.AmountSats,
+			PaidAt:      paidAt,
+			PaymentHash: hash,
+			Memo:        invoice.Memo,
+		}, tier); err != nil {
+			return invoice, err
+		}
+		if err := s.store.SettleWifiInvoice(ctx, hash, paidAt); err != nil {
+			return invoice, err
+		}
+	case expired:
+		if err := s.store.ExpireWifiInvoice(ctx, hash); err != nil {
+			return invoice, err
+		}
+	default:
+		return invoice, nil
+	}
+	return s.store.GetWifiInvoice(ctx, hash)
+}
+
+func writeWifiInvoiceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, errors.New("invoice not found"))
+	case errors.Is(err, errLNbitsUnavailable):
+		writeError(w, http.StatusBadGateway, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -12,9 +14,19 @@ import (
 type Config struct {
 	Addr                    string
 	DBPath                  string
+	AssetCacheDir           string // Where served assets are kept as files, named by hash
+	DefaultEvent            string // Optional: slug of the event unscoped routes serve
 	AdminToken              string
-	WebhookSecret           string // Optional: validates WiFi webhooks
-	WifiLightningAddress    string // Lightning address for WiFi upgrades
+	WebhookSecret           string        // Optional: fallback secret for webhook endpoints without one
+	WebhookTolerance        time.Duration // Max clock skew for signed webhooks; also how long nonces are kept
+	WifiLightningAddress    string        // Lightning address for WiFi upgrades
+	LNbitsURL               string        // Optional: LNbits instance for per-user WiFi invoices
+	LNbitsInvoiceKey        string        // Invoice/read key of the LNbits wallet
+	WifiInvoiceExpiry       time.Duration // How long a WiFi invoice can be paid
+	WifiInvoiceRateLimit    int           // WiFi invoices one client can create a minute
+	PortalToken             string        // Optional: bearer token for the captive portal voucher API
+	VoucherHookURL          string        // Optional: portal URL notified of minted vouchers
+	VoucherHookSecret       string        // Optional: signs voucher hook requests
 	PollInterval            time.Duration
 	PollConcurrency         int
 	HTTPTimeout             time.Duration
@@ -23,6 +35,8 @@ type Config struct {
 	DefaultLeaderboardLimit int
 	DataAPIBaseURL          string
 	CORSOrigins             []string
+	TrustedProxies          []string // Addresses or CIDRs whose X-Forwarded-For and X-Real-IP are believed
+	DisplayRegisterLimit    int      // Display registrations one client can make a minute
 }
 
 // FromEnv builds a Config from environment variables, applying sensible defaults.
@@ -30,9 +44,19 @@ func FromEnv() Config {
 	cfg := Config{
 		Addr:                    getEnv("ADDR", ":8080"),
 		DBPath:                  getEnv("DB_PATH", "dashboard.db"),
+		AssetCacheDir:           getEnv("ASSET_CACHE_DIR", filepath.Join(os.TempDir(), "dashboard-assets")),
+		DefaultEvent:            os.Getenv("DEFAULT_EVENT"),
 		AdminToken:              os.Getenv("ADMIN_TOKEN"),
 		WebhookSecret:           os.Getenv("WEBHOOK_SECRET"),           // Optional
 		WifiLightningAddress:    os.Getenv("WIFI_LIGHTNING_ADDRESS"),  // Optional
+		WebhookTolerance:        getDuration("WEBHOOK_TOLERANCE", 5*time.Minute),
+		LNbitsURL:               os.Getenv("LNBITS_URL"),
+		LNbitsInvoiceKey:        os.Getenv("LNBITS_INVOICE_KEY"),
+		WifiInvoiceExpiry:       getDuration("WIFI_INVOICE_EXPIRY", 10*time.Minute),
+		WifiInvoiceRateLimit:    getInt("WIFI_INVOICE_RATE_LIMIT", 10),
+		PortalToken:             os.Getenv("PORTAL_TOKEN"),
+		VoucherHookURL:          os.Getenv("VOUCHER_HOOK_URL"),
+		VoucherHookSecret:       os.Getenv("VOUCHER_HOOK_SECRET"),
 		PollInterval:            getDuration("POLL_INTERVAL", 30*time.Second),
 		PollConcurrency:         getInt("POLL_CONCURRENCY", 5),
 		HTTPTimeout:             getDuration("HTTP_TIMEOUT", 10*time.Second),
@@ -41,6 +65,8 @@ func FromEnv() Config {
 		DefaultLeaderboardLimit: getInt("LEADERBOARD_LIMIT", 10),
 		DataAPIBaseURL:          getEnv("SOURCE_BASE_URL", "https://api.paywithflash.com"),
 		CORSOrigins:             getSlice("CORS_ORIGINS", []string{"*"}),
+		TrustedProxies:          getSlice("TRUSTED_PROXIES", nil),
+		DisplayRegisterLimit:    getInt("DISPLAY_REGISTER_RATE_LIMIT", 10),
 	}
 	return cfg
 }
@@ -65,12 +91,126 @@ func (c Config) Validate() error {
 	if c.DefaultLeaderboardLimit <= 0 {
 		return fmt.Errorf("leaderboard limit must be > 0")
 	}
+	if c.RateWindow <= 0 {
+		return fmt.Errorf("rate window must be > 0")
+	}
+	if c.WebhookTolerance <= 0 {
+		return fmt.Errorf("webhook tolerance must be > 0")
+	}
+	if c.LNbitsURL != "" && c.LNbitsInvoiceKey == "" {
+		return fmt.Errorf("LNBITS_INVOICE_KEY must be set when LNBITS_URL is")
+	}
+	if c.LNbitsURL != "" && c.WifiInvoiceExpiry <= 0 {
+		return fmt.Errorf("wifi invoice expiry must be > 0")
+	}
+	if c.WifiInvoiceRateLimit <= 0 {
+		return fmt.Errorf("wifi invoice rate limit must be > 0")
+	}
+	if c.DisplayRegisterLimit <= 0 {
+		return fmt.Errorf("display register rate limit must be > 0")
+	}
+	if _, err := c.TrustedProxyPrefixes(); err != nil {
+		return err
+	}
 	if c.DataAPIBaseURL == "" {
 		return fmt.Errorf("SOURCE_BASE_URL must be set")
 	}
 	return nil
 }
 
+// TrustedProxyPrefixes parses TrustedProxies. A plain address trusts just
+// that address.
+func (c Config) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, raw := range c.TrustedProxies {
+		if addr, err := netip.ParseAddr(raw); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(raw)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not an address or CIDR", raw)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Runtime-editable settings. Values stored in the database override the
+// environment defaults and use the same format as the environment variables.
+const (
+	SettingTickerLimit      = "ticker_limit"
+	SettingRateWindow       = "rate_window"
+	SettingLeaderboardLimit = "leaderboard_limit"
+	SettingPollInterval     = "poll_interval"
+	SettingPollConcurrency  = "poll_concurrency"
+)
+
+// SettingKeys lists the runtime-editable settings in display order.
+var SettingKeys = []string{
+	SettingTickerLimit,
+	SettingRateWindow,
+	SettingLeaderboardLimit,
+	SettingPollInterval,
+	SettingPollConcurrency,
+}
+
+// WithSettings returns a copy of c with the given setting overrides applied.
+// It does not validate the result; call Validate for that.
+func (c Config) WithSettings(values map[string]string) (Config, error) {
+	out := c
+	for key, val := range values {
+		n, d, ok := out.setting(key)
+		if !ok {
+			return c, fmt.Errorf("unknown setting %q", key)
+		}
+		if n != nil {
+			i, err := strconv.Atoi(strings.TrimSpace(val))
+			if err != nil {
+				return c, fmt.Errorf("%s must be an integer", key)
+			}
+			*n = i
+			continue
+		}
+		dur, err := time.ParseDuration(strings.TrimSpace(val))
+		if err != nil {
+			return c, fmt.Errorf("%s must be a duration such as 30s or 5m", key)
+		}
+		*d = dur
+	}
+	return out, nil
+}
+
+// SettingValue returns a setting's current value: an int, or a duration
+// formatted as a string.
+func (c Config) SettingValue(key string) any {
+	n, d, ok := c.setting(key)
+	switch {
+	case !ok:
+		return nil
+	case n != nil:
+		return *n
+	default:
+		return d.String()
+	}
+}
+
+func (c *Config) setting(key string) (*int, *time.Duration, bool) {
+	switch key {
+	case SettingTickerLimit:
+		return &c.TickerLimit, nil, true
+	case SettingRateWindow:
+		return nil, &c.RateWindow, true
+	case SettingLeaderboardLimit:
+		return &c.DefaultLeaderboardLimit, nil, true
+	case SettingPollInterval:
+		return nil, &c.PollInterval, true
+	case SettingPollConcurrency:
+		return &c.PollConcurrency, nil, true
+	}
+	return nil, nil, false
+}
+
 func getEnv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
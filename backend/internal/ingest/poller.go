@@ -3,12 +3,14 @@ package ingest
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -26,12 +28,15 @@ type Config struct {
 
 // Poller fetches merchant data on a schedule and stores it.
 type Poller struct {
-	store       *store.Store
-	client      *http.Client
-	interval    time.Duration
-	concurrency int
-	baseURL     string
-	logger      *log.Logger
+	store   *store.Store
+	client  *http.Client
+	baseURL string
+	logger  *log.Logger
+
+	mu           sync.Mutex
+	interval     time.Duration
+	concurrency  int
+	reconfigured chan struct{}
 }
 
 // NewPoller returns a configured poller.
@@ -53,29 +58,70 @@ func NewPoller(st *store.Store, cfg Config, logger *log.Logger) *Poller {
 		base = "https://api.paywithflash.com"
 	}
 	return &Poller{
-		store:       st,
-		client:      &http.Client{Timeout: timeout},
-		interval:    interval,
-		concurrency: concurrency,
-		baseURL:     strings.TrimRight(base, "/"),
-		logger:      logger,
+		store:        st,
+		client:       &http.Client{Timeout: timeout},
+		interval:     interval,
+		concurrency:  concurrency,
+		baseURL:      strings.TrimRight(base, "/"),
+		logger:       logger,
+		reconfigured: make(chan struct{}, 1),
+	}
+}
+
+// Reconfigure changes the polling interval and worker count. A running poller
+// switches to the new interval immediately; non-positive values are ignored.
+func (p *Poller) Reconfigure(interval time.Duration, concurrency int) {
+	p.mu.Lock()
+	if interval > 0 {
+		p.interval = interval
+	}
+	if concurrency > 0 {
+		p.concurrency = concurrency
+	}
+	p.mu.Unlock()
+	select {
+	case p.reconfigured <- struct{}{}:
+	default:
 	}
 }
 
+func (p *Poller) pollInterval() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.interval
+}
+
+// workers returns how many workers to use for n jobs.
+func (p *Poller) workers(n int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.concurrency < n {
+		return p.concurrency
+	}
+	return n
+}
+
 // Start begins background polling until ctx is cancelled.
 func (p *Poller) Start(ctx context.Context) {
-	if p.interval <= 0 {
+	interval := p.pollInterval()
+	if interval <= 0 {
 		p.logger.Println("poller disabled: interval <= 0")
 		return
 	}
-	ticker := time.NewTicker(p.interval)
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
-	p.logger.Printf("poller started (interval=%s)\n", p.interval)
+	p.logger.Printf("poller started (interval=%s)\n", interval)
 	for {
 		select {
 		case <-ctx.Done():
 			p.logger.Println("poller stopped")
 			return
+		case <-p.reconfigured:
+			if next := p.pollInterval(); next != interval {
+				interval = next
+				ticker.Reset(interval)
+				p.logger.Printf("poller interval changed to %s\n", interval)
+			}
 		case <-ticker.C:
 			if err := p.pollAll(ctx); err != nil {
 				p.logger.Printf("poller cycle error: %v\n", err)
@@ -84,9 +130,17 @@ func (p *Poller) Start(ctx context.Context) {
 	}
 }
 
-// pollAll fetches enabled merchants concurrently using a worker pool.
+// pollAll fetches enabled merchants of the default event concurrently using
+// a worker pool.
 func (p *Poller) pollAll(ctx context.Context) error {
-	merchants, err := p.store.ListMerchants(ctx, true)
+	// Pin the event for the whole cycle so an admin switching the default
+	// event mid-cycle doesn't split a cycle across two events.
+	st := p.store.ForEvent(p.store.EventID())
+	event, err := st.CurrentEvent(ctx)
+	if err != nil {
+		return err
+	}
+	merchants, err := st.ListMerchants(ctx, true)
 	if err != nil {
 		return err
 	}
@@ -107,15 +161,12 @@ func (p *Poller) pollAll(ctx context.Context) error {
 		err        error
 	}
 	results := make(chan result, len(merchants))
-	workers := p.concurrency
-	if workers > len(merchants) {
-		workers = len(merchants)
-	}
+	workers := p.workers(len(merchants))
 
 	for i := 0; i < workers; i++ {
 		go func() {
 			for merchant := range work {
-				err := p.pollMerchant(ctx, merchant)
+				err := p.pollMerchant(ctx, st, event, merchant)
 				results <- result{merchantID: merchant.ID, err: err}
 			}
 		}()
@@ -133,16 +184,23 @@ func (p *Poller) pollAll(ctx context.Context) error {
 	return nil
 }
 
-// RefreshMerchant forces a poll for a single merchant.
-func (p *Poller) RefreshMerchant(ctx context.Context, merchantID string) error {
-	m, err := p.store.GetMerchant(ctx, merchantID)
+// RefreshMerchant forces a poll for a single merchant of the given event.
+func (p *Poller) RefreshMerchant(ctx context.Context, eventID int64, merchantID string) error {
+	st := p.store.ForEvent(eventID)
+	event, err := st.CurrentEvent(ctx)
 	if err != nil {
 		return err
 	}
-	return p.pollMerchant(ctx, m)
+	m, err := st.GetMerchant(ctx, merchantID)
+	if err != nil {
+		return err
+	}
+	return p.pollMerchant(ctx, st, event, m)
 }
 
-func (p *Poller) pollMerchant(ctx context.Context, merchant store.Merchant) error {
+// pollMerchant ingests one merchant into st. Upstream returns a merchant's
+// full history, so sales outside the event's date range are skipped.
+func (p *Poller) pollMerchant(ctx context.Context, st *store.Store, event store.Event, merchant store.Merchant) error {
 	reqCtx, cancel := context.WithTimeout(ctx, p.client.Timeout)
 	defer cancel()
 	payload, err := p.fetch(reqCtx, merchant)
@@ -155,6 +213,9 @@ func (p *Poller) pollMerchant(ctx context.Context, merchant store.Merchant) erro
 		if err != nil {
 			return fmt.Errorf("parse sale date: %w", err)
 		}
+		if !event.Contains(saleDate) {
+			continue
+		}
 		amount, err := parseSats(sale.TotalCostSats)
 		if err != nil {
 			return fmt.Errorf("parse sats: %w", err)
@@ -167,7 +228,7 @@ func (p *Poller) pollMerchant(ctx context.Context, merchant store.Merchant) erro
 			Source:     store.SourcePayWithFlash,
 		})
 	}
-	inserted, err := p.store.RecordTransactions(ctx, merchant.ID, txs)
+	inserted, err := st.RecordTransactions(ctx, merchant.ID, txs)
 	if err != nil {
 		return err
 	}
@@ -188,19 +249,86 @@ func (p *Poller) pollMerchant(ctx context.Context, merchant store.Merchant) erro
 			Active:            prod.ActiveStatus,
 		})
 	}
-	if err := p.store.UpsertProducts(ctx, merchant.ID, snapshots); err != nil {
+	if err := st.UpsertProducts(ctx, merchant.ID, snapshots); err != nil {
 		return err
 	}
-	if err := p.store.UpdateMerchantPollTime(ctx, merchant.ID, time.Now().UTC()); err != nil {
+	if err := st.UpdateMerchantPollTime(ctx, merchant.ID, time.Now().UTC()); err != nil {
 		return err
 	}
-	if _, err := p.store.ProcessMilestones(ctx); err != nil {
+	if _, err := st.ProcessMilestones(ctx); err != nil {
 		return err
 	}
 	p.logger.Printf("merchant %s poll complete (new_tx=%d)\n", merchant.ID, inserted)
 	return nil
 }
 
+// ProbeResult reports how the upstream API responded to a merchant's
+// credentials.
+type ProbeResult struct {
+	AuthOK       bool   `json:"auth_ok"`
+	UpstreamName string `json:"upstream_name,omitempty"`
+	Sales        int    `json:"sales"`
+	Products     int    `json:"products"`
+	Status       int    `json:"status,omitempty"` // HTTP status of an upstream error response
+	Error        string `json:"error,omitempty"`
+}
+
+// StatusError is returned when the upstream API answers with an error
+// status, as opposed to not answering at all.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "upstream responded " + e.Status
+}
+
+// Probe fetches each merchant from the upstream API without storing anything.
+// Results are returned in the same order as merchants.
+func (p *Poller) Probe(ctx context.Context, merchants []store.Merchant) []ProbeResult {
+	results := make([]ProbeResult, len(merchants))
+	work := make(chan int, len(merchants))
+	for i := range merchants {
+		work <- i
+	}
+	close(work)
+
+	workers := p.workers(len(merchants))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range work {
+				results[idx] = p.probe(ctx, merchants[idx])
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (p *Poller) probe(ctx context.Context, merchant store.Merchant) ProbeResult {
+	reqCtx, cancel := context.WithTimeout(ctx, p.client.Timeout)
+	defer cancel()
+	payload, err := p.fetch(reqCtx, merchant)
+	if err != nil {
+		result := ProbeResult{Error: err.Error()}
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			result.Status = statusErr.StatusCode
+		}
+		return result
+	}
+	return ProbeResult{
+		AuthOK:       true,
+		UpstreamName: payload.Name,
+		Sales:        len(payload.Sales),
+		Products:     len(payload.Products),
+	}
+}
+
 func (p *Poller) fetch(ctx context.Context, merchant store.Merchant) (sourceData, error) {
 	var out sourceData
 	base, err := url.Parse(p.baseURL)
@@ -222,7 +350,7 @@ func (p *Poller) fetch(ctx context.Context, merchant store.Merchant) (sourceData
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 300 {
-		return out, fmt.Errorf("upstream responded %s", resp.Status)
+		return out, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 	var envelope sourceEnvelope
 	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
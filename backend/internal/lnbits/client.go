@@ -0,0 +1,104 @@
+// Package lnbits is a minimal client for the LNbits wallet API, enough to
+// create incoming invoices and check whether they were paid.
+package lnbits
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when LNbits doesn't know a payment.
+var ErrNotFound = errors.New("lnbits payment not found")
+
+// Client talks to one LNbits wallet using its invoice/read key.
+type Client struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+// Invoice is a freshly created incoming payment request.
+type Invoice struct {
+	PaymentHash    string `json:"payment_hash"`
+	PaymentRequest string `json:"payment_request"` // bolt11
+	CheckingID     string `json:"checking_id"`
+}
+
+// Status is the state of an incoming payment.
+type Status struct {
+	Paid     bool   `json:"paid"`
+	Preimage string `json:"preimage"`
+}
+
+// New returns a client for the wallet at baseURL. A non-positive timeout
+// defaults to 10 seconds.
+func New(baseURL, apiKey string, timeout time.Duration) *Client {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		http:    &http.Client{Timeout: timeout},
+	}
+}
+
+// CreateInvoice requests a bolt11 invoice for amountSats that expires after
+// expiry.
+func (c *Client) CreateInvoice(ctx context.Context, amountSats int64, memo string, expiry time.Duration) (Invoice, error) {
+	var inv Invoice
+	body, err := json.Marshal(map[string]any{
+		"out":    false,
+		"amount": amountSats,
+		"memo":   memo,
+		"expiry": int64(expiry.Seconds()),
+	})
+	if err != nil {
+		return inv, err
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/v1/payments", body, &inv); err != nil {
+		return inv, err
+	}
+	if inv.PaymentHash == "" || inv.PaymentRequest == "" {
+		return inv, errors.New("lnbits returned an incomplete invoice")
+	}
+	return inv, nil
+}
+
+// PaymentStatus reports whether the invoice with paymentHash was paid.
+func (c *Client) PaymentStatus(ctx context.Context, paymentHash string) (Status, error) {
+	var st Status
+	err := c.do(ctx, http.MethodGet, path.Join("/api/v1/payments", url.PathEscape(paymentHash)), nil, &st)
+	return st, err
+}
+
+func (c *Client) do(ctx context.Context, method, endpoint string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", c.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("lnbits responded %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,111 @@
+package mock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// LNbits is a fake LNbits wallet API. It creates invoices with random payment
+// hashes and reports them unpaid until Settle is called, either directly or
+// through POST /api/v1/payments/{hash}/settle.
+type LNbits struct {
+	apiKey string
+
+	mu       sync.Mutex
+	invoices map[string]*lnbitsInvoice
+}
+
+type lnbitsInvoice struct {
+	amount int64
+	memo   string
+	paid   bool
+}
+
+// NewLNbits returns a fake wallet that accepts apiKey, or any key if empty.
+func NewLNbits(apiKey string) *LNbits {
+	return &LNbits{apiKey: apiKey, invoices: make(map[string]*lnbitsInvoice)}
+}
+
+// Settle marks an invoice paid. It reports false for unknown hashes.
+func (l *LNbits) Settle(paymentHash string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	inv, ok := l.invoices[paymentHash]
+	if ok {
+		inv.paid = true
+	}
+	return ok
+}
+
+func (l *LNbits) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if l.apiKey != "" && r.Header.Get("X-Api-Key") != l.apiKey {
+		http.Error(w, "invalid api key", http.StatusUnauthorized)
+		return
+	}
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/payments"), "/")
+	switch {
+	case rest == "" && r.Method == http.MethodPost:
+		l.handleCreate(w, r)
+	case rest != "" && r.Method == http.MethodGet:
+		l.handleStatus(w, rest)
+	case strings.HasSuffix(rest, "/settle") && r.Method == http.MethodPost:
+		if !l.Settle(strings.TrimSuffix(rest, "/settle")) {
+			http.Error(w, "payment not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (l *LNbits) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Out    bool   `json:"out"`
+		Amount int64  `json:"amount"`
+		Memo   string `json:"memo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Out || req.Amount <= 0 {
+		http.Error(w, "invalid invoice request", http.StatusBadRequest)
+		return
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hash := hex.EncodeToString(buf)
+
+	l.mu.Lock()
+	l.invoices[hash] = &lnbitsInvoice{amount: req.Amount, memo: req.Memo}
+	l.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"payment_hash":    hash,
+		"payment_request": fmt.Sprintf("lnbcrt%dn1mock%s", req.Amount*10, hash[:16]),
+		"checking_id":     hash,
+	})
+}
+
+func (l *LNbits) handleStatus(w http.ResponseWriter, hash string) {
+	l.mu.Lock()
+	inv, ok := l.invoices[hash]
+	var paid bool
+	if ok {
+		paid = inv.paid
+	}
+	l.mu.Unlock()
+	if !ok {
+		http.Error(w, "payment not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"paid": paid, "preimage": ""})
+}
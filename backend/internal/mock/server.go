@@ -48,6 +48,11 @@ func NewServer(cfg Config, logger *log.Logger) *Server {
 	mux.HandleFunc("/admin/merchants/", s.handleAdminMerchantsDetail)
 	mux.HandleFunc("/health", s.handleHealth)
 
+	// Fake LNbits wallet for WiFi invoices; accepts any API key
+	lnbits := NewLNbits("")
+	mux.Handle("/api/v1/payments", lnbits)
+	mux.Handle("/api/v1/payments/", lnbits)
+
 	s.server = &http.Server{
 		Addr:    cfg.Addr,
 		Handler: mux,
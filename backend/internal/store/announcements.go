@@ -0,0 +1,262 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxAnnouncementLength keeps announcements short enough to read from
+// across a hall.
+const maxAnnouncementLength = 280
+
+// AnnouncementPriority decides how prominently an announcement is shown and
+// which one wins when several are active.
+type AnnouncementPriority string
+
+const (
+	PriorityLow    AnnouncementPriority = "low"
+	PriorityNormal AnnouncementPriority = "normal"
+	PriorityHigh   AnnouncementPriority = "high"
+	PriorityUrgent AnnouncementPriority = "urgent"
+)
+
+// rank orders priorities from low to urgent; unknown ones rank 0.
+func (p AnnouncementPriority) rank() int {
+	switch p {
+	case PriorityLow:
+		return 1
+	case PriorityNormal:
+		return 2
+	case PriorityHigh:
+		return 3
+	case PriorityUrgent:
+		return 4
+	}
+	return 0
+}
+
+// Announcement is a message organisers push onto the dashboards. Empty
+// target lists mean every display and every scene.
+type Announcement struct {
+	ID         int64                `json:"id"`
+	EventID    int64                `json:"event_id"`
+	Message    string               `json:"message"`
+	Priority   AnnouncementPriority `json:"priority"`
+	StartsAt   time.Time            `json:"starts_at"`
+	EndsAt     *time.Time           `json:"ends_at"` // nil runs until deleted
+	DisplayIDs []string             `json:"display_ids"`
+	SceneIDs   []string             `json:"scene_ids"` // shown only while one of these scenes is on screen
+	CreatedAt  time.Time            `json:"created_at"`
+	UpdatedAt  time.Time            `json:"updated_at"`
+}
+
+// ActiveAt reports whether the announcement runs at t.
+func (a Announcement) ActiveAt(t time.Time) bool {
+	return !t.Before(a.StartsAt) && (a.EndsAt == nil || t.Before(*a.EndsAt))
+}
+
+// Targets reports whether the announcement is meant for a display and
+// scene. An empty displayID is a screen that didn't identify itself, which
+// only gets untargeted announcements; an empty sceneID matches any scene.
+func (a Announcement) Targets(displayID, sceneID string) bool {
+	if len(a.DisplayIDs) > 0 && !slices.Contains(a.DisplayIDs, displayID) {
+		return false
+	}
+	return sceneID == "" || len(a.SceneIDs) == 0 || slices.Contains(a.SceneIDs, sceneID)
+}
+
+func (s *Store) validateAnnouncement(ctx context.Context, a *Announcement) error {
+	a.Message = strings.TrimSpace(a.Message)
+	if a.Priority == "" {
+		a.Priority = PriorityNormal
+	}
+	switch {
+	case a.Message == "":
+		return errors.New("message is required")
+	case len([]rune(a.Message)) > maxAnnouncementLength:
+		return fmt.Errorf("message is limited to %d characters", maxAnnouncementLength)
+	case a.Priority.rank() == 0:
+		return errors.New("invalid priority: use low, normal, high or urgent")
+	case a.EndsAt != nil && !a.EndsAt.After(a.StartsAt):
+		return errors.New("ends_at must be after starts_at")
+	}
+	for _, id := range a.SceneIDs {
+		if _, err := s.GetScene(ctx, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("scene %q does not exist", id)
+			}
+			return err
+		}
+	}
+	for _, id := range a.DisplayIDs {
+		if _, err := s.GetDisplay(ctx, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("display %q does not exist", id)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+const announcementColumns = `id, event_id, message, priority, starts_at, ends_at, display_ids, scene_ids,
+	created_at, updated_at`
+
+func scanAnnouncement(row interface{ Scan(...any) error }) (Announcement, error) {
+	var a Announcement
+	var endsAt sql.NullTime
+	var displayIDs, sceneIDs string
+	err := row.Scan(&a.ID, &a.EventID, &a.Message, &a.Priority, &a.StartsAt, &endsAt, &displayIDs, &sceneIDs,
+		&a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return a, err
+	}
+	if endsAt.Valid {
+		a.EndsAt = &endsAt.Time
+	}
+	if err := json.Unmarshal([]byte(displayIDs), &a.DisplayIDs); err != nil {
+		return a, err
+	}
+	return a, json.Unmarshal([]byte(sceneIDs), &a.SceneIDs)
+}
+
+// targetsJSON encodes a target list, never as null.
+func targetsJSON(ids []string) (string, error) {
+	if ids == nil {
+		ids = []string{}
+	}
+	data, err := json.Marshal(ids)
+	return string(data), err
+}
+
+// ListAnnouncements returns the event's announcements, most recent start
+// first. With current set, ones that have ended are left out.
+func (s *Store) ListAnnouncements(ctx context.Context, current bool, now time.Time) ([]Announcement, error) {
+	query := `SELECT ` + announcementColumns + ` FROM announcements WHERE event_id=?`
+	args := []any{s.EventID()}
+	if current {
+		query += ` AND (ends_at IS NULL OR ends_at > ?)`
+		args = append(args, now.UTC())
+	}
+	query += ` ORDER BY starts_at DESC, id DESC`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := make([]Announcement, 0)
+	for rows.Next() {
+		a, err := scanAnnouncement(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, a)
+	}
+	return items, rows.Err()
+}
+
+// ActiveAnnouncements returns the announcements running at now for a
+// display and scene (see Announcement.Targets), most urgent first. With
+// upcoming set, ones that start later are included too.
+func (s *Store) ActiveAnnouncements(ctx context.Context, now time.Time, displayID, sceneID string, upcoming bool) ([]Announcement, error) {
+	all, err := s.ListAnnouncements(ctx, true, now)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Announcement, 0, len(all))
+	for _, a := range all {
+		if (upcoming || a.ActiveAt(now)) && a.Targets(displayID, sceneID) {
+			out = append(out, a)
+		}
+	}
+	slices.SortStableFunc(out, func(a, b Announcement) int {
+		return b.Priority.rank() - a.Priority.rank()
+	})
+	return out, nil
+}
+
+// GetAnnouncement fetches one of the event's announcements.
+func (s *Store) GetAnnouncement(ctx context.Context, id int64) (Announcement, error) {
+	return scanAnnouncement(s.db.QueryRowContext(ctx, `
+		SELECT `+announcementColumns+` FROM announcements WHERE event_id=? AND id=?
+	`, s.EventID(), id))
+}
+
+// CreateAnnouncement adds an announcement to the event. A zero StartsAt
+// starts it now.
+func (s *Store) CreateAnnouncement(ctx context.Context, a Announcement) (Announcement, error) {
+	now := time.Now().UTC()
+	if a.StartsAt.IsZero() {
+		a.StartsAt = now
+	}
+	if err := s.validateAnnouncement(ctx, &a); err != nil {
+		return a, err
+	}
+	displayIDs, err := targetsJSON(a.DisplayIDs)
+	if err != nil {
+		return a, err
+	}
+	sceneIDs, err := targetsJSON(a.SceneIDs)
+	if err != nil {
+		return a, err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO announcements (event_id, message, priority, starts_at, ends_at, display_ids, scene_ids,
+			created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.EventID(), a.Message, a.Priority, a.StartsAt.UTC(), nullTime(a.EndsAt), displayIDs, sceneIDs, now, now)
+	if err != nil {
+		return a, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return a, err
+	}
+	return s.GetAnnouncement(ctx, id)
+}
+
+// UpdateAnnouncement replaces an announcement's fields.
+func (s *Store) UpdateAnnouncement(ctx context.Context, a Announcement) (Announcement, error) {
+	if err := s.validateAnnouncement(ctx, &a); err != nil {
+		return a, err
+	}
+	displayIDs, err := targetsJSON(a.DisplayIDs)
+	if err != nil {
+		return a, err
+	}
+	sceneIDs, err := targetsJSON(a.SceneIDs)
+	if err != nil {
+		return a, err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE announcements
+		SET message=?, priority=?, starts_at=?, ends_at=?, display_ids=?, scene_ids=?, updated_at=?
+		WHERE event_id=? AND id=?
+	`, a.Message, a.Priority, a.StartsAt.UTC(), nullTime(a.EndsAt), displayIDs, sceneIDs, time.Now().UTC(),
+		s.EventID(), a.ID)
+	if err != nil {
+		return a, err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return a, sql.ErrNoRows
+	}
+	return s.GetAnnouncement(ctx, a.ID)
+}
+
+// DeleteAnnouncement removes an announcement.
+func (s *Store) DeleteAnnouncement(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM announcements WHERE event_id=? AND id=?`, s.EventID(), id)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,617 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Conditions are small boolean expressions over event metrics, such as
+//
+//	merchants_with_transactions(5) >= 10
+//	source_volume("wifi") > merchant_volume("coffee")
+//
+// They are parsed into a tree and evaluated here; nothing is passed to SQL
+// or executed beyond the fixed metric catalogue, and the size is bounded.
+const (
+	maxConditionLength  = 500
+	maxConditionNodes   = 100
+	maxConditionMetrics = 16
+	maxConditionDepth   = 20 // nested parentheses, ! and unary minus
+)
+
+// conditionArg is the kind of argument a metric takes.
+type conditionArg int
+
+const (
+	argNone conditionArg = iota
+	argString
+	argNumber
+	argDuration
+)
+
+// conditionMetricSpec describes a metric a condition can use.
+type conditionMetricSpec struct {
+	arg  conditionArg
+	help string
+}
+
+// conditionMetrics is the catalogue of metrics. Transaction-based ones
+// count the sales in the milestone's measurement window.
+var conditionMetrics = map[string]conditionMetricSpec{
+	"transactions":                {argNone, "number of sales"},
+	"volume":                      {argNone, "sats sold"},
+	"active_merchants":            {argNone, "merchants with at least one sale"},
+	"unique_products":             {argNone, "products sold at least once (whole event)"},
+	"max_payment":                 {argNone, "largest single payment in sats"},
+	"source_transactions":         {argString, "sales from a source, e.g. source_transactions(\"wifi\")"},
+	"source_volume":               {argString, "sats from a source"},
+	"merchant_transactions":       {argString, "one merchant's sales, by merchant id"},
+	"merchant_volume":             {argString, "one merchant's sats"},
+	"merchants_with_transactions": {argNumber, "merchants with at least n sales"},
+	"merchants_with_volume":       {argNumber, "merchants with at least n sats"},
+	"rate":                        {argDuration, "sales per minute over a window of at least 1m, e.g. rate(\"5m\")"},
+}
+
+// ConditionMetricHelp lists the metrics conditions can use, for docs and
+// editors.
+func ConditionMetricHelp() map[string]string {
+	out := make(map[string]string, len(conditionMetrics))
+	for name, spec := range conditionMetrics {
+		out[name] = spec.help
+	}
+	return out
+}
+
+// conditionMetric is one metric reference in a condition.
+type conditionMetric struct {
+	name string
+	str  string  // argString, argDuration
+	num  float64 // argNumber
+}
+
+// key identifies the metric in previews, e.g. source_volume("wifi").
+func (m conditionMetric) key() string {
+	switch conditionMetrics[m.name].arg {
+	case argString, argDuration:
+		return fmt.Sprintf("%s(%q)", m.name, m.str)
+	case argNumber:
+		return fmt.Sprintf("%s(%s)", m.name, strconv.FormatFloat(m.num, 'f', -1, 64))
+	}
+	return m.name
+}
+
+// condNode is a node of a parsed condition. Numeric nodes evaluate to a
+// number, the rest to 0 or 1.
+type condNode struct {
+	op          string // "num", "metric", "!", "neg" or a binary operator
+	num         float64
+	metric      int // index into Condition.metrics
+	left, right *condNode
+	boolean     bool
+}
+
+// Condition is a parsed, type-checked condition.
+type Condition struct {
+	root    *condNode
+	metrics []conditionMetric
+}
+
+// ConditionError points at the place in the source a condition failed to
+// parse.
+type ConditionError struct {
+	Pos int // byte offset
+	Msg string
+}
+
+func (e *ConditionError) Error() string {
+	return fmt.Sprintf("invalid condition at %d: %s", e.Pos, e.Msg)
+}
+
+// ParseCondition parses and type-checks a condition. The result must be a
+// comparison or a combination of them, and use at least one metric.
+func ParseCondition(src string) (*Condition, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, &ConditionError{Msg: "condition is empty"}
+	}
+	if len(src) > maxConditionLength {
+		return nil, &ConditionError{Msg: fmt.Sprintf("conditions are limited to %d characters", maxConditionLength)}
+	}
+	tokens, err := lexCondition(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens, cond: &Condition{}}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &ConditionError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %q", t.text)}
+	}
+	if !root.boolean {
+		return nil, &ConditionError{Msg: "condition must compare values, e.g. volume > 1000000"}
+	}
+	if len(p.cond.metrics) == 0 {
+		return nil, &ConditionError{Msg: "condition must use a metric, e.g. volume > 1000000"}
+	}
+	p.cond.root = root
+	return p.cond, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type condToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// conditionOps are the operators, longest first so <= wins over <.
+var conditionOps = []string{"&&", "||", "<=", ">=", "==", "!=", "<", ">", "!", "+", "-", "*", "/", "(", ")", ","}
+
+func lexCondition(src string) ([]condToken, error) {
+	var tokens []condToken
+	i := 0
+	for i < len(src) {
+		c, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(c):
+			i += size
+		case c >= '0' && c <= '9' || c == '.':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.' || src[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, condToken{tokNumber, src[start:i], start})
+		case c == '"':
+			end := strings.IndexByte(src[i+1:], '"')
+			if end < 0 {
+				return nil, &ConditionError{Pos: i, Msg: "unterminated string"}
+			}
+			tokens = append(tokens, condToken{tokString, src[i+1 : i+1+end], i})
+			i += end + 2
+		case c == '_' || c < utf8.RuneSelf && unicode.IsLetter(c):
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, condToken{tokIdent, strings.ToLower(src[start:i]), start})
+		default:
+			matched := false
+			for _, op := range conditionOps {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, condToken{tokOp, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, &ConditionError{Pos: i, Msg: fmt.Sprintf("unexpected %q", c)}
+			}
+		}
+	}
+	return append(tokens, condToken{kind: tokEOF, pos: len(src)}), nil
+}
+
+// conditionParser is a recursive-descent parser; precedence from low to
+// high is ||, &&, !, comparisons, + -, * /, unary minus.
+type conditionParser struct {
+	tokens []condToken
+	i      int
+	nodes  int
+	depth  int
+	cond   *Condition
+}
+
+func (p *conditionParser) peek() condToken { return p.tokens[p.i] }
+
+func (p *conditionParser) next() condToken {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the operator or keyword if it is next.
+func (p *conditionParser) accept(texts ...string) (condToken, bool) {
+	t := p.peek()
+	if t.kind != tokOp && t.kind != tokIdent {
+		return t, false
+	}
+	for _, text := range texts {
+		if t.text == text {
+			return p.next(), true
+		}
+	}
+	return t, false
+}
+
+func (p *conditionParser) node(n condNode, pos int) (*condNode, error) {
+	p.nodes++
+	if p.nodes > maxConditionNodes {
+		return nil, &ConditionError{Pos: pos, Msg: "condition is too long"}
+	}
+	return &n, nil
+}
+
+// enter counts a level of nesting; the caller leaves it again with
+// p.depth--.
+func (p *conditionParser) enter(pos int) error {
+	p.depth++
+	if p.depth > maxConditionDepth {
+		return &ConditionError{Pos: pos, Msg: "condition is nested too deeply"}
+	}
+	return nil
+}
+
+// combine builds a binary node, checking that both sides are numbers
+// (want false) or both comparisons (want true).
+func (p *conditionParser) combine(op condToken, left, right *condNode, want, result bool) (*condNode, error) {
+	if left.boolean != want || right.boolean != want {
+		kind := "numbers"
+		if want {
+			kind = "comparisons"
+		}
+		return nil, &ConditionError{Pos: op.pos, Msg: fmt.Sprintf("%s needs %s on both sides", op.text, kind)}
+	}
+	return p.node(condNode{op: op.text, left: left, right: right, boolean: result}, op.pos)
+}
+
+func (p *conditionParser) or() (*condNode, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("||", "or")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		op.text = "||"
+		if left, err = p.combine(op, left, right, true, true); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *conditionParser) and() (*condNode, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("&&", "and")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		op.text = "&&"
+		if left, err = p.combine(op, left, right, true, true); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *conditionParser) not() (*condNode, error) {
+	if op, ok := p.accept("!", "not"); ok {
+		if err := p.enter(op.pos); err != nil {
+			return nil, err
+		}
+		defer func() { p.depth-- }()
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		if !operand.boolean {
+			return nil, &ConditionError{Pos: op.pos, Msg: "! needs a comparison"}
+		}
+		return p.node(condNode{op: "!", left: operand, boolean: true}, op.pos)
+	}
+	return p.comparison()
+}
+
+func (p *conditionParser) comparison() (*condNode, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("<", "<=", ">", ">=", "==", "!=")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	return p.combine(op, left, right, false, true)
+}
+
+func (p *conditionParser) sum() (*condNode, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = p.combine(op, left, right, false, false); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *conditionParser) term() (*condNode, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = p.combine(op, left, right, false, false); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *conditionParser) unary() (*condNode, error) {
+	if op, ok := p.accept("-"); ok {
+		if err := p.enter(op.pos); err != nil {
+			return nil, err
+		}
+		defer func() { p.depth-- }()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if operand.boolean {
+			return nil, &ConditionError{Pos: op.pos, Msg: "- needs a number"}
+		}
+		return p.node(condNode{op: "neg", left: operand}, op.pos)
+	}
+	return p.primary()
+}
+
+func (p *conditionParser) primary() (*condNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		n, err := strconv.ParseFloat(strings.ReplaceAll(t.text, "_", ""), 64)
+		if err != nil {
+			return nil, &ConditionError{Pos: t.pos, Msg: fmt.Sprintf("invalid number %q", t.text)}
+		}
+		return p.node(condNode{op: "num", num: n}, t.pos)
+	case tokIdent:
+		return p.metric(t)
+	case tokOp:
+		if t.text == "(" {
+			if err := p.enter(t.pos); err != nil {
+				return nil, err
+			}
+			defer func() { p.depth-- }()
+			inner, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.accept(")"); !ok {
+				return nil, &ConditionError{Pos: p.peek().pos, Msg: "missing )"}
+			}
+			return inner, nil
+		}
+	case tokEOF:
+		return nil, &ConditionError{Pos: t.pos, Msg: "unexpected end of condition"}
+	}
+	return nil, &ConditionError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %q", t.text)}
+}
+
+// metric parses a metric reference and its argument, if it takes one.
+func (p *conditionParser) metric(t condToken) (*condNode, error) {
+	spec, ok := conditionMetrics[t.text]
+	if !ok {
+		return nil, &ConditionError{Pos: t.pos, Msg: fmt.Sprintf("unknown metric %q", t.text)}
+	}
+	m := conditionMetric{name: t.text}
+	if spec.arg != argNone {
+		if _, ok := p.accept("("); !ok {
+			return nil, &ConditionError{Pos: p.peek().pos, Msg: fmt.Sprintf("%s needs an argument", t.text)}
+		}
+		arg := p.next()
+		switch {
+		case spec.arg == argNumber && arg.kind == tokNumber:
+			n, err := strconv.ParseFloat(strings.ReplaceAll(arg.text, "_", ""), 64)
+			if err != nil || n < 0 {
+				return nil, &ConditionError{Pos: arg.pos, Msg: fmt.Sprintf("invalid number %q", arg.text)}
+			}
+			m.num = n
+		case spec.arg == argString && arg.kind == tokString && arg.text != "":
+			m.str = arg.text
+		case spec.arg == argDuration && arg.kind == tokString:
+			if d, err := time.ParseDuration(arg.text); err != nil || d < time.Minute {
+				return nil, &ConditionError{Pos: arg.pos, Msg: "rate needs a duration of at least 1m, e.g. \"5m\""}
+			}
+			m.str = arg.text
+		default:
+			kind := "a quoted string"
+			if spec.arg == argNumber {
+				kind = "a number"
+			}
+			return nil, &ConditionError{Pos: arg.pos, Msg: fmt.Sprintf("%s takes %s", t.text, kind)}
+		}
+		if _, ok := p.accept(")"); !ok {
+			return nil, &ConditionError{Pos: p.peek().pos, Msg: "missing )"}
+		}
+	}
+	index := -1
+	for i, existing := range p.cond.metrics {
+		if existing == m {
+			index = i
+		}
+	}
+	if index < 0 {
+		if len(p.cond.metrics) == maxConditionMetrics {
+			return nil, &ConditionError{Pos: t.pos, Msg: fmt.Sprintf("conditions can use at most %d metrics", maxConditionMetrics)}
+		}
+		p.cond.metrics = append(p.cond.metrics, m)
+		index = len(p.cond.metrics) - 1
+	}
+	return p.node(condNode{op: "metric", metric: index}, t.pos)
+}
+
+// eval evaluates a node given the metric values. Division by zero gives 0
+// rather than infinity.
+func (n *condNode) eval(values []float64) float64 {
+	b := func(v bool) float64 {
+		if v {
+			return 1
+		}
+		return 0
+	}
+	switch n.op {
+	case "num":
+		return n.num
+	case "metric":
+		return values[n.metric]
+	case "neg":
+		return -n.left.eval(values)
+	case "!":
+		return b(n.left.eval(values) == 0)
+	case "&&":
+		return b(n.left.eval(values) != 0 && n.right.eval(values) != 0)
+	case "||":
+		return b(n.left.eval(values) != 0 || n.right.eval(values) != 0)
+	}
+	l, r := n.left.eval(values), n.right.eval(values)
+	switch n.op {
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	case "*":
+		return l * r
+	case "/":
+		if r == 0 {
+			return 0
+		}
+		return l / r
+	case "<":
+		return b(l < r)
+	case "<=":
+		return b(l <= r)
+	case ">":
+		return b(l > r)
+	case ">=":
+		return b(l >= r)
+	case "==":
+		return b(l == r)
+	case "!=":
+		return b(l != r)
+	}
+	return math.NaN()
+}
+
+// evaluateCondition measures the condition's metrics over the milestone's
+// measurement window and evaluates it. Metric values are keyed as in
+// conditionMetric.key.
+func (s *Store) evaluateCondition(ctx context.Context, c *Condition, m Milestone) (bool, map[string]float64, error) {
+	values := make([]float64, len(c.metrics))
+	byKey := make(map[string]float64, len(c.metrics))
+	for i, metric := range c.metrics {
+		v, err := s.conditionMetricValue(ctx, metric, m)
+		if err != nil {
+			return false, nil, fmt.Errorf("%s: %w", metric.key(), err)
+		}
+		values[i] = v
+		byKey[metric.key()] = v
+	}
+	return c.root.eval(values) != 0, byKey, nil
+}
+
+func (s *Store) conditionMetricValue(ctx context.Context, metric conditionMetric, m Milestone) (float64, error) {
+	// Only the measurement window applies; the metric picks the rest
+	window := Milestone{MeasureFrom: m.MeasureFrom, MeasureUntil: m.MeasureUntil}
+	filter, filterArgs := window.transactionFilter()
+	where := ` WHERE t.event_id=?` + filter
+	args := append([]any{s.EventID()}, filterArgs...)
+	var query string
+	switch metric.name {
+	case "transactions":
+		query = `SELECT COUNT(*) FROM transactions t` + where
+	case "volume":
+		query = `SELECT COALESCE(SUM(t.amount_sats), 0) FROM transactions t` + where
+	case "active_merchants":
+		query = `SELECT COUNT(DISTINCT t.merchant_id) FROM transactions t` + where
+	case "unique_products":
+		query = `SELECT COUNT(*) FROM products WHERE event_id=? AND total_transactions > 0`
+		args = args[:1]
+	case "max_payment":
+		query = `SELECT COALESCE(MAX(t.amount_sats), 0) FROM transactions t` + where
+	case "source_transactions", "source_volume", "merchant_transactions", "merchant_volume":
+		column := "t.source"
+		if strings.HasPrefix(metric.name, "merchant_") {
+			column = "t.merchant_id"
+		}
+		aggregate := `COUNT(*)`
+		if strings.HasSuffix(metric.name, "_volume") {
+			aggregate = `COALESCE(SUM(t.amount_sats), 0)`
+		}
+		query = `SELECT ` + aggregate + ` FROM transactions t` + where + ` AND ` + column + `=?`
+		args = append(args, metric.str)
+	case "merchants_with_transactions", "merchants_with_volume":
+		aggregate := `COUNT(*)`
+		if metric.name == "merchants_with_volume" {
+			aggregate = `SUM(t.amount_sats)`
+		}
+		query = `SELECT COUNT(*) FROM (SELECT t.merchant_id FROM transactions t` + where +
+			` GROUP BY t.merchant_id HAVING ` + aggregate + ` >= ?)`
+		args = append(args, metric.num)
+	case "rate":
+		d, _ := time.ParseDuration(metric.str)
+		var count int64
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM transactions t`+where+` AND t.sale_date >= ?`,
+			append(args, time.Now().Add(-d).UTC())...).Scan(&count); err != nil {
+			return 0, err
+		}
+		return float64(count) / d.Minutes(), nil
+	default:
+		return 0, errors.New("unknown metric")
+	}
+	var value float64
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&value)
+	return value, err
+}
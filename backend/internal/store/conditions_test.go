@@ -0,0 +1,102 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+	t.Parallel()
+	valid := []string{
+		`merchants_with_transactions(5) >= 10`,
+		`source_volume("wifi") > merchant_volume("coffee")`,
+		`volume > 1_000 and not (transactions < 3) || rate("5m") >= 2`,
+		`volume / transactions >= 100 && -max_payment < 0`,
+		"volume\u00a0>\u00a01", // Unicode spaces separate tokens too
+		strings.Repeat("(", maxConditionDepth) + "volume > 1" + strings.Repeat(")", maxConditionDepth),
+	}
+	for _, src := range valid {
+		if _, err := ParseCondition(src); err != nil {
+			t.Errorf("%s: %v", src, err)
+		}
+	}
+
+	invalid := []struct {
+		name string
+		src  string
+		pos  int    // -1 to skip the check
+		msg  string // part of the message
+	}{
+		{"empty", ``, 0, "empty"},
+		{"too long", strings.Repeat(" ", maxConditionLength) + "volume > 1", 0, "limited"},
+		{"bare metric", `volume`, 0, "compare"},
+		{"missing operand", `volume >`, 8, "end of condition"},
+		{"dangling operator", `volume > 1 &&`, 13, "end of condition"},
+		{"unknown metric", `volumes > 1`, 0, "unknown metric"},
+		{"short rate window", `rate("30s") > 1`, 5, "at least 1m"},
+		{"unquoted string", `source_volume(wifi) > 1`, 14, "quoted string"},
+		{"quoted number", `merchants_with_transactions("5") > 1`, 28, "a number"},
+		{"unterminated string", `source_volume("wifi) > 1`, 14, "unterminated"},
+		{"chained comparison", `volume > 1 > 2`, 11, "unexpected"},
+		{"comparison as number", `(volume > 1) + 2 > 0`, 13, "numbers"},
+		{"number as comparison", `volume > 1 && 2`, 11, "comparisons"},
+		{"trailing statement", `volume > 1; drop table milestones`, 10, "unexpected ';'"},
+		{"no metric", `1 > 0`, 0, "metric"},
+		{"constants only", `2 * 3 == 6 || !(1 < 0)`, 0, "metric"},
+		{"non-ascii identifier", `volume > 1 && é > 2`, 14, "unexpected 'é'"},
+		{"non-ascii operator", `volume ≥ 1`, 7, "unexpected '≥'"},
+		{"too many nodes", strings.Repeat("volume>1&&", 25) + "volume>1", -1, "too long"},
+		{"nested parentheses", strings.Repeat("(", maxConditionDepth+1) + "volume > 1" + strings.Repeat(")", maxConditionDepth+1), maxConditionDepth, "nested too deeply"},
+		{"nested not", strings.Repeat("!", maxConditionDepth+1) + "(volume > 1)", maxConditionDepth, "nested too deeply"},
+		{"nested minus", strings.Repeat("-", maxConditionDepth+1) + "volume > 1", maxConditionDepth, "nested too deeply"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCondition(tt.src)
+			var condErr *ConditionError
+			if !errors.As(err, &condErr) {
+				t.Fatalf("expected a ConditionError, got %v", err)
+			}
+			if tt.pos >= 0 && condErr.Pos != tt.pos {
+				t.Errorf("expected the error at %d, got %d (%s)", tt.pos, condErr.Pos, condErr.Msg)
+			}
+			if !strings.Contains(condErr.Msg, tt.msg) {
+				t.Errorf("expected %q in the message, got %q", tt.msg, condErr.Msg)
+			}
+		})
+	}
+}
+
+func TestConditionPrecedence(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		src    string
+		volume float64
+		want   bool
+	}{
+		{`volume - 2 * 3 == 1`, 7, true},  // * before -
+		{`10 - volume - 2 == 5`, 3, true}, // left to right
+		{`volume / 2 / 2 == 2`, 8, true},  // left to right
+		{`-volume * 2 == -6`, 3, true},    // unary minus binds tightest
+		{`volume > 1 || volume > 5 && volume < 0`, 3, true},
+		{`(volume > 1 || volume > 5) && volume < 0`, 3, false},
+		{`not volume > 1 and volume > 0`, 0, false}, // ! before &&
+		{`!(volume > 1 && volume > 0)`, 0, true},
+		{`volume / 0 == 0`, 5, true}, // division by zero gives 0
+	}
+	for _, tt := range tests {
+		c, err := ParseCondition(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		values := make([]float64, len(c.metrics))
+		for i := range values {
+			values[i] = tt.volume
+		}
+		if got := c.root.eval(values) != 0; got != tt.want {
+			t.Errorf("%s with volume %v: expected %v, got %v", tt.src, tt.volume, tt.want, got)
+		}
+	}
+}
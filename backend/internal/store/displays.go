@@ -0,0 +1,354 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// DisplayPairingTTL is how long a registered display waits for approval.
+// Unapproved registrations older than this are dropped and the display has to
+// register again.
+const DisplayPairingTTL = time.Hour
+
+// displayCommandRetention is how long queued commands are kept, delivered or
+// not. Displays drop commands long before this, so older ones are only
+// history.
+const displayCommandRetention = 24 * time.Hour
+
+// ErrDisplayNotApproved is returned when commanding a display that hasn't
+// been paired yet.
+var ErrDisplayNotApproved = errors.New("display not approved")
+
+// DisplayStatus is where a display is in pairing.
+type DisplayStatus string
+
+const (
+	DisplayPending  DisplayStatus = "pending"
+	DisplayApproved DisplayStatus = "approved"
+)
+
+// Display is a dashboard screen that registered itself. It authenticates
+// with the token handed out at registration; only a hash is stored.
+type Display struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Status      DisplayStatus `json:"status"`
+	PairingCode string        `json:"pairing_code,omitempty"` // shown on screen until approved
+	Scene       string        `json:"scene"`                  // scene on screen at the last heartbeat
+	AppVersion  string        `json:"app_version"`
+	PlaylistID  *int64        `json:"playlist_id"` // nil plays the event's default rotation
+	CreatedAt   time.Time     `json:"created_at"`
+	ApprovedAt  *time.Time    `json:"approved_at"`
+	LastSeenAt  *time.Time    `json:"last_seen_at"`
+}
+
+// DisplayCommandType is what a command asks a display to do.
+type DisplayCommandType string
+
+const (
+	DisplayReload   DisplayCommandType = "reload"
+	DisplayScene    DisplayCommandType = "scene"    // jump to SceneID
+	DisplayMessage  DisplayCommandType = "message"  // overlay Message for DurationSeconds
+	DisplayPlaylist DisplayCommandType = "playlist" // fetch the assigned playlist again
+
+	DisplayAnnouncements DisplayCommandType = "announcements" // fetch announcements again
+)
+
+// defaultMessageSeconds is how long a message stays up when no duration is
+// given.
+const defaultMessageSeconds = 10
+
+// DisplayCommand is queued for one display until its command stream picks
+// it up.
+type DisplayCommand struct {
+	ID              int64              `json:"id"`
+	DisplayID       string             `json:"display_id"`
+	Type            DisplayCommandType `json:"type"`
+	SceneID         string             `json:"scene_id,omitempty"`
+	Message         string             `json:"message,omitempty"`
+	DurationSeconds int64              `json:"duration_seconds,omitempty"`
+	CreatedAt       time.Time          `json:"created_at"`
+	DeliveredAt     *time.Time         `json:"delivered_at"`
+}
+
+func hashDisplayToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+const displayColumns = `id, name, status, pairing_code, scene, app_version, playlist_id, created_at, approved_at,
+	last_seen_at`
+
+func scanDisplay(row interface{ Scan(...any) error }) (Display, error) {
+	var d Display
+	var playlistID sql.NullInt64
+	var approvedAt, lastSeenAt sql.NullTime
+	err := row.Scan(&d.ID, &d.Name, &d.Status, &d.PairingCode, &d.Scene, &d.AppVersion, &playlistID, &d.CreatedAt,
+		&approvedAt, &lastSeenAt)
+	if playlistID.Valid {
+		d.PlaylistID = &playlistID.Int64
+	}
+	if approvedAt.Valid {
+		d.ApprovedAt = &approvedAt.Time
+	}
+	if lastSeenAt.Valid {
+		d.LastSeenAt = &lastSeenAt.Time
+	}
+	return d, err
+}
+
+// RegisterDisplay adds a pending display with a fresh six-digit pairing code
+// and returns it with its token. The token is only available here.
+func (s *Store) RegisterDisplay(ctx context.Context, name, appVersion string) (Display, string, error) {
+	now := time.Now().UTC()
+	if _, err := s.db.ExecContext(ctx, `
+		DELETE FROM displays WHERE status=? AND created_at < ?
+	`, DisplayPending, now.Add(-DisplayPairingTTL)); err != nil {
+		return Display{}, "", err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return Display{}, "", err
+	}
+	token, err := randomHex(32)
+	if err != nil {
+		return Display{}, "", err
+	}
+	// Codes only need to be unique among displays waiting for approval
+	var code string
+	for attempt := 0; ; attempt++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+		if err != nil {
+			return Display{}, "", err
+		}
+		code = fmt.Sprintf("%06d", n.Int64())
+		var taken bool
+		if err := s.db.QueryRowContext(ctx, `
+			SELECT EXISTS(SELECT 1 FROM displays WHERE pairing_code=? AND status=?)
+		`, code, DisplayPending).Scan(&taken); err != nil {
+			return Display{}, "", err
+		}
+		if !taken {
+			break
+		}
+		if attempt == 10 {
+			return Display{}, "", errors.New("no free pairing code")
+		}
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO displays (id, name, token_hash, pairing_code, status, app_version, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, id, strings.TrimSpace(name), hashDisplayToken(token), code, DisplayPending, strings.TrimSpace(appVersion), now)
+	if err != nil {
+		return Display{}, "", err
+	}
+	d, err := s.GetDisplay(ctx, id)
+	return d, token, err
+}
+
+// GetDisplay fetches a display by id.
+func (s *Store) GetDisplay(ctx context.Context, id string) (Display, error) {
+	return scanDisplay(s.db.QueryRowContext(ctx, `
+		SELECT `+displayColumns+` FROM displays WHERE id=?
+	`, id))
+}
+
+// DisplayByToken fetches the display a token was issued to.
+func (s *Store) DisplayByToken(ctx context.Context, token string) (Display, error) {
+	if token == "" {
+		return Display{}, sql.ErrNoRows
+	}
+	return scanDisplay(s.db.QueryRowContext(ctx, `
+		SELECT `+displayColumns+` FROM displays WHERE token_hash=?
+	`, hashDisplayToken(token)))
+}
+
+// ListDisplays returns displays with pending ones first, then by name.
+func (s *Store) ListDisplays(ctx context.Context) ([]Display, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+displayColumns+` FROM displays
+		ORDER BY status = ? DESC, name, created_at
+	`, DisplayPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := make([]Display, 0)
+	for rows.Next() {
+		d, err := scanDisplay(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, d)
+	}
+	return items, rows.Err()
+}
+
+// ApproveDisplay pairs the pending display showing code, optionally naming
+// it. Codes past DisplayPairingTTL no longer match.
+func (s *Store) ApproveDisplay(ctx context.Context, code, name string) (Display, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return Display{}, errors.New("pairing_code is required")
+	}
+	now := time.Now().UTC()
+	var id string
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE displays SET status=?, approved_at=?, pairing_code='', name=COALESCE(NULLIF(?, ''), name)
+		WHERE pairing_code=? AND status=? AND created_at >= ?
+		RETURNING id
+	`, DisplayApproved, now, strings.TrimSpace(name), code, DisplayPending, now.Add(-DisplayPairingTTL)).Scan(&id)
+	if err != nil {
+		return Display{}, err
+	}
+	return s.GetDisplay(ctx, id)
+}
+
+// RenameDisplay changes a display's name.
+func (s *Store) RenameDisplay(ctx context.Context, id, name string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE displays SET name=? WHERE id=?`, strings.TrimSpace(name), id)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteDisplay removes a display and its queued commands, revoking its
+// token.
+func (s *Store) DeleteDisplay(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM displays WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	_, err = s.db.ExecContext(ctx, `DELETE FROM display_commands WHERE display_id=?`, id)
+	return err
+}
+
+// RecordDisplayHeartbeat notes that a display is alive and what it is
+// showing. An empty app version keeps the last one reported.
+func (s *Store) RecordDisplayHeartbeat(ctx context.Context, id, scene, appVersion string, at time.Time) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE displays SET last_seen_at=?, scene=?, app_version=COALESCE(NULLIF(?, ''), app_version)
+		WHERE id=?
+	`, at.UTC(), strings.TrimSpace(scene), strings.TrimSpace(appVersion), id)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func validateDisplayCommand(cmd *DisplayCommand) error {
+	cmd.SceneID = strings.TrimSpace(cmd.SceneID)
+	cmd.Message = strings.TrimSpace(cmd.Message)
+	switch cmd.Type {
+	case DisplayReload, DisplayPlaylist, DisplayAnnouncements:
+		cmd.SceneID, cmd.Message, cmd.DurationSeconds = "", "", 0
+	case DisplayScene:
+		if cmd.SceneID == "" {
+			return errors.New("scene_id is required")
+		}
+		cmd.Message, cmd.DurationSeconds = "", 0
+	case DisplayMessage:
+		if cmd.Message == "" {
+			return errors.New("message is required")
+		}
+		if cmd.DurationSeconds < 0 {
+			return errors.New("duration_seconds must not be negative")
+		}
+		if cmd.DurationSeconds == 0 {
+			cmd.DurationSeconds = defaultMessageSeconds
+		}
+		cmd.SceneID = ""
+	default:
+		return fmt.Errorf("unknown command type %q", cmd.Type)
+	}
+	return nil
+}
+
+// QueueDisplayCommand queues a command for an approved display, dropping
+// commands older than displayCommandRetention.
+func (s *Store) QueueDisplayCommand(ctx context.Context, cmd DisplayCommand) (DisplayCommand, error) {
+	if err := validateDisplayCommand(&cmd); err != nil {
+		return cmd, err
+	}
+	d, err := s.GetDisplay(ctx, cmd.DisplayID)
+	if err != nil {
+		return cmd, err
+	}
+	if d.Status != DisplayApproved {
+		return cmd, ErrDisplayNotApproved
+	}
+	cmd.CreatedAt = time.Now().UTC()
+	if _, err := s.db.ExecContext(ctx, `
+		DELETE FROM display_commands WHERE created_at < ?
+	`, cmd.CreatedAt.Add(-displayCommandRetention)); err != nil {
+		return cmd, err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO display_commands (display_id, type, scene_id, message, duration_seconds, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, cmd.DisplayID, cmd.Type, cmd.SceneID, cmd.Message, cmd.DurationSeconds, cmd.CreatedAt)
+	if err != nil {
+		return cmd, err
+	}
+	cmd.ID, err = res.LastInsertId()
+	return cmd, err
+}
+
+// PendingDisplayCommands returns a display's undelivered commands queued
+// after since, oldest first. Older ones are stale and never delivered.
+func (s *Store) PendingDisplayCommands(ctx context.Context, displayID string, since time.Time) ([]DisplayCommand, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, display_id, type, scene_id, message, duration_seconds, created_at
+		FROM display_commands
+		WHERE display_id=? AND delivered_at IS NULL AND created_at > ?
+		ORDER BY id
+	`, displayID, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := make([]DisplayCommand, 0)
+	for rows.Next() {
+		var cmd DisplayCommand
+		if err := rows.Scan(&cmd.ID, &cmd.DisplayID, &cmd.Type, &cmd.SceneID, &cmd.Message,
+			&cmd.DurationSeconds, &cmd.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, cmd)
+	}
+	return items, rows.Err()
+}
+
+// MarkDisplayCommandDelivered records that a command reached its display.
+func (s *Store) MarkDisplayCommandDelivered(ctx context.Context, id int64, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE display_commands SET delivered_at=? WHERE id=? AND delivered_at IS NULL
+	`, at.UTC(), id)
+	return err
+}
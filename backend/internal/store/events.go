@@ -0,0 +1,380 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultEventSlug names the event created for databases that predate events.
+const DefaultEventSlug = "default"
+
+// ErrEventExists is returned when creating an event whose slug is taken.
+var ErrEventExists = errors.New("event slug already exists")
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Event scopes merchants, transactions, milestones, scenes and WiFi config.
+type Event struct {
+	ID                   int64      `json:"id"`
+	Slug                 string     `json:"slug"`
+	Name                 string     `json:"name"`
+	StartsAt             *time.Time `json:"starts_at,omitempty"`
+	EndsAt               *time.Time `json:"ends_at,omitempty"`
+	WifiLightningAddress string     `json:"wifi_lightning_address"`
+	IsDefault            bool       `json:"is_default"`
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+}
+
+// Contains reports whether ts falls inside the event's optional date range.
+func (e Event) Contains(ts time.Time) bool {
+	if e.StartsAt != nil && ts.Before(*e.StartsAt) {
+		return false
+	}
+	if e.EndsAt != nil && ts.After(*e.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// ForEvent returns a handle whose queries are scoped to the given event.
+// Handles share the underlying database and data version.
+func (s *Store) ForEvent(eventID int64) *Store {
+	scoped := *s
+	scoped.event = eventID
+	return &scoped
+}
+
+// EventID returns the event this handle is scoped to. Unscoped handles follow
+// the current default event.
+func (s *Store) EventID() int64 {
+	if s.event != 0 {
+		return s.event
+	}
+	return s.defaultEvent.Load()
+}
+
+// loadDefaultEvent caches the id of the event flagged as default.
+func (s *Store) loadDefaultEvent(ctx context.Context) error {
+	var id int64
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM events WHERE is_default=1 ORDER BY id LIMIT 1`).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("load default event: %w", err)
+	}
+	s.defaultEvent.Store(id)
+	return nil
+}
+
+const eventColumns = `id, slug, name, starts_at, ends_at, wifi_lightning_address, is_default, created_at, updated_at`
+
+func scanEvent(row interface{ Scan(...any) error }) (Event, error) {
+	var e Event
+	var startsAt, endsAt sql.NullTime
+	var isDefault int
+	if err := row.Scan(&e.ID, &e.Slug, &e.Name, &startsAt, &endsAt, &e.WifiLightningAddress, &isDefault, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		return e, err
+	}
+	e.IsDefault = isDefault != 0
+	if startsAt.Valid {
+		t := startsAt.Time
+		e.StartsAt = &t
+	}
+	if endsAt.Valid {
+		t := endsAt.Time
+		e.EndsAt = &t
+	}
+	return e, nil
+}
+
+// ListEvents returns all events, newest first.
+func (s *Store) ListEvents(ctx context.Context) ([]Event, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+eventColumns+` FROM events ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]Event, 0)
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// GetEvent fetches an event by id.
+func (s *Store) GetEvent(ctx context.Context, id int64) (Event, error) {
+	return scanEvent(s.db.QueryRowContext(ctx, `SELECT `+eventColumns+` FROM events WHERE id=?`, id))
+}
+
+// GetEventBySlug fetches an event by slug.
+func (s *Store) GetEventBySlug(ctx context.Context, slug string) (Event, error) {
+	return scanEvent(s.db.QueryRowContext(ctx, `SELECT `+eventColumns+` FROM events WHERE slug=?`, slug))
+}
+
+// CurrentEvent fetches the event this handle is scoped to.
+func (s *Store) CurrentEvent(ctx context.Context) (Event, error) {
+	return s.GetEvent(ctx, s.EventID())
+}
+
+// CreateEvent inserts a new event and seeds its WiFi merchant, default scenes
+// and WiFi tier.
+func (s *Store) CreateEvent(ctx context.Context, e Event) (Event, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return e, err
+	}
+	defer tx.Rollback()
+	e, err = insertEvent(ctx, tx, e)
+	if err != nil {
+		return e, err
+	}
+	if err := seedEvent(ctx, tx, e.ID); err != nil {
+		return e, err
+	}
+	if err := seedWifiTier(ctx, tx, e.ID); err != nil {
+		return e, err
+	}
+	if err := tx.Commit(); err != nil {
+		return e, err
+	}
+	s.touch()
+	return e, nil
+}
+
+// EnsureEvent returns the event with the given slug, creating it if missing.
+func (s *Store) EnsureEvent(ctx context.Context, slug string) (Event, error) {
+	e, err := s.GetEventBySlug(ctx, slug)
+	if err == nil {
+		return e, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return e, err
+	}
+	return s.CreateEvent(ctx, Event{Slug: slug, Name: slug})
+}
+
+// UpdateEvent updates an event's descriptive fields. The slug is immutable.
+func (s *Store) UpdateEvent(ctx context.Context, e Event) (Event, error) {
+	if strings.TrimSpace(e.Name) == "" {
+		return e, errors.New("event name is required")
+	}
+	if e.StartsAt != nil && e.EndsAt != nil && e.EndsAt.Before(*e.StartsAt) {
+		return e, errors.New("event ends before it starts")
+	}
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE events
+		SET name=?, starts_at=?, ends_at=?, wifi_lightning_address=?, updated_at=?
+		WHERE id=?
+	`, e.Name, nullTime(e.StartsAt), nullTime(e.EndsAt), e.WifiLightningAddress, time.Now().UTC(), e.ID)
+	if err != nil {
+		return e, err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return e, sql.ErrNoRows
+	}
+	s.touch()
+	return s.GetEvent(ctx, e.ID)
+}
+
+// ActivateEvent makes the event with the given slug the default, which
+// unscoped handles, routes and the poller follow.
+func (s *Store) ActivateEvent(ctx context.Context, slug string) (Event, error) {
+	e, err := s.GetEventBySlug(ctx, slug)
+	if err != nil {
+		return e, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return e, err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, `UPDATE events SET is_default=0, updated_at=? WHERE is_default=1 AND id<>?`, now, e.ID); err != nil {
+		return e, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE events SET is_default=1, updated_at=? WHERE id=?`, now, e.ID); err != nil {
+		return e, err
+	}
+	if err := tx.Commit(); err != nil {
+		return e, err
+	}
+	s.defaultEvent.Store(e.ID)
+	s.touch()
+	return s.GetEvent(ctx, e.ID)
+}
+
+// CloneEvent creates a new event with the merchants, milestones, scenes,
+// playlists and WiFi configuration of the source event. Transactions, products,
+// archived milestones and milestone trigger state are not copied.
+func (s *Store) CloneEvent(ctx context.Context, sourceSlug string, target Event) (Event, error) {
+	source, err := s.GetEventBySlug(ctx, sourceSlug)
+	if err != nil {
+		return target, err
+	}
+	if target.WifiLightningAddress == "" {
+		target.WifiLightningAddress = source.WifiLightningAddress
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return target, err
+	}
+	defer tx.Rollback()
+	target, err = insertEvent(ctx, tx, target)
+	if err != nil {
+		return target, err
+	}
+	now := time.Now().UTC()
+	copies := []string{
+		`INSERT INTO merchants (event_id, id, public_key, alias, enabled, last_polled_at, created_at, updated_at)
+			SELECT ?1, id, public_key, alias, enabled, NULL, ?3, ?3 FROM merchants WHERE event_id=?2`,
+		`INSERT INTO milestones (event_id, name, type, params, threshold, recurring, max_ordinal, last_ordinal,
+				active_from, active_until, measure_from, measure_until, celebration, enabled, triggered_at, created_at,
+				updated_at)
+			SELECT ?1, name, type, params, threshold, recurring, max_ordinal, 0,
+				active_from, active_until, measure_from, measure_until, celebration, enabled, NULL, ?3, ?3
+			FROM milestones WHERE event_id=?2 AND archived_at IS NULL`,
+		`INSERT INTO scenes (event_id, id, name, duration, enabled, scene_order, type, title, body, asset_id, sponsor,
+				schedule, created_at, updated_at)
+			SELECT ?1, id, name, duration, enabled, scene_order, type, title, body, asset_id, sponsor,
+				schedule, ?3, ?3 FROM scenes WHERE event_id=?2`,
+		`INSERT INTO wifi_tiers (event_id, name, description, price_sats, duration_minutes, speed_mbps,
+				donation_percent, donation_recipient, enabled, sort_order, created_at, updated_at)
+			SELECT ?1, name, description, price_sats, duration_minutes, speed_mbps,
+				donation_percent, donation_recipient, enabled, sort_order, ?3, ?3 FROM wifi_tiers WHERE event_id=?2`,
+	}
+	for _, stmt := range copies {
+		if _, err := tx.ExecContext(ctx, stmt, target.ID, source.ID, now); err != nil {
+			return target, err
+		}
+	}
+	if err := clonePlaylists(ctx, tx, source.ID, target.ID, now); err != nil {
+		return target, err
+	}
+	// Fill in anything the source event was missing, such as the WiFi merchant.
+	if err := seedEvent(ctx, tx, target.ID); err != nil {
+		return target, err
+	}
+	if err := tx.Commit(); err != nil {
+		return target, err
+	}
+	s.touch()
+	return s.GetEvent(ctx, target.ID)
+}
+
+func insertEvent(ctx context.Context, tx *sql.Tx, e Event) (Event, error) {
+	e.Slug = strings.ToLower(strings.TrimSpace(e.Slug))
+	if !slugPattern.MatchString(e.Slug) {
+		return e, errors.New("invalid event slug: use lowercase letters, digits and dashes")
+	}
+	if strings.TrimSpace(e.Name) == "" {
+		e.Name = e.Slug
+	}
+	if e.StartsAt != nil && e.EndsAt != nil && e.EndsAt.Before(*e.StartsAt) {
+		return e, errors.New("event ends before it starts")
+	}
+	var exists int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM events WHERE slug=?`, e.Slug).Scan(&exists); err != nil {
+		return e, err
+	}
+	if exists > 0 {
+		return e, ErrEventExists
+	}
+	now := time.Now().UTC()
+	e.CreatedAt = now
+	e.UpdatedAt = now
+	e.IsDefault = false
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO events (slug, name, starts_at, ends_at, wifi_lightning_address, is_default, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, 0, ?, ?)
+	`, e.Slug, e.Name, nullTime(e.StartsAt), nullTime(e.EndsAt), e.WifiLightningAddress, now, now)
+	if err != nil {
+		return e, err
+	}
+	e.ID, err = res.LastInsertId()
+	return e, err
+}
+
+// defaultWifiTier is the offer new events start with.
+var defaultWifiTier = WifiTier{
+	Name:              "Upgrade",
+	Description:       `Upgrade your wifi from 30mbps to 100mbps. 8 hours for 2100 satoshis. 5% Gets donated to "Tollgate" which is making this possible.`,
+	PriceSats:         2100,
+	DurationMinutes:   480,
+	SpeedMbps:         100,
+	DonationPercent:   5,
+	DonationRecipient: "Tollgate",
+	Enabled:           true,
+	Order:             1,
+}
+
+// seedEvent creates the WiFi merchant and default scenes for an event if
+// they don't exist.
+func seedEvent(ctx context.Context, tx *sql.Tx, eventID int64) error {
+	now := time.Now().UTC()
+	_, err := tx.ExecContext(ctx, `
+		INSERT OR IGNORE INTO merchants (event_id, id, public_key, alias, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, eventID, "wifi", "wifi_payments", "WiFi Upgrades", 1, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to create wifi merchant: %w", err)
+	}
+
+	defaultScenes := []Scene{
+		{ID: "overview", Name: "Overview", Duration: 10000, Enabled: true, Order: 1},
+		{ID: "merchants", Name: "Merchants", Duration: 10000, Enabled: true, Order: 2},
+		{ID: "wifi", Name: "WiFi", Duration: 10000, Enabled: true, Order: 3},
+		{ID: "merch", Name: "Merch", Duration: 10000, Enabled: true, Order: 4},
+	}
+	for _, scene := range defaultScenes {
+		_, err := tx.ExecContext(ctx, `
+			INSERT OR IGNORE INTO scenes (event_id, id, name, duration, enabled, scene_order, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, eventID, scene.ID, scene.Name, scene.Duration, boolToInt(scene.Enabled), scene.Order, now, now)
+		if err != nil {
+			return fmt.Errorf("failed to seed scene %s: %w", scene.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// seedWifiTier adds the default WiFi tier to an event, or to every event if
+// eventID is 0. It only runs for new events, so tiers an admin deleted stay
+// deleted.
+func seedWifiTier(ctx context.Context, tx *sql.Tx, eventID int64) error {
+	t := defaultWifiTier
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO wifi_tiers (event_id, name, description, price_sats, duration_minutes, speed_mbps,
+			donation_percent, donation_recipient, enabled, sort_order, created_at, updated_at)
+		SELECT id, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?11
+		FROM events WHERE ?1 = 0 OR id = ?1
+	`, eventID, t.Name, t.Description, t.PriceSats, t.DurationMinutes, t.SpeedMbps,
+		t.DonationPercent, t.DonationRecipient, boolToInt(t.Enabled), t.Order, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to seed wifi tier: %w", err)
+	}
+	return nil
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+// timeOrNil is the reverse of nullTime.
+func timeOrNil(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
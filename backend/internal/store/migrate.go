@@ -0,0 +1,699 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration is a schema change applied once, tracked via PRAGMA user_version.
+type migration struct {
+	version int
+	name    string
+	apply   func(ctx context.Context, tx *sql.Tx) error
+}
+
+// migrations run in order after the base schema is created. Append only;
+// never edit a migration that has shipped.
+var migrations = []migration{
+	{version: 1, name: "events", apply: migrateEvents},
+	{version: 2, name: "settings", apply: migrateSettings},
+	{version: 3, name: "wifi tiers", apply: migrateWifiTiers},
+	{version: 4, name: "transaction external ids", apply: migrateExternalIDs},
+	{version: 5, name: "webhook endpoints", apply: migrateWebhookEndpoints},
+	{version: 6, name: "webhook signatures", apply: migrateWebhookSignatures},
+	{version: 7, name: "webhook inbox", apply: migrateWebhookInbox},
+	{version: 8, name: "wifi invoices", apply: migrateWifiInvoices},
+	{version: 9, name: "wifi vouchers", apply: migrateWifiVouchers},
+	{version: 10, name: "displays", apply: migrateDisplays},
+	{version: 11, name: "playlists", apply: migratePlaylists},
+	{version: 12, name: "content slides", apply: migrateContentSlides},
+	{version: 13, name: "announcements", apply: migrateAnnouncements},
+	{version: 14, name: "milestone params", apply: migrateMilestoneParams},
+	{version: 15, name: "recurring milestones", apply: migrateRecurringMilestones},
+	{version: 16, name: "milestone crossings", apply: migrateMilestoneCrossings},
+	{version: 17, name: "milestone trigger acks", apply: migrateTriggerAcks},
+	{version: 18, name: "milestone archive", apply: migrateMilestoneArchive},
+	{version: 19, name: "milestone schedule", apply: migrateMilestoneSchedule},
+	{version: 20, name: "milestone celebrations", apply: migrateMilestoneCelebrations},
+	{version: 21, name: "webhook delivery retention", apply: migrateWebhookDeliveryRetention},
+	{version: 22, name: "keep triggers of deleted milestones", apply: migrateTriggerMilestoneRef},
+}
+
+// migrate applies pending migrations, each in its own transaction.
+func (s *Store) migrate(ctx context.Context) error {
+	var current int
+	if err := s.db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&current); err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := m.apply(ctx, tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, m.version)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateEvents introduces the events table and scopes merchants,
+// transactions, products, milestones and scenes to an event. Existing rows
+// are assigned to a newly created default event. Tables whose keys change are
+// rebuilt, since SQLite cannot alter primary keys or unique constraints.
+func migrateEvents(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TABLE events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			slug TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			starts_at TIMESTAMP,
+			ends_at TIMESTAMP,
+			wifi_lightning_address TEXT NOT NULL DEFAULT '',
+			is_default INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);
+	`); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO events (slug, name, is_default, created_at, updated_at)
+		VALUES (?, ?, 1, ?, ?)
+	`, DefaultEventSlug, "Default Event", now, now)
+	if err != nil {
+		return err
+	}
+	eventID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	rebuilds := []string{
+		`CREATE TABLE merchants_new (
+			event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			id TEXT NOT NULL,
+			public_key TEXT NOT NULL,
+			alias TEXT NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 1,
+			last_polled_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY(event_id, id)
+		);`,
+		`INSERT INTO merchants_new (event_id, id, public_key, alias, enabled, last_polled_at, created_at, updated_at)
+			SELECT ?1, id, public_key, alias, enabled, last_polled_at, created_at, updated_at FROM merchants;`,
+		`DROP TABLE merchants;`,
+		`ALTER TABLE merchants_new RENAME TO merchants;`,
+
+		`CREATE TABLE transactions_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			merchant_id TEXT NOT NULL,
+			sale_id INTEGER NOT NULL,
+			sale_origin TEXT,
+			sale_date TIMESTAMP NOT NULL,
+			amount_sats INTEGER NOT NULL,
+			source TEXT NOT NULL DEFAULT 'pwf',
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(event_id, merchant_id) REFERENCES merchants(event_id, id) ON DELETE CASCADE,
+			UNIQUE(event_id, merchant_id, sale_id)
+		);`,
+		`INSERT INTO transactions_new (id, event_id, merchant_id, sale_id, sale_origin, sale_date, amount_sats, source, created_at)
+			SELECT id, ?1, merchant_id, sale_id, sale_origin, sale_date, amount_sats, source, created_at FROM transactions;`,
+		`DROP TABLE transactions;`,
+		`ALTER TABLE transactions_new RENAME TO transactions;`,
+		`CREATE INDEX idx_transactions_date ON transactions(sale_date DESC);`,
+		`CREATE INDEX idx_transactions_merchant ON transactions(merchant_id);`,
+		`CREATE INDEX idx_transactions_source ON transactions(source);`,
+		`CREATE INDEX idx_transactions_event_date ON transactions(event_id, sale_date DESC);`,
+
+		`CREATE TABLE products_new (
+			event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			merchant_id TEXT NOT NULL,
+			product_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			currency TEXT,
+			price TEXT,
+			total_transactions INTEGER NOT NULL,
+			total_revenue_sats INTEGER NOT NULL,
+			active INTEGER NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(event_id, merchant_id) REFERENCES merchants(event_id, id) ON DELETE CASCADE,
+			PRIMARY KEY(event_id, merchant_id, product_id)
+		);`,
+		`INSERT INTO products_new (event_id, merchant_id, product_id, name, currency, price, total_transactions, total_revenue_sats, active, updated_at)
+			SELECT ?1, merchant_id, product_id, name, currency, price, total_transactions, total_revenue_sats, active, updated_at FROM products;`,
+		`DROP TABLE products;`,
+		`ALTER TABLE products_new RENAME TO products;`,
+
+		`CREATE TABLE scenes_new (
+			event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			duration INTEGER NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 1,
+			scene_order INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY(event_id, id)
+		);`,
+		`INSERT INTO scenes_new (event_id, id, name, duration, enabled, scene_order, created_at, updated_at)
+			SELECT ?1, id, name, duration, enabled, scene_order, created_at, updated_at FROM scenes;`,
+		`DROP TABLE scenes;`,
+		`ALTER TABLE scenes_new RENAME TO scenes;`,
+
+		`ALTER TABLE milestones ADD COLUMN event_id INTEGER NOT NULL DEFAULT 0;`,
+		`UPDATE milestones SET event_id = ?1;`,
+		`CREATE INDEX idx_milestones_event ON milestones(event_id);`,
+		`ALTER TABLE milestone_triggers ADD COLUMN event_id INTEGER NOT NULL DEFAULT 0;`,
+		`UPDATE milestone_triggers SET event_id = ?1;`,
+		`CREATE INDEX idx_milestone_triggers_event ON milestone_triggers(event_id, triggered_at);`,
+	}
+	for _, stmt := range rebuilds {
+		if _, err := tx.ExecContext(ctx, stmt, eventID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSettings adds the key/value table holding runtime setting overrides.
+func migrateSettings(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);
+	`)
+	return err
+}
+
+// migrateWifiTiers moves the WiFi offer out of Go source into a per-event
+// table, seeding every existing event with the offer that used to be
+// hardcoded, and lets transactions record the tier they paid for.
+func migrateWifiTiers(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE wifi_tiers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			price_sats INTEGER NOT NULL,
+			duration_minutes INTEGER NOT NULL,
+			speed_mbps INTEGER NOT NULL DEFAULT 0,
+			donation_percent INTEGER NOT NULL DEFAULT 0,
+			donation_recipient TEXT NOT NULL DEFAULT '',
+			enabled INTEGER NOT NULL DEFAULT 1,
+			sort_order INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE INDEX idx_wifi_tiers_event ON wifi_tiers(event_id, sort_order);`,
+		`ALTER TABLE transactions ADD COLUMN wifi_tier_id INTEGER;`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return seedWifiTier(ctx, tx, 0)
+}
+
+// migrateExternalIDs deduplicates transactions on a text external_id per
+// source instead of the numeric sale_id, which webhook sources had to forge
+// from payment hashes. POS rows get "merchant:sale" ids, preserving their old
+// uniqueness; existing webhook rows keep their forged sale_id as
+// "legacy:<sale_id>" since the original hash was never stored.
+func migrateExternalIDs(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE transactions_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+			merchant_id TEXT NOT NULL,
+			sale_id INTEGER,
+			external_id TEXT NOT NULL,
+			sale_origin TEXT,
+			sale_date TIMESTAMP NOT NULL,
+			amount_sats INTEGER NOT NULL,
+			source TEXT NOT NULL DEFAULT 'pwf',
+			wifi_tier_id INTEGER,
+			payment_hash TEXT,
+			memo TEXT,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY(event_id, merchant_id) REFERENCES merchants(event_id, id) ON DELETE CASCADE
+		);`,
+		`INSERT INTO transactions_new (id, event_id, merchant_id, sale_id, external_id, sale_origin, sale_date,
+				amount_sats, source, wifi_tier_id, created_at)
+			SELECT id, event_id, merchant_id, sale_id,
+				CASE WHEN source = 'pwf' THEN merchant_id || ':' || sale_id ELSE 'legacy:' || sale_id END,
+				sale_origin, sale_date, amount_sats, source, wifi_tier_id, created_at
+			FROM transactions;`,
+		`DROP TABLE transactions;`,
+		`ALTER TABLE transactions_new RENAME TO transactions;`,
+		`CREATE UNIQUE INDEX idx_transactions_external ON transactions(event_id, source, external_id);`,
+		`CREATE INDEX idx_transactions_date ON transactions(sale_date DESC);`,
+		`CREATE INDEX idx_transactions_merchant ON transactions(merchant_id);`,
+		`CREATE INDEX idx_transactions_source ON transactions(source);`,
+		`CREATE INDEX idx_transactions_event_date ON transactions(event_id, sale_date DESC);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateWebhookEndpoints registers payment webhooks in the database. The
+// "wifi" endpoint reproduces the old hardcoded /v1/webhooks/wifi route: LNbits
+// payloads into the wifi merchant of whichever event is the default.
+func migrateWebhookEndpoints(ctx context.Context, tx *sql.Tx) error {
+	now := time.Now().UTC()
+	stmts := []string{
+		`CREATE TABLE webhook_endpoints (
+			id TEXT PRIMARY KEY,
+			event_id INTEGER NOT NULL DEFAULT 0,
+			name TEXT NOT NULL,
+			provider TEXT NOT NULL,
+			secret TEXT NOT NULL DEFAULT '',
+			merchant_id TEXT NOT NULL,
+			source TEXT NOT NULL,
+			mapping TEXT NOT NULL DEFAULT '{}',
+			enabled INTEGER NOT NULL DEFAULT 1,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);`,
+		`INSERT INTO webhook_endpoints (id, event_id, name, provider, merchant_id, source, created_at, updated_at)
+			VALUES ('wifi', 0, 'WiFi upgrades (LNbits)', 'lnbits', 'wifi', 'wifi', ?1, ?1);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateWebhookSignatures adds per-endpoint auth modes, a second secret for
+// rotation and the nonce table used to reject replayed deliveries. Existing
+// endpoints with a secret of their own switch to HMAC, and BTCPay endpoints to
+// its own signature. Only endpoints relying on WEBHOOK_SECRET, like the
+// built-in LNbits one, keep the plain shared-secret header.
+func migrateWebhookSignatures(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`ALTER TABLE webhook_endpoints ADD COLUMN previous_secret TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE webhook_endpoints ADD COLUMN auth_mode TEXT NOT NULL DEFAULT 'hmac'`,
+		`UPDATE webhook_endpoints SET auth_mode = CASE WHEN provider = 'btcpay' THEN 'btcpay' WHEN secret != '' THEN 'hmac' ELSE 'header' END`,
+		`CREATE TABLE webhook_nonces (
+			endpoint_id TEXT NOT NULL,
+			nonce TEXT NOT NULL,
+			seen_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (endpoint_id, nonce)
+		);`,
+		`CREATE INDEX idx_webhook_nonces_seen ON webhook_nonces(seen_at);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateWebhookInbox adds the table recording every inbound webhook request.
+func migrateWebhookInbox(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			endpoint_id TEXT NOT NULL,
+			event_id INTEGER NOT NULL,
+			received_at TIMESTAMP NOT NULL,
+			headers TEXT NOT NULL DEFAULT '{}',
+			body BLOB NOT NULL,
+			status_code INTEGER NOT NULL,
+			response TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			replay_of INTEGER
+		);`,
+		`CREATE INDEX idx_webhook_deliveries_endpoint ON webhook_deliveries(endpoint_id, id);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateWifiInvoices adds the per-user WiFi invoices created through LNbits.
+func migrateWifiInvoices(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE wifi_invoices (
+			payment_hash TEXT PRIMARY KEY,
+			event_id INTEGER NOT NULL,
+			tier_id INTEGER NOT NULL,
+			amount_sats INTEGER NOT NULL,
+			bolt11 TEXT NOT NULL,
+			checking_id TEXT NOT NULL,
+			memo TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'pending',
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			paid_at TIMESTAMP
+		);`,
+		`CREATE INDEX idx_wifi_invoices_event ON wifi_invoices(event_id, created_at);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateWifiVouchers adds the access codes minted for paid WiFi upgrades.
+func migrateWifiVouchers(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE wifi_vouchers (
+			code TEXT PRIMARY KEY,
+			event_id INTEGER NOT NULL,
+			payment_hash TEXT NOT NULL UNIQUE,
+			tier_id INTEGER NOT NULL,
+			duration_minutes INTEGER NOT NULL,
+			speed_mbps INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			redeemed_at TIMESTAMP,
+			redeemed_by TEXT NOT NULL DEFAULT ''
+		);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateDisplays(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE displays (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL DEFAULT '',
+			token_hash TEXT NOT NULL UNIQUE,
+			pairing_code TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'pending',
+			scene TEXT NOT NULL DEFAULT '',
+			app_version TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			approved_at TIMESTAMP,
+			last_seen_at TIMESTAMP
+		);`,
+		`CREATE INDEX idx_displays_pairing_code ON displays(pairing_code);`,
+		`CREATE TABLE display_commands (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			display_id TEXT NOT NULL,
+			type TEXT NOT NULL,
+			scene_id TEXT NOT NULL DEFAULT '',
+			message TEXT NOT NULL DEFAULT '',
+			duration_seconds INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL,
+			delivered_at TIMESTAMP
+		);`,
+		`CREATE INDEX idx_display_commands_pending ON display_commands(display_id, delivered_at);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migratePlaylists(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE playlists (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE INDEX idx_playlists_event ON playlists(event_id);`,
+		`CREATE TABLE playlist_entries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			playlist_id INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			scene_id TEXT NOT NULL,
+			duration INTEGER NOT NULL DEFAULT 0,
+			params TEXT NOT NULL DEFAULT '{}'
+		);`,
+		`CREATE INDEX idx_playlist_entries_playlist ON playlist_entries(playlist_id, position);`,
+		`ALTER TABLE displays ADD COLUMN playlist_id INTEGER;`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateContentSlides(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`ALTER TABLE scenes ADD COLUMN type TEXT NOT NULL DEFAULT 'builtin';`,
+		`ALTER TABLE scenes ADD COLUMN title TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE scenes ADD COLUMN body TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE scenes ADD COLUMN asset_id TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE scenes ADD COLUMN sponsor TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE scenes ADD COLUMN schedule TEXT NOT NULL DEFAULT '[]';`,
+		`CREATE TABLE assets (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL DEFAULT '',
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			data BLOB NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE TABLE slide_impressions (
+			event_id INTEGER NOT NULL,
+			scene_id TEXT NOT NULL,
+			display_id TEXT NOT NULL,
+			shown_at TIMESTAMP NOT NULL,
+			seconds INTEGER NOT NULL,
+			PRIMARY KEY (display_id, event_id, scene_id, shown_at)
+		);`,
+		`CREATE INDEX idx_slide_impressions_scene ON slide_impressions(event_id, scene_id, shown_at);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateAnnouncements(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE announcements (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event_id INTEGER NOT NULL,
+			message TEXT NOT NULL,
+			priority TEXT NOT NULL DEFAULT 'normal',
+			starts_at TIMESTAMP NOT NULL,
+			ends_at TIMESTAMP,
+			display_ids TEXT NOT NULL DEFAULT '[]',
+			scene_ids TEXT NOT NULL DEFAULT '[]',
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);`,
+		`CREATE INDEX idx_announcements_event ON announcements(event_id, ends_at);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateMilestoneParams stores the parameters of the milestone types that
+// need more than a threshold, such as the merchant a merchant milestone
+// counts. Existing milestones are global totals and take none.
+func migrateMilestoneParams(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `ALTER TABLE milestones ADD COLUMN params TEXT NOT NULL DEFAULT '{}';`)
+	return err
+}
+
+// migrateRecurringMilestones lets a milestone fire at every multiple of its
+// threshold. Triggers record which crossing they were; existing ones were
+// all the first.
+func migrateRecurringMilestones(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`ALTER TABLE milestones ADD COLUMN recurring INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE milestones ADD COLUMN max_ordinal INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE milestones ADD COLUMN last_ordinal INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE milestone_triggers ADD COLUMN ordinal INTEGER NOT NULL DEFAULT 1;`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateMilestoneCrossings records the transaction that crossed each
+// milestone. The details are copied so the trigger keeps them if the
+// merchant and its transactions are removed. Older triggers stay unattributed.
+func migrateMilestoneCrossings(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`ALTER TABLE milestone_triggers ADD COLUMN transaction_id INTEGER;`,
+		`ALTER TABLE milestone_triggers ADD COLUMN sale_id INTEGER;`,
+		`ALTER TABLE milestone_triggers ADD COLUMN merchant_id TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE milestone_triggers ADD COLUMN merchant_alias TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE milestone_triggers ADD COLUMN amount_sats INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE milestone_triggers ADD COLUMN sale_date TIMESTAMP;`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateTriggerAcks lets displays acknowledge the celebrations they played
+// and records which trigger a re-fire copied.
+func migrateTriggerAcks(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE milestone_trigger_acks (
+			trigger_id INTEGER NOT NULL,
+			display_id TEXT NOT NULL,
+			acked_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (trigger_id, display_id)
+		);`,
+		`ALTER TABLE milestone_triggers ADD COLUMN refire_of INTEGER;`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateMilestoneArchive lets admins put finished milestones away without
+// deleting them.
+func migrateMilestoneArchive(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `ALTER TABLE milestones ADD COLUMN archived_at TIMESTAMP;`)
+	return err
+}
+
+// migrateMilestoneSchedule adds the optional windows in which a milestone
+// can fire and over which it counts transactions.
+func migrateMilestoneSchedule(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`ALTER TABLE milestones ADD COLUMN active_from TIMESTAMP;`,
+		`ALTER TABLE milestones ADD COLUMN active_until TIMESTAMP;`,
+		`ALTER TABLE milestones ADD COLUMN measure_from TIMESTAMP;`,
+		`ALTER TABLE milestones ADD COLUMN measure_until TIMESTAMP;`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateMilestoneCelebrations adds the media, text and duration displays
+// celebrate a milestone with. Triggers keep a copy.
+func migrateMilestoneCelebrations(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`ALTER TABLE milestones ADD COLUMN celebration TEXT NOT NULL DEFAULT '{}';`,
+		`ALTER TABLE milestone_triggers ADD COLUMN celebration TEXT NOT NULL DEFAULT '{}';`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateWebhookDeliveryRetention marks inbox bodies that were cut short and
+// indexes deliveries by age for pruning.
+func migrateWebhookDeliveryRetention(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`ALTER TABLE webhook_deliveries ADD COLUMN truncated INTEGER NOT NULL DEFAULT 0;`,
+		`CREATE INDEX idx_webhook_deliveries_received ON webhook_deliveries(received_at);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateTriggerMilestoneRef rebuilds milestone_triggers so deleting a
+// milestone can't cascade to its trigger history should foreign keys be
+// enforced: milestone_id becomes nullable with ON DELETE SET NULL. The
+// AUTOINCREMENT sequence is carried over, since clients page triggers by id.
+func migrateTriggerMilestoneRef(ctx context.Context, tx *sql.Tx) error {
+	const columns = `id, event_id, milestone_id, name, type, threshold, ordinal, triggered_at, total_transactions,
+		total_volume_sats, transaction_id, sale_id, merchant_id, merchant_alias, amount_sats, sale_date, refire_of,
+		celebration`
+	stmts := []string{
+		`CREATE TABLE milestone_triggers_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event_id INTEGER NOT NULL DEFAULT 0,
+			milestone_id INTEGER REFERENCES milestones(id) ON DELETE SET NULL,
+			name TEXT NOT NULL,
+			type TEXT NOT NULL,
+			threshold INTEGER NOT NULL,
+			ordinal INTEGER NOT NULL DEFAULT 1,
+			triggered_at TIMESTAMP NOT NULL,
+			total_transactions INTEGER NOT NULL,
+			total_volume_sats INTEGER NOT NULL,
+			transaction_id INTEGER,
+			sale_id INTEGER,
+			merchant_id TEXT NOT NULL DEFAULT '',
+			merchant_alias TEXT NOT NULL DEFAULT '',
+			amount_sats INTEGER NOT NULL DEFAULT 0,
+			sale_date TIMESTAMP,
+			refire_of INTEGER,
+			celebration TEXT NOT NULL DEFAULT '{}'
+		);`,
+		`INSERT INTO milestone_triggers_new (` + columns + `) SELECT ` + columns + ` FROM milestone_triggers;`,
+		`DELETE FROM sqlite_sequence WHERE name = 'milestone_triggers_new';`,
+		`INSERT INTO sqlite_sequence (name, seq)
+			SELECT 'milestone_triggers_new', seq FROM sqlite_sequence WHERE name = 'milestone_triggers';`,
+		`DROP TABLE milestone_triggers;`,
+		`ALTER TABLE milestone_triggers_new RENAME TO milestone_triggers;`,
+		`CREATE INDEX idx_milestone_triggers_event ON milestone_triggers(event_id, triggered_at);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
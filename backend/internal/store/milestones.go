@@ -0,0 +1,640 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MilestoneMetric is what per-merchant and per-source milestones count.
+type MilestoneMetric string
+
+const (
+	MetricTransactions MilestoneMetric = "transactions"
+	MetricVolume       MilestoneMetric = "volume"
+)
+
+// MilestoneParams configures the milestone types that need more than a
+// threshold. Each type takes only the fields listed next to it.
+type MilestoneParams struct {
+	MerchantID string            `json:"merchant_id,omitempty"` // merchant: whose sales count
+	Source     TransactionSource `json:"source,omitempty"`      // source: which transaction source counts
+	Metric     MilestoneMetric   `json:"metric,omitempty"`      // merchant, source: transactions or volume
+	Window     string            `json:"window,omitempty"`      // rate: how far back to measure, as a duration
+	Condition  string            `json:"condition,omitempty"`   // expression: see ParseCondition
+}
+
+// UnmarshalJSON rejects unknown keys, so a typo doesn't silently create a
+// milestone that never fires.
+func (p *MilestoneParams) UnmarshalJSON(data []byte) error {
+	type plain MilestoneParams
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		*p = MilestoneParams{}
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var out plain
+	if err := dec.Decode(&out); err != nil {
+		return fmt.Errorf("invalid milestone params: %w", err)
+	}
+	*p = MilestoneParams(out)
+	return nil
+}
+
+// window returns the rate window; validateMilestoneType has checked it.
+func (p MilestoneParams) window() time.Duration {
+	d, _ := time.ParseDuration(p.Window)
+	return d
+}
+
+// validateMilestoneType checks the type and that params carries exactly the
+// fields the type uses.
+func validateMilestoneType(mt MilestoneType, p MilestoneParams) error {
+	var extra bool
+	switch MilestoneType(strings.ToLower(string(mt))) {
+	case MilestoneTransactions, MilestoneVolume, MilestoneActiveMerchants, MilestoneUniqueProducts, MilestoneSingleTransaction:
+		extra = p != MilestoneParams{}
+	case MilestoneMerchant:
+		if strings.TrimSpace(p.MerchantID) == "" {
+			return errors.New("merchant milestones need params.merchant_id")
+		}
+		if err := p.Metric.validate(); err != nil {
+			return err
+		}
+		extra = p.Source != "" || p.Window != "" || p.Condition != ""
+	case MilestoneSource:
+		if !slugPattern.MatchString(string(p.Source)) {
+			return errors.New("source milestones need params.source, a lowercase source tag")
+		}
+		if err := p.Metric.validate(); err != nil {
+			return err
+		}
+		extra = p.MerchantID != "" || p.Window != "" || p.Condition != ""
+	case MilestoneRate:
+		if d, err := time.ParseDuration(p.Window); err != nil || d < time.Minute {
+			return errors.New("rate milestones need params.window, a duration of at least 1m")
+		}
+		extra = p.MerchantID != "" || p.Source != "" || p.Metric != "" || p.Condition != ""
+	case MilestoneExpression:
+		if _, err := ParseCondition(p.Condition); err != nil {
+			return err
+		}
+		extra = p.MerchantID != "" || p.Source != "" || p.Metric != "" || p.Window != ""
+	default:
+		return errors.New("invalid milestone type")
+	}
+	if extra {
+		return fmt.Errorf("params not used by %s milestones", strings.ToLower(string(mt)))
+	}
+	return nil
+}
+
+func (m MilestoneMetric) validate() error {
+	switch m {
+	case MetricTransactions, MetricVolume:
+		return nil
+	case "":
+		return errors.New("params.metric is required: use transactions or volume")
+	default:
+		return errors.New("invalid params.metric: use transactions or volume")
+	}
+}
+
+// aggregate is the SQL aggregate over transactions for the metric.
+func (m MilestoneMetric) aggregate() string {
+	if m == MetricVolume {
+		return `COALESCE(SUM(amount_sats), 0)`
+	}
+	return `COUNT(*)`
+}
+
+// metric is what a milestone counts over its transactions.
+func (m Milestone) metric() MilestoneMetric {
+	switch m.Type {
+	case MilestoneVolume:
+		return MetricVolume
+	case MilestoneMerchant, MilestoneSource:
+		return m.Params.Metric
+	}
+	return MetricTransactions
+}
+
+// transactionFilter narrows the event's transactions to the ones a
+// milestone counts, as a condition on the transactions table aliased t.
+func (m Milestone) transactionFilter() (string, []any) {
+	var filter string
+	var args []any
+	switch m.Type {
+	case MilestoneMerchant:
+		filter, args = ` AND t.merchant_id=?`, []any{m.Params.MerchantID}
+	case MilestoneSource:
+		filter, args = ` AND t.source=?`, []any{m.Params.Source}
+	}
+	if m.MeasureFrom != nil {
+		filter += ` AND t.sale_date >= ?`
+		args = append(args, m.MeasureFrom.UTC())
+	}
+	if m.MeasureUntil != nil {
+		filter += ` AND t.sale_date < ?`
+		args = append(args, m.MeasureUntil.UTC())
+	}
+	return filter, args
+}
+
+// measures reports whether a sale at t is in the measurement window.
+func (m Milestone) measures(t time.Time) bool {
+	return (m.MeasureFrom == nil || !t.Before(*m.MeasureFrom)) && (m.MeasureUntil == nil || t.Before(*m.MeasureUntil))
+}
+
+// ActiveAt reports whether the milestone can fire at t.
+func (m Milestone) ActiveAt(t time.Time) bool {
+	return (m.ActiveFrom == nil || !t.Before(*m.ActiveFrom)) && (m.ActiveUntil == nil || t.Before(*m.ActiveUntil))
+}
+
+// sameMeasureWindow reports whether two milestones count the same sales
+// period.
+func (m Milestone) sameMeasureWindow(o Milestone) bool {
+	same := func(a, b *time.Time) bool {
+		return (a == nil && b == nil) || (a != nil && b != nil && a.Equal(*b))
+	}
+	return same(m.MeasureFrom, o.MeasureFrom) && same(m.MeasureUntil, o.MeasureUntil)
+}
+
+// milestoneValue measures what a milestone's threshold is compared against,
+// counting the transactions in its measurement window sold up to until, or
+// all of them when until is zero. Rate milestones report whole transactions
+// per minute over the window ending at until (or now); single-transaction
+// milestones the largest payment; expression milestones 1 while their
+// condition holds. Unique-product and expression milestones read the
+// current state whatever until is.
+func (s *Store) milestoneValue(ctx context.Context, m Milestone, until time.Time) (int64, error) {
+	where := ` WHERE t.event_id=?`
+	args := []any{s.EventID()}
+	if !until.IsZero() {
+		where += ` AND t.sale_date <= ?`
+		args = append(args, until.UTC())
+	}
+	filter, filterArgs := m.transactionFilter()
+	where += filter
+	args = append(args, filterArgs...)
+	var query string
+	switch m.Type {
+	case MilestoneTransactions, MilestoneVolume, MilestoneMerchant, MilestoneSource:
+		query = `SELECT ` + m.metric().aggregate() + ` FROM transactions t` + where
+	case MilestoneActiveMerchants:
+		query = `SELECT COUNT(DISTINCT t.merchant_id) FROM transactions t` + where
+	case MilestoneUniqueProducts:
+		query = `SELECT COUNT(*) FROM products WHERE event_id=? AND total_transactions > 0`
+		args = args[:1]
+	case MilestoneSingleTransaction:
+		query = `SELECT COALESCE(MAX(t.amount_sats), 0) FROM transactions t` + where
+	case MilestoneExpression:
+		c, err := ParseCondition(m.Params.Condition)
+		if err != nil {
+			return 0, err
+		}
+		ok, _, err := s.evaluateCondition(ctx, c, m)
+		if ok {
+			return 1, err
+		}
+		return 0, err
+	case MilestoneRate:
+		window := m.Params.window()
+		end := until
+		if end.IsZero() {
+			end = time.Now()
+		}
+		var count int64
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM transactions t`+where+` AND t.sale_date >= ?`,
+			append(args, end.Add(-window).UTC())...).Scan(&count); err != nil {
+			return 0, err
+		}
+		return int64(float64(count) / window.Minutes()), nil
+	default:
+		return 0, fmt.Errorf("unknown milestone type %q", m.Type)
+	}
+	var value int64
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&value)
+	return value, err
+}
+
+// maxCelebrationText and maxCelebrationDuration bound a celebration, which
+// takes over every display while it plays.
+const (
+	maxCelebrationText     = 200
+	maxCelebrationDuration = 60_000 // milliseconds
+)
+
+// validateCelebration checks a celebration's text and duration, and that
+// its assets exist and are the right kind of media.
+func (s *Store) validateCelebration(ctx context.Context, c MilestoneCelebration) error {
+	if utf8.RuneCountInString(c.Text) > maxCelebrationText {
+		return fmt.Errorf("celebration text is limited to %d characters", maxCelebrationText)
+	}
+	if c.Duration < 0 || c.Duration > maxCelebrationDuration {
+		return fmt.Errorf("celebration duration must be between 0 and %d ms", maxCelebrationDuration)
+	}
+	refs := []struct {
+		field string
+		id    string
+		kinds []string
+	}{
+		{"asset_id", c.AssetID, []string{"image", "video"}},
+		{"sound_asset_id", c.SoundAssetID, []string{"audio"}},
+	}
+	for _, ref := range refs {
+		if ref.id == "" {
+			continue
+		}
+		a, err := s.GetAsset(ctx, ref.id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("asset %s does not exist", ref.id)
+		}
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(ref.kinds, a.Kind()) {
+			return fmt.Errorf("celebration %s must be %s, not %s", ref.field, strings.Join(ref.kinds, " or "), a.ContentType)
+		}
+	}
+	return nil
+}
+
+// maxCrossingsPerPass bounds the triggers one recurring milestone records
+// in a single pass, in case a tiny step meets a big batch. Older crossings
+// beyond it are skipped.
+const maxCrossingsPerPass = 100
+
+// validateMilestone checks a milestone's type, params, windows and
+// recurrence.
+func validateMilestone(m Milestone) error {
+	if err := validateMilestoneType(m.Type, m.Params); err != nil {
+		return err
+	}
+	switch {
+	case m.ActiveFrom != nil && m.ActiveUntil != nil && !m.ActiveUntil.After(*m.ActiveFrom):
+		return errors.New("active_until must be after active_from")
+	case m.MeasureFrom != nil && m.MeasureUntil != nil && !m.MeasureUntil.After(*m.MeasureFrom):
+		return errors.New("measure_until must be after measure_from")
+	case (m.MeasureFrom != nil || m.MeasureUntil != nil) &&
+		MilestoneType(strings.ToLower(string(m.Type))) == MilestoneUniqueProducts:
+		return errors.New("unique_products milestones count the catalogue and can't have a measurement window")
+	case MilestoneType(strings.ToLower(string(m.Type))) == MilestoneExpression && m.Threshold != 0 && m.Threshold != 1:
+		return errors.New("expression milestones fire when their condition holds and take no threshold")
+	}
+	if !m.Recurring {
+		if m.MaxOrdinal != 0 {
+			return errors.New("max_ordinal is only used by recurring milestones")
+		}
+		return nil
+	}
+	switch MilestoneType(strings.ToLower(string(m.Type))) {
+	case MilestoneRate, MilestoneSingleTransaction, MilestoneExpression:
+		return fmt.Errorf("%s milestones can't recur", strings.ToLower(string(m.Type)))
+	}
+	if m.Threshold <= 0 {
+		return errors.New("recurring milestones need a positive threshold")
+	}
+	if m.MaxOrdinal < 0 {
+		return errors.New("max_ordinal must not be negative")
+	}
+	return nil
+}
+
+// NextThreshold is the value at which the milestone fires next.
+func (m Milestone) NextThreshold() int64 {
+	if m.Recurring {
+		return (m.LastOrdinal + 1) * m.Threshold
+	}
+	return m.Threshold
+}
+
+// ordinalAt is the last crossing of a recurring milestone at value, capped
+// at MaxOrdinal.
+func (m Milestone) ordinalAt(value int64) int64 {
+	n := value / m.Threshold
+	if m.MaxOrdinal > 0 && n > m.MaxOrdinal {
+		n = m.MaxOrdinal
+	}
+	return n
+}
+
+// finished reports whether a recurring milestone fired its last crossing.
+func (m Milestone) finished() bool {
+	return m.MaxOrdinal > 0 && m.LastOrdinal >= m.MaxOrdinal
+}
+
+// milestoneBaseline is the ordinal a recurring milestone starts counting
+// from: crossings already behind the current value are skipped rather than
+// replayed.
+func (s *Store) milestoneBaseline(ctx context.Context, m Milestone) (int64, error) {
+	value, err := s.milestoneValue(ctx, m, time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	return m.ordinalAt(value), nil
+}
+
+// milestoneCrossing is one threshold a milestone passed.
+type milestoneCrossing struct {
+	ordinal   int64
+	threshold int64
+}
+
+// crossings lists the thresholds passed at value that haven't fired yet.
+func (m Milestone) crossings(value int64) []milestoneCrossing {
+	if !m.Recurring {
+		if value >= m.Threshold {
+			return []milestoneCrossing{{ordinal: 1, threshold: m.Threshold}}
+		}
+		return nil
+	}
+	last := m.ordinalAt(value)
+	first := max(m.LastOrdinal+1, last-maxCrossingsPerPass+1)
+	var out []milestoneCrossing
+	for n := first; n <= last; n++ {
+		out = append(out, milestoneCrossing{ordinal: n, threshold: n * m.Threshold})
+	}
+	return out
+}
+
+// crossingColumns select a transaction as a TickerEntry from transactions t
+// joined to merchants mc.
+const crossingColumns = `t.id, t.sale_id, t.merchant_id, COALESCE(mc.alias, '') AS alias, t.amount_sats, t.sale_date`
+
+const crossingJoin = `transactions t LEFT JOIN merchants mc ON mc.event_id = t.event_id AND mc.id = t.merchant_id`
+
+// crossingTransaction replays the event's transactions in sale order and
+// returns the one at which the milestone reached threshold, so a trigger
+// names the payment that did it rather than the batch that reported it.
+// Unique-product milestones count the product catalogue, not payments, and
+// get nil, as does a threshold no longer reached.
+func (s *Store) crossingTransaction(ctx context.Context, m Milestone, threshold int64, now time.Time) (*TickerEntry, error) {
+	threshold = max(threshold, 1)
+	filter, filterArgs := m.transactionFilter()
+	args := append([]any{s.EventID()}, filterArgs...)
+	var query string
+	switch m.Type {
+	case MilestoneTransactions, MilestoneVolume, MilestoneMerchant, MilestoneSource:
+		if m.metric() == MetricVolume {
+			// The first transaction whose running total reaches threshold
+			query = `
+				SELECT id, sale_id, merchant_id, alias, amount_sats, sale_date FROM (
+					SELECT ` + crossingColumns + `,
+						SUM(t.amount_sats) OVER (ORDER BY t.sale_date, t.id) AS running
+					FROM ` + crossingJoin + `
+					WHERE t.event_id=?` + filter + `
+				) WHERE running >= ? ORDER BY sale_date, id LIMIT 1`
+			args = append(args, threshold)
+		} else {
+			query = `SELECT ` + crossingColumns + ` FROM ` + crossingJoin + ` WHERE t.event_id=?` + filter + `
+				ORDER BY t.sale_date, t.id LIMIT 1 OFFSET ?`
+			args = append(args, threshold-1)
+		}
+	case MilestoneActiveMerchants:
+		// Each merchant's first sale, in order
+		query = `
+			SELECT id, sale_id, merchant_id, alias, amount_sats, sale_date FROM (
+				SELECT ` + crossingColumns + `,
+					ROW_NUMBER() OVER (PARTITION BY t.merchant_id ORDER BY t.sale_date, t.id) AS n
+				FROM ` + crossingJoin + `
+				WHERE t.event_id=?` + filter + `
+			) WHERE n = 1 ORDER BY sale_date, id LIMIT 1 OFFSET ?`
+		args = append(args, threshold-1)
+	case MilestoneSingleTransaction:
+		query = `SELECT ` + crossingColumns + ` FROM ` + crossingJoin + ` WHERE t.event_id=?` + filter + `
+			AND t.amount_sats >= ? ORDER BY t.sale_date, t.id LIMIT 1`
+		args = append(args, threshold)
+	case MilestoneRate:
+		// The payment that brought the window up to the rate
+		window := m.Params.window()
+		need := max(int64(math.Ceil(float64(threshold)*window.Minutes())), 1)
+		query = `SELECT ` + crossingColumns + ` FROM ` + crossingJoin + ` WHERE t.event_id=?` + filter + `
+			AND t.sale_date >= ? ORDER BY t.sale_date, t.id LIMIT 1 OFFSET ?`
+		args = append(args, now.Add(-window).UTC(), need-1)
+	default:
+		return nil, nil
+	}
+	var e TickerEntry
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&e.ID, &e.SaleID, &e.MerchantID, &e.MerchantAlias, &e.AmountSats, &e.SaleDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// totalsThrough returns the event's transaction count and volume up to and
+// including a transaction, in sale order.
+func (s *Store) totalsThrough(ctx context.Context, transactionID int64) (int64, int64, error) {
+	var count, volume int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT n, running FROM (
+			SELECT id, ROW_NUMBER() OVER w AS n, SUM(amount_sats) OVER w AS running
+			FROM transactions WHERE event_id=?
+			WINDOW w AS (ORDER BY sale_date, id)
+		) WHERE id=?
+	`, s.EventID(), transactionID).Scan(&count, &volume)
+	return count, volume, err
+}
+
+// MilestoneProgress is how far a milestone is from firing next, in the
+// same shape for every type.
+type MilestoneProgress struct {
+	MilestoneID int64           `json:"milestone_id"`
+	Name        string          `json:"name"`
+	Type        MilestoneType   `json:"type"`
+	Params      MilestoneParams `json:"params"`
+	Ordinal     int64           `json:"ordinal"` // the crossing this is progress towards
+	Target      int64           `json:"target"`
+	Current     int64           `json:"current"`
+	Remaining   int64           `json:"remaining"`
+	Percent     float64         `json:"percent"` // recurring: of the current step
+	// RatePerMinute is how fast Current grew over the window; nil for
+	// types whose past values aren't known or don't add up
+	RatePerMinute *float64   `json:"rate_per_minute"`
+	ETASeconds    *int64     `json:"eta_seconds"` // nil when not growing
+	ExpectedAt    *time.Time `json:"expected_at"`
+}
+
+// growing reports whether a milestone's value only grows as payments come
+// in, so its recent growth predicts when it fires.
+func (m Milestone) growing() bool {
+	switch m.Type {
+	case MilestoneTransactions, MilestoneVolume, MilestoneMerchant, MilestoneSource, MilestoneActiveMerchants:
+		return true
+	}
+	return false
+}
+
+// MilestoneProgress reports progress for the event's enabled milestones
+// that can still fire, in threshold order. ETAs extrapolate the growth over
+// the last window.
+func (s *Store) MilestoneProgress(ctx context.Context, window time.Duration) ([]MilestoneProgress, error) {
+	if window <= 0 {
+		return nil, errors.New("window must be positive")
+	}
+	milestones, err := s.ListMilestones(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	out := make([]MilestoneProgress, 0, len(milestones))
+	for _, m := range milestones {
+		if !m.Enabled || m.Triggered || !m.ActiveAt(now) || validateMilestone(m) != nil {
+			continue
+		}
+		current, err := s.milestoneValue(ctx, m, time.Time{})
+		if err != nil {
+			return nil, fmt.Errorf("milestone %d: %w", m.ID, err)
+		}
+		p := MilestoneProgress{
+			MilestoneID: m.ID,
+			Name:        m.Name,
+			Type:        m.Type,
+			Params:      m.Params,
+			Ordinal:     1,
+			Target:      m.NextThreshold(),
+			Current:     current,
+		}
+		p.Remaining = max(p.Target-current, 0)
+		base, span := int64(0), p.Target
+		if m.Recurring {
+			p.Ordinal = m.LastOrdinal + 1
+			base, span = m.LastOrdinal*m.Threshold, m.Threshold
+		}
+		if span > 0 {
+			p.Percent = math.Min(100, math.Max(0, float64(current-base)/float64(span)*100))
+		}
+		if m.growing() {
+			before, err := s.milestoneValue(ctx, m, now.Add(-window))
+			if err != nil {
+				return nil, fmt.Errorf("milestone %d: %w", m.ID, err)
+			}
+			rate := float64(current-before) / window.Minutes()
+			p.RatePerMinute = &rate
+			if rate > 0 || p.Remaining == 0 { // reached but not yet processed: due now
+				var eta int64
+				if p.Remaining > 0 {
+					eta = int64(math.Ceil(float64(p.Remaining) / rate * 60))
+				}
+				at := now.Add(time.Duration(eta) * time.Second)
+				p.ETASeconds, p.ExpectedAt = &eta, &at
+			}
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// DeleteMilestone removes a milestone. Its triggers stay in the history;
+// they carry their own copy of the name, type and threshold.
+func (s *Store) DeleteMilestone(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM milestones WHERE event_id=? AND id=?`, s.EventID(), id)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	s.touch()
+	return nil
+}
+
+// ArchiveMilestone archives or restores a milestone. Archived milestones
+// keep their state and triggers but are left out of the list, progress and
+// processing. Archiving again keeps the first time.
+func (s *Store) ArchiveMilestone(ctx context.Context, id int64, archived bool) (Milestone, error) {
+	m, err := s.GetMilestone(ctx, id)
+	if err != nil {
+		return m, err
+	}
+	if m.Archived == archived {
+		return m, nil
+	}
+	now := time.Now().UTC()
+	var archivedAt *time.Time
+	if archived {
+		archivedAt = &now
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE milestones SET archived_at=?, updated_at=? WHERE event_id=? AND id=?
+	`, nullTime(archivedAt), now, s.EventID(), id); err != nil {
+		return m, err
+	}
+	s.touch()
+	return s.GetMilestone(ctx, id)
+}
+
+// DuplicateMilestone creates a copy of a milestone under a new name, with
+// fresh trigger state. The copy starts disabled, so it can be adjusted
+// before it fires on values the original already passed.
+func (s *Store) DuplicateMilestone(ctx context.Context, id int64, name string) (Milestone, error) {
+	m, err := s.GetMilestone(ctx, id)
+	if err != nil {
+		return m, err
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		name = m.Name + " (copy)"
+	}
+	m.Name = name
+	m.Enabled = false
+	return s.UpsertMilestone(ctx, m)
+}
+
+// MilestonePreview is what a milestone would see if it were saved now.
+type MilestonePreview struct {
+	Value        int64              `json:"value"`
+	Target       int64              `json:"target"`            // the next threshold after the crossings a recurring series skips
+	WouldTrigger bool               `json:"would_trigger"`     // on the next check
+	Metrics      map[string]float64 `json:"metrics,omitempty"` // expression: each metric's current value
+}
+
+// PreviewMilestone validates a milestone and measures it against the
+// event's current data without saving it.
+func (s *Store) PreviewMilestone(ctx context.Context, m Milestone) (MilestonePreview, error) {
+	var p MilestonePreview
+	if err := validateMilestone(m); err != nil {
+		return p, err
+	}
+	m.Type = MilestoneType(strings.ToLower(string(m.Type)))
+	if m.Type == MilestoneExpression {
+		c, err := ParseCondition(m.Params.Condition)
+		if err != nil {
+			return p, err
+		}
+		ok, metrics, err := s.evaluateCondition(ctx, c, m)
+		if err != nil {
+			return p, err
+		}
+		m.Threshold, p.Metrics = 1, metrics
+		if ok {
+			p.Value = 1
+		}
+	} else {
+		value, err := s.milestoneValue(ctx, m, time.Time{})
+		if err != nil {
+			return p, err
+		}
+		p.Value = value
+	}
+	if m.Recurring {
+		m.LastOrdinal = m.ordinalAt(p.Value)
+		p.Target = m.NextThreshold()
+		return p, nil
+	}
+	p.Target = m.Threshold
+	p.WouldTrigger = p.Value >= m.Threshold && m.ActiveAt(time.Now())
+	return p, nil
+}
@@ -0,0 +1,426 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxSceneLimit caps the rows a scene can ask for, matching the API's limit.
+const maxSceneLimit = 1000
+
+// SceneParams tunes what a scene shows. Every field is optional; scenes
+// ignore the ones they don't use.
+type SceneParams struct {
+	Source string `json:"source,omitempty"` // transaction source tag, or "all"
+	Metric string `json:"metric,omitempty"` // leaderboard metric: transactions or volume
+	Window string `json:"window,omitempty"` // leaderboard window as a duration ("24h") or "all"
+	Limit  int    `json:"limit,omitempty"`  // leaderboard rows
+}
+
+// UnmarshalJSON rejects unknown keys, so a typo doesn't silently fall back
+// to the scene's defaults.
+func (p *SceneParams) UnmarshalJSON(data []byte) error {
+	type plain SceneParams
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		*p = SceneParams{}
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var out plain
+	if err := dec.Decode(&out); err != nil {
+		return fmt.Errorf("invalid scene params: %w", err)
+	}
+	*p = SceneParams(out)
+	return nil
+}
+
+// Validate checks each parameter that is set.
+func (p SceneParams) Validate() error {
+	if p.Source != "" && p.Source != "all" && !slugPattern.MatchString(p.Source) {
+		return errors.New("invalid source: use all or a lowercase source tag")
+	}
+	switch p.Metric {
+	case "", "transactions", "volume":
+	default:
+		return errors.New("invalid metric: use transactions or volume")
+	}
+	if p.Window != "" && p.Window != "all" {
+		if d, err := time.ParseDuration(p.Window); err != nil || d <= 0 {
+			return errors.New("invalid window: use a duration such as 24h, or all")
+		}
+	}
+	if p.Limit < 0 || p.Limit > maxSceneLimit {
+		return fmt.Errorf("limit must be between 0 and %d", maxSceneLimit)
+	}
+	return nil
+}
+
+// PlaylistEntry shows one scene with its own duration and parameters.
+type PlaylistEntry struct {
+	ID       int64       `json:"id"`
+	SceneID  string      `json:"scene_id"`
+	Duration int64       `json:"duration"` // milliseconds; 0 uses the scene's duration
+	Params   SceneParams `json:"params"`
+}
+
+// Playlist is a scene rotation that can be assigned to displays. Entries are
+// in play order.
+type Playlist struct {
+	ID        int64           `json:"id"`
+	EventID   int64           `json:"event_id"`
+	Name      string          `json:"name"`
+	Entries   []PlaylistEntry `json:"entries"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+func (s *Store) validatePlaylist(ctx context.Context, p Playlist) error {
+	if strings.TrimSpace(p.Name) == "" {
+		return errors.New("playlist name is required")
+	}
+	for i, e := range p.Entries {
+		if e.Duration < 0 {
+			return fmt.Errorf("entry %d: duration must not be negative", i+1)
+		}
+		if err := e.Params.Validate(); err != nil {
+			return fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		if _, err := s.GetScene(ctx, e.SceneID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("entry %d: scene %q does not exist", i+1, e.SceneID)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) loadPlaylistEntries(ctx context.Context, p *Playlist) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, scene_id, duration, params FROM playlist_entries
+		WHERE playlist_id=? ORDER BY position
+	`, p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	p.Entries = make([]PlaylistEntry, 0)
+	for rows.Next() {
+		var e PlaylistEntry
+		var params string
+		if err := rows.Scan(&e.ID, &e.SceneID, &e.Duration, &params); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(params), &e.Params); err != nil {
+			return err
+		}
+		p.Entries = append(p.Entries, e)
+	}
+	return rows.Err()
+}
+
+func (s *Store) scanPlaylist(ctx context.Context, row interface{ Scan(...any) error }) (Playlist, error) {
+	var p Playlist
+	if err := row.Scan(&p.ID, &p.EventID, &p.Name, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return p, err
+	}
+	return p, s.loadPlaylistEntries(ctx, &p)
+}
+
+// ListPlaylists returns the event's playlists by name.
+func (s *Store) ListPlaylists(ctx context.Context) ([]Playlist, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, event_id, name, created_at, updated_at FROM playlists
+		WHERE event_id=? ORDER BY name, id
+	`, s.EventID())
+	if err != nil {
+		return nil, err
+	}
+	var items []Playlist
+	for rows.Next() {
+		var p Playlist
+		if err := rows.Scan(&p.ID, &p.EventID, &p.Name, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		items = append(items, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Entries are loaded after the cursor is closed; the pool has one
+	// connection
+	out := make([]Playlist, 0, len(items))
+	for _, p := range items {
+		if err := s.loadPlaylistEntries(ctx, &p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// GetPlaylist fetches one of the event's playlists.
+func (s *Store) GetPlaylist(ctx context.Context, id int64) (Playlist, error) {
+	return s.scanPlaylist(ctx, s.db.QueryRowContext(ctx, `
+		SELECT id, event_id, name, created_at, updated_at FROM playlists WHERE event_id=? AND id=?
+	`, s.EventID(), id))
+}
+
+// DisplayPlaylist fetches the playlist assigned to a display, in whichever
+// event it belongs to.
+func (s *Store) DisplayPlaylist(ctx context.Context, displayID string) (Playlist, error) {
+	return s.scanPlaylist(ctx, s.db.QueryRowContext(ctx, `
+		SELECT p.id, p.event_id, p.name, p.created_at, p.updated_at
+		FROM playlists p JOIN displays d ON d.playlist_id = p.id
+		WHERE d.id=?
+	`, displayID))
+}
+
+func insertPlaylistEntries(ctx context.Context, tx *sql.Tx, playlistID int64, entries []PlaylistEntry) error {
+	for i, e := range entries {
+		params, err := json.Marshal(e.Params)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO playlist_entries (playlist_id, position, scene_id, duration, params)
+			VALUES (?, ?, ?, ?, ?)
+		`, playlistID, i, e.SceneID, e.Duration, string(params)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreatePlaylist adds a playlist with its entries to the event.
+func (s *Store) CreatePlaylist(ctx context.Context, p Playlist) (Playlist, error) {
+	p.Name = strings.TrimSpace(p.Name)
+	if err := s.validatePlaylist(ctx, p); err != nil {
+		return p, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO playlists (event_id, name, created_at, updated_at) VALUES (?, ?, ?, ?)
+	`, s.EventID(), p.Name, now, now)
+	if err != nil {
+		return p, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return p, err
+	}
+	if err := insertPlaylistEntries(ctx, tx, id, p.Entries); err != nil {
+		return p, err
+	}
+	if err := tx.Commit(); err != nil {
+		return p, err
+	}
+	return s.GetPlaylist(ctx, id)
+}
+
+// UpdatePlaylist replaces a playlist's name and entries in one transaction.
+// Entries get new ids.
+func (s *Store) UpdatePlaylist(ctx context.Context, p Playlist) (Playlist, error) {
+	p.Name = strings.TrimSpace(p.Name)
+	if err := s.validatePlaylist(ctx, p); err != nil {
+		return p, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `
+		UPDATE playlists SET name=?, updated_at=? WHERE event_id=? AND id=?
+	`, p.Name, time.Now().UTC(), s.EventID(), p.ID)
+	if err != nil {
+		return p, err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return p, sql.ErrNoRows
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM playlist_entries WHERE playlist_id=?`, p.ID); err != nil {
+		return p, err
+	}
+	if err := insertPlaylistEntries(ctx, tx, p.ID, p.Entries); err != nil {
+		return p, err
+	}
+	if err := tx.Commit(); err != nil {
+		return p, err
+	}
+	return s.GetPlaylist(ctx, p.ID)
+}
+
+// ReorderPlaylist puts a playlist's entries in the order of entryIDs, which
+// must list every entry exactly once.
+func (s *Store) ReorderPlaylist(ctx context.Context, id int64, entryIDs []int64) (Playlist, error) {
+	p, err := s.GetPlaylist(ctx, id)
+	if err != nil {
+		return p, err
+	}
+	current := make(map[int64]bool, len(p.Entries))
+	for _, e := range p.Entries {
+		current[e.ID] = true
+	}
+	if len(entryIDs) != len(current) {
+		return p, fmt.Errorf("expected %d entry ids, got %d", len(current), len(entryIDs))
+	}
+	for _, entryID := range entryIDs {
+		if !current[entryID] {
+			return p, fmt.Errorf("entry %d is not in the playlist or is listed twice", entryID)
+		}
+		delete(current, entryID)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback()
+	for pos, entryID := range entryIDs {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE playlist_entries SET position=? WHERE playlist_id=? AND id=?
+		`, pos, id, entryID); err != nil {
+			return p, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE playlists SET updated_at=? WHERE id=?`, time.Now().UTC(), id); err != nil {
+		return p, err
+	}
+	if err := tx.Commit(); err != nil {
+		return p, err
+	}
+	return s.GetPlaylist(ctx, id)
+}
+
+// DeletePlaylist removes a playlist. Displays it was assigned to go back to
+// the event's default rotation.
+func (s *Store) DeletePlaylist(ctx context.Context, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `DELETE FROM playlists WHERE event_id=? AND id=?`, s.EventID(), id)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	stmts := []string{
+		`DELETE FROM playlist_entries WHERE playlist_id=?`,
+		`UPDATE displays SET playlist_id=NULL WHERE playlist_id=?`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AssignDisplayPlaylist sets the playlist a display plays; 0 clears it. The
+// playlist can belong to any event.
+func (s *Store) AssignDisplayPlaylist(ctx context.Context, displayID string, playlistID int64) error {
+	var assigned sql.NullInt64
+	if playlistID != 0 {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, `
+			SELECT EXISTS(SELECT 1 FROM playlists WHERE id=?)
+		`, playlistID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("playlist %d does not exist", playlistID)
+		}
+		assigned = sql.NullInt64{Int64: playlistID, Valid: true}
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE displays SET playlist_id=? WHERE id=?`, assigned, displayID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PlaylistDisplays returns the ids of approved displays playing a playlist.
+func (s *Store) PlaylistDisplays(ctx context.Context, playlistID int64) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id FROM displays WHERE playlist_id=? AND status=? ORDER BY id
+	`, playlistID, DisplayApproved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// clonePlaylists copies an event's playlists and their entries into another
+// event. Playlists get new ids, so display assignments stay with the source.
+func clonePlaylists(ctx context.Context, tx *sql.Tx, sourceID, targetID int64, now time.Time) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, name FROM playlists WHERE event_id=? ORDER BY id`, sourceID)
+	if err != nil {
+		return err
+	}
+	type playlist struct {
+		id   int64
+		name string
+	}
+	var playlists []playlist
+	for rows.Next() {
+		var p playlist
+		if err := rows.Scan(&p.id, &p.name); err != nil {
+			rows.Close()
+			return err
+		}
+		playlists = append(playlists, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, p := range playlists {
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO playlists (event_id, name, created_at, updated_at) VALUES (?, ?, ?, ?)
+		`, targetID, p.name, now, now)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO playlist_entries (playlist_id, position, scene_id, duration, params)
+			SELECT ?, position, scene_id, duration, params FROM playlist_entries WHERE playlist_id=?
+		`, id, p.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Settings returns the stored setting overrides keyed by setting name.
+// Settings are global rather than per event.
+func (s *Store) Settings(ctx context.Context) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT key, value FROM settings`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		out[key] = value
+	}
+	return out, rows.Err()
+}
+
+// SaveSettings stores setting overrides in a single transaction. A nil value
+// removes the override so the environment default applies again.
+func (s *Store) SaveSettings(ctx context.Context, values map[string]*string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	for key, value := range values {
+		if value == nil {
+			_, err = tx.ExecContext(ctx, `DELETE FROM settings WHERE key=?`, key)
+		} else {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO settings (key, value, updated_at) VALUES (?, ?, ?)
+				ON CONFLICT(key) DO UPDATE SET value=excluded.value, updated_at=excluded.updated_at
+			`, key, *value, now)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Settings such as the ticker limit shape public responses.
+	s.touch()
+	return nil
+}
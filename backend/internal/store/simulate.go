@@ -0,0 +1,335 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// maxSimulatedMilestones bounds the candidates one simulation replays.
+	maxSimulatedMilestones = 50
+	// maxSimulatedTriggers bounds the crossings one candidate records, in
+	// case a tiny recurring step meets a long event.
+	maxSimulatedTriggers = 1000
+)
+
+// SimulatedTrigger is when a candidate milestone would have fired.
+type SimulatedTrigger struct {
+	Milestone         int           `json:"milestone"` // index into the candidates
+	Name              string        `json:"name"`
+	Type              MilestoneType `json:"type"`
+	Threshold         int64         `json:"threshold"`
+	Ordinal           int64         `json:"ordinal"`
+	TriggeredAt       time.Time     `json:"triggered_at"` // the payment's sale date, or active_from if that is later
+	TotalTransactions int64         `json:"total_transactions"`
+	TotalVolumeSats   int64         `json:"total_volume_sats"`
+	Transaction       *TickerEntry  `json:"transaction"` // the replayed payment it fired at
+}
+
+// MilestoneSimulation is the result of replaying an event's transactions
+// against candidate milestones.
+type MilestoneSimulation struct {
+	Transactions int64              `json:"transactions"` // payments replayed
+	From         *time.Time         `json:"from"`         // first sale date replayed
+	Until        *time.Time         `json:"until"`        // last sale date replayed
+	Triggers     []SimulatedTrigger `json:"triggers"`     // in the order they would have fired
+	// MeanGapSeconds and LongestGapSeconds are measured between consecutive
+	// triggers; nil with fewer than two
+	MeanGapSeconds    *int64 `json:"mean_gap_seconds"`
+	LongestGapSeconds *int64 `json:"longest_gap_seconds"`
+	Truncated         bool   `json:"truncated"` // a candidate hit the trigger limit
+}
+
+// replayTotals accumulates the payments one candidate counts.
+type replayTotals struct {
+	transactions int64
+	volume       int64
+	maxPayment   int64
+	bySource     map[TransactionSource]*[2]int64 // transactions, volume
+	byMerchant   map[string]*[2]int64
+	rateWindow   time.Duration // longest rate window measured; 0 for none
+	saleDates    []time.Time   // within rateWindow of the last payment, in order
+}
+
+func (t *replayTotals) add(e TickerEntry, source TransactionSource) {
+	t.transactions++
+	t.volume += e.AmountSats
+	t.maxPayment = max(t.maxPayment, e.AmountSats)
+	addSums(t.bySource, source, e.AmountSats)
+	addSums(t.byMerchant, e.MerchantID, e.AmountSats)
+	if t.rateWindow > 0 {
+		t.saleDates = append(t.saleDates, e.SaleDate)
+		from := e.SaleDate.Add(-t.rateWindow)
+		i, _ := slices.BinarySearchFunc(t.saleDates, from, func(a, b time.Time) int { return a.Compare(b) })
+		t.saleDates = t.saleDates[i:]
+	}
+}
+
+func addSums[K comparable](sums map[K]*[2]int64, key K, amount int64) {
+	if sums[key] == nil {
+		sums[key] = new([2]int64)
+	}
+	sums[key][0]++
+	sums[key][1] += amount
+}
+
+// rate is transactions per minute over the window ending at the last
+// payment added, as the live check measures it.
+func (t *replayTotals) rate(window time.Duration) float64 {
+	if len(t.saleDates) == 0 {
+		return 0
+	}
+	from := t.saleDates[len(t.saleDates)-1].Add(-window)
+	i, _ := slices.BinarySearchFunc(t.saleDates, from, func(a, b time.Time) int { return a.Compare(b) })
+	return float64(len(t.saleDates)-i) / window.Minutes()
+}
+
+// metric is the replayed value of a condition metric.
+func (t *replayTotals) metric(metric conditionMetric) float64 {
+	pick := func(sums *[2]int64, volume bool) float64 {
+		switch {
+		case sums == nil:
+			return 0
+		case volume:
+			return float64(sums[1])
+		default:
+			return float64(sums[0])
+		}
+	}
+	volume := strings.HasSuffix(metric.name, "_volume")
+	switch metric.name {
+	case "transactions":
+		return float64(t.transactions)
+	case "volume":
+		return float64(t.volume)
+	case "active_merchants":
+		return float64(len(t.byMerchant))
+	case "max_payment":
+		return float64(t.maxPayment)
+	case "source_transactions", "source_volume":
+		return pick(t.bySource[TransactionSource(metric.str)], volume)
+	case "merchant_transactions", "merchant_volume":
+		return pick(t.byMerchant[metric.str], volume)
+	case "merchants_with_transactions", "merchants_with_volume":
+		var n int
+		for _, sums := range t.byMerchant {
+			if pick(sums, volume) >= metric.num {
+				n++
+			}
+		}
+		return float64(n)
+	case "rate":
+		d, _ := time.ParseDuration(metric.str)
+		return t.rate(d)
+	}
+	return 0
+}
+
+// replayCandidate is one candidate milestone during a simulation.
+type replayCandidate struct {
+	index     int
+	m         Milestone
+	condition *Condition
+	totals    replayTotals
+	triggers  []SimulatedTrigger
+	done      bool
+}
+
+// value is what the live check would compare against the threshold after
+// the payments added so far.
+func (c *replayCandidate) value() int64 {
+	t := &c.totals
+	switch c.m.Type {
+	case MilestoneTransactions:
+		return t.transactions
+	case MilestoneVolume:
+		return t.volume
+	case MilestoneMerchant, MilestoneSource:
+		sums := t.byMerchant[c.m.Params.MerchantID]
+		if c.m.Type == MilestoneSource {
+			sums = t.bySource[c.m.Params.Source]
+		}
+		if sums == nil {
+			return 0
+		}
+		if c.m.metric() == MetricVolume {
+			return sums[1]
+		}
+		return sums[0]
+	case MilestoneActiveMerchants:
+		return int64(len(t.byMerchant))
+	case MilestoneSingleTransaction:
+		return t.maxPayment
+	case MilestoneRate:
+		return int64(t.rate(c.m.Params.window()))
+	case MilestoneExpression:
+		values := make([]float64, len(c.condition.metrics))
+		for i, metric := range c.condition.metrics {
+			values[i] = t.metric(metric)
+		}
+		if c.condition.root.eval(values) != 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// check records the crossings the candidate passed at e. Totals only grow
+// for most types, so a crossing before active_from fires when the period
+// starts; rate and expression milestones can fall back and only fire at
+// payments inside it.
+func (c *replayCandidate) check(e TickerEntry, totalTransactions, totalVolume int64) {
+	if c.done {
+		return
+	}
+	at := e.SaleDate
+	if c.m.ActiveUntil != nil && !at.Before(*c.m.ActiveUntil) {
+		return
+	}
+	if c.m.ActiveFrom != nil && at.Before(*c.m.ActiveFrom) {
+		if c.m.Type == MilestoneRate || c.m.Type == MilestoneExpression {
+			return
+		}
+		at = *c.m.ActiveFrom
+	}
+	for _, crossing := range c.m.crossings(c.value()) {
+		if len(c.triggers) == maxSimulatedTriggers {
+			c.done = true
+			return
+		}
+		entry := e
+		c.triggers = append(c.triggers, SimulatedTrigger{
+			Milestone:         c.index,
+			Name:              c.m.Name,
+			Type:              c.m.Type,
+			Threshold:         crossing.threshold,
+			Ordinal:           crossing.ordinal,
+			TriggeredAt:       at,
+			TotalTransactions: totalTransactions,
+			TotalVolumeSats:   totalVolume,
+			Transaction:       &entry,
+		})
+		c.m.LastOrdinal = crossing.ordinal
+	}
+	c.done = len(c.triggers) > 0 && (!c.m.Recurring || c.m.finished())
+}
+
+// SimulateMilestones replays the event's transactions in sale order against
+// candidate milestones and reports when each would have fired. Nothing is
+// written: candidates don't need to be saved and no triggers are recorded.
+// Recurring candidates start from zero. unique_products can't be replayed,
+// as product sales aren't recorded per payment.
+func (s *Store) SimulateMilestones(ctx context.Context, candidates []Milestone) (MilestoneSimulation, error) {
+	sim := MilestoneSimulation{Triggers: make([]SimulatedTrigger, 0)}
+	switch {
+	case len(candidates) == 0:
+		return sim, errors.New("no milestones to simulate")
+	case len(candidates) > maxSimulatedMilestones:
+		return sim, fmt.Errorf("at most %d milestones can be simulated at once", maxSimulatedMilestones)
+	}
+	replay := make([]*replayCandidate, len(candidates))
+	for i, m := range candidates {
+		if err := validateMilestone(m); err != nil {
+			return sim, fmt.Errorf("milestone %d: %w", i, err)
+		}
+		m.Type = MilestoneType(strings.ToLower(string(m.Type)))
+		m.LastOrdinal = 0
+		c := &replayCandidate{index: i, m: m, totals: replayTotals{
+			bySource:   make(map[TransactionSource]*[2]int64),
+			byMerchant: make(map[string]*[2]int64),
+		}}
+		switch m.Type {
+		case MilestoneUniqueProducts:
+			return sim, fmt.Errorf("milestone %d: unique_products milestones can't be simulated", i)
+		case MilestoneRate:
+			c.totals.rateWindow = m.Params.window()
+		case MilestoneExpression:
+			c.m.Threshold = 1
+			c.condition, _ = ParseCondition(m.Params.Condition)
+			for _, metric := range c.condition.metrics {
+				switch metric.name {
+				case "unique_products":
+					return sim, fmt.Errorf("milestone %d: unique_products can't be simulated", i)
+				case "rate":
+					d, _ := time.ParseDuration(metric.str)
+					c.totals.rateWindow = max(c.totals.rateWindow, d)
+				}
+			}
+		}
+		replay[i] = c
+	}
+
+	payments, err := s.replayPayments(ctx)
+	if err != nil {
+		return sim, err
+	}
+	var totalVolume int64
+	for _, p := range payments {
+		e := p.entry
+		sim.Transactions++
+		totalVolume += e.AmountSats
+		if sim.From == nil {
+			sim.From = &e.SaleDate
+		}
+		sim.Until = &e.SaleDate
+		for _, c := range replay {
+			if !c.m.measures(e.SaleDate) {
+				continue
+			}
+			c.totals.add(e, p.source)
+			c.check(e, sim.Transactions, totalVolume)
+		}
+	}
+
+	for _, c := range replay {
+		sim.Triggers = append(sim.Triggers, c.triggers...)
+		sim.Truncated = sim.Truncated || len(c.triggers) == maxSimulatedTriggers
+	}
+	slices.SortStableFunc(sim.Triggers, func(a, b SimulatedTrigger) int {
+		return a.TriggeredAt.Compare(b.TriggeredAt)
+	})
+	if n := len(sim.Triggers); n > 1 {
+		var longest time.Duration
+		for i := 1; i < n; i++ {
+			longest = max(longest, sim.Triggers[i].TriggeredAt.Sub(sim.Triggers[i-1].TriggeredAt))
+		}
+		mean := int64(sim.Triggers[n-1].TriggeredAt.Sub(sim.Triggers[0].TriggeredAt).Seconds()) / int64(n-1)
+		longestSeconds := int64(longest.Seconds())
+		sim.MeanGapSeconds, sim.LongestGapSeconds = &mean, &longestSeconds
+	}
+	return sim, nil
+}
+
+// replayPayment is one transaction read for a simulation.
+type replayPayment struct {
+	entry  TickerEntry
+	source TransactionSource
+}
+
+// replayPayments reads the event's transactions in sale order. They are read
+// in full before replaying, so the connection isn't held while candidates
+// are checked.
+func (s *Store) replayPayments(ctx context.Context) ([]replayPayment, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+crossingColumns+`, t.source FROM `+crossingJoin+`
+		WHERE t.event_id=? ORDER BY t.sale_date, t.id
+	`, s.EventID())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var payments []replayPayment
+	for rows.Next() {
+		var p replayPayment
+		e := &p.entry
+		if err := rows.Scan(&e.ID, &e.SaleID, &e.MerchantID, &e.MerchantAlias, &e.AmountSats, &e.SaleDate, &p.source); err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return payments, rows.Err()
+}
@@ -0,0 +1,278 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SceneType tells the frontend how to render a scene.
+type SceneType string
+
+const (
+	SceneBuiltin SceneType = "builtin" // one of the frontend's own views, picked by id
+	SceneContent SceneType = "content" // an admin-managed slide, such as a sponsor
+)
+
+// ErrAssetInUse is returned when deleting an asset a slide, milestone or
+// recorded trigger still uses.
+var ErrAssetInUse = errors.New("asset is used by a slide, milestone or trigger")
+
+// maxImpressionSeconds caps how long one reported showing can last.
+const maxImpressionSeconds = 3600
+
+// ScheduleWindow is a period during which a content slide is shown.
+type ScheduleWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// SlideContent is what a content slide shows. A slide with no schedule is
+// always shown; otherwise only inside one of its windows.
+type SlideContent struct {
+	Title    string           `json:"title"`
+	Body     string           `json:"body"`
+	AssetID  string           `json:"asset_id"` // image served from /v1/assets/{id}
+	Sponsor  string           `json:"sponsor"`
+	Schedule []ScheduleWindow `json:"schedule"`
+}
+
+// ActiveAt reports whether the slide is scheduled at t.
+func (c SlideContent) ActiveAt(t time.Time) bool {
+	if len(c.Schedule) == 0 {
+		return true
+	}
+	for _, w := range c.Schedule {
+		if !t.Before(w.Start) && t.Before(w.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveAt reports whether a scene should be in the rotation at t. Only
+// content slides have schedules.
+func (sc Scene) ActiveAt(t time.Time) bool {
+	return sc.Content == nil || sc.Content.ActiveAt(t)
+}
+
+func (s *Store) validateScene(ctx context.Context, sc Scene) error {
+	switch sc.Type {
+	case "", SceneBuiltin:
+		if sc.Content != nil {
+			return errors.New("only content scenes have content")
+		}
+		return nil
+	case SceneContent:
+	default:
+		return fmt.Errorf("unknown scene type %q", sc.Type)
+	}
+	c := sc.Content
+	if c == nil || (strings.TrimSpace(c.Title) == "" && strings.TrimSpace(c.Body) == "" && c.AssetID == "") {
+		return errors.New("content slides need a title, body or image")
+	}
+	for i, w := range c.Schedule {
+		if w.Start.IsZero() || w.End.IsZero() || !w.End.After(w.Start) {
+			return fmt.Errorf("schedule window %d: end must be after start", i+1)
+		}
+	}
+	if c.AssetID != "" {
+		a, err := s.GetAsset(ctx, c.AssetID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("asset %s does not exist", c.AssetID)
+		}
+		if err != nil {
+			return err
+		}
+		if a.Kind() != "image" {
+			return fmt.Errorf("asset %s is not an image", c.AssetID)
+		}
+	}
+	return nil
+}
+
+// Asset is an uploaded image, sound or clip. Its id is the SHA-256 of the
+// content, so the same file uploaded twice is stored once.
+type Asset struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Kind is the asset's top-level media type: image, audio or video.
+func (a Asset) Kind() string {
+	kind, _, _ := strings.Cut(a.ContentType, "/")
+	return kind
+}
+
+const assetColumns = `id, name, content_type, size, created_at`
+
+func scanAsset(row interface{ Scan(...any) error }) (Asset, error) {
+	var a Asset
+	err := row.Scan(&a.ID, &a.Name, &a.ContentType, &a.Size, &a.CreatedAt)
+	return a, err
+}
+
+// SaveAsset stores an uploaded file. Assets are shared by all events.
+func (s *Store) SaveAsset(ctx context.Context, name, contentType string, data []byte) (Asset, error) {
+	if len(data) == 0 {
+		return Asset{}, errors.New("empty file")
+	}
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])
+	_, err := s.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO assets (id, name, content_type, size, data, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, id, strings.TrimSpace(name), contentType, len(data), data, time.Now().UTC())
+	if err != nil {
+		return Asset{}, err
+	}
+	return s.GetAsset(ctx, id)
+}
+
+// GetAsset fetches an asset's metadata.
+func (s *Store) GetAsset(ctx context.Context, id string) (Asset, error) {
+	return scanAsset(s.db.QueryRowContext(ctx, `SELECT `+assetColumns+` FROM assets WHERE id=?`, id))
+}
+
+// AssetData fetches an asset with its content.
+func (s *Store) AssetData(ctx context.Context, id string) (Asset, []byte, error) {
+	var a Asset
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT `+assetColumns+`, data FROM assets WHERE id=?
+	`, id).Scan(&a.ID, &a.Name, &a.ContentType, &a.Size, &a.CreatedAt, &data)
+	return a, data, err
+}
+
+// ListAssets returns all assets, newest first.
+func (s *Store) ListAssets(ctx context.Context) ([]Asset, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+assetColumns+` FROM assets ORDER BY created_at DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := make([]Asset, 0)
+	for rows.Next() {
+		a, err := scanAsset(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, a)
+	}
+	return items, rows.Err()
+}
+
+// DeleteAsset removes an asset no slide, milestone or recorded trigger in
+// any event uses, so celebrations can always be played again.
+func (s *Store) DeleteAsset(ctx context.Context, id string) error {
+	var used bool
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM scenes WHERE asset_id=?1)
+			OR EXISTS(SELECT 1 FROM milestones WHERE json_extract(celebration, '$.asset_id')=?1
+				OR json_extract(celebration, '$.sound_asset_id')=?1)
+			OR EXISTS(SELECT 1 FROM milestone_triggers WHERE json_extract(celebration, '$.asset_id')=?1
+				OR json_extract(celebration, '$.sound_asset_id')=?1)
+	`, id).Scan(&used); err != nil {
+		return err
+	}
+	if used {
+		return ErrAssetInUse
+	}
+	res, err := s.db.ExecContext(ctx, `DELETE FROM assets WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SlideImpression is one completed showing of a slide, as reported by a
+// display.
+type SlideImpression struct {
+	SceneID string    `json:"scene_id"`
+	ShownAt time.Time `json:"shown_at"`
+	Seconds int64     `json:"seconds"`
+}
+
+// RecordSlideImpressions stores a display's showings of the event's content
+// slides and returns how many were new. Showings of other scenes and
+// implausible durations are skipped; a showing reported twice (a retried
+// heartbeat) is counted once.
+func (s *Store) RecordSlideImpressions(ctx context.Context, displayID string, impressions []SlideImpression) (int, error) {
+	if len(impressions) == 0 {
+		return 0, nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	recorded := 0
+	for _, imp := range impressions {
+		if imp.Seconds <= 0 || imp.Seconds > maxImpressionSeconds || imp.ShownAt.IsZero() {
+			continue
+		}
+		res, err := tx.ExecContext(ctx, `
+			INSERT OR IGNORE INTO slide_impressions (event_id, scene_id, display_id, shown_at, seconds)
+			SELECT event_id, id, ?, ?, ? FROM scenes WHERE event_id=? AND id=? AND type=?
+		`, displayID, imp.ShownAt.UTC(), imp.Seconds, s.EventID(), imp.SceneID, SceneContent)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		recorded += int(n)
+	}
+	return recorded, tx.Commit()
+}
+
+// SlideAirtime is how often and how long a content slide was on screen.
+type SlideAirtime struct {
+	SceneID        string `json:"scene_id"`
+	Name           string `json:"name"`
+	Sponsor        string `json:"sponsor"`
+	Impressions    int64  `json:"impressions"`
+	AirtimeSeconds int64  `json:"airtime_seconds"`
+	Displays       int64  `json:"displays"`
+}
+
+// SlideReport sums the event's slide impressions shown in [from, to). Zero
+// times leave that end open. Every content slide is listed, including ones
+// that were never shown.
+func (s *Store) SlideReport(ctx context.Context, from, to time.Time) ([]SlideAirtime, error) {
+	if to.IsZero() {
+		to = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT sc.id, sc.name, sc.sponsor, COUNT(i.scene_id), COALESCE(SUM(i.seconds), 0),
+			COUNT(DISTINCT i.display_id)
+		FROM scenes sc
+		LEFT JOIN slide_impressions i ON i.event_id = sc.event_id AND i.scene_id = sc.id
+			AND i.shown_at >= ? AND i.shown_at < ?
+		WHERE sc.event_id=? AND sc.type=?
+		GROUP BY sc.id
+		ORDER BY sc.sponsor, sc.scene_order, sc.id
+	`, from.UTC(), to.UTC(), s.EventID(), SceneContent)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := make([]SlideAirtime, 0)
+	for rows.Next() {
+		var a SlideAirtime
+		if err := rows.Scan(&a.SceneID, &a.Name, &a.Sponsor, &a.Impressions, &a.AirtimeSeconds, &a.Displays); err != nil {
+			return nil, err
+		}
+		items = append(items, a)
+	}
+	return items, rows.Err()
+}
@@ -3,9 +3,12 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -32,11 +35,15 @@ const (
 
 // TransactionInput represents a sale from the upstream API.
 type TransactionInput struct {
-	SaleID     int64
-	SaleOrigin string
-	SaleDate   time.Time
-	AmountSats int64
-	Source     TransactionSource // Data source: pwf, wifi, etc.
+	SaleID      int64  // Upstream POS sale id; 0 for sources without one
+	ExternalID  string // Dedupe key within the source; defaults to "merchant:sale"
+	SaleOrigin  string
+	SaleDate    time.Time
+	AmountSats  int64
+	Source      TransactionSource // Data source: pwf, wifi, etc.
+	WifiTierID  *int64            // WiFi tier the payment matched, if any
+	PaymentHash string
+	Memo        string
 }
 
 // ProductSnapshot captures the upstream per-product cumulative stats.
@@ -64,7 +71,8 @@ type Summary struct {
 
 // TickerEntry is a row in the public live ticker.
 type TickerEntry struct {
-	SaleID        int64     `json:"sale_id"`
+	ID            int64     `json:"id"`
+	SaleID        *int64    `json:"sale_id"`
 	MerchantID    string    `json:"merchant_id"`
 	MerchantAlias string    `json:"merchant_alias"`
 	AmountSats    int64     `json:"amount_sats"`
@@ -92,49 +100,107 @@ type ProductLeaderboardRow struct {
 type MilestoneType string
 
 const (
-	MilestoneTransactions MilestoneType = "transactions"
-	MilestoneVolume       MilestoneType = "volume"
+	MilestoneTransactions      MilestoneType = "transactions"       // all transactions
+	MilestoneVolume            MilestoneType = "volume"             // all sats
+	MilestoneMerchant          MilestoneType = "merchant"           // one merchant's transactions or sats
+	MilestoneSource            MilestoneType = "source"             // one source's transactions or sats
+	MilestoneActiveMerchants   MilestoneType = "active_merchants"   // merchants with at least one sale
+	MilestoneUniqueProducts    MilestoneType = "unique_products"    // products sold at least once
+	MilestoneRate              MilestoneType = "rate"               // transactions per minute over a window
+	MilestoneSingleTransaction MilestoneType = "single_transaction" // one payment of at least threshold sats
+	MilestoneExpression        MilestoneType = "expression"         // params.condition holds
 )
 
 // Milestone config row.
 type Milestone struct {
-	ID          int64         `json:"id"`
-	Name        string        `json:"name"`
-	Type        MilestoneType `json:"type"`
-	Threshold   int64         `json:"threshold"`
-	Enabled     bool          `json:"enabled"`
-	Triggered   bool          `json:"triggered"`
-	TriggeredAt *time.Time    `json:"triggered_at,omitempty"`
-	CreatedAt   time.Time     `json:"created_at"`
-	UpdatedAt   time.Time     `json:"updated_at"`
+	ID          int64           `json:"id"`
+	Name        string          `json:"name"`
+	Type        MilestoneType   `json:"type"`
+	Params      MilestoneParams `json:"params"`
+	Threshold   int64           `json:"threshold"`    // the step for recurring milestones
+	Recurring   bool            `json:"recurring"`    // fire at every multiple of threshold
+	MaxOrdinal  int64           `json:"max_ordinal"`  // recurring: last crossing to fire; 0 never stops
+	LastOrdinal int64           `json:"last_ordinal"` // recurring: last crossing fired or skipped
+	// ActiveFrom and ActiveUntil bound when the milestone can fire;
+	// MeasureFrom and MeasureUntil which sales it counts. Nil is open-ended.
+	ActiveFrom   *time.Time           `json:"active_from"`
+	ActiveUntil  *time.Time           `json:"active_until"`
+	MeasureFrom  *time.Time           `json:"measure_from"`
+	MeasureUntil *time.Time           `json:"measure_until"`
+	Celebration  MilestoneCelebration `json:"celebration"`
+	Enabled      bool                 `json:"enabled"`
+	Triggered    bool                 `json:"triggered"` // recurring: the series reached max_ordinal
+	TriggeredAt  *time.Time           `json:"triggered_at,omitempty"`
+	Archived     bool                 `json:"archived"` // hidden from the list and never fires
+	ArchivedAt   *time.Time           `json:"archived_at,omitempty"`
+	CreatedAt    time.Time            `json:"created_at"`
+	UpdatedAt    time.Time            `json:"updated_at"`
+}
+
+// MilestoneCelebration is how displays celebrate a milestone. All fields
+// are optional; an empty celebration gets the frontend's default animation.
+type MilestoneCelebration struct {
+	AssetID      string `json:"asset_id,omitempty"`       // image or clip served from /v1/assets/{id}
+	SoundAssetID string `json:"sound_asset_id,omitempty"` // audio played with it
+	Text         string `json:"text,omitempty"`           // shown instead of the name
+	Duration     int64  `json:"duration,omitempty"`       // milliseconds; 0 uses the display's default
 }
 
 // MilestoneTrigger records an actual trigger event for the dashboard.
 type MilestoneTrigger struct {
 	ID                int64     `json:"id"`
-	MilestoneID       int64     `json:"milestone_id"`
+	MilestoneID       int64     `json:"milestone_id"` // 0 if the milestone's reference was cleared
 	Name              string    `json:"name"`
 	Type              string    `json:"type"`
 	Threshold         int64     `json:"threshold"`
+	Ordinal           int64     `json:"ordinal"` // which crossing of a recurring milestone; 1 otherwise
 	TriggeredAt       time.Time `json:"triggered_at"`
-	TotalTransactions int64     `json:"total_transactions"`
+	TotalTransactions int64     `json:"total_transactions"` // event totals through Transaction, if known
 	TotalVolumeSats   int64     `json:"total_volume_sats"`
+	// Transaction is the payment that crossed the threshold; nil for
+	// unique_products milestones, which don't count payments
+	Transaction *TickerEntry `json:"transaction"`
+	// Celebration is the milestone's, as it was when the trigger was
+	// recorded
+	Celebration MilestoneCelebration `json:"celebration"`
+	RefireOf    *int64               `json:"refire_of,omitempty"` // the trigger an admin re-fired
 }
 
 // Scene represents a dashboard scene configuration.
 type Scene struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Duration  int64     `json:"duration"` // milliseconds
-	Enabled   bool      `json:"enabled"`
-	Order     int64     `json:"order"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        string        `json:"id"`
+	Name      string        `json:"name"`
+	Duration  int64         `json:"duration"` // milliseconds
+	Enabled   bool          `json:"enabled"`
+	Order     int64         `json:"order"`
+	Type      SceneType     `json:"type"`
+	Content   *SlideContent `json:"content,omitempty"` // content slides only
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
 }
 
 // Store wraps the SQLite database and queries.
 type Store struct {
-	db *sql.DB
+	db           *sql.DB
+	version      *dataVersion
+	defaultEvent *atomic.Int64
+	event        int64       // 0 follows defaultEvent; see ForEvent
+	milestones   *sync.Mutex // serialises ProcessMilestones across handles
+}
+
+// DataVersion identifies a snapshot of the stored data. Seq increases on
+// every write; Boot distinguishes process lifetimes so versions never repeat
+// across restarts.
+type DataVersion struct {
+	Boot       int64
+	Seq        uint64
+	ModifiedAt time.Time
+}
+
+type dataVersion struct {
+	boot     int64
+	seq      atomic.Uint64
+	modified atomic.Int64 // unix nanoseconds
 }
 
 // New opens a SQLite database located at the supplied path. Call Init afterwards.
@@ -153,7 +219,35 @@ func New(path string) (*Store, error) {
 		return nil, err
 	}
 	db.SetMaxOpenConns(1)
-	return &Store{db: db}, nil
+	now := time.Now().UTC()
+	version := &dataVersion{boot: now.UnixNano()}
+	version.modified.Store(now.UnixNano())
+	return &Store{db: db, version: version, defaultEvent: new(atomic.Int64), milestones: new(sync.Mutex)}, nil
+}
+
+// DataVersion returns the current data version. Callers can use it to tag
+// derived results and detect whether anything has been written since.
+func (s *Store) DataVersion() DataVersion {
+	// Load the sequence before the timestamp so ModifiedAt is never older
+	// than the write that produced Seq.
+	seq := s.version.seq.Load()
+	return DataVersion{
+		Boot:       s.version.boot,
+		Seq:        seq,
+		ModifiedAt: time.Unix(0, s.version.modified.Load()).UTC(),
+	}
+}
+
+// touch records that data changed.
+func (s *Store) touch() {
+	s.version.modified.Store(time.Now().UTC().UnixNano())
+	s.version.seq.Add(1)
+}
+
+// Touch records a change made outside the database that still shapes
+// responses tagged with the data version, such as the live config.
+func (s *Store) Touch() {
+	s.touch()
 }
 
 // Close the underlying DB.
@@ -241,42 +335,36 @@ func (s *Store) Init(ctx context.Context) error {
 
 	// Migration: Add source column to existing transactions table
 	// This is safe to run multiple times (will error if column exists, which we ignore)
-	migrations := []string{
+	legacyMigrations := []string{
 		`ALTER TABLE transactions ADD COLUMN source TEXT NOT NULL DEFAULT 'pwf';`,
 		`CREATE INDEX IF NOT EXISTS idx_transactions_source ON transactions(source);`,
 	}
-	for _, migration := range migrations {
+	for _, migration := range legacyMigrations {
 		// Ignore errors - column may already exist
 		s.db.ExecContext(ctx, migration)
 	}
 
-	// Auto-create WiFi merchant if it doesn't exist
-	now := time.Now().UTC()
-	_, err := s.db.ExecContext(ctx, `
-		INSERT OR IGNORE INTO merchants (id, public_key, alias, enabled, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, "wifi", "wifi_payments", "WiFi Upgrades", 1, now, now)
-	if err != nil {
-		return fmt.Errorf("failed to create wifi merchant: %w", err)
+	if err := s.migrate(ctx); err != nil {
+		return err
+	}
+	if err := s.loadDefaultEvent(ctx); err != nil {
+		return err
 	}
 
-	// Seed default scenes if they don't exist
-	defaultScenes := []Scene{
-		{ID: "overview", Name: "Overview", Duration: 10000, Enabled: true, Order: 1},
-		{ID: "merchants", Name: "Merchants", Duration: 10000, Enabled: true, Order: 2},
-		{ID: "wifi", Name: "WiFi", Duration: 10000, Enabled: true, Order: 3},
-		{ID: "merch", Name: "Merch", Duration: 10000, Enabled: true, Order: 4},
+	// Auto-create the WiFi merchant and default scenes if they don't exist
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
-	for _, scene := range defaultScenes {
-		_, err := s.db.ExecContext(ctx, `
-			INSERT OR IGNORE INTO scenes (id, name, duration, enabled, scene_order, created_at, updated_at)
-			VALUES (?, ?, ?, ?, ?, ?, ?)
-		`, scene.ID, scene.Name, scene.Duration, boolToInt(scene.Enabled), scene.Order, now, now)
-		if err != nil {
-			return fmt.Errorf("failed to seed scene %s: %w", scene.ID, err)
-		}
+	defer tx.Rollback()
+	if err := seedEvent(ctx, tx, s.EventID()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
 	}
 
+	s.touch()
 	return nil
 }
 
@@ -286,15 +374,64 @@ func (s *Store) UpsertMerchant(ctx context.Context, m Merchant) error {
 	m.CreatedAt = now
 	m.UpdatedAt = now
 	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO merchants (id, public_key, alias, enabled, last_polled_at, created_at, updated_at)
-		VALUES (?, ?, ?, ?, NULL, ?, ?)
-		ON CONFLICT(id) DO UPDATE SET
+		INSERT INTO merchants (event_id, id, public_key, alias, enabled, last_polled_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, NULL, ?, ?)
+		ON CONFLICT(event_id, id) DO UPDATE SET
 			public_key=excluded.public_key,
 			alias=excluded.alias,
 			enabled=excluded.enabled,
 			updated_at=excluded.updated_at
-	`, m.ID, m.PublicKey, m.Alias, boolToInt(m.Enabled), m.CreatedAt, m.UpdatedAt)
-	return err
+	`, s.EventID(), m.ID, m.PublicKey, m.Alias, boolToInt(m.Enabled), m.CreatedAt, m.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	s.touch()
+	return nil
+}
+
+// ImportMerchants upserts merchants in a single transaction, so either every
+// row is stored or none is. It returns how many merchants were newly created.
+func (s *Store) ImportMerchants(ctx context.Context, merchants []Merchant) (int, error) {
+	if len(merchants) == 0 {
+		return 0, nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	eventID := s.EventID()
+	now := time.Now().UTC()
+	created := 0
+	for _, m := range merchants {
+		var exists int
+		err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM merchants WHERE event_id=? AND id=?
+		`, eventID, m.ID).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO merchants (event_id, id, public_key, alias, enabled, last_polled_at, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, NULL, ?, ?)
+			ON CONFLICT(event_id, id) DO UPDATE SET
+				public_key=excluded.public_key,
+				alias=excluded.alias,
+				enabled=excluded.enabled,
+				updated_at=excluded.updated_at
+		`, eventID, m.ID, m.PublicKey, m.Alias, boolToInt(m.Enabled), now, now); err != nil {
+			return 0, fmt.Errorf("import merchant %s: %w", m.ID, err)
+		}
+		if exists == 0 {
+			created++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	s.touch()
+	return created, nil
 }
 
 // UpdateMerchant updates fields for the merchant.
@@ -303,20 +440,21 @@ func (s *Store) UpdateMerchant(ctx context.Context, m Merchant) error {
 	res, err := s.db.ExecContext(ctx, `
 		UPDATE merchants
 		SET public_key=?, alias=?, enabled=?, updated_at=?
-		WHERE id=?
-	`, m.PublicKey, m.Alias, boolToInt(m.Enabled), m.UpdatedAt, m.ID)
+		WHERE event_id=? AND id=?
+	`, m.PublicKey, m.Alias, boolToInt(m.Enabled), m.UpdatedAt, s.EventID(), m.ID)
 	if err != nil {
 		return err
 	}
 	if rows, _ := res.RowsAffected(); rows == 0 {
 		return sql.ErrNoRows
 	}
+	s.touch()
 	return nil
 }
 
 // DeleteMerchant removes a merchant and all associated transactions and products.
 func (s *Store) DeleteMerchant(ctx context.Context, id string) error {
-	res, err := s.db.ExecContext(ctx, `DELETE FROM merchants WHERE id=?`, id)
+	res, err := s.db.ExecContext(ctx, `DELETE FROM merchants WHERE event_id=? AND id=?`, s.EventID(), id)
 	if err != nil {
 		return err
 	}
@@ -325,14 +463,17 @@ func (s *Store) DeleteMerchant(ctx context.Context, id string) error {
 	}
 	// Associated transactions and products will be cascade deleted if foreign keys are set
 	// Otherwise, we should delete them explicitly here
+	s.touch()
 	return nil
 }
 
-// UpdateMerchantPollTime stores the last poll timestamp.
+// UpdateMerchantPollTime stores the last poll timestamp. It does not bump the
+// data version: no public response depends on poll times, and bumping on every
+// poll would defeat response caching.
 func (s *Store) UpdateMerchantPollTime(ctx context.Context, merchantID string, ts time.Time) error {
 	_, err := s.db.ExecContext(ctx, `
-		UPDATE merchants SET last_polled_at=?, updated_at=? WHERE id=?
-	`, ts, ts, merchantID)
+		UPDATE merchants SET last_polled_at=?, updated_at=? WHERE event_id=? AND id=?
+	`, ts, ts, s.EventID(), merchantID)
 	return err
 }
 
@@ -343,8 +484,8 @@ func (s *Store) GetMerchant(ctx context.Context, id string) (Merchant, error) {
 	var enabled int
 	err := s.db.QueryRowContext(ctx, `
 		SELECT id, public_key, alias, enabled, last_polled_at, created_at, updated_at
-		FROM merchants WHERE id=?
-	`, id).Scan(&m.ID, &m.PublicKey, &m.Alias, &enabled, &last, &m.CreatedAt, &m.UpdatedAt)
+		FROM merchants WHERE event_id=? AND id=?
+	`, s.EventID(), id).Scan(&m.ID, &m.PublicKey, &m.Alias, &enabled, &last, &m.CreatedAt, &m.UpdatedAt)
 	if err != nil {
 		return m, err
 	}
@@ -361,12 +502,13 @@ func (s *Store) ListMerchants(ctx context.Context, onlyEnabled bool) ([]Merchant
 	query := `
 		SELECT id, public_key, alias, enabled, last_polled_at, created_at, updated_at
 		FROM merchants
+		WHERE event_id=?
 	`
 	if onlyEnabled {
-		query += ` WHERE enabled=1`
+		query += ` AND enabled=1`
 	}
 	query += ` ORDER BY alias`
-	rows, err := s.db.QueryContext(ctx, query)
+	rows, err := s.db.QueryContext(ctx, query, s.EventID())
 	if err != nil {
 		return nil, err
 	}
@@ -399,9 +541,10 @@ func (s *Store) RecordTransactions(ctx context.Context, merchantID string, txns
 		return 0, err
 	}
 	stmt, err := tx.PrepareContext(ctx, `
-		INSERT INTO transactions (merchant_id, sale_id, sale_origin, sale_date, amount_sats, source, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(merchant_id, sale_id) DO NOTHING
+		INSERT INTO transactions (event_id, merchant_id, sale_id, external_id, sale_origin, sale_date,
+			amount_sats, source, wifi_tier_id, payment_hash, memo, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(event_id, source, external_id) DO NOTHING
 	`)
 	if err != nil {
 		tx.Rollback()
@@ -411,8 +554,22 @@ func (s *Store) RecordTransactions(ctx context.Context, merchantID string, txns
 
 	var inserted int64
 	now := time.Now().UTC()
+	eventID := s.EventID()
 	for _, t := range txns {
-		res, err := stmt.ExecContext(ctx, merchantID, t.SaleID, t.SaleOrigin, t.SaleDate, t.AmountSats, t.Source, now)
+		var saleID sql.NullInt64
+		if t.SaleID != 0 {
+			saleID = sql.NullInt64{Int64: t.SaleID, Valid: true}
+		}
+		externalID := t.ExternalID
+		if externalID == "" {
+			if !saleID.Valid {
+				tx.Rollback()
+				return 0, errors.New("transaction needs a sale id or external id")
+			}
+			externalID = fmt.Sprintf("%s:%d", merchantID, t.SaleID)
+		}
+		res, err := stmt.ExecContext(ctx, eventID, merchantID, saleID, externalID, t.SaleOrigin, t.SaleDate,
+			t.AmountSats, t.Source, t.WifiTierID, nullString(t.PaymentHash), nullString(t.Memo), now)
 		if err != nil {
 			tx.Rollback()
 			return 0, err
@@ -424,6 +581,9 @@ func (s *Store) RecordTransactions(ctx context.Context, merchantID string, txns
 	if err := tx.Commit(); err != nil {
 		return 0, err
 	}
+	if inserted > 0 {
+		s.touch()
+	}
 	return inserted, nil
 }
 
@@ -437,9 +597,9 @@ func (s *Store) UpsertProducts(ctx context.Context, merchantID string, products
 		return err
 	}
 	stmt, err := tx.PrepareContext(ctx, `
-		INSERT INTO products (merchant_id, product_id, name, currency, price, total_transactions, total_revenue_sats, active, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(merchant_id, product_id) DO UPDATE SET
+		INSERT INTO products (event_id, merchant_id, product_id, name, currency, price, total_transactions, total_revenue_sats, active, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(event_id, merchant_id, product_id) DO UPDATE SET
 			name=excluded.name,
 			currency=excluded.currency,
 			price=excluded.price,
@@ -447,6 +607,12 @@ func (s *Store) UpsertProducts(ctx context.Context, merchantID string, products
 			total_revenue_sats=excluded.total_revenue_sats,
 			active=excluded.active,
 			updated_at=excluded.updated_at
+		WHERE name IS NOT excluded.name
+			OR currency IS NOT excluded.currency
+			OR price IS NOT excluded.price
+			OR total_transactions IS NOT excluded.total_transactions
+			OR total_revenue_sats IS NOT excluded.total_revenue_sats
+			OR active IS NOT excluded.active
 	`)
 	if err != nil {
 		tx.Rollback()
@@ -454,9 +620,12 @@ func (s *Store) UpsertProducts(ctx context.Context, merchantID string, products
 	}
 	defer stmt.Close()
 
+	var changed int64
 	now := time.Now().UTC()
+	eventID := s.EventID()
 	for _, p := range products {
-		if _, err := stmt.ExecContext(ctx,
+		res, err := stmt.ExecContext(ctx,
+			eventID,
 			merchantID,
 			p.ProductID,
 			p.Name,
@@ -466,12 +635,24 @@ func (s *Store) UpsertProducts(ctx context.Context, merchantID string, products
 			p.TotalRevenueSats,
 			boolToInt(p.Active),
 			now,
-		); err != nil {
+		)
+		if err != nil {
 			tx.Rollback()
 			return err
 		}
+		if rows, _ := res.RowsAffected(); rows > 0 {
+			changed += rows
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Unchanged snapshots are skipped by the upsert so that repeated polls
+	// do not invalidate cached responses.
+	if changed > 0 {
+		s.touch()
 	}
-	return tx.Commit()
+	return nil
 }
 
 // Summary returns aggregate dashboard metrics.
@@ -483,16 +664,16 @@ func (s *Store) Summary(ctx context.Context, rateWindow time.Duration) (Summary,
 	start := time.Now().UTC().Add(-rateWindow)
 	query := `
 		SELECT
-			(SELECT COUNT(*) FROM transactions) AS total_tx,
-			(SELECT COALESCE(SUM(amount_sats), 0) FROM transactions) AS total_vol,
-			(SELECT COUNT(*) FROM merchants WHERE enabled=1) AS active_merchants,
-			(SELECT COUNT(*) FROM merchants) AS total_merchants,
-			(SELECT COUNT(*) FROM products WHERE active=1) AS unique_products,
-			(SELECT COUNT(*) FROM transactions WHERE sale_date >= ?) AS window_tx,
-			(SELECT COALESCE(SUM(amount_sats), 0) FROM transactions WHERE sale_date >= ?) AS window_vol
+			(SELECT COUNT(*) FROM transactions WHERE event_id=?1) AS total_tx,
+			(SELECT COALESCE(SUM(amount_sats), 0) FROM transactions WHERE event_id=?1) AS total_vol,
+			(SELECT COUNT(*) FROM merchants WHERE event_id=?1 AND enabled=1) AS active_merchants,
+			(SELECT COUNT(*) FROM merchants WHERE event_id=?1) AS total_merchants,
+			(SELECT COUNT(*) FROM products WHERE event_id=?1 AND active=1) AS unique_products,
+			(SELECT COUNT(*) FROM transactions WHERE event_id=?1 AND sale_date >= ?2) AS window_tx,
+			(SELECT COALESCE(SUM(amount_sats), 0) FROM transactions WHERE event_id=?1 AND sale_date >= ?2) AS window_vol
 	`
 
-	err := s.db.QueryRowContext(ctx, query, start, start).Scan(
+	err := s.db.QueryRowContext(ctx, query, s.EventID(), start).Scan(
 		&out.TotalTransactions,
 		&out.TotalVolumeSats,
 		&out.ActiveMerchants,
@@ -526,11 +707,12 @@ func (s *Store) SummaryBySource(ctx context.Context, rateWindow time.Duration, s
 	var out Summary
 	var windowCount, windowVolume int64
 
-	// Build WHERE clause for source filtering
-	whereClause := ""
+	// Build WHERE clause for event and source filtering
+	eventID := s.EventID()
+	whereClause := " WHERE event_id = ?"
 	var sourceValue string
 	if source != "" && source != "all" {
-		whereClause = " WHERE source = ?"
+		whereClause += " AND source = ?"
 		sourceValue = source
 	}
 
@@ -540,29 +722,27 @@ func (s *Store) SummaryBySource(ctx context.Context, rateWindow time.Duration, s
 		SELECT
 			(SELECT COUNT(*) FROM transactions` + whereClause + `) AS total_tx,
 			(SELECT COALESCE(SUM(amount_sats), 0) FROM transactions` + whereClause + `) AS total_vol,
-			(SELECT COUNT(*) FROM merchants WHERE enabled=1) AS active_merchants,
-			(SELECT COUNT(*) FROM merchants) AS total_merchants,
-			(SELECT COUNT(*) FROM products WHERE active=1) AS unique_products,
+			(SELECT COUNT(*) FROM merchants WHERE event_id = ? AND enabled=1) AS active_merchants,
+			(SELECT COUNT(*) FROM merchants WHERE event_id = ?) AS total_merchants,
+			(SELECT COUNT(*) FROM products WHERE event_id = ? AND active=1) AS unique_products,
 			? AS window_tx_placeholder,
 			? AS window_vol_placeholder
 	`
 
 	// Build window queries separately
-	windowCondition := " WHERE sale_date >= ?"
-	if whereClause != "" {
-		windowCondition = whereClause + " AND sale_date >= ?"
-	}
+	windowCondition := whereClause + " AND sale_date >= ?"
 	windowTxQuery := "SELECT COUNT(*) FROM transactions" + windowCondition
 	windowVolQuery := "SELECT COALESCE(SUM(amount_sats), 0) FROM transactions" + windowCondition
 
 	// Execute main query
 	args := []any{}
-	if whereClause != "" {
+	if sourceValue != "" {
 		// Need source filter for each subquery
-		args = append(args, sourceValue, sourceValue, 0, 0) // placeholders for window queries
+		args = append(args, eventID, sourceValue, eventID, sourceValue)
 	} else {
-		args = append(args, 0, 0) // placeholders
+		args = append(args, eventID, eventID)
 	}
+	args = append(args, eventID, eventID, eventID, 0, 0) // merchant/product counts, then placeholders for window queries
 
 	var totalTx, totalVol, activeMerchants, totalMerchants, uniqueProducts int64
 	err := s.db.QueryRowContext(ctx, query, args...).Scan(
@@ -579,8 +759,8 @@ func (s *Store) SummaryBySource(ctx context.Context, rateWindow time.Duration, s
 	}
 
 	// Execute window queries
-	windowArgs := []any{}
-	if whereClause != "" {
+	windowArgs := []any{eventID}
+	if sourceValue != "" {
 		windowArgs = append(windowArgs, sourceValue, start)
 	} else {
 		windowArgs = append(windowArgs, start)
@@ -617,20 +797,20 @@ func (s *Store) SummaryBySource(ctx context.Context, rateWindow time.Duration, s
 // LatestTransactions returns the latest N ticker rows, optionally filtered by source.
 // source can be "pwf", "wifi", or empty/"all" for all sources.
 func (s *Store) LatestTransactions(ctx context.Context, limit int, source string) ([]TickerEntry, error) {
-	whereClause := ""
-	args := []any{}
+	whereClause := "WHERE t.event_id = ?"
+	args := []any{s.EventID()}
 
 	if source != "" && source != "all" {
-		whereClause = "WHERE t.source = ?"
+		whereClause += " AND t.source = ?"
 		args = append(args, source)
 	}
 
 	args = append(args, limit)
 
 	query := `
-		SELECT t.sale_id, t.merchant_id, m.alias, t.amount_sats, t.sale_date
+		SELECT t.id, t.sale_id, t.merchant_id, m.alias, t.amount_sats, t.sale_date
 		FROM transactions t
-		JOIN merchants m ON m.id = t.merchant_id
+		JOIN merchants m ON m.event_id = t.event_id AND m.id = t.merchant_id
 		` + whereClause + `
 		ORDER BY t.sale_date DESC
 		LIMIT ?
@@ -645,7 +825,7 @@ func (s *Store) LatestTransactions(ctx context.Context, limit int, source string
 	out := make([]TickerEntry, 0)
 	for rows.Next() {
 		var entry TickerEntry
-		if err := rows.Scan(&entry.SaleID, &entry.MerchantID, &entry.MerchantAlias, &entry.AmountSats, &entry.SaleDate); err != nil {
+		if err := rows.Scan(&entry.ID, &entry.SaleID, &entry.MerchantID, &entry.MerchantAlias, &entry.AmountSats, &entry.SaleDate); err != nil {
 			return nil, err
 		}
 		out = append(out, entry)
@@ -658,15 +838,16 @@ func (s *Store) MerchantLeaderboard(ctx context.Context, window time.Duration, m
 	if limit <= 0 {
 		return nil, fmt.Errorf("limit must be > 0")
 	}
-	args := []any{}
+	args := []any{s.EventID()}
 	query := `
 		SELECT t.merchant_id, m.alias, COUNT(t.id) AS tx_count, COALESCE(SUM(t.amount_sats),0) AS volume
 		FROM transactions t
-		JOIN merchants m ON m.id = t.merchant_id
+		JOIN merchants m ON m.event_id = t.event_id AND m.id = t.merchant_id
+		WHERE t.event_id = ?
 	`
 	if window > 0 {
 		start := time.Now().UTC().Add(-window)
-		query += ` WHERE t.sale_date >= ?`
+		query += ` AND t.sale_date >= ?`
 		args = append(args, start)
 	}
 	if strings.ToLower(metric) == "volume" {
@@ -703,7 +884,7 @@ func (s *Store) ProductLeaderboard(ctx context.Context, metric string, limit int
 		query = `
 			SELECT p.merchant_id, p.product_id, p.name, p.total_transactions, p.total_revenue_sats
 			FROM products p
-			WHERE p.active=1
+			WHERE p.event_id=? AND p.active=1
 			ORDER BY total_revenue_sats DESC, p.name ASC
 			LIMIT ?
 		`
@@ -711,12 +892,12 @@ func (s *Store) ProductLeaderboard(ctx context.Context, metric string, limit int
 		query = `
 			SELECT p.merchant_id, p.product_id, p.name, p.total_transactions, p.total_revenue_sats
 			FROM products p
-			WHERE p.active=1
+			WHERE p.event_id=? AND p.active=1
 			ORDER BY total_transactions DESC, p.name ASC
 			LIMIT ?
 		`
 	}
-	rows, err := s.db.QueryContext(ctx, query, limit)
+	rows, err := s.db.QueryContext(ctx, query, s.EventID(), limit)
 	if err != nil {
 		return nil, err
 	}
@@ -733,136 +914,275 @@ func (s *Store) ProductLeaderboard(ctx context.Context, metric string, limit int
 	return out, rows.Err()
 }
 
-// ListMilestones returns all milestone configs.
-func (s *Store) ListMilestones(ctx context.Context) ([]Milestone, error) {
-	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, name, type, threshold, enabled, triggered_at, created_at, updated_at
-		FROM milestones
-		ORDER BY threshold ASC
-	`)
+// ListMilestones returns the milestone configs, leaving out archived ones
+// unless includeArchived is set.
+func (s *Store) ListMilestones(ctx context.Context, includeArchived bool) ([]Milestone, error) {
+	query := `SELECT ` + milestoneColumns + ` FROM milestones WHERE event_id=?`
+	if !includeArchived {
+		query += ` AND archived_at IS NULL`
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY threshold ASC`, s.EventID())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	out := make([]Milestone, 0)
 	for rows.Next() {
-		var m Milestone
-		var triggeredAt sql.NullTime
-		if err := rows.Scan(&m.ID, &m.Name, &m.Type, &m.Threshold, &m.Enabled, &triggeredAt, &m.CreatedAt, &m.UpdatedAt); err != nil {
+		m, err := scanMilestone(rows)
+		if err != nil {
 			return nil, err
 		}
-		m.Type = MilestoneType(strings.ToLower(string(m.Type)))
-		m.Triggered = triggeredAt.Valid
-		if triggeredAt.Valid {
-			t := triggeredAt.Time
-			m.TriggeredAt = &t
-		}
 		out = append(out, m)
 	}
 	return out, rows.Err()
 }
 
 // UpsertMilestone inserts a new milestone row.
+// Recurring milestones start after the crossings the current value has
+// already passed.
 func (s *Store) UpsertMilestone(ctx context.Context, m Milestone) (Milestone, error) {
-	if err := validateMilestoneType(m.Type); err != nil {
+	if err := validateMilestone(m); err != nil {
+		return m, err
+	}
+	if err := s.validateCelebration(ctx, m.Celebration); err != nil {
 		return m, err
 	}
 	m.Type = MilestoneType(strings.ToLower(string(m.Type)))
+	if m.Type == MilestoneExpression {
+		m.Threshold = 1
+	}
+	params, err := json.Marshal(m.Params)
+	if err != nil {
+		return m, err
+	}
+	celebration, err := json.Marshal(m.Celebration)
+	if err != nil {
+		return m, err
+	}
+	m.LastOrdinal = 0
+	if m.Recurring {
+		if m.LastOrdinal, err = s.milestoneBaseline(ctx, m); err != nil {
+			return m, err
+		}
+	}
 	now := time.Now().UTC()
 	m.CreatedAt = now
 	m.UpdatedAt = now
+	var triggeredAt *time.Time
+	if m.Recurring && m.finished() {
+		triggeredAt = &now
+	}
 	res, err := s.db.ExecContext(ctx, `
-		INSERT INTO milestones (name, type, threshold, enabled, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, m.Name, string(m.Type), m.Threshold, boolToInt(m.Enabled), m.CreatedAt, m.UpdatedAt)
+		INSERT INTO milestones (event_id, name, type, params, threshold, recurring, max_ordinal, last_ordinal,
+			active_from, active_until, measure_from, measure_until, celebration, enabled, triggered_at, created_at,
+			updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.EventID(), m.Name, string(m.Type), string(params), m.Threshold, boolToInt(m.Recurring), m.MaxOrdinal,
+		m.LastOrdinal, nullTime(m.ActiveFrom), nullTime(m.ActiveUntil), nullTime(m.MeasureFrom), nullTime(m.MeasureUntil),
+		string(celebration), boolToInt(m.Enabled), nullTime(triggeredAt), m.CreatedAt, m.UpdatedAt)
 	if err != nil {
 		return m, err
 	}
 	m.ID, _ = res.LastInsertId()
+	m.Triggered = triggeredAt != nil
+	m.TriggeredAt = triggeredAt
+	s.touch()
 	return m, nil
 }
 
 // UpdateMilestone updates fields and optionally resets the trigger state.
+// A recurring series starts over from the current value when it is reset or
+// what it measures changes; otherwise it carries on, and raising max_ordinal
+// resumes a finished series.
 func (s *Store) UpdateMilestone(ctx context.Context, id int64, update Milestone, reset bool) (Milestone, error) {
-	if err := validateMilestoneType(update.Type); err != nil {
+	if err := validateMilestone(update); err != nil {
+		return update, err
+	}
+	if err := s.validateCelebration(ctx, update.Celebration); err != nil {
 		return update, err
 	}
 	update.Type = MilestoneType(strings.ToLower(string(update.Type)))
+	if update.Type == MilestoneExpression {
+		update.Threshold = 1
+	}
+	params, err := json.Marshal(update.Params)
+	if err != nil {
+		return update, err
+	}
+	celebration, err := json.Marshal(update.Celebration)
+	if err != nil {
+		return update, err
+	}
+	current, err := s.GetMilestone(ctx, id)
+	if err != nil {
+		return update, err
+	}
 	update.UpdatedAt = time.Now().UTC()
-	resetClause := "triggered_at = triggered_at"
+	update.TriggeredAt = current.TriggeredAt
 	if reset {
-		resetClause = "triggered_at = NULL"
+		update.TriggeredAt = nil
+	}
+	update.LastOrdinal = 0
+	if update.Recurring {
+		update.LastOrdinal = current.LastOrdinal
+		if reset || !current.Recurring || current.Threshold != update.Threshold || current.Type != update.Type ||
+			current.Params != update.Params || !current.sameMeasureWindow(update) {
+			if update.LastOrdinal, err = s.milestoneBaseline(ctx, update); err != nil {
+				return update, err
+			}
+		}
+		switch {
+		case !update.finished():
+			update.TriggeredAt = nil
+		case update.TriggeredAt == nil:
+			update.TriggeredAt = &update.UpdatedAt
+		}
 	}
 	res, err := s.db.ExecContext(ctx, `
 		UPDATE milestones
-		SET name=?, type=?, threshold=?, enabled=?, updated_at=?, `+resetClause+`
-		WHERE id=?
-	`, update.Name, string(update.Type), update.Threshold, boolToInt(update.Enabled), update.UpdatedAt, id)
+		SET name=?, type=?, params=?, threshold=?, recurring=?, max_ordinal=?, last_ordinal=?, active_from=?,
+			active_until=?, measure_from=?, measure_until=?, celebration=?, enabled=?, triggered_at=?, updated_at=?
+		WHERE event_id=? AND id=?
+	`, update.Name, string(update.Type), string(params), update.Threshold, boolToInt(update.Recurring), update.MaxOrdinal,
+		update.LastOrdinal, nullTime(update.ActiveFrom), nullTime(update.ActiveUntil), nullTime(update.MeasureFrom),
+		nullTime(update.MeasureUntil), string(celebration), boolToInt(update.Enabled), nullTime(update.TriggeredAt),
+		update.UpdatedAt, s.EventID(), id)
 	if err != nil {
 		return update, err
 	}
 	if rows, _ := res.RowsAffected(); rows == 0 {
 		return update, sql.ErrNoRows
 	}
+	s.touch()
 	return s.GetMilestone(ctx, id)
 }
 
 // GetMilestone fetches a milestone.
 func (s *Store) GetMilestone(ctx context.Context, id int64) (Milestone, error) {
-	var m Milestone
-	var triggeredAt sql.NullTime
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, name, type, threshold, enabled, triggered_at, created_at, updated_at
+	return scanMilestone(s.db.QueryRowContext(ctx, `
+		SELECT `+milestoneColumns+`
 		FROM milestones
-		WHERE id=?
-	`, id).Scan(&m.ID, &m.Name, &m.Type, &m.Threshold, &m.Enabled, &triggeredAt, &m.CreatedAt, &m.UpdatedAt)
+		WHERE event_id=? AND id=?
+	`, s.EventID(), id))
+}
+
+const milestoneColumns = `id, name, type, params, threshold, recurring, max_ordinal, last_ordinal, active_from,
+	active_until, measure_from, measure_until, celebration, enabled, triggered_at, archived_at, created_at, updated_at`
+
+func scanMilestone(row interface{ Scan(...any) error }) (Milestone, error) {
+	var m Milestone
+	var params, celebration string
+	var activeFrom, activeUntil, measureFrom, measureUntil, triggeredAt, archivedAt sql.NullTime
+	err := row.Scan(&m.ID, &m.Name, &m.Type, &params, &m.Threshold, &m.Recurring, &m.MaxOrdinal, &m.LastOrdinal,
+		&activeFrom, &activeUntil, &measureFrom, &measureUntil, &celebration, &m.Enabled, &triggeredAt, &archivedAt,
+		&m.CreatedAt, &m.UpdatedAt)
 	if err != nil {
 		return m, err
 	}
-	m.Triggered = triggeredAt.Valid
+	m.ActiveFrom, m.ActiveUntil = timeOrNil(activeFrom), timeOrNil(activeUntil)
+	m.MeasureFrom, m.MeasureUntil = timeOrNil(measureFrom), timeOrNil(measureUntil)
+	if err := json.Unmarshal([]byte(params), &m.Params); err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal([]byte(celebration), &m.Celebration); err != nil {
+		return m, err
+	}
 	m.Type = MilestoneType(strings.ToLower(string(m.Type)))
+	m.Triggered = triggeredAt.Valid
 	if triggeredAt.Valid {
 		t := triggeredAt.Time
 		m.TriggeredAt = &t
 	}
+	m.Archived = archivedAt.Valid
+	if archivedAt.Valid {
+		t := archivedAt.Time
+		m.ArchivedAt = &t
+	}
 	return m, nil
 }
 
-// ProcessMilestones checks thresholds and records triggers once.
+// ProcessMilestones checks thresholds and records triggers once. Each
+// trigger is attributed to the transaction that crossed the threshold in
+// sale order, however many milestones one batch crossed. Passes run one at
+// a time, so concurrent ingestion can't record a crossing twice.
 func (s *Store) ProcessMilestones(ctx context.Context) ([]MilestoneTrigger, error) {
+	s.milestones.Lock()
+	defer s.milestones.Unlock()
 	totalTx, totalVol, err := s.currentTotals(ctx)
 	if err != nil {
 		return nil, err
 	}
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, name, type, threshold
+		SELECT `+milestoneColumns+`
 		FROM milestones
-		WHERE enabled=1 AND triggered_at IS NULL
-	`)
+		WHERE event_id=? AND enabled=1 AND triggered_at IS NULL AND archived_at IS NULL
+	`, s.EventID())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	type candidate struct {
-		id        int64
-		name      string
-		typ       string
-		threshold int64
-	}
-	var candidates []candidate
+	var candidates []Milestone
 	for rows.Next() {
-		var c candidate
-		if err := rows.Scan(&c.id, &c.name, &c.typ, &c.threshold); err != nil {
+		m, err := scanMilestone(rows)
+		if err != nil {
 			return nil, err
 		}
-		candidates = append(candidates, c)
+		candidates = append(candidates, m)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	if len(candidates) == 0 {
+	// Measure before opening the transaction, which holds the only connection
+	now := time.Now().UTC()
+	type reachedMilestone struct {
+		Milestone
+		crossings []milestoneCrossing
+		triggers  []MilestoneTrigger // one per crossing, attributed
+	}
+	var reached []reachedMilestone
+	for _, m := range candidates {
+		if validateMilestone(m) != nil {
+			continue // written before its type was checked
+		}
+		if !m.ActiveAt(now) {
+			continue
+		}
+		value, err := s.milestoneValue(ctx, m, time.Time{})
+		if err != nil {
+			return nil, fmt.Errorf("milestone %d: %w", m.ID, err)
+		}
+		crossings := m.crossings(value)
+		if len(crossings) == 0 {
+			continue
+		}
+		r := reachedMilestone{Milestone: m, crossings: crossings}
+		for _, crossing := range crossings {
+			tr := MilestoneTrigger{
+				MilestoneID:       m.ID,
+				Name:              m.Name,
+				Type:              string(m.Type),
+				Threshold:         crossing.threshold,
+				Ordinal:           crossing.ordinal,
+				TriggeredAt:       now,
+				TotalTransactions: totalTx,
+				TotalVolumeSats:   totalVol,
+				Celebration:       m.Celebration,
+			}
+			if tr.Transaction, err = s.crossingTransaction(ctx, m, crossing.threshold, now); err != nil {
+				return nil, fmt.Errorf("milestone %d: %w", m.ID, err)
+			}
+			if tr.Transaction != nil {
+				if tr.TotalTransactions, tr.TotalVolumeSats, err = s.totalsThrough(ctx, tr.Transaction.ID); err != nil {
+					return nil, fmt.Errorf("milestone %d: %w", m.ID, err)
+				}
+			}
+			r.triggers = append(r.triggers, tr)
+		}
+		reached = append(reached, r)
+	}
+	if len(reached) == 0 {
 		return nil, nil
 	}
 
@@ -873,66 +1193,87 @@ func (s *Store) ProcessMilestones(ctx context.Context) ([]MilestoneTrigger, erro
 	defer tx.Rollback()
 
 	var triggered []MilestoneTrigger
-	now := time.Now().UTC()
-	for _, c := range candidates {
-		var condition bool
-		switch MilestoneType(c.typ) {
-		case MilestoneTransactions:
-			condition = totalTx >= c.threshold
-		case MilestoneVolume:
-			condition = totalVol >= c.threshold
-		default:
-			continue
-		}
-		if !condition {
-			continue
-		}
-		if _, err := tx.ExecContext(ctx, `
-			UPDATE milestones SET triggered_at=? WHERE id=? AND triggered_at IS NULL
-		`, now, c.id); err != nil {
-			return nil, err
+	for _, c := range reached {
+		// Guard on the state that was measured, so a concurrent pass that
+		// got there first doesn't record the same crossings again
+		var res sql.Result
+		if c.Recurring {
+			measured := c.LastOrdinal
+			c.LastOrdinal = c.crossings[len(c.crossings)-1].ordinal
+			var finishedAt *time.Time
+			if c.finished() {
+				finishedAt = &now
+			}
+			res, err = tx.ExecContext(ctx, `
+				UPDATE milestones SET last_ordinal=?, triggered_at=? WHERE id=? AND last_ordinal=? AND triggered_at IS NULL
+			`, c.LastOrdinal, nullTime(finishedAt), c.ID, measured)
+		} else {
+			res, err = tx.ExecContext(ctx, `
+				UPDATE milestones SET triggered_at=? WHERE id=? AND triggered_at IS NULL
+			`, now, c.ID)
 		}
-		res, err := tx.ExecContext(ctx, `
-			INSERT INTO milestone_triggers (milestone_id, name, type, threshold, triggered_at, total_transactions, total_volume_sats)
-			VALUES (?, ?, ?, ?, ?, ?, ?)
-		`, c.id, c.name, c.typ, c.threshold, now, totalTx, totalVol)
 		if err != nil {
 			return nil, err
 		}
-		triggerID, _ := res.LastInsertId()
-		triggered = append(triggered, MilestoneTrigger{
-			ID:                triggerID,
-			MilestoneID:       c.id,
-			Name:              c.name,
-			Type:              c.typ,
-			Threshold:         c.threshold,
-			TriggeredAt:       now,
-			TotalTransactions: totalTx,
-			TotalVolumeSats:   totalVol,
-		})
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			continue
+		}
+		for _, tr := range c.triggers {
+			var txID, saleID sql.NullInt64
+			var merchantID, alias string
+			var amount int64
+			var saleDate *time.Time
+			if e := tr.Transaction; e != nil {
+				txID = sql.NullInt64{Int64: e.ID, Valid: true}
+				if e.SaleID != nil {
+					saleID = sql.NullInt64{Int64: *e.SaleID, Valid: true}
+				}
+				merchantID, alias, amount, saleDate = e.MerchantID, e.MerchantAlias, e.AmountSats, &e.SaleDate
+			}
+			celebration, err := json.Marshal(tr.Celebration)
+			if err != nil {
+				return nil, err
+			}
+			res, err := tx.ExecContext(ctx, `
+				INSERT INTO milestone_triggers (event_id, milestone_id, name, type, threshold, ordinal, triggered_at,
+					total_transactions, total_volume_sats, transaction_id, sale_id, merchant_id, merchant_alias,
+					amount_sats, sale_date, celebration)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, s.EventID(), tr.MilestoneID, tr.Name, tr.Type, tr.Threshold, tr.Ordinal, tr.TriggeredAt,
+				tr.TotalTransactions, tr.TotalVolumeSats, txID, saleID, merchantID, alias, amount, nullTime(saleDate),
+				string(celebration))
+			if err != nil {
+				return nil, err
+			}
+			tr.ID, _ = res.LastInsertId()
+			triggered = append(triggered, tr)
+		}
 	}
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
+	if len(triggered) > 0 {
+		s.touch()
+	}
 	return triggered, nil
 }
 
 // MilestoneTriggersSince returns triggers since a timestamp.
 func (s *Store) MilestoneTriggersSince(ctx context.Context, since time.Time) ([]MilestoneTrigger, error) {
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, milestone_id, name, type, threshold, triggered_at, total_transactions, total_volume_sats
+		SELECT `+triggerColumns+`
 		FROM milestone_triggers
-		WHERE triggered_at >= ?
-		ORDER BY triggered_at DESC
-	`, since)
+		WHERE event_id = ? AND triggered_at >= ?
+		ORDER BY triggered_at DESC, id DESC
+	`, s.EventID(), since)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	out := make([]MilestoneTrigger, 0)
 	for rows.Next() {
-		var m MilestoneTrigger
-		if err := rows.Scan(&m.ID, &m.MilestoneID, &m.Name, &m.Type, &m.Threshold, &m.TriggeredAt, &m.TotalTransactions, &m.TotalVolumeSats); err != nil {
+		m, err := scanTrigger(rows)
+		if err != nil {
 			return nil, err
 		}
 		out = append(out, m)
@@ -940,16 +1281,52 @@ func (s *Store) MilestoneTriggersSince(ctx context.Context, since time.Time) ([]
 	return out, rows.Err()
 }
 
+const triggerColumns = `id, milestone_id, name, type, threshold, ordinal, triggered_at, total_transactions,
+	total_volume_sats, transaction_id, sale_id, merchant_id, merchant_alias, amount_sats, sale_date, celebration,
+	refire_of`
+
+func scanTrigger(row interface{ Scan(...any) error }) (MilestoneTrigger, error) {
+	var m MilestoneTrigger
+	var txID sql.NullInt64
+	var e TickerEntry
+	var saleDate sql.NullTime
+	var celebration string
+	var refireOf, milestoneID sql.NullInt64
+	err := row.Scan(&m.ID, &milestoneID, &m.Name, &m.Type, &m.Threshold, &m.Ordinal, &m.TriggeredAt,
+		&m.TotalTransactions, &m.TotalVolumeSats, &txID, &e.SaleID, &e.MerchantID, &e.MerchantAlias, &e.AmountSats, &saleDate,
+		&celebration, &refireOf)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal([]byte(celebration), &m.Celebration); err != nil {
+		return m, err
+	}
+	m.MilestoneID = milestoneID.Int64
+	if refireOf.Valid {
+		m.RefireOf = &refireOf.Int64
+	}
+	if txID.Valid {
+		e.ID = txID.Int64
+		e.SaleDate = saleDate.Time
+		m.Transaction = &e
+	}
+	return m, nil
+}
+
 func (s *Store) currentTotals(ctx context.Context) (int64, int64, error) {
 	var totalTx, totalVol int64
 	if err := s.db.QueryRowContext(ctx, `
-		SELECT COUNT(*), COALESCE(SUM(amount_sats),0) FROM transactions
-	`).Scan(&totalTx, &totalVol); err != nil {
+		SELECT COUNT(*), COALESCE(SUM(amount_sats),0) FROM transactions WHERE event_id=?
+	`, s.EventID()).Scan(&totalTx, &totalVol); err != nil {
 		return 0, 0, err
 	}
 	return totalTx, totalVol, nil
 }
 
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
+
 func boolToInt(v bool) int {
 	if v {
 		return 1
@@ -957,27 +1334,42 @@ func boolToInt(v bool) int {
 	return 0
 }
 
-func validateMilestoneType(mt MilestoneType) error {
-	switch MilestoneType(strings.ToLower(string(mt))) {
-	case MilestoneTransactions, MilestoneVolume:
-		return nil
-	default:
-		return errors.New("invalid milestone type")
+const sceneColumns = `id, name, duration, enabled, scene_order, type, title, body, asset_id, sponsor, schedule,
+	created_at, updated_at`
+
+func scanScene(row interface{ Scan(...any) error }) (Scene, error) {
+	var sc Scene
+	var enabled int
+	var content SlideContent
+	var schedule string
+	err := row.Scan(&sc.ID, &sc.Name, &sc.Duration, &enabled, &sc.Order, &sc.Type, &content.Title, &content.Body,
+		&content.AssetID, &content.Sponsor, &schedule, &sc.CreatedAt, &sc.UpdatedAt)
+	if err != nil {
+		return sc, err
 	}
+	sc.Enabled = enabled != 0
+	if sc.Type == SceneContent {
+		if err := json.Unmarshal([]byte(schedule), &content.Schedule); err != nil {
+			return sc, fmt.Errorf("scene %s: invalid schedule: %w", sc.ID, err)
+		}
+		sc.Content = &content
+	}
+	return sc, nil
 }
 
 // ListScenes returns all scenes ordered by scene_order.
 func (s *Store) ListScenes(ctx context.Context, onlyEnabled bool) ([]Scene, error) {
 	query := `
-		SELECT id, name, duration, enabled, scene_order, created_at, updated_at
+		SELECT ` + sceneColumns + `
 		FROM scenes
+		WHERE event_id=?
 	`
 	if onlyEnabled {
-		query += ` WHERE enabled=1`
+		query += ` AND enabled=1`
 	}
 	query += ` ORDER BY scene_order ASC`
 
-	rows, err := s.db.QueryContext(ctx, query)
+	rows, err := s.db.QueryContext(ctx, query, s.EventID())
 	if err != nil {
 		return nil, err
 	}
@@ -985,12 +1377,10 @@ func (s *Store) ListScenes(ctx context.Context, onlyEnabled bool) ([]Scene, erro
 
 	out := make([]Scene, 0)
 	for rows.Next() {
-		var sc Scene
-		var enabled int
-		if err := rows.Scan(&sc.ID, &sc.Name, &sc.Duration, &enabled, &sc.Order, &sc.CreatedAt, &sc.UpdatedAt); err != nil {
+		sc, err := scanScene(rows)
+		if err != nil {
 			return nil, err
 		}
-		sc.Enabled = enabled != 0
 		out = append(out, sc)
 	}
 	return out, rows.Err()
@@ -998,62 +1388,113 @@ func (s *Store) ListScenes(ctx context.Context, onlyEnabled bool) ([]Scene, erro
 
 // GetScene fetches a scene by ID.
 func (s *Store) GetScene(ctx context.Context, id string) (Scene, error) {
-	var sc Scene
-	var enabled int
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, name, duration, enabled, scene_order, created_at, updated_at
-		FROM scenes WHERE id=?
-	`, id).Scan(&sc.ID, &sc.Name, &sc.Duration, &enabled, &sc.Order, &sc.CreatedAt, &sc.UpdatedAt)
+	return scanScene(s.db.QueryRowContext(ctx, `
+		SELECT `+sceneColumns+`
+		FROM scenes WHERE event_id=? AND id=?
+	`, s.EventID(), id))
+}
+
+// sceneContentArgs returns the content columns of a scene, empty for
+// built-in scenes.
+func sceneContentArgs(sc Scene) ([]any, error) {
+	if sc.Type == "" {
+		sc.Type = SceneBuiltin
+	}
+	content := SlideContent{Schedule: []ScheduleWindow{}}
+	if sc.Content != nil {
+		content = *sc.Content
+	}
+	if content.Schedule == nil {
+		content.Schedule = []ScheduleWindow{}
+	}
+	schedule, err := json.Marshal(content.Schedule)
 	if err != nil {
-		return sc, err
+		return nil, err
 	}
-	sc.Enabled = enabled != 0
-	return sc, nil
+	return []any{sc.Type, content.Title, content.Body, content.AssetID, content.Sponsor, string(schedule)}, nil
 }
 
 // UpsertScene inserts or updates a scene.
 func (s *Store) UpsertScene(ctx context.Context, sc Scene) error {
+	if err := s.validateScene(ctx, sc); err != nil {
+		return err
+	}
+	content, err := sceneContentArgs(sc)
+	if err != nil {
+		return err
+	}
 	now := time.Now().UTC()
 	sc.CreatedAt = now
 	sc.UpdatedAt = now
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO scenes (id, name, duration, enabled, scene_order, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(id) DO UPDATE SET
+	args := append([]any{s.EventID(), sc.ID, sc.Name, sc.Duration, boolToInt(sc.Enabled), sc.Order}, content...)
+	args = append(args, sc.CreatedAt, sc.UpdatedAt)
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO scenes (event_id, id, name, duration, enabled, scene_order, type, title, body, asset_id,
+			sponsor, schedule, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(event_id, id) DO UPDATE SET
 			name=excluded.name,
 			duration=excluded.duration,
 			enabled=excluded.enabled,
 			scene_order=excluded.scene_order,
+			type=excluded.type,
+			title=excluded.title,
+			body=excluded.body,
+			asset_id=excluded.asset_id,
+			sponsor=excluded.sponsor,
+			schedule=excluded.schedule,
 			updated_at=excluded.updated_at
-	`, sc.ID, sc.Name, sc.Duration, boolToInt(sc.Enabled), sc.Order, sc.CreatedAt, sc.UpdatedAt)
-	return err
+	`, args...)
+	if err != nil {
+		return err
+	}
+	s.touch()
+	return nil
 }
 
 // UpdateScene updates a scene.
 func (s *Store) UpdateScene(ctx context.Context, sc Scene) error {
+	if err := s.validateScene(ctx, sc); err != nil {
+		return err
+	}
+	content, err := sceneContentArgs(sc)
+	if err != nil {
+		return err
+	}
 	sc.UpdatedAt = time.Now().UTC()
+	args := append([]any{sc.Name, sc.Duration, boolToInt(sc.Enabled), sc.Order}, content...)
+	args = append(args, sc.UpdatedAt, s.EventID(), sc.ID)
 	res, err := s.db.ExecContext(ctx, `
 		UPDATE scenes
-		SET name=?, duration=?, enabled=?, scene_order=?, updated_at=?
-		WHERE id=?
-	`, sc.Name, sc.Duration, boolToInt(sc.Enabled), sc.Order, sc.UpdatedAt, sc.ID)
+		SET name=?, duration=?, enabled=?, scene_order=?, type=?, title=?, body=?, asset_id=?, sponsor=?,
+			schedule=?, updated_at=?
+		WHERE event_id=? AND id=?
+	`, args...)
 	if err != nil {
 		return err
 	}
 	if rows, _ := res.RowsAffected(); rows == 0 {
 		return sql.ErrNoRows
 	}
+	s.touch()
 	return nil
 }
 
-// DeleteScene removes a scene.
+// DeleteScene removes a scene and drops it from the event's playlists.
 func (s *Store) DeleteScene(ctx context.Context, id string) error {
-	res, err := s.db.ExecContext(ctx, `DELETE FROM scenes WHERE id=?`, id)
+	res, err := s.db.ExecContext(ctx, `DELETE FROM scenes WHERE event_id=? AND id=?`, s.EventID(), id)
 	if err != nil {
 		return err
 	}
 	if rows, _ := res.RowsAffected(); rows == 0 {
 		return sql.ErrNoRows
 	}
+	if _, err := s.db.ExecContext(ctx, `
+		DELETE FROM playlist_entries
+		WHERE scene_id=? AND playlist_id IN (SELECT id FROM playlists WHERE event_id=?)
+	`, id, s.EventID()); err != nil {
+		return err
+	}
+	s.touch()
 	return nil
 }
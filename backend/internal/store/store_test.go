@@ -2,6 +2,13 @@ package store_test
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -107,6 +114,560 @@ func TestMilestonesTriggerOnce(t *testing.T) {
 	}
 }
 
+func TestMilestoneTypes(t *testing.T) {
+	t.Parallel()
+	st := newTestStore(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"bar", "cafe", "idle"} {
+		if err := st.UpsertMerchant(ctx, store.Merchant{ID: id, PublicKey: "pk", Alias: id, Enabled: true}); err != nil {
+			t.Fatalf("upsert merchant: %v", err)
+		}
+	}
+
+	invalid := map[string]store.Milestone{
+		"merchant without id":     {Type: store.MilestoneMerchant, Params: store.MilestoneParams{Metric: store.MetricVolume}},
+		"merchant without metric": {Type: store.MilestoneMerchant, Params: store.MilestoneParams{MerchantID: "bar"}},
+		"bad source":              {Type: store.MilestoneSource, Params: store.MilestoneParams{Source: "Wi Fi", Metric: store.MetricVolume}},
+		"short rate window":       {Type: store.MilestoneRate, Params: store.MilestoneParams{Window: "30s"}},
+		"params on volume":        {Type: store.MilestoneVolume, Params: store.MilestoneParams{Window: "5m"}},
+		"unknown type":            {Type: "weather"},
+	}
+	for name, m := range invalid {
+		m.Name, m.Threshold, m.Enabled = name, 1, true
+		if _, err := st.UpsertMilestone(ctx, m); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	milestones := []store.Milestone{
+		{Name: "Bar 3 sales", Type: store.MilestoneMerchant, Params: store.MilestoneParams{MerchantID: "bar", Metric: store.MetricTransactions}, Threshold: 3},
+		{Name: "Cafe 1000 sats", Type: store.MilestoneMerchant, Params: store.MilestoneParams{MerchantID: "cafe", Metric: store.MetricVolume}, Threshold: 1000},
+		{Name: "2 WiFi upgrades", Type: store.MilestoneSource, Params: store.MilestoneParams{Source: store.SourceWifi, Metric: store.MetricTransactions}, Threshold: 2},
+		{Name: "2 merchants selling", Type: store.MilestoneActiveMerchants, Threshold: 2},
+		{Name: "3 merchants selling", Type: store.MilestoneActiveMerchants, Threshold: 3},
+		{Name: "2 products sold", Type: store.MilestoneUniqueProducts, Threshold: 2},
+		{Name: "1 tx/min", Type: store.MilestoneRate, Params: store.MilestoneParams{Window: "5m"}, Threshold: 1},
+		{Name: "Whale", Type: store.MilestoneSingleTransaction, Threshold: 5000},
+	}
+	for _, m := range milestones {
+		m.Enabled = true
+		if _, err := st.UpsertMilestone(ctx, m); err != nil {
+			t.Fatalf("upsert %s: %v", m.Name, err)
+		}
+	}
+
+	now := time.Now()
+	if _, err := st.RecordTransactions(ctx, "bar", []store.TransactionInput{
+		{SaleID: 1, SaleDate: now.Add(-4 * time.Minute), AmountSats: 100},
+		{SaleID: 2, SaleDate: now.Add(-3 * time.Minute), AmountSats: 200},
+		{SaleID: 3, SaleDate: now.Add(-2 * time.Minute), AmountSats: 4999},
+		{ExternalID: "inv1", SaleDate: now.Add(-time.Minute), AmountSats: 500, Source: store.SourceWifi},
+	}); err != nil {
+		t.Fatalf("record transactions: %v", err)
+	}
+	if _, err := st.RecordTransactions(ctx, "cafe", []store.TransactionInput{
+		{SaleID: 1, SaleDate: now, AmountSats: 900},
+	}); err != nil {
+		t.Fatalf("record transactions: %v", err)
+	}
+	if err := st.UpsertProducts(ctx, "bar", []store.ProductSnapshot{
+		{ProductID: 1, Name: "Beer", TotalTransactions: 3, Active: true},
+		{ProductID: 2, Name: "Wine", TotalTransactions: 0, Active: true},
+	}); err != nil {
+		t.Fatalf("upsert products: %v", err)
+	}
+
+	triggered, err := st.ProcessMilestones(ctx)
+	if err != nil {
+		t.Fatalf("process milestones: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, tr := range triggered {
+		names[tr.Name] = true
+	}
+	// Four bar sales (one of them WiFi), two merchants, one product sold and
+	// five payments in five minutes
+	want := map[string]bool{"Bar 3 sales": true, "2 merchants selling": true, "1 tx/min": true}
+	if len(names) != len(want) {
+		t.Errorf("expected %v to trigger, got %v", want, names)
+	}
+	for name := range want {
+		if !names[name] {
+			t.Errorf("expected %q to trigger, got %v", name, names)
+		}
+	}
+
+	if _, err := st.RecordTransactions(ctx, "cafe", []store.TransactionInput{
+		{SaleID: 2, SaleDate: now, AmountSats: 5000},
+		{ExternalID: "inv2", SaleDate: now, AmountSats: 500, Source: store.SourceWifi},
+	}); err != nil {
+		t.Fatalf("record transactions: %v", err)
+	}
+	if err := st.UpsertProducts(ctx, "cafe", []store.ProductSnapshot{
+		{ProductID: 1, Name: "Coffee", TotalTransactions: 2, Active: true},
+	}); err != nil {
+		t.Fatalf("upsert products: %v", err)
+	}
+	triggered, err = st.ProcessMilestones(ctx)
+	if err != nil {
+		t.Fatalf("process milestones second pass: %v", err)
+	}
+	if len(triggered) != 4 {
+		t.Errorf("expected cafe, WiFi, products and whale milestones, got %+v", triggered)
+	}
+
+	list, err := st.ListMilestones(ctx, false)
+	if err != nil {
+		t.Fatalf("list milestones: %v", err)
+	}
+	for _, m := range list {
+		if m.Name == "Cafe 1000 sats" && (m.Params.MerchantID != "cafe" || m.Params.Metric != store.MetricVolume) {
+			t.Errorf("params not stored: %+v", m)
+		}
+		if m.Name == "3 merchants selling" && m.Triggered {
+			t.Errorf("idle merchant counted as active")
+		}
+	}
+}
+
+func TestRecurringMilestones(t *testing.T) {
+	t.Parallel()
+	st := newTestStore(t)
+	ctx := context.Background()
+
+	if err := st.UpsertMerchant(ctx, store.Merchant{ID: "m1", PublicKey: "pk", Alias: "Merchant", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+	record := func(id int64, sats int64) {
+		t.Helper()
+		if _, err := st.RecordTransactions(ctx, "m1", []store.TransactionInput{{SaleID: id, SaleDate: time.Now(), AmountSats: sats}}); err != nil {
+			t.Fatalf("record transaction: %v", err)
+		}
+	}
+
+	for name, m := range map[string]store.Milestone{
+		"recurring rate":  {Type: store.MilestoneRate, Params: store.MilestoneParams{Window: "5m"}, Threshold: 1, Recurring: true},
+		"zero step":       {Type: store.MilestoneVolume, Recurring: true},
+		"one-shot capped": {Type: store.MilestoneVolume, Threshold: 1000, MaxOrdinal: 3},
+	} {
+		m.Name = name
+		if _, err := st.UpsertMilestone(ctx, m); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// Crossings before the series was created are not replayed
+	record(1, 2500)
+	series, err := st.UpsertMilestone(ctx, store.Milestone{
+		Name: "Every 1000 sats", Type: store.MilestoneVolume, Threshold: 1000, Recurring: true, MaxOrdinal: 5, Enabled: true,
+	})
+	if err != nil {
+		t.Fatalf("upsert milestone: %v", err)
+	}
+	if series.LastOrdinal != 2 || series.Triggered || series.NextThreshold() != 3000 {
+		t.Fatalf("unexpected new series: %+v", series)
+	}
+	if triggered, err := st.ProcessMilestones(ctx); err != nil || len(triggered) != 0 {
+		t.Fatalf("expected no triggers before the next crossing, got %+v (%v)", triggered, err)
+	}
+
+	// One batch crossing several thresholds records each, up to the cap
+	record(2, 3700)
+	triggered, err := st.ProcessMilestones(ctx)
+	if err != nil {
+		t.Fatalf("process milestones: %v", err)
+	}
+	if len(triggered) != 3 {
+		t.Fatalf("expected crossings 3 to 5, got %+v", triggered)
+	}
+	for i, tr := range triggered {
+		if tr.Ordinal != int64(i+3) || tr.Threshold != int64(i+3)*1000 {
+			t.Errorf("trigger %d: unexpected ordinal %d threshold %d", i, tr.Ordinal, tr.Threshold)
+		}
+	}
+	series, _ = st.GetMilestone(ctx, series.ID)
+	if series.LastOrdinal != 5 || !series.Triggered {
+		t.Errorf("expected the series to finish at its cap, got %+v", series)
+	}
+	if triggered, _ := st.ProcessMilestones(ctx); len(triggered) != 0 {
+		t.Errorf("expected a finished series to stay quiet, got %+v", triggered)
+	}
+
+	// Lifting the cap resumes the series where it stopped
+	series.MaxOrdinal = 0
+	series, err = st.UpdateMilestone(ctx, series.ID, series, false)
+	if err != nil {
+		t.Fatalf("update milestone: %v", err)
+	}
+	if series.Triggered || series.LastOrdinal != 5 {
+		t.Fatalf("expected the series to resume, got %+v", series)
+	}
+	triggered, err = st.ProcessMilestones(ctx)
+	if err != nil {
+		t.Fatalf("process milestones: %v", err)
+	}
+	if len(triggered) != 1 || triggered[0].Ordinal != 6 {
+		t.Errorf("expected crossing 6, got %+v", triggered)
+	}
+
+	// Changing the step starts over from the current value
+	series.Threshold = 2500
+	series, err = st.UpdateMilestone(ctx, series.ID, series, false)
+	if err != nil {
+		t.Fatalf("update milestone: %v", err)
+	}
+	if series.LastOrdinal != 2 {
+		t.Errorf("expected the new series to start at 2 x 2500, got %+v", series)
+	}
+
+	triggers, err := st.MilestoneTriggersSince(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("list triggers: %v", err)
+	}
+	if len(triggers) != 4 {
+		t.Errorf("expected 4 persisted triggers, got %d", len(triggers))
+	}
+}
+
+func TestMilestoneCrossings(t *testing.T) {
+	t.Parallel()
+	st := newTestStore(t)
+	ctx := context.Background()
+
+	for _, m := range []store.Merchant{{ID: "bar", Alias: "Satoshi's Bar"}, {ID: "cafe", Alias: "Lightning Cafe"}} {
+		m.PublicKey, m.Enabled = "pk", true
+		if err := st.UpsertMerchant(ctx, m); err != nil {
+			t.Fatalf("upsert merchant: %v", err)
+		}
+	}
+	for _, m := range []store.Milestone{
+		{Name: "3 sales", Type: store.MilestoneTransactions, Threshold: 3},
+		{Name: "1000 sats", Type: store.MilestoneVolume, Threshold: 1000},
+		{Name: "Every 2 sales", Type: store.MilestoneTransactions, Threshold: 2, Recurring: true},
+		{Name: "Cafe 500 sats", Type: store.MilestoneMerchant, Params: store.MilestoneParams{MerchantID: "cafe", Metric: store.MetricVolume}, Threshold: 500},
+		{Name: "Big spender", Type: store.MilestoneSingleTransaction, Threshold: 700},
+		{Name: "2 merchants", Type: store.MilestoneActiveMerchants, Threshold: 2},
+	} {
+		m.Enabled = true
+		if _, err := st.UpsertMilestone(ctx, m); err != nil {
+			t.Fatalf("upsert %s: %v", m.Name, err)
+		}
+	}
+
+	// Both merchants report their batches before any milestone check runs;
+	// in sale order the payments are bar 100, cafe 300, bar 200, cafe 700
+	start := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	if _, err := st.RecordTransactions(ctx, "bar", []store.TransactionInput{
+		{SaleID: 1, SaleDate: start, AmountSats: 100},
+		{SaleID: 2, SaleDate: start.Add(2 * time.Minute), AmountSats: 200},
+	}); err != nil {
+		t.Fatalf("record transactions: %v", err)
+	}
+	if _, err := st.RecordTransactions(ctx, "cafe", []store.TransactionInput{
+		{SaleID: 1, SaleDate: start.Add(time.Minute), AmountSats: 300},
+		{SaleID: 2, SaleDate: start.Add(3 * time.Minute), AmountSats: 700},
+	}); err != nil {
+		t.Fatalf("record transactions: %v", err)
+	}
+
+	// Concurrent passes record each crossing once
+	var mu sync.Mutex
+	var triggered []store.MilestoneTrigger
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := st.ProcessMilestones(ctx)
+			if err != nil {
+				t.Errorf("process milestones: %v", err)
+			}
+			mu.Lock()
+			triggered = append(triggered, got...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(triggered) != 7 {
+		t.Fatalf("expected 7 triggers across passes, got %d: %+v", len(triggered), triggered)
+	}
+
+	type crossing struct {
+		merchant string
+		sats     int64
+		date     time.Time
+		total    int64
+	}
+	want := map[string]crossing{
+		"3 sales#1":       {"Satoshi's Bar", 200, start.Add(2 * time.Minute), 3},
+		"1000 sats#1":     {"Lightning Cafe", 700, start.Add(3 * time.Minute), 4},
+		"Every 2 sales#1": {"Lightning Cafe", 300, start.Add(time.Minute), 2},
+		"Every 2 sales#2": {"Lightning Cafe", 700, start.Add(3 * time.Minute), 4},
+		"Cafe 500 sats#1": {"Lightning Cafe", 700, start.Add(3 * time.Minute), 4},
+		"Big spender#1":   {"Lightning Cafe", 700, start.Add(3 * time.Minute), 4},
+		"2 merchants#1":   {"Lightning Cafe", 300, start.Add(time.Minute), 2},
+	}
+	stored, err := st.MilestoneTriggersSince(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("list triggers: %v", err)
+	}
+	if len(stored) != len(want) {
+		t.Fatalf("expected %d persisted triggers, got %d", len(want), len(stored))
+	}
+	for _, tr := range stored {
+		key := fmt.Sprintf("%s#%d", tr.Name, tr.Ordinal)
+		w, ok := want[key]
+		e := tr.Transaction
+		if !ok || e == nil {
+			t.Errorf("%s: unexpected trigger %+v", key, tr)
+			continue
+		}
+		if e.MerchantAlias != w.merchant || e.AmountSats != w.sats || !e.SaleDate.Equal(w.date) || tr.TotalTransactions != w.total {
+			t.Errorf("%s: crossed at %s %d sats %s (total %d), want %+v", key, e.MerchantAlias, e.AmountSats, e.SaleDate, tr.TotalTransactions, w)
+		}
+	}
+}
+
+func TestTimeBoundedMilestones(t *testing.T) {
+	t.Parallel()
+	st := newTestStore(t)
+	ctx := context.Background()
+	if err := st.UpsertMerchant(ctx, store.Merchant{ID: "merch", PublicKey: "pk", Alias: "Merch booth", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+	// Three sales in the morning, two over lunch
+	now := time.Now().UTC().Truncate(time.Second)
+	morning, lunch := now.Add(-4*time.Hour), now.Add(-time.Hour)
+	var txs []store.TransactionInput
+	for i, at := range []time.Time{morning, morning.Add(time.Minute), morning.Add(2 * time.Minute), lunch, lunch.Add(time.Minute)} {
+		txs = append(txs, store.TransactionInput{SaleID: int64(i + 1), SaleDate: at, AmountSats: 100})
+	}
+	if _, err := st.RecordTransactions(ctx, "merch", txs); err != nil {
+		t.Fatalf("record transactions: %v", err)
+	}
+
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	lunchStart, lunchEnd := at(-90*time.Minute), at(30*time.Minute)
+	for _, m := range []store.Milestone{
+		{Name: "200 sats over lunch", Type: store.MilestoneVolume, Threshold: 200, MeasureFrom: lunchStart, MeasureUntil: lunchEnd, ActiveFrom: lunchStart, ActiveUntil: lunchEnd},
+		{Name: "3 lunch sales", Type: store.MilestoneTransactions, Threshold: 3, MeasureFrom: lunchStart},
+		{Name: "Morning rush", Type: store.MilestoneTransactions, Threshold: 3, MeasureUntil: lunchStart},
+		{Name: "Not yet", Type: store.MilestoneTransactions, Threshold: 1, ActiveFrom: at(time.Hour)},
+		{Name: "Too late", Type: store.MilestoneTransactions, Threshold: 1, ActiveUntil: at(-time.Minute)},
+	} {
+		m.Enabled = true
+		if _, err := st.UpsertMilestone(ctx, m); err != nil {
+			t.Fatalf("upsert %s: %v", m.Name, err)
+		}
+	}
+	triggers, err := st.ProcessMilestones(ctx)
+	if err != nil {
+		t.Fatalf("process milestones: %v", err)
+	}
+	byName := make(map[string]store.MilestoneTrigger)
+	for _, tr := range triggers {
+		byName[tr.Name] = tr
+	}
+	if len(triggers) != 2 {
+		t.Fatalf("expected only the lunch volume and morning milestones to fire, got %+v", triggers)
+	}
+	// Only lunch sales count, so the second one crossed 200 sats
+	if tr, ok := byName["200 sats over lunch"]; !ok || tr.Transaction == nil || !tr.Transaction.SaleDate.Equal(lunch.Add(time.Minute)) {
+		t.Errorf("expected the second lunch sale to cross, got %+v", tr)
+	}
+	if tr, ok := byName["Morning rush"]; !ok || tr.Transaction == nil || !tr.Transaction.SaleDate.Equal(morning.Add(2*time.Minute)) {
+		t.Errorf("expected the third morning sale to cross, got %+v", tr)
+	}
+
+	invalid := map[string]store.Milestone{
+		"inverted active window":  {Name: "x", Type: store.MilestoneVolume, Threshold: 1, ActiveFrom: lunchEnd, ActiveUntil: lunchStart},
+		"inverted measure window": {Name: "x", Type: store.MilestoneVolume, Threshold: 1, MeasureFrom: lunchEnd, MeasureUntil: lunchStart},
+		"windowed catalogue":      {Name: "x", Type: store.MilestoneUniqueProducts, Threshold: 1, MeasureFrom: lunchStart},
+	}
+	for name, m := range invalid {
+		if _, err := st.UpsertMilestone(ctx, m); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestExpressionMilestones(t *testing.T) {
+	t.Parallel()
+	st := newTestStore(t)
+	ctx := context.Background()
+	// Six sales at the bar, five at the cafe, one coffee and two WiFi upgrades
+	sales := map[string]int{"bar": 6, "cafe": 5, "coffee": 1}
+	start := time.Now().UTC().Add(-time.Hour)
+	for id, n := range sales {
+		if err := st.UpsertMerchant(ctx, store.Merchant{ID: id, PublicKey: "pk", Alias: id, Enabled: true}); err != nil {
+			t.Fatalf("upsert merchant: %v", err)
+		}
+		var txs []store.TransactionInput
+		for i := 0; i < n; i++ {
+			txs = append(txs, store.TransactionInput{SaleID: int64(i + 1), SaleDate: start.Add(time.Duration(i) * time.Minute), AmountSats: 100})
+		}
+		if _, err := st.RecordTransactions(ctx, id, txs); err != nil {
+			t.Fatalf("record transactions: %v", err)
+		}
+	}
+	if _, err := st.RecordTransactions(ctx, "coffee", []store.TransactionInput{
+		{ExternalID: "h1", SaleDate: start, AmountSats: 500, Source: store.SourceWifi},
+		{ExternalID: "h2", SaleDate: start, AmountSats: 500, Source: store.SourceWifi},
+	}); err != nil {
+		t.Fatalf("record wifi: %v", err)
+	}
+
+	expression := func(name, condition string) store.Milestone {
+		return store.Milestone{Name: name, Type: store.MilestoneExpression, Params: store.MilestoneParams{Condition: condition}, Enabled: true}
+	}
+	for _, m := range []store.Milestone{
+		expression("Two busy merchants", `merchants_with_transactions(5) >= 2`),
+		expression("Three busy merchants", `merchants_with_transactions(5) >= 3`),
+		expression("WiFi beats coffee", `source_volume("wifi") > merchant_volume("coffee") - source_volume("wifi")`),
+	} {
+		if _, err := st.UpsertMilestone(ctx, m); err != nil {
+			t.Fatalf("upsert %s: %v", m.Name, err)
+		}
+	}
+	for name, m := range map[string]store.Milestone{
+		"bad condition": expression("x", `volume >`),
+		"threshold":     {Name: "x", Type: store.MilestoneExpression, Params: store.MilestoneParams{Condition: "volume > 1"}, Threshold: 5},
+		"recurring":     {Name: "x", Type: store.MilestoneExpression, Params: store.MilestoneParams{Condition: "volume > 1"}, Recurring: true},
+		"other params":  {Name: "x", Type: store.MilestoneVolume, Params: store.MilestoneParams{Condition: "volume > 1"}, Threshold: 5},
+	} {
+		if _, err := st.UpsertMilestone(ctx, m); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	triggers, err := st.ProcessMilestones(ctx)
+	if err != nil {
+		t.Fatalf("process milestones: %v", err)
+	}
+	var names []string
+	for _, tr := range triggers {
+		names = append(names, tr.Name)
+		if tr.Threshold != 1 || tr.Transaction != nil {
+			t.Errorf("expected an unattributed trigger, got %+v", tr)
+		}
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"Two busy merchants", "WiFi beats coffee"}) {
+		t.Errorf("unexpected triggers: %v", names)
+	}
+
+	preview, err := st.PreviewMilestone(ctx, expression("Preview", `merchants_with_transactions(5) >= 3 || volume >= 2000`))
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	want := map[string]float64{"merchants_with_transactions(5)": 2, "volume": 2200}
+	if preview.Value != 1 || !preview.WouldTrigger || !maps.Equal(preview.Metrics, want) {
+		t.Errorf("unexpected preview: %+v", preview)
+	}
+}
+
+func TestSimulateMilestones(t *testing.T) {
+	t.Parallel()
+	st := newTestStore(t)
+	ctx := context.Background()
+	for _, id := range []string{"bar", "cafe"} {
+		if err := st.UpsertMerchant(ctx, store.Merchant{ID: id, PublicKey: "pk", Alias: id, Enabled: true}); err != nil {
+			t.Fatalf("upsert merchant: %v", err)
+		}
+	}
+	// 100 sats every ten minutes from 10:00, alternating bar and cafe, and a
+	// 1,000 sat WiFi upgrade at 10:35
+	base := time.Date(2025, 11, 17, 10, 0, 0, 0, time.UTC)
+	for i := range 12 {
+		merchant := []string{"bar", "cafe"}[i%2]
+		if _, err := st.RecordTransactions(ctx, merchant, []store.TransactionInput{
+			{SaleID: int64(i + 1), SaleDate: base.Add(time.Duration(i) * 10 * time.Minute), AmountSats: 100},
+		}); err != nil {
+			t.Fatalf("record transaction: %v", err)
+		}
+	}
+	if _, err := st.RecordTransactions(ctx, "cafe", []store.TransactionInput{
+		{ExternalID: "h1", SaleDate: base.Add(35 * time.Minute), AmountSats: 1000, Source: store.SourceWifi},
+	}); err != nil {
+		t.Fatalf("record wifi: %v", err)
+	}
+
+	activeFrom := base.Add(time.Hour)
+	sim, err := st.SimulateMilestones(ctx, []store.Milestone{
+		{Name: "Five sales", Type: store.MilestoneTransactions, Threshold: 5},
+		{Name: "Every 500 sats", Type: store.MilestoneVolume, Threshold: 500, Recurring: true, MaxOrdinal: 2},
+		{Name: "Big payment", Type: store.MilestoneSingleTransaction, Threshold: 1000},
+		{Name: "Late first sale", Type: store.MilestoneTransactions, Threshold: 1, ActiveFrom: &activeFrom},
+		{Name: "Busy bar", Type: store.MilestoneExpression, Params: store.MilestoneParams{Condition: `merchant_transactions("bar") >= 3`}},
+	})
+	if err != nil {
+		t.Fatalf("simulate: %v", err)
+	}
+	type fired struct {
+		milestone int
+		ordinal   int64
+		at        time.Duration
+	}
+	want := []fired{{0, 1, 35 * time.Minute}, {1, 1, 35 * time.Minute}, {1, 2, 35 * time.Minute}, {2, 1, 35 * time.Minute}, {4, 1, 40 * time.Minute}, {3, 1, time.Hour}}
+	var got []fired
+	for _, tr := range sim.Triggers {
+		got = append(got, fired{tr.Milestone, tr.Ordinal, tr.TriggeredAt.Sub(base)})
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("unexpected triggers: %+v", got)
+	}
+	if tr := sim.Triggers[0]; tr.TotalTransactions != 5 || tr.TotalVolumeSats != 1400 || tr.Transaction.AmountSats != 1000 {
+		t.Errorf("unexpected crossing payment: %+v", tr)
+	}
+	// Deferred to active_from, but still the first sale
+	if tr := sim.Triggers[5]; !tr.Transaction.SaleDate.Equal(base) || tr.TotalTransactions != 1 {
+		t.Errorf("unexpected deferred trigger: %+v", tr)
+	}
+	if sim.Transactions != 13 || !sim.From.Equal(base) || *sim.MeanGapSeconds != 300 || *sim.LongestGapSeconds != 1200 {
+		t.Errorf("unexpected summary: %+v", sim)
+	}
+
+	// Nothing is saved
+	triggers, err := st.ListMilestoneTriggers(ctx, store.MilestoneTriggerFilter{})
+	if err != nil {
+		t.Fatalf("list triggers: %v", err)
+	}
+	milestones, err := st.ListMilestones(ctx, true)
+	if err != nil {
+		t.Fatalf("list milestones: %v", err)
+	}
+	if len(triggers) != 0 || len(milestones) != 0 {
+		t.Errorf("simulation wrote %d triggers and %d milestones", len(triggers), len(milestones))
+	}
+
+	// Rates count the payments in the window before each one: 10:20, 10:30
+	// and 10:35 in the last 15 minutes, five in the last hour
+	sim, err = st.SimulateMilestones(ctx, []store.Milestone{
+		{Name: "Rush", Type: store.MilestoneExpression, Params: store.MilestoneParams{Condition: `rate("15m") * 15 >= 3 && rate("1h") * 60 >= 5`}},
+	})
+	if err != nil {
+		t.Fatalf("simulate rates: %v", err)
+	}
+	if len(sim.Triggers) != 1 || !sim.Triggers[0].TriggeredAt.Equal(base.Add(35*time.Minute)) {
+		t.Errorf("expected the rate condition to fire at 10:35, got %+v", sim.Triggers)
+	}
+
+	for name, candidates := range map[string][]store.Milestone{
+		"none":            nil,
+		"unique products": {{Name: "x", Type: store.MilestoneUniqueProducts, Threshold: 1}},
+		"in a condition":  {{Name: "x", Type: store.MilestoneExpression, Params: store.MilestoneParams{Condition: "unique_products > 1"}}},
+		"invalid":         {{Name: "x", Type: store.MilestoneRate, Threshold: 1}},
+	} {
+		if _, err := st.SimulateMilestones(ctx, candidates); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
 func newTestStore(t *testing.T) *store.Store {
 	t.Helper()
 	st, err := store.New(":memory:")
@@ -121,3 +682,194 @@ func newTestStore(t *testing.T) *store.Store {
 	})
 	return st
 }
+
+func TestEventsScopeData(t *testing.T) {
+	t.Parallel()
+	st := newTestStore(t)
+	ctx := context.Background()
+
+	ab24, err := st.CreateEvent(ctx, store.Event{Slug: "ab24", Name: "Adopting Bitcoin 2024"})
+	if err != nil {
+		t.Fatalf("create event: %v", err)
+	}
+	scoped := st.ForEvent(ab24.ID)
+	if err := scoped.UpsertMerchant(ctx, store.Merchant{ID: "m1", PublicKey: "pk", Alias: "Old Booth", Enabled: true}); err != nil {
+		t.Fatalf("upsert merchant: %v", err)
+	}
+	if _, err := scoped.RecordTransactions(ctx, "m1", []store.TransactionInput{
+		{SaleID: 1, SaleDate: time.Now(), AmountSats: 100},
+	}); err != nil {
+		t.Fatalf("record transactions: %v", err)
+	}
+	if _, err := scoped.UpsertMilestone(ctx, store.Milestone{Name: "First sale", Type: store.MilestoneTransactions, Threshold: 1, Enabled: true}); err != nil {
+		t.Fatalf("upsert milestone: %v", err)
+	}
+	if _, err := scoped.ProcessMilestones(ctx); err != nil {
+		t.Fatalf("process milestones: %v", err)
+	}
+	if _, err := scoped.CreatePlaylist(ctx, store.Playlist{Name: "Hall A", Entries: []store.PlaylistEntry{
+		{SceneID: "merchants", Params: store.SceneParams{Metric: "volume", Window: "1h"}},
+		{SceneID: "overview"},
+	}}); err != nil {
+		t.Fatalf("create playlist: %v", err)
+	}
+
+	// The default event sees none of it.
+	summary, err := st.SummaryBySource(ctx, time.Minute, "all")
+	if err != nil {
+		t.Fatalf("summary: %v", err)
+	}
+	if summary.TotalTransactions != 0 {
+		t.Fatalf("expected default event to have 0 transactions, got %d", summary.TotalTransactions)
+	}
+
+	// A clone copies config but not transactions or trigger state.
+	ab25, err := st.CloneEvent(ctx, "ab24", store.Event{Slug: "ab25", Name: "Adopting Bitcoin 2025"})
+	if err != nil {
+		t.Fatalf("clone event: %v", err)
+	}
+	clone := st.ForEvent(ab25.ID)
+	merchants, err := clone.ListMerchants(ctx, false)
+	if err != nil {
+		t.Fatalf("list merchants: %v", err)
+	}
+	if len(merchants) != 2 { // m1 plus the seeded wifi merchant
+		t.Fatalf("expected 2 cloned merchants, got %d", len(merchants))
+	}
+	milestones, err := clone.ListMilestones(ctx, false)
+	if err != nil {
+		t.Fatalf("list milestones: %v", err)
+	}
+	if len(milestones) != 1 || milestones[0].Triggered {
+		t.Fatalf("expected 1 untriggered cloned milestone, got %+v", milestones)
+	}
+	playlists, err := clone.ListPlaylists(ctx)
+	if err != nil {
+		t.Fatalf("list playlists: %v", err)
+	}
+	if len(playlists) != 1 || len(playlists[0].Entries) != 2 || playlists[0].Entries[0].Params.Window != "1h" {
+		t.Fatalf("expected the playlist to be cloned with its entries, got %+v", playlists)
+	}
+	// The same upstream sale id can be recorded once per event.
+	inserted, err := clone.RecordTransactions(ctx, "m1", []store.TransactionInput{
+		{SaleID: 1, SaleDate: time.Now(), AmountSats: 100},
+	})
+	if err != nil {
+		t.Fatalf("record cloned transactions: %v", err)
+	}
+	if inserted != 1 {
+		t.Fatalf("expected 1 inserted row in cloned event, got %d", inserted)
+	}
+
+	// Activating an event moves unscoped handles over to it.
+	if _, err := st.ActivateEvent(ctx, "ab25"); err != nil {
+		t.Fatalf("activate event: %v", err)
+	}
+	if st.EventID() != ab25.ID {
+		t.Fatalf("expected default event %d, got %d", ab25.ID, st.EventID())
+	}
+}
+
+func TestWifiVouchers(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+
+	tier := store.WifiTier{ID: 1, DurationMinutes: 60, SpeedMbps: 100}
+	paidAt := time.Now().Add(-30 * time.Minute)
+	v, minted, err := st.MintWifiVoucher(ctx, "hash1", tier, paidAt)
+	if err != nil || !minted {
+		t.Fatalf("mint voucher: minted=%v err=%v", minted, err)
+	}
+	if len(v.Code) != 11 || v.Code[5] != '-' || !v.ExpiresAt.Equal(paidAt.UTC().Add(time.Hour)) {
+		t.Fatalf("unexpected voucher: %+v", v)
+	}
+	again, minted, err := st.MintWifiVoucher(ctx, "hash1", tier, time.Now())
+	if err != nil || minted || again.Code != v.Code {
+		t.Fatalf("expected the existing voucher back, got %+v minted=%v err=%v", again, minted, err)
+	}
+
+	// Codes are matched however they're typed
+	typed := strings.ToLower(strings.ReplaceAll(v.Code, "-", " "))
+	if _, err := st.RedeemWifiVoucher(ctx, typed, "aa:bb", time.Now()); err != nil {
+		t.Fatalf("redeem voucher: %v", err)
+	}
+	if _, err := st.RedeemWifiVoucher(ctx, v.Code, "aa:bb", time.Now()); err != nil {
+		t.Errorf("redeem again from the same client: %v", err)
+	}
+	if _, err := st.RedeemWifiVoucher(ctx, v.Code, "cc:dd", time.Now()); !errors.Is(err, store.ErrVoucherRedeemed) {
+		t.Errorf("expected ErrVoucherRedeemed for another client, got %v", err)
+	}
+	if _, err := st.RedeemWifiVoucher(ctx, v.Code, "aa:bb", time.Now().Add(time.Hour)); !errors.Is(err, store.ErrVoucherExpired) {
+		t.Errorf("expected ErrVoucherExpired after the tier duration, got %v", err)
+	}
+}
+
+func TestWebhookDeliveryPruning(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+
+	old, err := st.RecordWebhookDelivery(ctx, store.WebhookDelivery{
+		EndpointID: "booth-pay", ReceivedAt: time.Now().Add(-31 * 24 * time.Hour), Body: "{}", StatusCode: 200,
+	})
+	if err != nil {
+		t.Fatalf("record old delivery: %v", err)
+	}
+	recent, err := st.RecordWebhookDelivery(ctx, store.WebhookDelivery{EndpointID: "booth-pay", Body: "{}", StatusCode: 200})
+	if err != nil {
+		t.Fatalf("record delivery: %v", err)
+	}
+	if _, err := st.GetWebhookDelivery(ctx, old.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the month-old delivery to be pruned, got %v", err)
+	}
+	if _, err := st.GetWebhookDelivery(ctx, recent.ID); err != nil {
+		t.Errorf("recent delivery: %v", err)
+	}
+}
+
+func TestCreateEventSeedsWifiTier(t *testing.T) {
+	st := newTestStore(t)
+	ctx := context.Background()
+
+	e, err := st.CreateEvent(ctx, store.Event{Slug: "ab26", Name: "Adopting Bitcoin 2026"})
+	if err != nil {
+		t.Fatalf("create event: %v", err)
+	}
+	scoped := st.ForEvent(e.ID)
+	tiers, err := scoped.ListWifiTiers(ctx, true)
+	if err != nil {
+		t.Fatalf("list tiers: %v", err)
+	}
+	if len(tiers) != 1 || tiers[0].PriceSats != 2100 || tiers[0].DurationMinutes != 480 {
+		t.Fatalf("expected the default tier, got %+v", tiers)
+	}
+	if tier, err := scoped.ClassifyWifiPayment(ctx, 2100); err != nil || tier.ID != tiers[0].ID {
+		t.Errorf("expected a 2100 sat payment to buy the default tier, got %+v (%v)", tier, err)
+	}
+
+	// A clone copies the source's tiers instead of adding another default
+	clone, err := st.CloneEvent(ctx, "ab26", store.Event{Slug: "ab27"})
+	if err != nil {
+		t.Fatalf("clone event: %v", err)
+	}
+	if tiers, err := st.ForEvent(clone.ID).ListWifiTiers(ctx, false); err != nil || len(tiers) != 1 {
+		t.Errorf("expected 1 cloned tier, got %d (%v)", len(tiers), err)
+	}
+
+	// Deleted tiers don't come back on the next start or in clones
+	if err := scoped.DeleteWifiTier(ctx, tiers[0].ID); err != nil {
+		t.Fatalf("delete tier: %v", err)
+	}
+	if err := st.Init(ctx); err != nil {
+		t.Fatalf("init store: %v", err)
+	}
+	if tiers, err := scoped.ListWifiTiers(ctx, false); err != nil || len(tiers) != 0 {
+		t.Errorf("expected no tiers after a restart, got %d (%v)", len(tiers), err)
+	}
+	clone, err = st.CloneEvent(ctx, "ab26", store.Event{Slug: "ab28"})
+	if err != nil {
+		t.Fatalf("clone event: %v", err)
+	}
+	if tiers, err := st.ForEvent(clone.ID).ListWifiTiers(ctx, false); err != nil || len(tiers) != 0 {
+		t.Errorf("expected no tiers in a clone of an event without any, got %d (%v)", len(tiers), err)
+	}
+}
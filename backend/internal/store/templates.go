@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+)
+
+// MilestoneTemplate is a built-in set of milestones an event can import in
+// one go.
+type MilestoneTemplate struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Milestones  []Milestone `json:"milestones"`
+}
+
+// milestoneTemplates are the built-in templates. Thresholds are tuned for a
+// conference of a few thousand attendees.
+var milestoneTemplates = []MilestoneTemplate{
+	{
+		ID:          "conference-ladder",
+		Name:        "Standard conference ladder",
+		Description: "Sales and volume milestones from the first payment to 10M sats, plus a celebration every million sats",
+		Milestones: []Milestone{
+			{Name: "First sale", Type: MilestoneTransactions, Threshold: 1},
+			{Name: "100 sales", Type: MilestoneTransactions, Threshold: 100},
+			{Name: "1,000 sales", Type: MilestoneTransactions, Threshold: 1000},
+			{Name: "5,000 sales", Type: MilestoneTransactions, Threshold: 5000},
+			{Name: "100k sats", Type: MilestoneVolume, Threshold: 100_000},
+			{Name: "1M sats", Type: MilestoneVolume, Threshold: 1_000_000},
+			{Name: "10M sats", Type: MilestoneVolume, Threshold: 10_000_000},
+			{Name: "Another million sats", Type: MilestoneVolume, Threshold: 1_000_000, Recurring: true},
+			{Name: "10 merchants live", Type: MilestoneActiveMerchants, Threshold: 10},
+		},
+	},
+	{
+		ID:          "wifi",
+		Name:        "WiFi upgrades",
+		Description: "Counts paid WiFi upgrades",
+		Milestones: []Milestone{
+			{Name: "First WiFi upgrade", Type: MilestoneSource, Params: MilestoneParams{Source: SourceWifi, Metric: MetricTransactions}, Threshold: 1},
+			{Name: "100 WiFi upgrades", Type: MilestoneSource, Params: MilestoneParams{Source: SourceWifi, Metric: MetricTransactions}, Threshold: 100},
+		},
+	},
+	{
+		ID:          "hype",
+		Name:        "Hype moments",
+		Description: "Bursts of activity and big single payments",
+		Milestones: []Milestone{
+			{Name: "10 sales a minute", Type: MilestoneRate, Params: MilestoneParams{Window: "5m"}, Threshold: 10},
+			{Name: "Whale payment", Type: MilestoneSingleTransaction, Threshold: 1_000_000},
+		},
+	},
+}
+
+// MilestoneTemplates returns the built-in templates.
+func MilestoneTemplates() []MilestoneTemplate {
+	return milestoneTemplates
+}
+
+// ImportMilestoneTemplate creates a template's milestones in the event,
+// enabled, and returns the ones created. Milestones whose name the event
+// already uses are skipped, so importing twice is harmless.
+func (s *Store) ImportMilestoneTemplate(ctx context.Context, templateID string) ([]Milestone, error) {
+	i := slices.IndexFunc(milestoneTemplates, func(t MilestoneTemplate) bool { return t.ID == templateID })
+	if i < 0 {
+		return nil, sql.ErrNoRows
+	}
+	existing, err := s.ListMilestones(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(existing))
+	for _, m := range existing {
+		names[m.Name] = true
+	}
+	created := make([]Milestone, 0, len(milestoneTemplates[i].Milestones))
+	for _, m := range milestoneTemplates[i].Milestones {
+		if names[m.Name] {
+			continue
+		}
+		m.Enabled = true
+		saved, err := s.UpsertMilestone(ctx, m)
+		if err != nil {
+			return created, fmt.Errorf("milestone %q: %w", m.Name, err)
+		}
+		created = append(created, saved)
+	}
+	return created, nil
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// MilestoneTriggerFilter selects triggers for cursor-based consumers.
+// Results are in id order, which is the order they were recorded in.
+type MilestoneTriggerFilter struct {
+	AfterID   int64     // only triggers with a larger id
+	Since     time.Time // only triggers recorded at or after this
+	DisplayID string    // only triggers this display hasn't acknowledged
+	Limit     int       // 0 returns all
+}
+
+// MilestoneTriggerAck records that a display played a trigger.
+type MilestoneTriggerAck struct {
+	DisplayID   string    `json:"display_id"`
+	DisplayName string    `json:"display_name"` // empty once the display is removed
+	AckedAt     time.Time `json:"acked_at"`
+}
+
+// MilestoneTriggerReport is a trigger with the displays that played it.
+type MilestoneTriggerReport struct {
+	MilestoneTrigger
+	Acks []MilestoneTriggerAck `json:"acks"`
+}
+
+// ListMilestoneTriggers returns the event's triggers matching a filter,
+// oldest first.
+func (s *Store) ListMilestoneTriggers(ctx context.Context, f MilestoneTriggerFilter) ([]MilestoneTrigger, error) {
+	query := `SELECT ` + triggerColumns + ` FROM milestone_triggers t WHERE event_id=? AND id>?`
+	args := []any{s.EventID(), f.AfterID}
+	if !f.Since.IsZero() {
+		query += ` AND triggered_at >= ?`
+		args = append(args, f.Since.UTC())
+	}
+	if f.DisplayID != "" {
+		query += ` AND NOT EXISTS (
+			SELECT 1 FROM milestone_trigger_acks a WHERE a.trigger_id=t.id AND a.display_id=?
+		)`
+		args = append(args, f.DisplayID)
+	}
+	query += ` ORDER BY id LIMIT ?`
+	if f.Limit <= 0 {
+		f.Limit = -1 // no limit
+	}
+	args = append(args, f.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]MilestoneTrigger, 0)
+	for rows.Next() {
+		tr, err := scanTrigger(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, tr)
+	}
+	return out, rows.Err()
+}
+
+// GetMilestoneTrigger fetches one of the event's triggers.
+func (s *Store) GetMilestoneTrigger(ctx context.Context, id int64) (MilestoneTrigger, error) {
+	return scanTrigger(s.db.QueryRowContext(ctx, `
+		SELECT `+triggerColumns+` FROM milestone_triggers WHERE event_id=? AND id=?
+	`, s.EventID(), id))
+}
+
+// MilestoneTriggerReports returns the triggers matching a filter with the
+// displays that acknowledged each.
+func (s *Store) MilestoneTriggerReports(ctx context.Context, f MilestoneTriggerFilter) ([]MilestoneTriggerReport, error) {
+	triggers, err := s.ListMilestoneTriggers(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]MilestoneTriggerReport, 0, len(triggers))
+	if len(triggers) == 0 {
+		return out, nil
+	}
+	index := make(map[int64]int, len(triggers))
+	for i, tr := range triggers {
+		index[tr.ID] = i
+		out = append(out, MilestoneTriggerReport{MilestoneTrigger: tr, Acks: make([]MilestoneTriggerAck, 0)})
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT a.trigger_id, a.display_id, COALESCE(d.name, ''), a.acked_at
+		FROM milestone_trigger_acks a
+		JOIN milestone_triggers t ON t.id = a.trigger_id
+		LEFT JOIN displays d ON d.id = a.display_id
+		WHERE t.event_id=? AND a.trigger_id BETWEEN ? AND ?
+		ORDER BY a.acked_at, a.display_id
+	`, s.EventID(), triggers[0].ID, triggers[len(triggers)-1].ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var triggerID int64
+		var ack MilestoneTriggerAck
+		if err := rows.Scan(&triggerID, &ack.DisplayID, &ack.DisplayName, &ack.AckedAt); err != nil {
+			return nil, err
+		}
+		if i, ok := index[triggerID]; ok {
+			out[i].Acks = append(out[i].Acks, ack)
+		}
+	}
+	return out, rows.Err()
+}
+
+// AckMilestoneTrigger records that a display played one of the event's
+// triggers. Acknowledging again keeps the first time.
+func (s *Store) AckMilestoneTrigger(ctx context.Context, triggerID int64, displayID string, at time.Time) error {
+	if _, err := s.GetMilestoneTrigger(ctx, triggerID); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO milestone_trigger_acks (trigger_id, display_id, acked_at) VALUES (?, ?, ?)
+	`, triggerID, displayID, at.UTC())
+	return err
+}
+
+// RefireMilestoneTrigger records a copy of a trigger under a new id, so
+// cursor consumers play the celebration again. The milestone itself is left
+// as it is. Re-firing a re-fire points back at the original.
+func (s *Store) RefireMilestoneTrigger(ctx context.Context, id int64) (MilestoneTrigger, error) {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO milestone_triggers (event_id, milestone_id, name, type, threshold, ordinal, triggered_at,
+			total_transactions, total_volume_sats, transaction_id, sale_id, merchant_id, merchant_alias,
+			amount_sats, sale_date, celebration, refire_of)
+		SELECT event_id, milestone_id, name, type, threshold, ordinal, ?,
+			total_transactions, total_volume_sats, transaction_id, sale_id, merchant_id, merchant_alias,
+			amount_sats, sale_date, celebration, COALESCE(refire_of, id)
+		FROM milestone_triggers WHERE event_id=? AND id=?
+	`, time.Now().UTC(), s.EventID(), id)
+	if err != nil {
+		return MilestoneTrigger{}, err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return MilestoneTrigger{}, sql.ErrNoRows
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return MilestoneTrigger{}, err
+	}
+	s.touch()
+	return s.GetMilestoneTrigger(ctx, newID)
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrVoucherExpired is returned when redeeming a voucher past its expiry.
+	ErrVoucherExpired = errors.New("voucher expired")
+	// ErrVoucherRedeemed is returned when a voucher was already redeemed by
+	// another client.
+	ErrVoucherRedeemed = errors.New("voucher already redeemed")
+)
+
+// WifiVoucher is the access code handed out for a paid WiFi upgrade. Access
+// lasts the tier's duration from payment.
+type WifiVoucher struct {
+	Code            string     `json:"code"`
+	EventID         int64      `json:"event_id"`
+	PaymentHash     string     `json:"payment_hash"`
+	TierID          int64      `json:"tier_id"`
+	DurationMinutes int64      `json:"duration_minutes"`
+	SpeedMbps       int64      `json:"speed_mbps"`
+	CreatedAt       time.Time  `json:"created_at"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	RedeemedAt      *time.Time `json:"redeemed_at"`
+	RedeemedBy      string     `json:"redeemed_by"` // client id reported by the captive portal
+}
+
+// voucherAlphabet leaves out characters that are easy to misread.
+const voucherAlphabet = "ABCDEFGHJKMNPQRSTVWXYZ23456789"
+
+// newVoucherCode returns a random code formatted as XXXXX-XXXXX.
+func newVoucherCode() (string, error) {
+	// Bytes past the largest multiple of the alphabet size are skipped so
+	// every character is equally likely.
+	limit := byte(256 / len(voucherAlphabet) * len(voucherAlphabet))
+	code := make([]byte, 0, 11)
+	buf := make([]byte, 16)
+	for len(code) < 11 {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if b >= limit || len(code) == 11 {
+				continue
+			}
+			if len(code) == 5 {
+				code = append(code, '-')
+			}
+			code = append(code, voucherAlphabet[int(b)%len(voucherAlphabet)])
+		}
+	}
+	return string(code), nil
+}
+
+// NormalizeVoucherCode uppercases a code typed by hand and restores its dash.
+func NormalizeVoucherCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) == 10 {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
+
+const wifiVoucherColumns = `code, event_id, payment_hash, tier_id, duration_minutes, speed_mbps, created_at,
+	expires_at, redeemed_at, redeemed_by`
+
+func scanWifiVoucher(row interface{ Scan(...any) error }) (WifiVoucher, error) {
+	var v WifiVoucher
+	var redeemedAt sql.NullTime
+	err := row.Scan(&v.Code, &v.EventID, &v.PaymentHash, &v.TierID, &v.DurationMinutes, &v.SpeedMbps,
+		&v.CreatedAt, &v.ExpiresAt, &redeemedAt, &v.RedeemedBy)
+	if redeemedAt.Valid {
+		v.RedeemedAt = &redeemedAt.Time
+	}
+	return v, err
+}
+
+// MintWifiVoucher issues the voucher for a paid WiFi payment in the store's
+// event. Minting is idempotent per payment hash: a payment that already has a
+// voucher gets the existing one back, with minted false.
+func (s *Store) MintWifiVoucher(ctx context.Context, paymentHash string, tier WifiTier, paidAt time.Time) (WifiVoucher, bool, error) {
+	if paymentHash == "" {
+		return WifiVoucher{}, false, errors.New("payment hash is required")
+	}
+	code, err := newVoucherCode()
+	if err != nil {
+		return WifiVoucher{}, false, err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO wifi_vouchers (code, event_id, payment_hash, tier_id, duration_minutes, speed_mbps,
+			created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(payment_hash) DO NOTHING
+	`, code, s.EventID(), paymentHash, tier.ID, tier.DurationMinutes, tier.SpeedMbps, time.Now().UTC(),
+		paidAt.UTC().Add(time.Duration(tier.DurationMinutes)*time.Minute))
+	if err != nil {
+		return WifiVoucher{}, false, err
+	}
+	minted, _ := res.RowsAffected()
+	v, err := s.GetWifiVoucherByPaymentHash(ctx, paymentHash)
+	return v, minted == 1, err
+}
+
+// GetWifiVoucher fetches a voucher by code regardless of event.
+func (s *Store) GetWifiVoucher(ctx context.Context, code string) (WifiVoucher, error) {
+	return scanWifiVoucher(s.db.QueryRowContext(ctx, `
+		SELECT `+wifiVoucherColumns+` FROM wifi_vouchers WHERE code=?
+	`, NormalizeVoucherCode(code)))
+}
+
+// GetWifiVoucherByPaymentHash fetches the voucher minted for a payment.
+func (s *Store) GetWifiVoucherByPaymentHash(ctx context.Context, paymentHash string) (WifiVoucher, error) {
+	return scanWifiVoucher(s.db.QueryRowContext(ctx, `
+		SELECT `+wifiVoucherColumns+` FROM wifi_vouchers WHERE payment_hash=?
+	`, paymentHash))
+}
+
+// RedeemWifiVoucher binds a voucher to the client that first uses it.
+// Redeeming again from the same client succeeds, so a portal can re-validate
+// a reconnecting device.
+func (s *Store) RedeemWifiVoucher(ctx context.Context, code, clientID string, now time.Time) (WifiVoucher, error) {
+	if strings.TrimSpace(clientID) == "" {
+		return WifiVoucher{}, errors.New("client_id is required")
+	}
+	v, err := s.GetWifiVoucher(ctx, code)
+	if err != nil {
+		return v, err
+	}
+	switch {
+	case !now.Before(v.ExpiresAt):
+		return v, ErrVoucherExpired
+	case v.RedeemedAt != nil && v.RedeemedBy != clientID:
+		return v, ErrVoucherRedeemed
+	case v.RedeemedAt != nil:
+		return v, nil
+	}
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE wifi_vouchers SET redeemed_at=?, redeemed_by=? WHERE code=? AND redeemed_at IS NULL
+	`, now.UTC(), clientID, v.Code)
+	if err != nil {
+		return v, err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		// Lost a race with another client
+		return v, ErrVoucherRedeemed
+	}
+	return s.GetWifiVoucher(ctx, v.Code)
+}
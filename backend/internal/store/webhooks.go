@@ -0,0 +1,357 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrWebhookEndpointExists is returned when creating an endpoint whose id is
+// taken.
+var ErrWebhookEndpointExists = errors.New("webhook endpoint id already exists")
+
+// WebhookEndpoint routes payment notifications from one provider into a
+// merchant. Its ID is the path segment in /v1/webhooks/{id}.
+type WebhookEndpoint struct {
+	ID             string            `json:"id"`
+	EventID        int64             `json:"event_id"` // 0 follows the default event
+	Name           string            `json:"name"`
+	Provider       string            `json:"provider"`
+	Secret         string            `json:"secret"`
+	PreviousSecret string            `json:"previous_secret"` // still accepted after a rotation
+	AuthMode       string            `json:"auth_mode"`       // hmac, header or btcpay
+	MerchantID     string            `json:"merchant_id"`
+	Source         TransactionSource `json:"source"`
+	Mapping        map[string]string `json:"mapping"` // field paths for the generic provider
+	Enabled        bool              `json:"enabled"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+const webhookEndpointColumns = `id, event_id, name, provider, secret, previous_secret, auth_mode, merchant_id, source, mapping, enabled, created_at, updated_at`
+
+func scanWebhookEndpoint(row interface{ Scan(...any) error }) (WebhookEndpoint, error) {
+	var e WebhookEndpoint
+	var mapping string
+	var enabled int
+	if err := row.Scan(&e.ID, &e.EventID, &e.Name, &e.Provider, &e.Secret, &e.PreviousSecret, &e.AuthMode, &e.MerchantID, &e.Source,
+		&mapping, &enabled, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		return e, err
+	}
+	e.Enabled = enabled != 0
+	e.Mapping = make(map[string]string)
+	if err := json.Unmarshal([]byte(mapping), &e.Mapping); err != nil {
+		return e, fmt.Errorf("webhook endpoint %s mapping: %w", e.ID, err)
+	}
+	return e, nil
+}
+
+// ListWebhookEndpoints returns endpoints that record into the store's event,
+// including those that follow the default event.
+func (s *Store) ListWebhookEndpoints(ctx context.Context) ([]WebhookEndpoint, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+webhookEndpointColumns+` FROM webhook_endpoints
+		WHERE event_id=? OR event_id=0
+		ORDER BY name, id
+	`, s.EventID())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]WebhookEndpoint, 0)
+	for rows.Next() {
+		e, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// GetWebhookEndpoint fetches an endpoint of the store's event, or one that
+// follows the default event.
+func (s *Store) GetWebhookEndpoint(ctx context.Context, id string) (WebhookEndpoint, error) {
+	return scanWebhookEndpoint(s.db.QueryRowContext(ctx, `
+		SELECT `+webhookEndpointColumns+` FROM webhook_endpoints WHERE id=? AND (event_id=? OR event_id=0)
+	`, id, s.EventID()))
+}
+
+// FindWebhookEndpoint fetches an endpoint by id regardless of event, since
+// inbound webhooks arrive without an event scope.
+func (s *Store) FindWebhookEndpoint(ctx context.Context, id string) (WebhookEndpoint, error) {
+	return scanWebhookEndpoint(s.db.QueryRowContext(ctx, `
+		SELECT `+webhookEndpointColumns+` FROM webhook_endpoints WHERE id=?
+	`, id))
+}
+
+// CreateWebhookEndpoint registers an endpoint recording into the store's
+// event. An empty ID is replaced with a random one.
+func (s *Store) CreateWebhookEndpoint(ctx context.Context, e WebhookEndpoint) (WebhookEndpoint, error) {
+	if e.ID == "" {
+		buf := make([]byte, 12)
+		if _, err := rand.Read(buf); err != nil {
+			return e, err
+		}
+		e.ID = hex.EncodeToString(buf)
+	}
+	e.EventID = s.EventID()
+	if err := s.validateWebhookEndpoint(ctx, e); err != nil {
+		return e, err
+	}
+	mapping, err := json.Marshal(e.Mapping)
+	if err != nil {
+		return e, err
+	}
+	var exists int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM webhook_endpoints WHERE id=?`, e.ID).Scan(&exists); err != nil {
+		return e, err
+	}
+	if exists > 0 {
+		return e, ErrWebhookEndpointExists
+	}
+	now := time.Now().UTC()
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhook_endpoints (id, event_id, name, provider, secret, previous_secret, auth_mode, merchant_id, source, mapping, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.ID, e.EventID, e.Name, e.Provider, e.Secret, e.PreviousSecret, e.AuthMode, e.MerchantID, e.Source, string(mapping),
+		boolToInt(e.Enabled), now, now); err != nil {
+		return e, err
+	}
+	return s.GetWebhookEndpoint(ctx, e.ID)
+}
+
+// UpdateWebhookEndpoint replaces an endpoint's editable fields. The id and
+// event are fixed.
+func (s *Store) UpdateWebhookEndpoint(ctx context.Context, e WebhookEndpoint) (WebhookEndpoint, error) {
+	if err := s.validateWebhookEndpoint(ctx, e); err != nil {
+		return e, err
+	}
+	mapping, err := json.Marshal(e.Mapping)
+	if err != nil {
+		return e, err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_endpoints
+		SET name=?, provider=?, secret=?, previous_secret=?, auth_mode=?, merchant_id=?, source=?, mapping=?, enabled=?, updated_at=?
+		WHERE id=? AND (event_id=? OR event_id=0)
+	`, e.Name, e.Provider, e.Secret, e.PreviousSecret, e.AuthMode, e.MerchantID, e.Source, string(mapping), boolToInt(e.Enabled),
+		time.Now().UTC(), e.ID, s.EventID())
+	if err != nil {
+		return e, err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return e, sql.ErrNoRows
+	}
+	return s.GetWebhookEndpoint(ctx, e.ID)
+}
+
+// RotateWebhookSecret makes secret the endpoint's current secret and keeps the
+// old one as PreviousSecret, so both are accepted until the previous secret is
+// cleared.
+func (s *Store) RotateWebhookSecret(ctx context.Context, id, secret string) (WebhookEndpoint, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_endpoints SET previous_secret=secret, secret=?, updated_at=?
+		WHERE id=? AND (event_id=? OR event_id=0)
+	`, secret, time.Now().UTC(), id, s.EventID())
+	if err != nil {
+		return WebhookEndpoint{}, err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return WebhookEndpoint{}, sql.ErrNoRows
+	}
+	return s.GetWebhookEndpoint(ctx, id)
+}
+
+// ClaimWebhookNonce records a delivery nonce for an endpoint and reports
+// whether it was new. Nonces older than ttl are pruned first; signed requests
+// that old are already rejected by their timestamp.
+func (s *Store) ClaimWebhookNonce(ctx context.Context, endpointID, nonce string, ttl time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM webhook_nonces WHERE seen_at < ?`, now.Add(-ttl)); err != nil {
+		return false, err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO webhook_nonces (endpoint_id, nonce, seen_at) VALUES (?, ?, ?)
+	`, endpointID, nonce, now)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows == 1, err
+}
+
+// ReleaseWebhookNonce forgets a claimed nonce, so a delivery that failed
+// after claiming it can be retried with the same signature.
+func (s *Store) ReleaseWebhookNonce(ctx context.Context, endpointID, nonce string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM webhook_nonces WHERE endpoint_id=? AND nonce=?`, endpointID, nonce)
+	return err
+}
+
+// DeleteWebhookEndpoint removes an endpoint. Recorded transactions are kept.
+func (s *Store) DeleteWebhookEndpoint(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhook_endpoints WHERE id=? AND (event_id=? OR event_id=0)`, id, s.EventID())
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *Store) validateWebhookEndpoint(ctx context.Context, e WebhookEndpoint) error {
+	switch {
+	case !slugPattern.MatchString(e.ID):
+		return errors.New("invalid endpoint id: use lowercase letters, digits and dashes")
+	case strings.TrimSpace(e.Name) == "":
+		return errors.New("endpoint name is required")
+	case !slugPattern.MatchString(string(e.Source)):
+		return errors.New("invalid source: use lowercase letters, digits and dashes")
+	case e.MerchantID == "":
+		return errors.New("merchant_id is required")
+	}
+	if _, err := s.ForEvent(e.EventID).GetMerchant(ctx, e.MerchantID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("merchant %s does not exist in the endpoint's event", e.MerchantID)
+		}
+		return err
+	}
+	return nil
+}
+
+// WebhookDelivery is one inbound webhook request as received, with the
+// response it got.
+type WebhookDelivery struct {
+	ID         int64               `json:"id"`
+	EndpointID string              `json:"endpoint_id"`
+	EventID    int64               `json:"event_id"` // event scope of the request's URL
+	ReceivedAt time.Time           `json:"received_at"`
+	Headers    map[string][]string `json:"headers"`
+	Body       string              `json:"body"`
+	Truncated  bool                `json:"truncated"` // only the start of Body was kept
+	StatusCode int                 `json:"status_code"`
+	Response   json.RawMessage     `json:"response"`
+	Error      string              `json:"error"`
+	ReplayOf   *int64              `json:"replay_of"`
+}
+
+const (
+	// webhookDeliveryRetention is how long the inbox keeps a delivery.
+	webhookDeliveryRetention = 30 * 24 * time.Hour
+	// maxWebhookDeliveries caps the inbox; the oldest deliveries go first.
+	maxWebhookDeliveries = 10000
+)
+
+// WebhookDeliveryFilter narrows ListWebhookDeliveries. Zero values match
+// everything.
+type WebhookDeliveryFilter struct {
+	EndpointID string
+	FailedOnly bool  // status codes of 400 and above
+	BeforeID   int64 // for paging back from the newest delivery
+	Limit      int
+}
+
+const webhookDeliveryColumns = `id, endpoint_id, event_id, received_at, headers, body, truncated, status_code, response, error, replay_of`
+
+func scanWebhookDelivery(row interface{ Scan(...any) error }) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var headers, response string
+	var body []byte
+	var replayOf sql.NullInt64
+	if err := row.Scan(&d.ID, &d.EndpointID, &d.EventID, &d.ReceivedAt, &headers, &body, &d.Truncated, &d.StatusCode,
+		&response, &d.Error, &replayOf); err != nil {
+		return d, err
+	}
+	d.Body = string(body)
+	if response != "" {
+		d.Response = json.RawMessage(response)
+	}
+	if replayOf.Valid {
+		d.ReplayOf = &replayOf.Int64
+	}
+	if err := json.Unmarshal([]byte(headers), &d.Headers); err != nil {
+		return d, fmt.Errorf("webhook delivery %d headers: %w", d.ID, err)
+	}
+	return d, nil
+}
+
+// RecordWebhookDelivery stores an inbound request in the inbox, pruning
+// deliveries past the retention period or the row cap first. It does not
+// bump the data version; the inbox isn't part of any dashboard response.
+func (s *Store) RecordWebhookDelivery(ctx context.Context, d WebhookDelivery) (WebhookDelivery, error) {
+	if d.ReceivedAt.IsZero() {
+		d.ReceivedAt = time.Now().UTC()
+	}
+	headers, err := json.Marshal(d.Headers)
+	if err != nil {
+		return d, err
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		DELETE FROM webhook_deliveries WHERE received_at < ? OR id <= (
+			SELECT id FROM webhook_deliveries ORDER BY id DESC LIMIT 1 OFFSET ?
+		)
+	`, d.ReceivedAt.Add(-webhookDeliveryRetention), maxWebhookDeliveries-1); err != nil {
+		return d, err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, received_at, headers, body, truncated, status_code, response, error, replay_of)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, d.EndpointID, d.EventID, d.ReceivedAt, string(headers), []byte(d.Body), boolToInt(d.Truncated), d.StatusCode,
+		string(d.Response), d.Error, d.ReplayOf)
+	if err != nil {
+		return d, err
+	}
+	d.ID, err = res.LastInsertId()
+	return d, err
+}
+
+// ListWebhookDeliveries returns inbox entries across all events, newest first.
+func (s *Store) ListWebhookDeliveries(ctx context.Context, f WebhookDeliveryFilter) ([]WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE 1=1`
+	var args []any
+	if f.EndpointID != "" {
+		query += ` AND endpoint_id=?`
+		args = append(args, f.EndpointID)
+	}
+	if f.FailedOnly {
+		query += ` AND status_code >= 400`
+	}
+	if f.BeforeID > 0 {
+		query += ` AND id < ?`
+		args = append(args, f.BeforeID)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	if f.Limit <= 0 {
+		f.Limit = -1 // no limit
+	}
+	args = append(args, f.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// GetWebhookDelivery fetches one inbox entry.
+func (s *Store) GetWebhookDelivery(ctx context.Context, id int64) (WebhookDelivery, error) {
+	return scanWebhookDelivery(s.db.QueryRowContext(ctx, `
+		SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries WHERE id=?
+	`, id))
+}
@@ -0,0 +1,231 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// WifiTier is one WiFi upgrade offer of an event.
+type WifiTier struct {
+	ID                int64     `json:"id"`
+	Name              string    `json:"name"`
+	Description       string    `json:"description"`
+	PriceSats         int64     `json:"price_sats"`
+	DurationMinutes   int64     `json:"duration_minutes"`
+	SpeedMbps         int64     `json:"speed_mbps"`
+	DonationPercent   int64     `json:"donation_percent"`
+	DonationRecipient string    `json:"donation_recipient"`
+	Enabled           bool      `json:"enabled"`
+	Order             int64     `json:"order"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+func (t WifiTier) validate() error {
+	switch {
+	case strings.TrimSpace(t.Name) == "":
+		return errors.New("tier name is required")
+	case t.PriceSats <= 0:
+		return errors.New("price_sats must be > 0")
+	case t.DurationMinutes <= 0:
+		return errors.New("duration_minutes must be > 0")
+	case t.SpeedMbps < 0:
+		return errors.New("speed_mbps must be >= 0")
+	case t.DonationPercent < 0 || t.DonationPercent > 100:
+		return errors.New("donation_percent must be between 0 and 100")
+	}
+	return nil
+}
+
+const wifiTierColumns = `id, name, description, price_sats, duration_minutes, speed_mbps,
+	donation_percent, donation_recipient, enabled, sort_order, created_at, updated_at`
+
+func scanWifiTier(row interface{ Scan(...any) error }) (WifiTier, error) {
+	var t WifiTier
+	var enabled int
+	err := row.Scan(&t.ID, &t.Name, &t.Description, &t.PriceSats, &t.DurationMinutes, &t.SpeedMbps,
+		&t.DonationPercent, &t.DonationRecipient, &enabled, &t.Order, &t.CreatedAt, &t.UpdatedAt)
+	t.Enabled = enabled != 0
+	return t, err
+}
+
+// ListWifiTiers returns the event's WiFi tiers in display order.
+func (s *Store) ListWifiTiers(ctx context.Context, onlyEnabled bool) ([]WifiTier, error) {
+	query := `SELECT ` + wifiTierColumns + ` FROM wifi_tiers WHERE event_id=?`
+	if onlyEnabled {
+		query += ` AND enabled=1`
+	}
+	query += ` ORDER BY sort_order, price_sats, id`
+	rows, err := s.db.QueryContext(ctx, query, s.EventID())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]WifiTier, 0)
+	for rows.Next() {
+		t, err := scanWifiTier(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// GetWifiTier fetches a WiFi tier by id.
+func (s *Store) GetWifiTier(ctx context.Context, id int64) (WifiTier, error) {
+	return scanWifiTier(s.db.QueryRowContext(ctx, `
+		SELECT `+wifiTierColumns+` FROM wifi_tiers WHERE event_id=? AND id=?
+	`, s.EventID(), id))
+}
+
+// CreateWifiTier adds a WiFi tier to the event.
+func (s *Store) CreateWifiTier(ctx context.Context, t WifiTier) (WifiTier, error) {
+	if err := t.validate(); err != nil {
+		return t, err
+	}
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO wifi_tiers (event_id, name, description, price_sats, duration_minutes, speed_mbps,
+			donation_percent, donation_recipient, enabled, sort_order, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.EventID(), t.Name, t.Description, t.PriceSats, t.DurationMinutes, t.SpeedMbps,
+		t.DonationPercent, t.DonationRecipient, boolToInt(t.Enabled), t.Order, now, now)
+	if err != nil {
+		return t, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return t, err
+	}
+	s.touch()
+	return s.GetWifiTier(ctx, id)
+}
+
+// UpdateWifiTier replaces all editable fields of a WiFi tier.
+func (s *Store) UpdateWifiTier(ctx context.Context, t WifiTier) (WifiTier, error) {
+	if err := t.validate(); err != nil {
+		return t, err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE wifi_tiers
+		SET name=?, description=?, price_sats=?, duration_minutes=?, speed_mbps=?,
+			donation_percent=?, donation_recipient=?, enabled=?, sort_order=?, updated_at=?
+		WHERE event_id=? AND id=?
+	`, t.Name, t.Description, t.PriceSats, t.DurationMinutes, t.SpeedMbps,
+		t.DonationPercent, t.DonationRecipient, boolToInt(t.Enabled), t.Order, time.Now().UTC(),
+		s.EventID(), t.ID)
+	if err != nil {
+		return t, err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return t, sql.ErrNoRows
+	}
+	s.touch()
+	return s.GetWifiTier(ctx, t.ID)
+}
+
+// DeleteWifiTier removes a WiFi tier. Transactions keep their tier id.
+func (s *Store) DeleteWifiTier(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM wifi_tiers WHERE event_id=? AND id=?`, s.EventID(), id)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	s.touch()
+	return nil
+}
+
+// ClassifyWifiPayment returns the most expensive enabled tier the amount
+// covers, so overpayments still count towards a tier. It returns
+// sql.ErrNoRows when the amount is below every tier.
+func (s *Store) ClassifyWifiPayment(ctx context.Context, amountSats int64) (WifiTier, error) {
+	return scanWifiTier(s.db.QueryRowContext(ctx, `
+		SELECT `+wifiTierColumns+` FROM wifi_tiers
+		WHERE event_id=? AND enabled=1 AND price_sats <= ?
+		ORDER BY price_sats DESC, sort_order, id
+		LIMIT 1
+	`, s.EventID(), amountSats))
+}
+
+// WifiInvoiceStatus is the state of a WiFi invoice.
+type WifiInvoiceStatus string
+
+const (
+	WifiInvoicePending WifiInvoiceStatus = "pending"
+	WifiInvoicePaid    WifiInvoiceStatus = "paid"
+	WifiInvoiceExpired WifiInvoiceStatus = "expired"
+)
+
+// WifiInvoice is a Lightning invoice issued for one WiFi tier purchase. It is
+// keyed by payment hash, which is also the external id of the transaction
+// recorded once it is paid.
+type WifiInvoice struct {
+	PaymentHash string            `json:"payment_hash"`
+	EventID     int64             `json:"event_id"`
+	TierID      int64             `json:"tier_id"`
+	AmountSats  int64             `json:"amount_sats"`
+	Bolt11      string            `json:"bolt11"`
+	CheckingID  string            `json:"checking_id"`
+	Memo        string            `json:"memo"`
+	Status      WifiInvoiceStatus `json:"status"`
+	CreatedAt   time.Time         `json:"created_at"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+	PaidAt      *time.Time        `json:"paid_at"`
+}
+
+const wifiInvoiceColumns = `payment_hash, event_id, tier_id, amount_sats, bolt11, checking_id, memo, status,
+	created_at, expires_at, paid_at`
+
+func scanWifiInvoice(row interface{ Scan(...any) error }) (WifiInvoice, error) {
+	var inv WifiInvoice
+	var paidAt sql.NullTime
+	err := row.Scan(&inv.PaymentHash, &inv.EventID, &inv.TierID, &inv.AmountSats, &inv.Bolt11, &inv.CheckingID,
+		&inv.Memo, &inv.Status, &inv.CreatedAt, &inv.ExpiresAt, &paidAt)
+	if paidAt.Valid {
+		inv.PaidAt = &paidAt.Time
+	}
+	return inv, err
+}
+
+// CreateWifiInvoice stores a pending invoice for the store's event.
+func (s *Store) CreateWifiInvoice(ctx context.Context, inv WifiInvoice) (WifiInvoice, error) {
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO wifi_invoices (payment_hash, event_id, tier_id, amount_sats, bolt11, checking_id, memo,
+			status, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, inv.PaymentHash, s.EventID(), inv.TierID, inv.AmountSats, inv.Bolt11, inv.CheckingID, inv.Memo,
+		WifiInvoicePending, time.Now().UTC(), inv.ExpiresAt.UTC()); err != nil {
+		return inv, err
+	}
+	return s.GetWifiInvoice(ctx, inv.PaymentHash)
+}
+
+// GetWifiInvoice fetches an invoice by payment hash regardless of event.
+func (s *Store) GetWifiInvoice(ctx context.Context, paymentHash string) (WifiInvoice, error) {
+	return scanWifiInvoice(s.db.QueryRowContext(ctx, `
+		SELECT `+wifiInvoiceColumns+` FROM wifi_invoices WHERE payment_hash=?
+	`, paymentHash))
+}
+
+// SettleWifiInvoice marks an invoice paid. An expired invoice can still be
+// settled if the payment arrived late.
+func (s *Store) SettleWifiInvoice(ctx context.Context, paymentHash string, paidAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE wifi_invoices SET status=?, paid_at=? WHERE payment_hash=? AND status!=?
+	`, WifiInvoicePaid, paidAt.UTC(), paymentHash, WifiInvoicePaid)
+	return err
+}
+
+// ExpireWifiInvoice marks a pending invoice expired.
+func (s *Store) ExpireWifiInvoice(ctx context.Context, paymentHash string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE wifi_invoices SET status=? WHERE payment_hash=? AND status=?
+	`, WifiInvoiceExpired, paymentHash, WifiInvoicePending)
+	return err
+}
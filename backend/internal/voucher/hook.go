@@ -0,0 +1,66 @@
+// Package voucher lets a captive portal learn about WiFi vouchers as they are
+// minted, so it can provision access before the attendee types the code.
+package voucher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adopting-bitcoin/dashboard/internal/store"
+	"github.com/adopting-bitcoin/dashboard/internal/webhook"
+)
+
+// Hook is notified of every newly minted voucher. Portals that only validate
+// codes on demand through the portal API don't need one.
+type Hook interface {
+	Minted(ctx context.Context, v store.WifiVoucher) error
+}
+
+// HTTPHook POSTs each minted voucher as JSON to a portal URL. With a secret,
+// requests carry the same X-Webhook-Timestamp and X-Webhook-Signature headers
+// inbound hmac webhooks use.
+type HTTPHook struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewHTTPHook returns a hook posting to url. A non-positive timeout defaults
+// to 10 seconds.
+func NewHTTPHook(url, secret string, timeout time.Duration) *HTTPHook {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &HTTPHook{url: url, secret: secret, client: &http.Client{Timeout: timeout}}
+}
+
+func (h *HTTPHook) Minted(ctx context.Context, v store.WifiVoucher) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.secret != "" {
+		ts := time.Now().Unix()
+		req.Header.Set(webhook.HeaderTimestamp, strconv.FormatInt(ts, 10))
+		req.Header.Set(webhook.HeaderSignature, webhook.Sign(h.secret, ts, body))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("portal responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Auth modes for webhook endpoints.
+const (
+	// AuthHMAC expects X-Webhook-Timestamp (unix seconds) and
+	// X-Webhook-Signature: sha256=<hex HMAC-SHA256 of "timestamp.body">.
+	AuthHMAC = "hmac"
+	// AuthHeader compares a static X-Webhook-Secret header, for senders such
+	// as LNbits that can set headers but not sign. It cannot detect replays.
+	AuthHeader = "header"
+	// AuthBTCPay checks BTCPay Server's BTCPay-Sig header, an HMAC-SHA256 of
+	// the body alone.
+	AuthBTCPay = "btcpay"
+)
+
+// Header names used by the hmac and header auth modes.
+const (
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+	HeaderSecret    = "X-Webhook-Secret"
+)
+
+var (
+	ErrUnauthorized = errors.New("invalid webhook signature")
+	ErrStale        = errors.New("webhook timestamp outside tolerance")
+)
+
+// ValidAuthMode reports whether mode is a known auth mode.
+func ValidAuthMode(mode string) bool {
+	return mode == AuthHMAC || mode == AuthHeader || mode == AuthBTCPay
+}
+
+// Sign returns the X-Webhook-Signature value for a body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify authenticates a request against any of secrets, so a previous secret
+// keeps working while senders switch to a new one. Empty secrets are skipped.
+// For AuthHMAC it returns the signature as a nonce, which the caller must
+// record to reject replays within the tolerance window.
+func Verify(mode string, secrets []string, header http.Header, body []byte, now time.Time, tolerance time.Duration) (string, error) {
+	switch mode {
+	case AuthHMAC:
+		raw := header.Get(HeaderTimestamp)
+		ts, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%w: missing or invalid %s", ErrUnauthorized, HeaderTimestamp)
+		}
+		sig := strings.TrimSpace(header.Get(HeaderSignature))
+		for _, secret := range secrets {
+			if secret != "" && hmac.Equal([]byte(sig), []byte(Sign(secret, ts, body))) {
+				if skew := now.Sub(time.Unix(ts, 0)); skew > tolerance || skew < -tolerance {
+					return "", ErrStale
+				}
+				return sig, nil
+			}
+		}
+	case AuthHeader:
+		provided := header.Get(HeaderSecret)
+		for _, secret := range secrets {
+			if secret != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) == 1 {
+				return "", nil
+			}
+		}
+	case AuthBTCPay:
+		sig := strings.TrimSpace(header.Get("BTCPay-Sig"))
+		for _, secret := range secrets {
+			if secret == "" {
+				continue
+			}
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(body)
+			if hmac.Equal([]byte(sig), []byte("sha256="+hex.EncodeToString(mac.Sum(nil)))) {
+				return "", nil
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown auth mode %q", mode)
+	}
+	return "", ErrUnauthorized
+}
@@ -0,0 +1,277 @@
+// Package webhook turns payment notifications from different providers into
+// a common Payment.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Provider names accepted by New.
+const (
+	ProviderLNbits  = "lnbits"
+	ProviderBTCPay  = "btcpay"
+	ProviderGeneric = "generic"
+)
+
+// ErrIgnored is returned for well-formed notifications that don't represent a
+// completed payment, such as BTCPay invoice-created events. Callers should
+// acknowledge them without recording anything.
+var ErrIgnored = errors.New("notification ignored")
+
+// Payment is a provider-independent payment notification.
+type Payment struct {
+	ExternalID  string // Unique per payment within a source
+	AmountSats  int64
+	PaidAt      time.Time // Zero if the provider didn't say
+	PaymentHash string
+	Memo        string
+}
+
+// Provider parses a provider's notification body.
+type Provider interface {
+	Parse(body []byte) (Payment, error)
+}
+
+// New returns the provider adapter for name. mapping configures the generic
+// provider and is ignored by the others.
+func New(name string, mapping map[string]string) (Provider, error) {
+	switch name {
+	case ProviderLNbits:
+		return lnbits{}, nil
+	case ProviderBTCPay:
+		return btcpay{}, nil
+	case ProviderGeneric:
+		return newGeneric(mapping)
+	}
+	return nil, fmt.Errorf("unknown webhook provider %q", name)
+}
+
+// lnbits parses LNbits lnurlp payment webhooks.
+type lnbits struct{}
+
+func (lnbits) Parse(body []byte) (Payment, error) {
+	var payload struct {
+		Amount      int64  `json:"amount"` // millisats
+		Memo        string `json:"memo"`
+		PaymentHash string `json:"payment_hash"`
+		Time        int64  `json:"time"` // unix timestamp
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return Payment{}, err
+	}
+	if payload.PaymentHash == "" {
+		return Payment{}, errors.New("missing payment_hash")
+	}
+	// Convert millisats to sats
+	amount := payload.Amount / 1000
+	if amount <= 0 {
+		amount = payload.Amount // Fallback if already in sats
+	}
+	p := Payment{
+		ExternalID:  payload.PaymentHash,
+		AmountSats:  amount,
+		PaymentHash: payload.PaymentHash,
+		Memo:        payload.Memo,
+	}
+	if payload.Time != 0 {
+		p.PaidAt = time.Unix(payload.Time, 0).UTC()
+	}
+	return p, nil
+}
+
+// btcpay parses BTCPay Server Greenfield invoice webhooks. Only
+// InvoicePaymentSettled carries an amount, so each settled payment is recorded
+// individually and every other event type is ignored.
+type btcpay struct{}
+
+func (btcpay) Parse(body []byte) (Payment, error) {
+	var payload struct {
+		Type      string `json:"type"`
+		Timestamp int64  `json:"timestamp"`
+		InvoiceID string `json:"invoiceId"`
+		Payment   struct {
+			ID    string `json:"id"`
+			Value string `json:"value"` // BTC
+		} `json:"payment"`
+		Metadata struct {
+			ItemDesc string `json:"itemDesc"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return Payment{}, err
+	}
+	if payload.Type != "InvoicePaymentSettled" {
+		return Payment{}, ErrIgnored
+	}
+	if payload.InvoiceID == "" || payload.Payment.ID == "" {
+		return Payment{}, errors.New("missing invoiceId or payment id")
+	}
+	amount, err := toSats(payload.Payment.Value, "btc")
+	if err != nil {
+		return Payment{}, fmt.Errorf("payment value: %w", err)
+	}
+	p := Payment{
+		ExternalID: payload.InvoiceID + ":" + payload.Payment.ID,
+		AmountSats: amount,
+		Memo:       payload.Metadata.ItemDesc,
+	}
+	if payload.Timestamp != 0 {
+		p.PaidAt = time.Unix(payload.Timestamp, 0).UTC()
+	}
+	return p, nil
+}
+
+// Generic mapping keys. Values are dot-separated paths into the JSON body,
+// except MappingUnit which is one of "sat", "msat" or "btc".
+const (
+	MappingExternalID  = "external_id"
+	MappingAmount      = "amount"
+	MappingUnit        = "unit"
+	MappingTime        = "time"
+	MappingPaymentHash = "payment_hash"
+	MappingMemo        = "memo"
+)
+
+// generic reads payments from arbitrary JSON using a field mapping.
+type generic struct {
+	mapping map[string]string
+	unit    string
+}
+
+func newGeneric(mapping map[string]string) (generic, error) {
+	g := generic{mapping: mapping, unit: "sat"}
+	for key, val := range mapping {
+		switch key {
+		case MappingExternalID, MappingAmount, MappingTime, MappingPaymentHash, MappingMemo:
+		case MappingUnit:
+			if val != "sat" && val != "msat" && val != "btc" {
+				return g, fmt.Errorf("unit must be sat, msat or btc")
+			}
+			g.unit = val
+		default:
+			return g, fmt.Errorf("unknown mapping field %q", key)
+		}
+	}
+	if mapping[MappingExternalID] == "" || mapping[MappingAmount] == "" {
+		return g, errors.New("generic mapping needs external_id and amount paths")
+	}
+	return g, nil
+}
+
+func (g generic) Parse(body []byte) (Payment, error) {
+	doc, err := decodeDocument(body)
+	if err != nil {
+		return Payment{}, err
+	}
+	var p Payment
+	id, ok := lookup(doc, g.mapping[MappingExternalID])
+	if !ok || scalar(id) == "" {
+		return p, fmt.Errorf("missing %s", g.mapping[MappingExternalID])
+	}
+	p.ExternalID = scalar(id)
+	amount, ok := lookup(doc, g.mapping[MappingAmount])
+	if !ok {
+		return p, fmt.Errorf("missing %s", g.mapping[MappingAmount])
+	}
+	sats, err := toSats(scalar(amount), g.unit)
+	if err != nil {
+		return p, fmt.Errorf("amount: %w", err)
+	}
+	p.AmountSats = sats
+	if v, ok := lookup(doc, g.mapping[MappingTime]); ok {
+		if p.PaidAt, err = parseTime(v); err != nil {
+			return p, fmt.Errorf("time: %w", err)
+		}
+	}
+	if v, ok := lookup(doc, g.mapping[MappingPaymentHash]); ok {
+		p.PaymentHash = scalar(v)
+	}
+	if v, ok := lookup(doc, g.mapping[MappingMemo]); ok {
+		p.Memo = scalar(v)
+	}
+	return p, nil
+}
+
+// decodeDocument decodes a JSON body keeping numbers as json.Number, so large
+// integer ids don't lose precision as float64.
+func decodeDocument(body []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid character after top-level value")
+	}
+	return doc, nil
+}
+
+// lookup follows a dot-separated path through nested JSON objects.
+func lookup(doc any, path string) (any, bool) {
+	if path == "" {
+		return nil, false
+	}
+	cur := doc
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, cur != nil
+}
+
+func scalar(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		return fmt.Sprint(t)
+	}
+	return ""
+}
+
+func toSats(val, unit string) (int64, error) {
+	dec, err := decimal.NewFromString(strings.TrimSpace(val))
+	if err != nil {
+		return 0, err
+	}
+	switch unit {
+	case "msat":
+		dec = dec.Div(decimal.NewFromInt(1000))
+	case "btc":
+		dec = dec.Mul(decimal.NewFromInt(100_000_000))
+	}
+	sats := dec.Round(0).IntPart()
+	if sats <= 0 {
+		return 0, errors.New("amount must be positive")
+	}
+	return sats, nil
+}
+
+// parseTime accepts unix seconds or an RFC 3339 string.
+func parseTime(v any) (time.Time, error) {
+	switch t := v.(type) {
+	case json.Number:
+		secs, err := decimal.NewFromString(t.String())
+		return time.Unix(secs.IntPart(), 0).UTC(), err
+	case string:
+		ts, err := time.Parse(time.RFC3339Nano, t)
+		return ts.UTC(), err
+	}
+	return time.Time{}, errors.New("unsupported time format")
+}
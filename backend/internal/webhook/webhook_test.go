@@ -0,0 +1,154 @@
+package webhook_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adopting-bitcoin/dashboard/internal/webhook"
+)
+
+func TestProviders(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		mapping  map[string]string
+		body     string
+		want     webhook.Payment
+		wantErr  error
+	}{
+		{
+			name:     "lnbits millisats",
+			provider: webhook.ProviderLNbits,
+			body:     `{"amount":2100000,"memo":"WiFi","payment_hash":"abc","time":1699000000}`,
+			want:     webhook.Payment{ExternalID: "abc", AmountSats: 2100, PaidAt: time.Unix(1699000000, 0).UTC(), PaymentHash: "abc", Memo: "WiFi"},
+		},
+		{
+			name:     "btcpay settled payment",
+			provider: webhook.ProviderBTCPay,
+			body:     `{"type":"InvoicePaymentSettled","timestamp":1699000000,"invoiceId":"inv1","payment":{"id":"p1","value":"0.00021"},"metadata":{"itemDesc":"Coffee"}}`,
+			want:     webhook.Payment{ExternalID: "inv1:p1", AmountSats: 21000, PaidAt: time.Unix(1699000000, 0).UTC(), Memo: "Coffee"},
+		},
+		{
+			name:     "btcpay other event",
+			provider: webhook.ProviderBTCPay,
+			body:     `{"type":"InvoiceCreated","invoiceId":"inv1"}`,
+			wantErr:  webhook.ErrIgnored,
+		},
+		{
+			name:     "generic nested paths",
+			provider: webhook.ProviderGeneric,
+			mapping:  map[string]string{"external_id": "data.id", "amount": "data.msats", "unit": "msat", "time": "data.paid_at", "memo": "note"},
+			body:     `{"data":{"id":42,"msats":"5000000","paid_at":"2025-11-10T14:00:00Z"},"note":"Pupusa"}`,
+			want:     webhook.Payment{ExternalID: "42", AmountSats: 5000, PaidAt: time.Date(2025, 11, 10, 14, 0, 0, 0, time.UTC), Memo: "Pupusa"},
+		},
+		{
+			// 2^53+1 and 2^53 are the same float64, so ids this large must be
+			// kept as their literal digits
+			name:     "generic id above 2^53",
+			provider: webhook.ProviderGeneric,
+			mapping:  map[string]string{"external_id": "id", "amount": "sats", "time": "at"},
+			body:     `{"id":9007199254740993,"sats":1e3,"at":1699000000}`,
+			want:     webhook.Payment{ExternalID: "9007199254740993", AmountSats: 1000, PaidAt: time.Unix(1699000000, 0).UTC()},
+		},
+		{
+			name:     "generic neighbouring id",
+			provider: webhook.ProviderGeneric,
+			mapping:  map[string]string{"external_id": "id", "amount": "sats"},
+			body:     `{"id":9007199254740992,"sats":1000}`,
+			want:     webhook.Payment{ExternalID: "9007199254740992", AmountSats: 1000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := webhook.New(tt.provider, tt.mapping)
+			if err != nil {
+				t.Fatalf("new provider: %v", err)
+			}
+			got, err := p.Parse([]byte(tt.body))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenericMappingValidation(t *testing.T) {
+	for _, mapping := range []map[string]string{
+		{"amount": "a"},
+		{"external_id": "id", "amount": "a", "unit": "eur"},
+		{"external_id": "id", "amount": "a", "colour": "blue"},
+	} {
+		if _, err := webhook.New(webhook.ProviderGeneric, mapping); err == nil {
+			t.Errorf("mapping %v: expected error", mapping)
+		}
+	}
+	if _, err := webhook.New("stripe", nil); err == nil {
+		t.Errorf("expected unknown provider error")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"p1"}`)
+	signed := func(secret string, at time.Time) http.Header {
+		h := http.Header{}
+		h.Set(webhook.HeaderTimestamp, strconv.FormatInt(at.Unix(), 10))
+		h.Set(webhook.HeaderSignature, webhook.Sign(secret, at.Unix(), body))
+		return h
+	}
+	btcpaySig := func(secret string) http.Header {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		h := http.Header{}
+		h.Set("BTCPay-Sig", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		return h
+	}
+	plain := http.Header{}
+	plain.Set(webhook.HeaderSecret, "old")
+
+	tests := []struct {
+		name    string
+		mode    string
+		secrets []string
+		header  http.Header
+		wantErr error
+	}{
+		{"hmac current secret", webhook.AuthHMAC, []string{"new", "old"}, signed("new", now), nil},
+		{"hmac previous secret", webhook.AuthHMAC, []string{"new", "old"}, signed("old", now.Add(-time.Minute)), nil},
+		{"hmac wrong secret", webhook.AuthHMAC, []string{"new"}, signed("old", now), webhook.ErrUnauthorized},
+		{"hmac stale", webhook.AuthHMAC, []string{"new"}, signed("new", now.Add(-6*time.Minute)), webhook.ErrStale},
+		{"hmac from the future", webhook.AuthHMAC, []string{"new"}, signed("new", now.Add(6*time.Minute)), webhook.ErrStale},
+		{"hmac unsigned", webhook.AuthHMAC, []string{"new"}, http.Header{}, webhook.ErrUnauthorized},
+		{"empty secrets never match", webhook.AuthHMAC, []string{"", ""}, signed("", now), webhook.ErrUnauthorized},
+		{"header", webhook.AuthHeader, []string{"new", "old"}, plain, nil},
+		{"header wrong secret", webhook.AuthHeader, []string{"new"}, plain, webhook.ErrUnauthorized},
+		{"btcpay", webhook.AuthBTCPay, []string{"new"}, btcpaySig("new"), nil},
+		{"btcpay wrong secret", webhook.AuthBTCPay, []string{"new"}, btcpaySig("old"), webhook.ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nonce, err := webhook.Verify(tt.mode, tt.secrets, tt.header, body, now, 5*time.Minute)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && tt.mode == webhook.AuthHMAC && nonce != tt.header.Get(webhook.HeaderSignature) {
+				t.Errorf("expected the signature as nonce, got %q", nonce)
+			}
+		})
+	}
+}
@@ -185,7 +185,7 @@ VALIDATE:
 	}
 
 	// Check ticker has data
-	tickerData, err := st.LatestTransactions(ctx, 10)
+	tickerData, err := st.LatestTransactions(ctx, 10, "all")
 	if err != nil {
 		t.Fatalf("failed to get ticker: %v", err)
 	}
@@ -231,14 +231,14 @@ VALIDATE:
 		t.Logf("Testing idempotency by polling merchant %s twice", testMerchant)
 
 		// First poll
-		if err := poller.RefreshMerchant(ctx, testMerchant); err != nil {
+		if err := poller.RefreshMerchant(ctx, st.EventID(), testMerchant); err != nil {
 			t.Fatalf("first refresh failed: %v", err)
 		}
 
 		summary1, _ := st.Summary(ctx, 0)
 
 		// Second poll (should be idempotent)
-		if err := poller.RefreshMerchant(ctx, testMerchant); err != nil {
+		if err := poller.RefreshMerchant(ctx, st.EventID(), testMerchant); err != nil {
 			t.Fatalf("second refresh failed: %v", err)
 		}
 
@@ -15,7 +15,7 @@ export function Ticker({ entries = [], btcPriceUsd }: Props) {
         {safeEntries.slice(0, 12).map((entry) => {
           const usd = satsToUsd(entry.amount_sats, btcPriceUsd ?? 0);
           return (
-            <div className="ticker__row" key={entry.sale_id}>
+            <div className="ticker__row" key={entry.id}>
               <div>
                 <div className="ticker__alias">{entry.merchant_alias}</div>
                 <div className="ticker__time">
@@ -10,7 +10,8 @@ export type Summary = {
 };
 
 export type TickerEntry = {
-  sale_id: number;
+  id: number;
+  sale_id: number | null;
   merchant_id: string;
   merchant_alias: string;
   amount_sats: number;